@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/osgochina/dmicro/drpc"
+	"github.com/osgochina/dmicro/drpc/message"
+	"github.com/osgochina/dmicro/registry"
+	"github.com/osgochina/dmicro/selector"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// NodeResult 广播请求中单个节点的结果
+type NodeResult struct {
+	Node *registry.Node
+	// Result 节点返回的响应内容，Broadcast 时为nil
+	Result interface{}
+	Status *drpc.Status
+}
+
+// NodeResults 广播请求中所有节点的结果，按照节点地址排序
+type NodeResults []*NodeResult
+
+// OK 是否所有节点都成功
+func (that NodeResults) OK() bool {
+	return len(that.Failed()) == 0
+}
+
+// Failed 返回失败的节点
+func (that NodeResults) Failed() NodeResults {
+	var failed NodeResults
+	for _, r := range that {
+		if !r.Status.OK() {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// Broadcast 向服务的所有节点发送push消息，返回每个节点的结果
+//  只有获取节点失败或者客户端已关闭时才返回错误，单个节点失败记录在节点的结果中
+func (that *RpcClient) Broadcast(serviceMethod string, arg interface{}, setting ...message.MsgSetting) (NodeResults, *drpc.Status) {
+	return that.fanOut(message.NewMessage(setting...).Context(), func(sess *pooledSession, r *NodeResult) {
+		r.Status = sess.Push(serviceMethod, arg, sendSetting(setting)...)
+	})
+}
+
+// CallAll 请求服务的所有节点，返回每个节点的结果
+//  result 必须是指针，只用来确定响应内容的类型，每个节点的响应内容写入新创建的对象中
+//  只有获取节点失败或者客户端已关闭时才返回错误，单个节点失败记录在节点的结果中
+func (that *RpcClient) CallAll(serviceMethod string, args interface{}, result interface{}, setting ...message.MsgSetting) (NodeResults, *drpc.Status) {
+	resultType := reflect.TypeOf(result)
+	if resultType == nil || resultType.Kind() != reflect.Ptr {
+		return nil, drpc.NewStatus(drpc.CodeBadMessage, "result must be a pointer", "")
+	}
+	return that.fanOut(message.NewMessage(setting...).Context(), func(sess *pooledSession, r *NodeResult) {
+		r.Result = reflect.New(resultType.Elem()).Interface()
+		r.Status = sess.Call(serviceMethod, args, r.Result, sendSetting(setting)...).Status()
+	})
+}
+
+// 并发的在所有节点上执行 fn，同时执行的数量不超过 BroadcastConcurrency
+//  ctx 结束时还没有开始的节点直接返回超时或者取消的状态
+func (that *RpcClient) fanOut(ctx context.Context, fn func(sess *pooledSession, r *NodeResult)) (NodeResults, *drpc.Status) {
+	select {
+	case <-that.closeCh:
+		return nil, rerClientClosed
+	default:
+	}
+	nodes, stat := that.allNodes()
+	if stat != nil {
+		return nil, stat
+	}
+	concurrency := that.opts.BroadcastConcurrency
+	if concurrency <= 0 || concurrency > len(nodes) {
+		concurrency = len(nodes)
+	}
+	var (
+		results = make(NodeResults, len(nodes))
+		sem     = make(chan struct{}, concurrency)
+		wg      sync.WaitGroup
+	)
+	for i, node := range nodes {
+		results[i] = &NodeResult{Node: node}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(r *NodeResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := ctx.Err(); err != nil {
+				r.Status = drpc.StatusFromError(err)
+				return
+			}
+			sess, stat := that.pool.get(ctx, r.Node.Address)
+			if err := ctx.Err(); stat != nil && err != nil {
+				r.Status = drpc.StatusFromError(err)
+				return
+			}
+			if stat != nil {
+				r.Status = drpc.NewStatus(drpc.CodeDialFailed, "", stat)
+				that.mark(r.Node, r.Status)
+				return
+			}
+			done := that.begin(r.Node)
+			fn(sess, r)
+			done()
+			sess.release()
+			that.mark(r.Node, r.Status)
+		}(results[i])
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// 获取注册中心中服务的全部节点，不经过路由规则和熔断器的过滤，相同地址的节点只保留一个
+func (that *RpcClient) allNodes() ([]*registry.Node, *drpc.Status) {
+	services, err := that.opts.Registry.GetService(that.opts.ServiceName)
+	if err != nil {
+		return nil, drpc.NewStatus(drpc.CodeInternalServerError, fmt.Sprintf("dmicro.client service %s: %s", that.opts.ServiceName, err.Error()))
+	}
+	var (
+		nodes []*registry.Node
+		seen  = make(map[string]bool)
+	)
+	for _, s := range services {
+		for _, node := range s.Nodes {
+			if seen[node.Address] {
+				continue
+			}
+			seen[node.Address] = true
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, drpc.NewStatus(drpc.CodeInternalServerError, fmt.Sprintf("dmicro.client service %s: %s", that.opts.ServiceName, selector.ErrNoneAvailable.Error()))
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Address < nodes[j].Address
+	})
+	return nodes, nil
+}
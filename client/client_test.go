@@ -9,8 +9,11 @@ import (
 	"github.com/osgochina/dmicro/drpc/plugin/ignorecase"
 	"github.com/osgochina/dmicro/logger"
 	"github.com/osgochina/dmicro/registry"
+	"github.com/osgochina/dmicro/registry/memory"
+	"github.com/osgochina/dmicro/selector"
 	"github.com/osgochina/dmicro/server"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -30,6 +33,11 @@ func (that *Math) Add(arg *[]int) (int, *drpc.Status) {
 
 var once sync.Once
 
+// 每个测试使用独立的内存注册中心，避免多个服务端和客户端同时初始化 registry.DefaultRegistry
+func newTestRegistry(serviceName string) registry.Registry {
+	return memory.NewRegistry(registry.OptServiceName(serviceName), registry.OptServiceVersion("1.0.0"))
+}
+
 func getServer(serverName string, addr string) {
 	once.Do(func() {
 		svr := server.NewRpcServer(serverName,
@@ -118,3 +126,550 @@ func TestNewRpcClientMemory(t *testing.T) {
 		t.Assert(result, 15)
 	})
 }
+
+type Flaky struct {
+	drpc.CallCtx
+}
+
+var flakyCalls int32
+
+// Fail 前两次请求返回 503 错误
+func (that *Flaky) Fail(_ *string) (int32, *drpc.Status) {
+	n := atomic.AddInt32(&flakyCalls, 1)
+	if n%3 != 0 {
+		return n, drpc.NewStatus(503, "unavailable", nil)
+	}
+	return n, nil
+}
+
+func TestRpcClientRetry(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		serviceName := "testretry"
+		r := newTestRegistry(serviceName)
+		svr := server.NewRpcServer(serviceName, server.OptListenAddress("127.0.0.1:9093"), server.OptRegistry(r))
+		svr.RouteCall(new(Flaky))
+		go func() {
+			_ = svr.ListenAndServe()
+		}()
+		time.Sleep(1 * time.Second)
+		s := &registry.Service{
+			Nodes: []*registry.Node{
+				{Address: "127.0.0.1:9093"},
+			},
+		}
+		policy := &client.RetryPolicy{
+			MaxAttempts:    3,
+			RetryableCodes: []int32{503},
+			InitialBackoff: 10 * time.Millisecond,
+			Jitter:         true,
+		}
+		cli := client.NewRpcClient(serviceName,
+			client.OptCustomService(s),
+			client.OptRetryPolicy(policy, "/flaky/fail"),
+		)
+		defer cli.Close()
+
+		var result int32
+		stat := cli.Call("/flaky/fail", "", &result).Status()
+		t.Assert(stat.OK(), true)
+		t.Assert(result, 3)
+
+		// 单次请求不重试
+		stat = cli.Call("/flaky/fail", "", &result, client.WithRetryPolicy(nil)).Status()
+		t.Assert(stat.Code(), 503)
+		stat = cli.Call("/flaky/fail", "", &result, client.WithRetryPolicy(nil), message.WithContext(context.Background())).Status()
+		t.Assert(stat.Code(), 503)
+
+		// 异步请求同样按照策略重试
+		callCmdChan := make(chan drpc.CallCmd, 1)
+		cli.AsyncCall("/flaky/fail", "", &result, callCmdChan)
+		callCmd := <-callCmdChan
+		t.Assert(callCmd.StatusOK(), true)
+		t.Assert(result, 6)
+
+		// 非幂等的路由不重试业务错误
+		cli2 := client.NewRpcClient(serviceName,
+			client.OptCustomService(s),
+			client.OptRetryPolicy(policy),
+			client.OptNonIdempotent("/flaky/fail"),
+		)
+		defer cli2.Close()
+		stat = cli2.Call("/flaky/fail", "", &result).Status()
+		t.Assert(stat.Code(), 503)
+	})
+}
+
+// Panic 处理方法panic，客户端收到 CodeInternalServerError
+func (that *Flaky) Panic(_ *string) (int32, *drpc.Status) {
+	panic("oops")
+}
+
+// 业务错误以及处理方法的panic不会熔断节点
+func TestRpcClientBreaker(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		serviceName := "testbreaker"
+		r := newTestRegistry(serviceName)
+		svr := server.NewRpcServer(serviceName, server.OptListenAddress("127.0.0.1:9109"), server.OptRegistry(r))
+		svr.RouteCall(new(Flaky))
+		svr.RouteCall(new(Math))
+		go func() {
+			_ = svr.ListenAndServe()
+		}()
+		defer svr.Close()
+		time.Sleep(1 * time.Second)
+		s := &registry.Service{
+			Nodes: []*registry.Node{
+				{Address: "127.0.0.1:9109"},
+			},
+		}
+		cli := client.NewRpcClient(serviceName,
+			client.OptSelector(selector.NewSelector(selector.OptBreaker(selector.BreakerOptions{ConsecutiveFailures: 1}))),
+			client.OptCustomService(s),
+		)
+		defer cli.Close()
+
+		var result int32
+		for i := 0; i < 3; i++ {
+			t.Assert(cli.Call("/flaky/panic", "", &result).Status().Code(), drpc.CodeInternalServerError)
+		}
+		var sum int
+		t.Assert(cli.Call("/math/add", []int{1, 2}, &sum).Status().OK(), true)
+		t.Assert(sum, 3)
+	})
+}
+
+type Hedge struct {
+	drpc.CallCtx
+	name  string
+	delay time.Duration
+}
+
+// Name 等待一段时间后返回节点名字
+func (that *Hedge) Name(_ *string) (string, *drpc.Status) {
+	time.Sleep(that.delay)
+	return that.name, nil
+}
+
+// 每次请求都使用新的 Hedge 实例
+func newHedge(name string, delay time.Duration) drpc.CtrlFactory {
+	return func() interface{} {
+		return &Hedge{name: name, delay: delay}
+	}
+}
+
+// 总是选择地址最小的节点
+func firstNode(services []*registry.Service) selector.Next {
+	return func() (*registry.Node, error) {
+		var first *registry.Node
+		for _, s := range services {
+			for _, node := range s.Nodes {
+				if first == nil || node.Address < first.Address {
+					first = node
+				}
+			}
+		}
+		if first == nil {
+			return nil, selector.ErrNoneAvailable
+		}
+		return first, nil
+	}
+}
+
+func TestRpcClientHedge(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		serviceName := "testhedge"
+		r := newTestRegistry(serviceName)
+		slow := server.NewRpcServer(serviceName, server.OptListenAddress("127.0.0.1:9094"), server.OptRegistry(r))
+		slow.RouteCall(newHedge("slow", time.Second))
+		fast := server.NewRpcServer(serviceName, server.OptListenAddress("127.0.0.1:9095"), server.OptRegistry(r))
+		fast.RouteCall(newHedge("fast", 0))
+		go func() {
+			_ = slow.ListenAndServe()
+		}()
+		go func() {
+			_ = fast.ListenAndServe()
+		}()
+		time.Sleep(1 * time.Second)
+		s := &registry.Service{
+			Nodes: []*registry.Node{
+				{Id: "slow", Address: "127.0.0.1:9094"},
+				{Id: "fast", Address: "127.0.0.1:9095"},
+			},
+		}
+		cli := client.NewRpcClient(serviceName,
+			client.OptCustomService(s),
+			client.OptStrategy(firstNode),
+			client.OptHedgePolicy(&client.HedgePolicy{Delay: 50 * time.Millisecond}, "/hedge/name"),
+		)
+		defer cli.Close()
+
+		var result string
+		start := time.Now()
+		stat := cli.Call("/hedge/name", "", &result).Status()
+		t.Assert(stat.OK(), true)
+		t.Assert(result, "fast")
+		t.AssertLT(time.Since(start), 500*time.Millisecond)
+
+		var found bool
+		for _, hs := range client.HedgeStats() {
+			if hs.Service == serviceName && hs.ServiceMethod == "/hedge/name" {
+				found = true
+				t.Assert(hs.Requests, 1)
+				t.Assert(hs.Hedges, 1)
+				t.Assert(hs.HedgeWins, 1)
+			}
+		}
+		t.Assert(found, true)
+	})
+}
+
+func findPoolStat(service string) *client.PoolStat {
+	for _, stat := range client.PoolStats() {
+		if stat.Service == service {
+			return stat
+		}
+	}
+	return nil
+}
+
+func TestRpcClientPool(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		serviceName := "testpool"
+		r := newTestRegistry(serviceName)
+		srv := server.NewRpcServer(serviceName, server.OptListenAddress("127.0.0.1:9096"), server.OptRegistry(r))
+		srv.RouteCall(newHedge("pool", 200*time.Millisecond))
+		go func() {
+			_ = srv.ListenAndServe()
+		}()
+		time.Sleep(1 * time.Second)
+		s := &registry.Service{
+			Nodes: []*registry.Node{
+				{Id: "pool", Address: "127.0.0.1:9096"},
+			},
+		}
+		cli := client.NewRpcClient(serviceName,
+			client.OptCustomService(s),
+			client.OptPoolSize(3),
+			client.OptPoolWarmup(2),
+			client.OptPoolIdleTimeout(300*time.Millisecond),
+		)
+		defer cli.Close()
+
+		// 创建客户端后预先建立session
+		time.Sleep(200 * time.Millisecond)
+		stat := findPoolStat(serviceName)
+		t.AssertNE(stat, nil)
+		t.Assert(stat.Sessions, 2)
+
+		// 所有session都在忙时建立新的session，数量不超过上限
+		var wg sync.WaitGroup
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var result string
+				t.Assert(cli.Call("/hedge/name", "", &result).Status().OK(), true)
+				t.Assert(result, "pool")
+			}()
+		}
+		wg.Wait()
+		stat = findPoolStat(serviceName)
+		t.Assert(stat.Sessions, 3)
+		t.Assert(stat.Dials, 3)
+		t.Assert(stat.Inflight, 0)
+
+		// 闲置的session被关闭，保留预热的session
+		time.Sleep(800 * time.Millisecond)
+		stat = findPoolStat(serviceName)
+		t.Assert(stat.Sessions, 2)
+		t.Assert(stat.IdleClosed, 1)
+
+		// 同时请求没有session的节点时只建立一个session
+		cold := client.NewRpcClient("testpoolcold",
+			client.OptCustomService(&registry.Service{
+				Nodes: []*registry.Node{{Id: "pool", Address: "127.0.0.1:9096"}},
+			}),
+			client.OptPoolSize(1),
+		)
+		defer cold.Close()
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var result string
+				t.Assert(cold.Call("/hedge/name", "", &result).Status().OK(), true)
+			}()
+		}
+		wg.Wait()
+		stat = findPoolStat("testpoolcold")
+		t.Assert(stat.Sessions, 1)
+		t.Assert(stat.Dials, 1)
+	})
+}
+
+// 没有设置注册中心时，使用默认注册中心中的节点预热
+func TestRpcClientDefaultRegistryWarmup(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		serviceName := "testdefaultwarmup"
+		// 服务端使用独立的注册中心，节点手动注册到默认注册中心，避免客户端初始化默认注册中心时与服务端的租约协程竞争
+		defaultRegistry := registry.DefaultRegistry
+		registry.DefaultRegistry = memory.NewRegistry()
+		defer func() {
+			registry.DefaultRegistry = defaultRegistry
+		}()
+		t.AssertNil(registry.DefaultRegistry.Register(&registry.Service{
+			Name:    serviceName,
+			Version: "1.0.0",
+			Nodes:   []*registry.Node{{Id: "warmup", Address: "127.0.0.1:9106"}},
+		}))
+		srv := server.NewRpcServer(serviceName,
+			server.OptListenAddress("127.0.0.1:9106"),
+			server.OptRegistry(newTestRegistry(serviceName)),
+		)
+		srv.RouteCall(newHedge("warmup", 0))
+		go func() {
+			_ = srv.ListenAndServe()
+		}()
+		defer srv.Close()
+		time.Sleep(1 * time.Second)
+
+		cli := client.NewRpcClient(serviceName, client.OptPoolWarmup(1))
+		defer cli.Close()
+
+		time.Sleep(200 * time.Millisecond)
+		stat := findPoolStat(serviceName)
+		t.AssertNE(stat, nil)
+		t.Assert(stat.Sessions, 1)
+	})
+}
+
+func TestRpcClientRouter(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		serviceName := "testrouter"
+		r := newTestRegistry(serviceName)
+		shared := server.NewRpcServer(serviceName, server.OptListenAddress("127.0.0.1:9097"), server.OptRegistry(r))
+		shared.RouteCall(newHedge("shared", 0))
+		acme := server.NewRpcServer(serviceName, server.OptListenAddress("127.0.0.1:9098"), server.OptRegistry(r))
+		acme.RouteCall(newHedge("acme", 0))
+		go func() {
+			_ = shared.ListenAndServe()
+		}()
+		go func() {
+			_ = acme.ListenAndServe()
+		}()
+		time.Sleep(1 * time.Second)
+		s := &registry.Service{
+			Nodes: []*registry.Node{
+				{Id: "shared", Address: "127.0.0.1:9097"},
+				{Id: "acme", Address: "127.0.0.1:9098", Metadata: map[string]string{"tenant": "acme"}},
+			},
+		}
+		cli := client.NewRpcClient(serviceName,
+			client.OptCustomService(s),
+			client.OptRouteRules(selector.RouteRule{
+				Match:  map[string]string{"X-Tenant": "acme"},
+				Labels: map[string]string{"tenant": "acme"},
+			}),
+		)
+		defer cli.Close()
+
+		var result string
+		for i := 0; i < 5; i++ {
+			t.Assert(cli.Call("/hedge/name", "", &result, message.WithSetMeta("X-Tenant", "acme")).Status().OK(), true)
+			t.Assert(result, "acme")
+		}
+
+		// 运行时更新规则，其他请求不发送到租户的节点
+		cli.Router().Update(selector.RouteRule{
+			Match:  map[string]string{"X-Tenant": "acme"},
+			Labels: map[string]string{"tenant": "acme"},
+		}, selector.RouteRule{
+			Prefer: map[string]string{"tenant": ""},
+		})
+		for i := 0; i < 5; i++ {
+			t.Assert(cli.Call("/hedge/name", "", &result).Status().OK(), true)
+			t.Assert(result, "shared")
+		}
+	})
+}
+
+var invalidated int32
+
+// 收到push消息后计数
+func invalidate(_ drpc.PushCtx, _ *string) *drpc.Status {
+	atomic.AddInt32(&invalidated, 1)
+	return nil
+}
+
+func TestRpcClientBroadcast(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		serviceName := "testbroadcast"
+		r := newTestRegistry(serviceName)
+		addrs := []string{"127.0.0.1:9099", "127.0.0.1:9100"}
+		for _, addr := range addrs {
+			srv := server.NewRpcServer(serviceName, server.OptListenAddress(addr), server.OptRegistry(r))
+			srv.RouteCall(newHedge(addr, 0))
+			srv.RoutePushFunc(invalidate)
+			go func() {
+				_ = srv.ListenAndServe()
+			}()
+		}
+		time.Sleep(1 * time.Second)
+		s := &registry.Service{
+			Nodes: []*registry.Node{
+				{Id: "a", Address: addrs[0]},
+				{Id: "b", Address: addrs[1]},
+				// 没有启动的节点
+				{Id: "c", Address: "127.0.0.1:9103"},
+			},
+		}
+		cli := client.NewRpcClient(serviceName,
+			client.OptCustomService(s),
+			client.OptBroadcastConcurrency(2),
+		)
+		defer cli.Close()
+
+		var result string
+		results, stat := cli.CallAll("/hedge/name", "", &result)
+		t.AssertNil(stat)
+		t.Assert(len(results), 3)
+		t.Assert(results.OK(), false)
+		for i, addr := range addrs {
+			t.Assert(results[i].Node.Address, addr)
+			t.Assert(results[i].Status.OK(), true)
+			t.Assert(*results[i].Result.(*string), addr)
+		}
+		failed := results.Failed()
+		t.Assert(len(failed), 1)
+		t.Assert(failed[0].Node.Address, "127.0.0.1:9103")
+		t.Assert(failed[0].Status.Code(), drpc.CodeDialFailed)
+
+		results, stat = cli.Broadcast("/invalidate", "")
+		t.AssertNil(stat)
+		t.Assert(len(results.Failed()), 1)
+		time.Sleep(100 * time.Millisecond)
+		t.Assert(atomic.LoadInt32(&invalidated), 2)
+
+		_, stat = cli.CallAll("/hedge/name", "", result)
+		t.Assert(stat.Code(), drpc.CodeBadMessage)
+	})
+}
+
+// 只设置选择器时，从选择器的注册中心获取全部节点
+func TestRpcClientBroadcastSelector(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		serviceName := "testbroadcastselector"
+		r := memory.NewRegistry(registry.OptServiceName(serviceName), registry.OptServiceVersion("1.0.0"))
+		srv := server.NewRpcServer(serviceName,
+			server.OptListenAddress("127.0.0.1:9107"),
+			server.OptRegistry(r),
+		)
+		srv.RouteCall(newHedge("selector", 0))
+		go func() {
+			_ = srv.ListenAndServe()
+		}()
+		defer srv.Close()
+		time.Sleep(1 * time.Second)
+
+		cli := client.NewRpcClient(serviceName,
+			client.OptSelector(selector.NewSelector(selector.OptRegistry(r))),
+		)
+		defer cli.Close()
+
+		var result string
+		results, stat := cli.CallAll("/hedge/name", "", &result)
+		t.AssertNil(stat)
+		t.Assert(results.OK(), true)
+		t.Assert(len(results), 1)
+		t.Assert(*results[0].Result.(*string), "selector")
+	})
+}
+
+func TestRpcClientCallContext(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		serviceName := "testcallcontext"
+		r := newTestRegistry(serviceName)
+		srv := server.NewRpcServer(serviceName, server.OptListenAddress("127.0.0.1:9102"), server.OptRegistry(r))
+		srv.RouteCall(newHedge("slow", time.Second))
+		go func() {
+			_ = srv.ListenAndServe()
+		}()
+		time.Sleep(1 * time.Second)
+		cli := client.NewRpcClient(serviceName,
+			client.OptCustomService(&registry.Service{
+				Nodes: []*registry.Node{{Id: "slow", Address: "127.0.0.1:9102"}},
+			}),
+		)
+		defer cli.Close()
+
+		// 等待响应时超时
+		var result string
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		stat := cli.CallContext(ctx, "/hedge/name", "", &result).Status()
+		t.Assert(stat.Code(), drpc.CodeHandleTimeout)
+		t.AssertLT(time.Since(start), 500*time.Millisecond)
+
+		// ctx 已经取消时不发送请求
+		ctx, cancel = context.WithCancel(context.Background())
+		cancel()
+		stat = cli.CallContext(ctx, "/hedge/name", "", &result).Status()
+		t.Assert(stat.Code(), drpc.CodeCallCanceled)
+
+		// 等待重试时超时，不再重试
+		dead := client.NewRpcClient(serviceName,
+			client.OptCustomService(&registry.Service{
+				Nodes: []*registry.Node{{Id: "dead", Address: "127.0.0.1:9103"}},
+			}),
+			client.OptRetryPolicy(&client.RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second}),
+		)
+		defer dead.Close()
+		ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start = time.Now()
+		stat = dead.CallContext(ctx, "/hedge/name", "", &result).Status()
+		t.Assert(stat.Code(), drpc.CodeHandleTimeout)
+		t.AssertLT(time.Since(start), 500*time.Millisecond)
+	})
+}
+
+// 单次请求的节点选择设置与 message.WithContext 的顺序无关
+func TestRpcClientSettingOrder(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		serviceName := "testsettingorder"
+		r := newTestRegistry(serviceName)
+		addrs := []string{"127.0.0.1:9104", "127.0.0.1:9105"}
+		s := &registry.Service{}
+		for _, addr := range addrs {
+			srv := server.NewRpcServer(serviceName, server.OptListenAddress(addr), server.OptRegistry(r))
+			srv.RouteCall(newHedge(addr, 0))
+			go func() {
+				_ = srv.ListenAndServe()
+			}()
+			defer srv.Close()
+			s.Nodes = append(s.Nodes, &registry.Node{Id: addr, Address: addr})
+		}
+		time.Sleep(1 * time.Second)
+		cli := client.NewRpcClient(serviceName,
+			client.OptCustomService(s),
+			client.OptStrategy(selector.RoundRobin),
+		)
+		defer cli.Close()
+
+		var result, first string
+		for i := 0; i < 4; i++ {
+			stat := cli.Call("/hedge/name", "", &result, client.WithHashKey("user-1"), message.WithContext(context.Background())).Status()
+			t.Assert(stat.OK(), true)
+			if i == 0 {
+				first = result
+			}
+			t.Assert(result, first)
+		}
+		for i := 0; i < 4; i++ {
+			stat := cli.Call("/hedge/name", "", &result, client.WithStrategy(firstNode), message.WithContext(context.Background())).Status()
+			t.Assert(stat.OK(), true)
+			t.Assert(result, addrs[0])
+		}
+	})
+}
@@ -0,0 +1,287 @@
+package client
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/container/gmap"
+	"github.com/osgochina/dmicro/drpc"
+	"github.com/osgochina/dmicro/drpc/message"
+	"github.com/osgochina/dmicro/registry"
+	"github.com/osgochina/dmicro/selector"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HedgePolicy 对冲请求策略，只适用于只读的路由
+//  第一次请求超过等待时间没有返回时，向其他节点发送对冲请求，最先成功返回的请求作为结果，其他请求被取消
+type HedgePolicy struct {
+	// Delay 发送对冲请求前等待的时间，为0时按照 Percentile 计算
+	Delay time.Duration
+	// Percentile 按照路由最近请求耗时的百分位计算等待时间，取值 (0,1)，默认 0.95
+	Percentile float64
+	// MinDelay 等待时间的下限，请求耗时的样本不足时也使用该值
+	MinDelay time.Duration
+	// MaxHedges 单次请求最多发送的对冲请求数，默认1
+	MaxHedges int
+	// MaxRatio 对冲请求数占请求总数的比例上限，例如 0.1 表示对冲请求最多占请求总数的10%，为0时不限制
+	MaxRatio float64
+}
+
+// HedgeStat 路由的对冲请求统计
+type HedgeStat struct {
+	Service       string `json:"service"`
+	ServiceMethod string `json:"service_method"`
+	// Requests 开启了对冲的请求数
+	Requests int64 `json:"requests"`
+	// Hedges 发送的对冲请求数
+	Hedges int64 `json:"hedges"`
+	// HedgeWins 对冲请求先于第一次请求成功返回的次数
+	HedgeWins int64 `json:"hedge_wins"`
+}
+
+const (
+	defaultHedgePercentile = 0.95
+	// 计算百分位时保留的请求耗时样本数
+	hedgeSampleSize = 128
+	// 计算百分位至少需要的样本数
+	hedgeMinSamples = 10
+)
+
+// 所有路由的对冲统计，key为 服务名+路由
+var hedgeRecorders = gmap.NewStrAnyMap(true)
+
+// 路由的请求耗时和对冲次数
+type hedgeRecorder struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	stat    HedgeStat
+}
+
+func getHedgeRecorder(service, serviceMethod string) *hedgeRecorder {
+	return hedgeRecorders.GetOrSetFuncLock(service+"\x00"+serviceMethod, func() interface{} {
+		return &hedgeRecorder{
+			samples: make([]time.Duration, 0, hedgeSampleSize),
+			stat:    HedgeStat{Service: service, ServiceMethod: serviceMethod},
+		}
+	}).(*hedgeRecorder)
+}
+
+// 记录请求耗时
+func (that *hedgeRecorder) observe(d time.Duration) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	if len(that.samples) < hedgeSampleSize {
+		that.samples = append(that.samples, d)
+		return
+	}
+	that.samples[that.next] = d
+	that.next = (that.next + 1) % hedgeSampleSize
+}
+
+// 计算发送对冲请求前的等待时间
+func (that *hedgeRecorder) delay(policy *HedgePolicy) time.Duration {
+	if policy.Delay > 0 {
+		return policy.Delay
+	}
+	percentile := policy.Percentile
+	if percentile <= 0 || percentile >= 1 {
+		percentile = defaultHedgePercentile
+	}
+	that.mu.Lock()
+	if len(that.samples) < hedgeMinSamples {
+		that.mu.Unlock()
+		return policy.MinDelay
+	}
+	samples := make([]time.Duration, len(that.samples))
+	copy(samples, that.samples)
+	that.mu.Unlock()
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+	d := samples[int(float64(len(samples)-1)*percentile)]
+	if d < policy.MinDelay {
+		return policy.MinDelay
+	}
+	return d
+}
+
+// 判断是否可以再发送一个对冲请求，可以时计数
+func (that *hedgeRecorder) allowHedge(policy *HedgePolicy) bool {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	if policy.MaxRatio > 0 && float64(that.stat.Hedges+1) > policy.MaxRatio*float64(that.stat.Requests) {
+		return false
+	}
+	that.stat.Hedges++
+	return true
+}
+
+func (that *hedgeRecorder) incRequests() {
+	that.mu.Lock()
+	that.stat.Requests++
+	that.mu.Unlock()
+}
+
+func (that *hedgeRecorder) incWins() {
+	that.mu.Lock()
+	that.stat.HedgeWins++
+	that.mu.Unlock()
+}
+
+// HedgeStats 获取当前进程中所有开启了对冲的路由的统计，按照服务名和路由排序
+func HedgeStats() []*HedgeStat {
+	stats := make([]*HedgeStat, 0, hedgeRecorders.Size())
+	hedgeRecorders.Iterator(func(_ string, v interface{}) bool {
+		r := v.(*hedgeRecorder)
+		r.mu.Lock()
+		stat := r.stat
+		r.mu.Unlock()
+		stats = append(stats, &stat)
+		return true
+	})
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Service != stats[j].Service {
+			return stats[i].Service < stats[j].Service
+		}
+		return stats[i].ServiceMethod < stats[j].ServiceMethod
+	})
+	return stats
+}
+
+// 对冲请求中的一次请求
+type hedgeLeg struct {
+	node   *registry.Node
+	cmd    drpc.CallCmd
+	result interface{}
+	cancel context.CancelFunc
+	done   func()
+	start  time.Time
+}
+
+// 对冲请求返回的命令，响应内容写入调用方传入的 result
+type hedgedCallCmd struct {
+	drpc.CallCmd
+	result interface{}
+}
+
+func (that *hedgedCallCmd) Reply() (interface{}, *drpc.Status) {
+	_, stat := that.CallCmd.Reply()
+	return that.result, stat
+}
+
+// 发起对冲请求，每次请求选择不同的节点，请求过的节点写入 tried
+//  每次请求使用独立的 result，成功返回的请求把结果复制到调用方传入的 result 中
+func (that *RpcClient) callHedged(policy *HedgePolicy, serviceMethod string, args interface{}, result interface{}, selectOpts []selector.SelectOption, tried map[string]bool, ctx context.Context, setting []message.MsgSetting) drpc.CallCmd {
+	var (
+		recorder   = getHedgeRecorder(that.opts.ServiceName, serviceMethod)
+		maxHedges  = policy.MaxHedges
+		resultType = reflect.TypeOf(result)
+		legs       []*hedgeLeg
+		pending    int
+		lastCmd    drpc.CallCmd
+	)
+	if maxHedges <= 0 {
+		maxHedges = 1
+	}
+	//所有请求的结果都写入 replies，容量足够时不会阻塞
+	replies := make(chan drpc.CallCmd, maxHedges+1)
+	recorder.incRequests()
+	startLeg := func() drpc.CallCmd {
+		sess, node, stat := that.selectSession(ctx, serviceMethod, selectOpts...)
+		if node != nil {
+			tried[node.Address] = true
+		}
+		if stat != nil {
+			return drpc.NewFakeCallCmd(serviceMethod, args, result, stat)
+		}
+		done := that.begin(node)
+		leg := &hedgeLeg{
+			node:   node,
+			result: reflect.New(resultType.Elem()).Interface(),
+			done: func() {
+				done()
+				sess.release()
+			},
+			start: time.Now(),
+		}
+		var legCtx context.Context
+		legCtx, leg.cancel = context.WithCancel(ctx)
+		leg.cmd = sess.AsyncCall(serviceMethod, args, leg.result, replies, append(sendSetting(setting), message.WithContext(legCtx))...)
+		legs = append(legs, leg)
+		pending++
+		return nil
+	}
+	findLeg := func(cmd drpc.CallCmd) (int, *hedgeLeg) {
+		for i, leg := range legs {
+			if leg.cmd == cmd {
+				return i, leg
+			}
+		}
+		return -1, nil
+	}
+	if cmd := startLeg(); cmd != nil {
+		return cmd
+	}
+	timer := time.NewTimer(recorder.delay(policy))
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if len(legs) <= maxHedges && recorder.allowHedge(policy) {
+				//选不出节点时不影响正在进行的请求
+				_ = startLeg()
+				if len(legs) <= maxHedges {
+					timer.Reset(recorder.delay(policy))
+				}
+			}
+		case cmd := <-replies:
+			pending--
+			idx, leg := findLeg(cmd)
+			leg.done()
+			leg.cancel()
+			that.mark(leg.node, cmd.Status())
+			recorder.observe(time.Since(leg.start))
+			lastCmd = &hedgedCallCmd{CallCmd: cmd, result: leg.result}
+			if !cmd.StatusOK() && pending > 0 {
+				continue
+			}
+			if cmd.StatusOK() {
+				reflect.ValueOf(result).Elem().Set(reflect.ValueOf(leg.result).Elem())
+				lastCmd = &hedgedCallCmd{CallCmd: cmd, result: result}
+				if idx > 0 {
+					recorder.incWins()
+				}
+			}
+			//取消其他请求，等待它们结束后释放节点的负载计数
+			for _, l := range legs {
+				if l != leg {
+					l.cancel()
+				}
+			}
+			if pending > 0 {
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if _, l := findLeg(<-replies); l != nil {
+							l.done()
+						}
+					}
+				}(pending)
+			}
+			return lastCmd
+		}
+	}
+}
+
+// 判断请求是否可以对冲，需要路由配置了对冲策略，不是非幂等的路由，并且 result 是指针
+func (that *RpcClient) hedgePolicy(serviceMethod string, result interface{}) *HedgePolicy {
+	policy, ok := that.opts.HedgePolicies[serviceMethod]
+	if !ok || policy == nil || result == nil || that.opts.NonIdempotent[serviceMethod] {
+		return nil
+	}
+	if reflect.TypeOf(result).Kind() != reflect.Ptr {
+		return nil
+	}
+	return policy
+}
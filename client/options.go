@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"github.com/osgochina/dmicro/drpc"
+	"github.com/osgochina/dmicro/drpc/message"
 	"github.com/osgochina/dmicro/drpc/proto"
 	"github.com/osgochina/dmicro/logger"
 	"github.com/osgochina/dmicro/metrics"
@@ -14,27 +15,38 @@ import (
 )
 
 type Options struct {
-	Context           context.Context // 上下文
-	ServiceName       string          // 服务名称
-	ServiceVersion    string          // 服务版本
-	Network           string          // 网络类型
-	LocalIP           string          // 本地网络
-	TlsCertFile       string
-	TlsKeyFile        string
-	TLSConfig         *tls.Config
-	ProtoFunc         proto.ProtoFunc
-	SessionAge        time.Duration
-	ContextAge        time.Duration
-	DialTimeout       time.Duration
-	SlowCometDuration time.Duration
-	BodyCodec         string
-	PrintDetail       bool
-	HeartbeatTime     time.Duration
-	RetryTimes        int
-	GlobalPlugin      []drpc.Plugin
-	Registry          registry.Registry
-	Selector          selector.Selector
-	Metrics           metrics.Metrics // 统计信息
+	Context              context.Context // 上下文
+	ServiceName          string          // 服务名称
+	ServiceVersion       string          // 服务版本
+	Network              string          // 网络类型
+	LocalIP              string          // 本地网络
+	TlsCertFile          string
+	TlsKeyFile           string
+	TLSConfig            *tls.Config
+	ProtoFunc            proto.ProtoFunc
+	SessionAge           time.Duration
+	ContextAge           time.Duration
+	DialTimeout          time.Duration
+	SlowCometDuration    time.Duration
+	BodyCodec            string
+	PrintDetail          bool
+	HeartbeatTime        time.Duration
+	RetryTimes           int
+	RetryPolicy          *RetryPolicy            // 默认的重试策略，为nil时按照 RetryTimes 重试链接错误
+	RetryPolicies        map[string]*RetryPolicy // 路由的重试策略
+	NonIdempotent        map[string]bool         // 非幂等的路由，只在链接失败时重试
+	HedgePolicies        map[string]*HedgePolicy // 开启了对冲请求的只读路由
+	PoolSize             int                     // 每个节点最多保持的session数
+	PoolWarmup           int                     // 每个节点预先建立的session数，这些session不会因为闲置被关闭
+	PoolIdleTimeout      time.Duration           // session闲置超过该时间后被关闭，为0时不关闭
+	BroadcastConcurrency int                     // 广播请求同时请求的节点数上限
+	GlobalPlugin         []drpc.Plugin
+	Registry             registry.Registry
+	Selector             selector.Selector
+	Strategy             selector.Strategy // 节点选择策略，为nil时使用选择器的默认策略
+	HashKeyMeta          string            // 一致性哈希使用的元数据key，请求中存在该元数据时按照它的值选择节点
+	Router               *selector.Router  // 路由规则，按照服务的版本和节点的元数据过滤节点
+	Metrics              metrics.Metrics   // 统计信息
 }
 
 type Option func(*Options)
@@ -42,18 +54,20 @@ type Option func(*Options)
 // NewOptions 初始化配置
 func NewOptions(options ...Option) Options {
 	opts := Options{
-		Context:           context.Background(),
-		Network:           "tcp",
-		LocalIP:           "0.0.0.0",
-		BodyCodec:         defaultBodyCodec,
-		SessionAge:        defaultSessionAge,
-		ContextAge:        defaultContextAge,
-		DialTimeout:       defaultDialTimeout,
-		SlowCometDuration: defaultSlowCometDuration,
-		RetryTimes:        defaultRetryTimes,
-		PrintDetail:       false,
-		HeartbeatTime:     time.Duration(0),
-		ProtoFunc:         drpc.DefaultProtoFunc(),
+		Context:              context.Background(),
+		Network:              "tcp",
+		LocalIP:              "0.0.0.0",
+		BodyCodec:            defaultBodyCodec,
+		SessionAge:           defaultSessionAge,
+		ContextAge:           defaultContextAge,
+		DialTimeout:          defaultDialTimeout,
+		SlowCometDuration:    defaultSlowCometDuration,
+		RetryTimes:           defaultRetryTimes,
+		PoolSize:             defaultPoolSize,
+		BroadcastConcurrency: defaultBroadcastConcurrency,
+		PrintDetail:          false,
+		HeartbeatTime:        time.Duration(0),
+		ProtoFunc:            drpc.DefaultProtoFunc(),
 	}
 	for _, o := range options {
 		o(&opts)
@@ -111,6 +125,21 @@ func OptSelector(s selector.Selector) Option {
 	}
 }
 
+// OptStrategy 设置节点选择策略
+func OptStrategy(s selector.Strategy) Option {
+	return func(o *Options) {
+		o.Strategy = s
+	}
+}
+
+// OptHashKeyMeta 设置一致性哈希使用的元数据key
+//  请求的元数据中存在该key时，使用 selector.ConsistentHash 按照它的值选择节点，相同的值总是请求同一个节点
+func OptHashKeyMeta(metaKey string) Option {
+	return func(o *Options) {
+		o.HashKeyMeta = metaKey
+	}
+}
+
 // OptGlobalPlugin 设置插件
 func OptGlobalPlugin(plugin ...drpc.Plugin) Option {
 	return func(o *Options) {
@@ -154,6 +183,81 @@ func OptRetryTimes(n int) Option {
 	}
 }
 
+// OptRetryPolicy 设置重试策略，未传入路由时设置默认的重试策略
+func OptRetryPolicy(policy *RetryPolicy, serviceMethod ...string) Option {
+	return func(o *Options) {
+		if len(serviceMethod) == 0 {
+			o.RetryPolicy = policy
+			return
+		}
+		if o.RetryPolicies == nil {
+			o.RetryPolicies = make(map[string]*RetryPolicy)
+		}
+		for _, sm := range serviceMethod {
+			o.RetryPolicies[sm] = policy
+		}
+	}
+}
+
+// OptNonIdempotent 设置非幂等的路由，这些路由只在链接失败，请求还没有发送到服务端时重试
+func OptNonIdempotent(serviceMethod ...string) Option {
+	return func(o *Options) {
+		if o.NonIdempotent == nil {
+			o.NonIdempotent = make(map[string]bool)
+		}
+		for _, sm := range serviceMethod {
+			o.NonIdempotent[sm] = true
+		}
+	}
+}
+
+// OptHedgePolicy 为只读的路由开启对冲请求
+func OptHedgePolicy(policy *HedgePolicy, serviceMethod ...string) Option {
+	return func(o *Options) {
+		if o.HedgePolicies == nil {
+			o.HedgePolicies = make(map[string]*HedgePolicy)
+		}
+		for _, sm := range serviceMethod {
+			o.HedgePolicies[sm] = policy
+		}
+	}
+}
+
+// OptRouteRules 设置路由规则，可以通过 RpcClient.Router 在运行时更新
+func OptRouteRules(rules ...selector.RouteRule) Option {
+	return func(o *Options) {
+		o.Router = selector.NewRouter(rules...)
+	}
+}
+
+// OptBroadcastConcurrency 设置广播请求同时请求的节点数上限
+func OptBroadcastConcurrency(n int) Option {
+	return func(o *Options) {
+		o.BroadcastConcurrency = n
+	}
+}
+
+// OptPoolSize 设置每个节点最多保持的session数
+func OptPoolSize(n int) Option {
+	return func(o *Options) {
+		o.PoolSize = n
+	}
+}
+
+// OptPoolWarmup 设置每个节点预先建立的session数
+func OptPoolWarmup(n int) Option {
+	return func(o *Options) {
+		o.PoolWarmup = n
+	}
+}
+
+// OptPoolIdleTimeout 设置session的最大闲置时间
+func OptPoolIdleTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.PoolIdleTimeout = d
+	}
+}
+
 // OptSessionAge 设置会话生命周期
 func OptSessionAge(n time.Duration) Option {
 	return func(o *Options) {
@@ -240,3 +344,40 @@ func OptMetrics(m metrics.Metrics) Option {
 		o.Metrics = m
 	}
 }
+
+// 只在客户端使用的元数据，用来保存单次请求的设置，发送消息前删除
+const (
+	metaStrategy    = "_client_strategy"
+	metaHashKey     = "_client_hash_key"
+	metaRetryPolicy = "_client_retry_policy"
+)
+
+var localMeta = map[string]bool{
+	metaStrategy:    true,
+	metaHashKey:     true,
+	metaRetryPolicy: true,
+}
+
+// 发送消息时使用的设置，删除只在客户端使用的元数据
+func sendSetting(setting []message.MsgSetting) []message.MsgSetting {
+	s := make([]message.MsgSetting, 0, len(setting)+len(localMeta))
+	s = append(s, setting...)
+	for key := range localMeta {
+		s = append(s, message.WithDelMeta(key))
+	}
+	return s
+}
+
+// WithStrategy 单次请求使用指定的节点选择策略，优先级高于 OptStrategy
+func WithStrategy(s selector.Strategy) message.MsgSetting {
+	return func(m message.Message) {
+		m.Meta().Set(metaStrategy, s)
+	}
+}
+
+// WithHashKey 单次请求使用一致性哈希选择节点，相同的 key 总是请求同一个节点
+func WithHashKey(key string) message.MsgSetting {
+	return func(m message.Message) {
+		m.Meta().Set(metaHashKey, key)
+	}
+}
@@ -0,0 +1,378 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/gogf/gf/v2/container/gmap"
+	"github.com/osgochina/dmicro/drpc"
+	"github.com/osgochina/dmicro/logger"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PoolStat 节点连接池的统计
+type PoolStat struct {
+	Service string `json:"service"`
+	Node    string `json:"node"`
+	// Sessions 当前的session数
+	Sessions int `json:"sessions"`
+	// Inflight 正在进行的请求数
+	Inflight int64 `json:"inflight"`
+	// Dials 建立session的次数
+	Dials int64 `json:"dials"`
+	// IdleClosed 因为闲置被关闭的session数
+	IdleClosed int64 `json:"idle_closed"`
+}
+
+// 当前进程中所有客户端的连接池
+var sessionPools = gmap.NewAnyAnyMap(true)
+
+// PoolStats 获取当前进程中所有客户端连接池的统计，按照服务名和节点地址排序
+func PoolStats() []*PoolStat {
+	var stats []*PoolStat
+	sessionPools.Iterator(func(k interface{}, _ interface{}) bool {
+		stats = append(stats, k.(*sessionPool).stats()...)
+		return true
+	})
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Service != stats[j].Service {
+			return stats[i].Service < stats[j].Service
+		}
+		return stats[i].Node < stats[j].Node
+	})
+	return stats
+}
+
+// 连接池中的session，记录正在进行的请求数和最后使用的时间
+type pooledSession struct {
+	drpc.Session
+	inflight int64
+	lastUsed int64
+}
+
+// 开始使用session
+func (that *pooledSession) acquire() {
+	atomic.AddInt64(&that.inflight, 1)
+	atomic.StoreInt64(&that.lastUsed, time.Now().UnixNano())
+}
+
+// 结束使用session
+func (that *pooledSession) release() {
+	atomic.AddInt64(&that.inflight, -1)
+	atomic.StoreInt64(&that.lastUsed, time.Now().UnixNano())
+}
+
+// 是否闲置超过了指定的时间
+func (that *pooledSession) idle(now time.Time, timeout time.Duration) bool {
+	return atomic.LoadInt64(&that.inflight) == 0 && now.UnixNano()-atomic.LoadInt64(&that.lastUsed) > int64(timeout)
+}
+
+// 单个节点的session列表
+type nodePool struct {
+	addr       string
+	mu         sync.Mutex
+	sessions   []*pooledSession
+	dialing    int
+	dialed     chan struct{} // 正在建立的session完成时关闭
+	seq        int
+	dials      int64
+	idleClosed int64
+	removed    bool
+}
+
+// 删除已经断开的session
+func (that *nodePool) removeUnhealthy() {
+	sessions := that.sessions[:0]
+	for _, s := range that.sessions {
+		if s.Health() {
+			sessions = append(sessions, s)
+		}
+	}
+	that.sessions = sessions
+}
+
+// 正在进行的请求数最少的session
+func (that *nodePool) leastBusy() *pooledSession {
+	var (
+		best    *pooledSession
+		minLoad int64
+	)
+	for _, s := range that.sessions {
+		load := atomic.LoadInt64(&s.inflight)
+		if best == nil || load < minLoad {
+			best, minLoad = s, load
+		}
+	}
+	return best
+}
+
+// 等待正在建立的session完成，调用前需要持有锁
+func (that *nodePool) waitDial() <-chan struct{} {
+	if that.dialed == nil {
+		that.dialed = make(chan struct{})
+	}
+	return that.dialed
+}
+
+// 通知等待的请求session已经建立完成，调用前需要持有锁
+func (that *nodePool) notifyDial() {
+	if that.dialed != nil {
+		close(that.dialed)
+		that.dialed = nil
+	}
+}
+
+// 新session的id，第一个session使用节点地址作为id，其他的session在地址后面加上序号
+func (that *nodePool) nextID() string {
+	for _, s := range that.sessions {
+		if s.ID() == that.addr {
+			that.seq++
+			return fmt.Sprintf("%s#%d", that.addr, that.seq)
+		}
+	}
+	return that.addr
+}
+
+// 每个节点保持多个session的连接池
+//  请求时选择正在进行的请求数最少的session，所有session都在忙并且数量没有达到上限时建立新的session
+//  后台定时关闭闲置的session，并且为每个节点预先建立 warmup 个session
+type sessionPool struct {
+	service     string
+	size        int
+	warmup      int
+	idleTimeout time.Duration
+	dial        func(addr string) (drpc.Session, *drpc.Status)
+	nodes       *gmap.StrAnyMap
+}
+
+func newSessionPool(service string, size, warmup int, idleTimeout time.Duration, dial func(addr string) (drpc.Session, *drpc.Status)) *sessionPool {
+	if size <= 0 {
+		size = 1
+	}
+	if warmup > size {
+		warmup = size
+	}
+	p := &sessionPool{
+		service:     service,
+		size:        size,
+		warmup:      warmup,
+		idleTimeout: idleTimeout,
+		dial:        dial,
+		nodes:       gmap.NewStrAnyMap(true),
+	}
+	sessionPools.Set(p, nil)
+	return p
+}
+
+// 获取节点的session列表，第一次使用节点时在后台预热
+func (that *sessionPool) node(addr string) *nodePool {
+	var created bool
+	p := that.nodes.GetOrSetFuncLock(addr, func() interface{} {
+		created = true
+		return &nodePool{addr: addr}
+	}).(*nodePool)
+	if created && that.warmup > 1 {
+		go that.fill(p, that.warmup)
+	}
+	return p
+}
+
+// 选择节点上最空闲的session，并且开始使用
+//  没有可用的session并且数量已经达到上限时，等待正在建立的session，不再建立新的session
+//  ctx 在建立session的过程中结束时直接返回，建立好的session仍然放入连接池
+func (that *sessionPool) get(ctx context.Context, addr string) (*pooledSession, *drpc.Status) {
+	for {
+		p := that.node(addr)
+		p.mu.Lock()
+		//节点的session列表已经因为闲置被删除，重新获取
+		for p.removed {
+			p.mu.Unlock()
+			p = that.node(addr)
+			p.mu.Lock()
+		}
+		p.removeUnhealthy()
+		best := p.leastBusy()
+		full := len(p.sessions)+p.dialing >= that.size
+		if best != nil && (atomic.LoadInt64(&best.inflight) == 0 || full) {
+			best.acquire()
+			p.mu.Unlock()
+			return best, nil
+		}
+		if best == nil && full {
+			dialed := p.waitDial()
+			p.mu.Unlock()
+			select {
+			case <-dialed:
+				continue
+			case <-ctx.Done():
+				return nil, drpc.StatusFromError(ctx.Err())
+			}
+		}
+		p.dialing++
+		p.mu.Unlock()
+		s, stat := that.addContext(ctx, p)
+		if stat != nil {
+			//已经存在可用的session时，建立新session失败不影响请求
+			if best != nil && ctx.Err() == nil {
+				best.acquire()
+				return best, nil
+			}
+			return nil, stat
+		}
+		s.acquire()
+		return s, nil
+	}
+}
+
+// 建立新的session并放入节点的session列表，调用前需要增加 dialing 计数
+func (that *sessionPool) add(p *nodePool) (*pooledSession, *drpc.Status) {
+	sess, stat := that.dial(p.addr)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dialing--
+	p.notifyDial()
+	if !stat.OK() {
+		return nil, stat
+	}
+	p.dials++
+	sess.SetID(p.nextID())
+	s := &pooledSession{Session: sess, lastUsed: time.Now().UnixNano()}
+	p.sessions = append(p.sessions, s)
+	return s, nil
+}
+
+// 与 add 相同，ctx 结束时不再等待session建立完成
+func (that *sessionPool) addContext(ctx context.Context, p *nodePool) (*pooledSession, *drpc.Status) {
+	if ctx.Done() == nil {
+		return that.add(p)
+	}
+	type added struct {
+		s    *pooledSession
+		stat *drpc.Status
+	}
+	ch := make(chan added, 1)
+	go func() {
+		s, stat := that.add(p)
+		ch <- added{s, stat}
+	}()
+	select {
+	case r := <-ch:
+		return r.s, r.stat
+	case <-ctx.Done():
+		return nil, drpc.StatusFromError(ctx.Err())
+	}
+}
+
+// 为节点建立session，直到数量达到 n
+func (that *sessionPool) fill(p *nodePool, n int) {
+	for {
+		p.mu.Lock()
+		p.removeUnhealthy()
+		if p.removed || len(p.sessions)+p.dialing >= n {
+			p.mu.Unlock()
+			return
+		}
+		p.dialing++
+		p.mu.Unlock()
+		if _, stat := that.add(p); stat != nil {
+			logger.Warningf(context.TODO(), "预热节点 %s 的session失败: %s", p.addr, stat.String())
+			return
+		}
+	}
+}
+
+// 关闭闲置的session，节点上至少保留 keep 个session
+func (that *sessionPool) closeIdle(p *nodePool, keep int) {
+	var (
+		now    = time.Now()
+		closed []*pooledSession
+	)
+	p.mu.Lock()
+	p.removeUnhealthy()
+	remain := len(p.sessions)
+	sessions := p.sessions[:0]
+	for _, s := range p.sessions {
+		if remain > keep && s.idle(now, that.idleTimeout) {
+			closed = append(closed, s)
+			remain--
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	p.sessions = sessions
+	p.idleClosed += int64(len(closed))
+	if len(p.sessions) == 0 && p.dialing == 0 {
+		p.removed = true
+		that.nodes.Remove(p.addr)
+	}
+	p.mu.Unlock()
+	for _, s := range closed {
+		_ = s.Close()
+	}
+}
+
+// 定时维护连接池，关闭闲置的session，为注册中心中存在的节点补足预热的session
+//  nodes 返回注册中心中当前的节点地址，返回nil时表示无法获取节点，不做预热
+func (that *sessionPool) maintain(closeCh <-chan bool, nodes func() []string) {
+	interval := that.idleTimeout / 2
+	if interval <= 0 || interval > 5*time.Second {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		addrs := nodes()
+		alive := make(map[string]bool, len(addrs))
+		for _, addr := range addrs {
+			alive[addr] = true
+			if that.warmup > 0 {
+				that.fill(that.node(addr), that.warmup)
+			}
+		}
+		if that.idleTimeout > 0 {
+			for _, v := range that.nodes.Values() {
+				p := v.(*nodePool)
+				keep := 0
+				if addrs == nil || alive[p.addr] {
+					keep = that.warmup
+				}
+				that.closeIdle(p, keep)
+			}
+		}
+		select {
+		case <-closeCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// 连接池中每个节点的统计
+func (that *sessionPool) stats() []*PoolStat {
+	var stats []*PoolStat
+	that.nodes.Iterator(func(addr string, v interface{}) bool {
+		p := v.(*nodePool)
+		p.mu.Lock()
+		stat := &PoolStat{
+			Service:    that.service,
+			Node:       addr,
+			Sessions:   len(p.sessions),
+			Dials:      p.dials,
+			IdleClosed: p.idleClosed,
+		}
+		for _, s := range p.sessions {
+			stat.Inflight += atomic.LoadInt64(&s.inflight)
+		}
+		p.mu.Unlock()
+		stats = append(stats, stat)
+		return true
+	})
+	return stats
+}
+
+// 关闭连接池，session由 Endpoint 负责关闭
+func (that *sessionPool) close() {
+	sessionPools.Remove(that)
+}
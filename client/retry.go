@@ -0,0 +1,196 @@
+package client
+
+import (
+	"context"
+	"github.com/osgochina/dmicro/drpc"
+	"github.com/osgochina/dmicro/drpc/message"
+	"github.com/osgochina/dmicro/logger"
+	"github.com/osgochina/dmicro/registry"
+	"github.com/osgochina/dmicro/selector"
+	"github.com/osgochina/dmicro/utils/backoff"
+	"time"
+)
+
+// RetryPolicy 重试策略
+type RetryPolicy struct {
+	// MaxAttempts 最多请求的次数，包含第一次请求，小于等于1表示不重试
+	MaxAttempts int
+	// RetryableCodes 可以重试的状态码，为空时只重试链接错误
+	RetryableCodes []int32
+	// InitialBackoff 第一次重试前等待的时间，之后每次翻倍，为0时不等待
+	InitialBackoff time.Duration
+	// MaxBackoff 等待时间的上限，为0时不限制
+	MaxBackoff time.Duration
+	// Jitter 是否在 [0,等待时间) 之间随机选择实际的等待时间，避免多个客户端同时重试
+	Jitter bool
+	// Budget 重试的总时间预算，从第一次请求开始计算，超过后不再重试，为0时不限制
+	Budget time.Duration
+}
+
+// 链接错误，请求没有被服务端处理，可以安全的重试
+var connErrorCodes = []int32{drpc.CodeDialFailed, drpc.CodeConnClosed}
+
+// 判断状态码是否可以重试
+//  非幂等的路由只在链接失败时重试，此时请求还没有发送到服务端
+func (that *RetryPolicy) retryable(stat *drpc.Status, idempotent bool) bool {
+	if stat.OK() {
+		return false
+	}
+	if !idempotent {
+		return stat.Code() == drpc.CodeDialFailed
+	}
+	codes := that.RetryableCodes
+	if len(codes) == 0 {
+		codes = connErrorCodes
+	}
+	for _, code := range codes {
+		if stat.Code() == code {
+			return true
+		}
+	}
+	return false
+}
+
+// 第 attempts 次重试前需要等待的时间
+func (that *RetryPolicy) backoff(attempts int) time.Duration {
+	d := backoff.Exponential(attempts, that.InitialBackoff, that.MaxBackoff)
+	if that.Jitter {
+		d = backoff.FullJitter(d)
+	}
+	return d
+}
+
+// WithRetryPolicy 单次请求使用指定的重试策略，优先级高于 OptRetryPolicy，传入nil表示不重试
+func WithRetryPolicy(policy *RetryPolicy) message.MsgSetting {
+	return func(m message.Message) {
+		m.Meta().Set(metaRetryPolicy, policy)
+	}
+}
+
+// 获取请求使用的重试策略
+//  优先级: WithRetryPolicy 设置的策略 > 路由的策略 > 默认策略 > 按照 RetryTimes 重试链接错误
+func (that *RpcClient) retryPolicy(serviceMethod string, msg message.Message) *RetryPolicy {
+	if v, ok := msg.Meta().Search(metaRetryPolicy); ok {
+		if policy, _ := v.(*RetryPolicy); policy != nil {
+			return policy
+		}
+		return &RetryPolicy{MaxAttempts: 1}
+	}
+	if policy, ok := that.opts.RetryPolicies[serviceMethod]; ok {
+		return policy
+	}
+	if that.opts.RetryPolicy != nil {
+		return that.opts.RetryPolicy
+	}
+	return &RetryPolicy{MaxAttempts: that.opts.RetryTimes}
+}
+
+// 按照重试策略发起请求，每次重试都会排除已经请求过的节点
+func (that *RpcClient) callWithRetry(serviceMethod string, args interface{}, result interface{}, setting ...message.MsgSetting) drpc.CallCmd {
+	var (
+		msg        = message.NewMessage(setting...)
+		policy     = that.retryPolicy(serviceMethod, msg)
+		idempotent = !that.opts.NonIdempotent[serviceMethod]
+		start      = time.Now()
+		tried      = make(map[string]bool)
+		selectOpts = append(that.selectOptions(setting), excludeNodes(tried))
+		callCmd    drpc.CallCmd
+	)
+	hedge := that.hedgePolicy(serviceMethod, result)
+	for i := 0; ; i++ {
+		if hedge != nil {
+			callCmd = that.callHedged(hedge, serviceMethod, args, result, selectOpts, tried, msg.Context(), setting)
+		} else {
+			callCmd = that.callNode(msg.Context(), serviceMethod, args, result, selectOpts, tried, setting)
+		}
+		if err := msg.Context().Err(); err != nil && !callCmd.StatusOK() {
+			//上下文结束时不再重试
+			return drpc.NewFakeCallCmd(serviceMethod, args, result, drpc.StatusFromError(err))
+		}
+		if i+1 >= policy.MaxAttempts || !policy.retryable(callCmd.Status(), idempotent) {
+			return callCmd
+		}
+		wait := policy.backoff(i + 1)
+		if policy.Budget > 0 && time.Since(start)+wait > policy.Budget {
+			return callCmd
+		}
+		logger.Debugf(context.TODO(), "请求 %s 第[%d]次出错，%v 后重试，错误原因: %s", serviceMethod, i+1, wait, callCmd.Status().String())
+		if !that.sleep(msg.Context(), wait) {
+			if err := msg.Context().Err(); err != nil {
+				return drpc.NewFakeCallCmd(serviceMethod, args, result, drpc.StatusFromError(err))
+			}
+			return callCmd
+		}
+	}
+}
+
+// 选择节点并发起一次请求，请求过的节点写入 tried
+func (that *RpcClient) callNode(ctx context.Context, serviceMethod string, args interface{}, result interface{}, selectOpts []selector.SelectOption, tried map[string]bool, setting []message.MsgSetting) drpc.CallCmd {
+	sess, node, stat := that.selectSession(ctx, serviceMethod, selectOpts...)
+	if node != nil {
+		tried[node.Address] = true
+	}
+	if stat != nil {
+		return drpc.NewFakeCallCmd(serviceMethod, args, result, stat)
+	}
+	var callCmdChan = make(chan drpc.CallCmd, 1)
+	done := that.begin(node)
+	sess.AsyncCall(serviceMethod, args, result, callCmdChan, sendSetting(setting)...)
+	callCmd := <-callCmdChan
+	done()
+	sess.release()
+	that.mark(node, callCmd.Status())
+	return callCmd
+}
+
+// 等待重试，请求被取消或者客户端关闭时返回false
+func (that *RpcClient) sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		select {
+		case <-that.closeCh:
+			return false
+		case <-ctx.Done():
+			return false
+		default:
+			return true
+		}
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-that.closeCh:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// 排除已经请求过的节点，全部节点都请求过时不再排除
+func excludeNodes(tried map[string]bool) selector.SelectOption {
+	return selector.OptWithFilter(func(services []*registry.Service) []*registry.Service {
+		if len(tried) == 0 {
+			return services
+		}
+		filtered := make([]*registry.Service, 0, len(services))
+		for _, s := range services {
+			nodes := make([]*registry.Node, 0, len(s.Nodes))
+			for _, node := range s.Nodes {
+				if !tried[node.Address] {
+					nodes = append(nodes, node)
+				}
+			}
+			if len(nodes) == 0 {
+				continue
+			}
+			cp := *s
+			cp.Nodes = nodes
+			filtered = append(filtered, &cp)
+		}
+		if len(filtered) == 0 {
+			return services
+		}
+		return filtered
+	})
+}
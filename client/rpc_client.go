@@ -2,7 +2,9 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/gogf/gf/v2/util/gconv"
 	"github.com/osgochina/dmicro/drpc"
 	"github.com/osgochina/dmicro/drpc/message"
 	"github.com/osgochina/dmicro/drpc/plugin/heartbeat"
@@ -27,6 +29,10 @@ var (
 	defaultSlowCometDuration = time.Duration(0)
 	// DefaultRetryTimes 默认重试次数
 	defaultRetryTimes = 2
+	// DefaultPoolSize 默认每个节点保持的session数
+	defaultPoolSize = 1
+	// DefaultBroadcastConcurrency 默认广播请求同时请求的节点数
+	defaultBroadcastConcurrency = 16
 
 	// RerClientClosed 客户端已关闭错误信息
 	rerClientClosed = drpc.NewStatus(100, "client is closed", "")
@@ -36,10 +42,13 @@ var (
 type RpcClient struct {
 	endpoint drpc.Endpoint
 	opts     Options
+	pool     *sessionPool
 	closeCh  chan bool
 	closeMu  sync.Mutex
 }
 
+var _ drpc.Caller = new(RpcClient)
+
 // NewRpcClient 创建rpc客户端
 func NewRpcClient(serviceName string, opt ...Option) *RpcClient {
 	opts := NewOptions(append([]Option{OptServiceName(serviceName)}, opt...)...)
@@ -76,6 +85,22 @@ func NewRpcClient(serviceName string, opt ...Option) *RpcClient {
 		endpoint: endpoint,
 		closeCh:  make(chan bool),
 	}
+	// 连接池预热、维护以及广播需要提前获取注册中心，没有设置选择器时使用默认的注册中心
+	if rc.opts.Selector == nil {
+		rc.defaultSelector(rc.opts.ServiceName)
+	} else if rc.opts.Registry == nil {
+		// 只设置了选择器时，使用选择器的注册中心
+		rc.opts.Registry = rc.opts.Selector.Options().Registry
+		if rc.opts.Registry == nil {
+			rc.opts.Registry = registry.DefaultRegistry
+		}
+	}
+	rc.pool = newSessionPool(opts.ServiceName, opts.PoolSize, opts.PoolWarmup, opts.PoolIdleTimeout, func(addr string) (drpc.Session, *drpc.Status) {
+		return endpoint.Dial(addr, opts.ProtoFunc)
+	})
+	if rc.pool.warmup > 0 || rc.pool.idleTimeout > 0 {
+		go rc.pool.maintain(rc.closeCh, rc.registryNodes)
+	}
 	return rc
 }
 
@@ -84,35 +109,23 @@ func (that *RpcClient) Options() Options {
 	return that.opts
 }
 
-// Call 请求服务端
+// Call 请求服务端，出错时按照重试策略重试
 func (that *RpcClient) Call(serviceMethod string, args interface{}, result interface{}, setting ...message.MsgSetting) drpc.CallCmd {
 	select {
 	case <-that.closeCh:
 		return drpc.NewFakeCallCmd(serviceMethod, args, result, rerClientClosed)
 	default:
 	}
-	var (
-		callCmd  drpc.CallCmd
-		connFail bool
-	)
-	for i := 0; i < that.opts.RetryTimes; i++ {
-		sess, stat := that.selectSession(serviceMethod)
-		if stat != nil {
-			return drpc.NewFakeCallCmd(serviceMethod, args, result, stat)
-		}
-		var callCmdChan = make(chan drpc.CallCmd, 1)
-		sess.AsyncCall(serviceMethod, args, result, callCmdChan, setting...)
-		callCmd = <-callCmdChan
-		// 判断错误类型是否是链接出错，如果不是链接出错，则直接返回错误信息，如果是链接出错，则删除该链接，重新执行
-		connFail = drpc.IsConnError(callCmd.Status())
-		if !connFail {
-			return callCmd
-		}
-		if i > 0 {
-			logger.Debugf(context.TODO(), "链接第[%d]出错，错误原因: %s", i, callCmd.Status().String())
-		}
+	return that.callWithRetry(serviceMethod, args, result, setting...)
+}
+
+// CallContext 与 Call 相同，ctx 作为消息的上下文，在 setting 之前设置
+//  ctx 结束时不再选择节点、建立session和重试，返回超时或者取消的状态
+func (that *RpcClient) CallContext(ctx context.Context, serviceMethod string, args interface{}, result interface{}, setting ...message.MsgSetting) drpc.CallCmd {
+	if err := ctx.Err(); err != nil {
+		return drpc.NewFakeCallCmd(serviceMethod, args, result, drpc.StatusFromError(err))
 	}
-	return callCmd
+	return that.Call(serviceMethod, args, result, append([]message.MsgSetting{message.WithContext(ctx)}, setting...)...)
 }
 
 // Push 发送push消息
@@ -125,14 +138,18 @@ func (that *RpcClient) Push(serviceMethod string, arg interface{}, setting ...me
 	var (
 		stat     *drpc.Status
 		connFail bool
-		sess     drpc.Session
+		sess     *pooledSession
+		node     *registry.Node
+		ctx      = message.NewMessage(setting...).Context()
 	)
 	for i := 0; i < that.opts.RetryTimes; i++ {
-		sess, stat = that.selectSession(serviceMethod)
+		sess, node, stat = that.selectSession(ctx, serviceMethod, that.selectOptions(setting)...)
 		if stat != nil {
 			return stat
 		}
-		stat = sess.Push(serviceMethod, arg, setting...)
+		stat = sess.Push(serviceMethod, arg, sendSetting(setting)...)
+		sess.release()
+		that.mark(node, stat)
 		connFail = !drpc.IsConnError(stat)
 		if connFail {
 			return stat
@@ -144,7 +161,7 @@ func (that *RpcClient) Push(serviceMethod string, arg interface{}, setting ...me
 	return stat
 }
 
-// AsyncCall 异步请求
+// AsyncCall 异步请求，出错时按照重试策略重试
 func (that *RpcClient) AsyncCall(serviceMethod string, arg interface{}, result interface{}, callCmdChan chan<- drpc.CallCmd, setting ...message.MsgSetting) drpc.CallCmd {
 	if callCmdChan == nil {
 		callCmdChan = make(chan drpc.CallCmd, 10) // buffered.
@@ -160,14 +177,14 @@ func (that *RpcClient) AsyncCall(serviceMethod string, arg interface{}, result i
 		return callCmd
 	default:
 	}
-	sess, stat := that.selectSession(serviceMethod)
-	if stat != nil {
-		callCmd := drpc.NewFakeCallCmd(serviceMethod, arg, result, stat)
-		callCmdChan <- callCmd
-		return callCmd
-	}
-	callCmd := sess.AsyncCall(serviceMethod, arg, result, callCmdChan, setting...)
-	return callCmd
+	output := message.NewMessage(append([]message.MsgSetting{
+		message.WithMType(drpc.TypeCall),
+		message.WithServiceMethod(serviceMethod),
+		message.WithBody(arg),
+	}, setting...)...)
+	return drpc.NewAsyncCallCmd(nil, output, callCmdChan, func() drpc.CallCmd {
+		return that.callWithRetry(serviceMethod, arg, result, setting...)
+	})
 }
 
 // SubRoute 设置服务的路由组
@@ -185,6 +202,11 @@ func (that *RpcClient) RoutePushFunc(pushHandleFunc interface{}, plugin ...drpc.
 	return that.endpoint.RoutePushFunc(pushHandleFunc, plugin...)
 }
 
+// Router 返回路由规则，没有设置路由规则时返回nil
+func (that *RpcClient) Router() *selector.Router {
+	return that.opts.Router
+}
+
 // Endpoint 返回Endpoint对象
 func (that *RpcClient) Endpoint() drpc.Endpoint {
 	return that.endpoint
@@ -199,6 +221,7 @@ func (that *RpcClient) Close() {
 		return
 	default:
 		close(that.closeCh)
+		that.pool.close()
 		_ = that.endpoint.Close()
 		_ = that.opts.Selector.Close()
 		if that.opts.Metrics != nil {
@@ -207,42 +230,122 @@ func (that *RpcClient) Close() {
 	}
 }
 
-// 选择session
-func (that *RpcClient) selectSession(serviceMethod string) (drpc.Session, *drpc.Status) {
-
-	next, err := that.next(that.Options().ServiceName)
+// 选择session，同时返回session对应的节点
+//  ctx 结束时不再选择节点和建立session，返回超时或者取消的状态
+func (that *RpcClient) selectSession(ctx context.Context, serviceMethod string, opts ...selector.SelectOption) (*pooledSession, *registry.Node, *drpc.Status) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, drpc.StatusFromError(err)
+	}
+	next, err := that.next(that.Options().ServiceName, opts...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	node, e := next()
 	if e != nil {
 		if e == selector.ErrNotFound {
-			return nil, drpc.NewStatus(drpc.CodeInternalServerError, fmt.Sprintf("dmicro.client service %s: %s", that.Options().ServiceName, e.Error()))
+			return nil, nil, drpc.NewStatus(drpc.CodeInternalServerError, fmt.Sprintf("dmicro.client service %s: %s", that.Options().ServiceName, e.Error()))
+		}
+		return nil, nil, drpc.NewStatus(drpc.CodeInternalServerError, fmt.Sprintf("dmicro.client error selecting %s node: %s", that.Options().ServiceName, e.Error()))
+	}
+	s, stat := that.pool.get(ctx, node.Address)
+	if stat != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, node, drpc.StatusFromError(err)
+		}
+		stat = drpc.NewStatus(drpc.CodeDialFailed, "", stat)
+		that.mark(node, stat)
+		return nil, node, stat
+	}
+	return s, node, nil
+}
+
+// 获取注册中心中服务的节点地址，用于连接池预热，无法获取时返回nil
+func (that *RpcClient) registryNodes() []string {
+	services, err := that.opts.Registry.GetService(that.opts.ServiceName)
+	if err != nil {
+		return nil
+	}
+	addrs := make([]string, 0)
+	for _, s := range services {
+		for _, node := range s.Nodes {
+			addrs = append(addrs, node.Address)
 		}
-		return nil, drpc.NewStatus(drpc.CodeInternalServerError, fmt.Sprintf("dmicro.client error selecting %s node: %s", that.Options().ServiceName, e.Error()))
 	}
-	addr := node.Address
-	s, found := that.endpoint.GetSession(addr)
-	if found && s.Health() {
-		if s.Health() {
-			return s, nil
+	return addrs
+}
+
+// 获取节点选择参数
+//  优先级: WithStrategy 指定的策略 > 哈希key对应的一致性哈希 > 客户端配置的策略
+//  设置了路由规则时，按照请求的元数据过滤节点
+func (that *RpcClient) selectOptions(setting []message.MsgSetting) []selector.SelectOption {
+	var (
+		opts     []selector.SelectOption
+		strategy = that.opts.Strategy
+		msg      = message.NewMessage(setting...)
+	)
+	if len(setting) > 0 {
+		if key, ok := that.hashKey(msg); ok {
+			strategy = selector.ConsistentHash(key)
+		}
+		if s, ok := msg.Meta().Get(metaStrategy).(selector.Strategy); ok && s != nil {
+			strategy = s
 		}
-		_ = s.Close()
 	}
-	s, stat := that.endpoint.Dial(addr, that.opts.ProtoFunc)
-	if !stat.OK() {
-		return s, drpc.NewStatus(drpc.CodeDialFailed, "", stat)
+	if strategy != nil {
+		opts = append(opts, selector.OptWithStrategy(strategy))
 	}
-	s.SetID(addr)
-	return s, nil
+	if that.opts.Router != nil {
+		meta := make(map[string]string)
+		msg.Meta().Iterator(func(k interface{}, v interface{}) bool {
+			if key := gconv.String(k); !localMeta[key] {
+				meta[key] = gconv.String(v)
+			}
+			return true
+		})
+		opts = append(opts, selector.OptWithFilter(that.opts.Router.Filter(meta)))
+	}
+	return opts
 }
 
-// 获取服务可用的节点列表
-func (that *RpcClient) next(serviceName string) (selector.Next, *drpc.Status) {
-	if that.opts.Selector == nil {
-		that.defaultSelector(serviceName)
+// 获取一致性哈希使用的key，WithHashKey 设置的key优先于元数据中的key
+func (that *RpcClient) hashKey(msg message.Message) (string, bool) {
+	if key, ok := msg.Meta().Get(metaHashKey).(string); ok {
+		return key, true
 	}
-	next, err := that.opts.Selector.Select(serviceName)
+	if len(that.opts.HashKeyMeta) > 0 {
+		if v := msg.Meta().Get(that.opts.HashKeyMeta); v != nil {
+			return gconv.String(v), true
+		}
+	}
+	return "", false
+}
+
+// 开始请求节点，选择器支持时记录节点的请求耗时和进行中的请求数，返回的函数在请求结束时调用
+func (that *RpcClient) begin(node *registry.Node) func() {
+	if tracker, ok := that.opts.Selector.(selector.Tracker); ok {
+		return tracker.Begin(that.opts.ServiceName, node)
+	}
+	return func() {}
+}
+
+// 向选择器报告节点的调用结果，只有链接、拨号以及超时的错误按照失败报告
+//  业务错误以及处理方法的panic说明节点可以正常处理请求，按照成功报告
+func (that *RpcClient) mark(node *registry.Node, stat *drpc.Status) {
+	if that.opts.Selector == nil || node == nil {
+		return
+	}
+	var err error
+	switch stat.Code() {
+	case drpc.CodeWrongConn, drpc.CodeConnClosed, drpc.CodeWriteFailed, drpc.CodeDialFailed,
+		drpc.CodeHandleTimeout:
+		err = errors.New(stat.String())
+	}
+	that.opts.Selector.Mark(that.opts.ServiceName, node, err)
+}
+
+// 获取服务可用的节点列表
+func (that *RpcClient) next(serviceName string, opts ...selector.SelectOption) (selector.Next, *drpc.Status) {
+	next, err := that.opts.Selector.Select(serviceName, opts...)
 	if err != nil {
 		if err == selector.ErrNotFound {
 			return nil, drpc.NewStatus(drpc.CodeInternalServerError, fmt.Sprintf("dmicro.client service %s: %s", serviceName, err.Error()))
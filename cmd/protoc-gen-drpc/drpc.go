@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/osgochina/dmicro"
+	"github.com/osgochina/dmicro/drpc"
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+const (
+	mapperHTTP = "http"
+	mapperRPC  = "rpc"
+)
+
+const (
+	drpcPackage    = protogen.GoImportPath("github.com/osgochina/dmicro/drpc")
+	messagePackage = protogen.GoImportPath("github.com/osgochina/dmicro/drpc/message")
+)
+
+// CallCtx 提供的方法名，服务的方法不能与它们重名，否则路由注册时会被忽略
+var callCtxMethods = func() map[string]bool {
+	t := reflect.TypeOf((*drpc.CallCtx)(nil)).Elem()
+	methods := make(map[string]bool, t.NumMethod())
+	for i := 0; i < t.NumMethod(); i++ {
+		methods[t.Method(i).Name] = true
+	}
+	return methods
+}()
+
+// 生成全部需要生成的文件
+func generate(gen *protogen.Plugin, mapper string) error {
+	var m drpc.ServiceMethodMapper
+	switch mapper {
+	case mapperHTTP:
+		m = drpc.HTTPServiceMethodMapper
+	case mapperRPC:
+		m = drpc.RPCServiceMethodMapper
+	default:
+		return fmt.Errorf("protoc-gen-drpc: unknown mapper %q, must be %q or %q", mapper, mapperHTTP, mapperRPC)
+	}
+	for _, f := range gen.Files {
+		if !f.Generate || len(f.Services) == 0 {
+			continue
+		}
+		if err := generateFile(gen, f, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 生成单个 proto 文件对应的 _drpc.pb.go 文件
+func generateFile(gen *protogen.Plugin, file *protogen.File, mapper drpc.ServiceMethodMapper) error {
+	g := gen.NewGeneratedFile(file.GeneratedFilenamePrefix+"_drpc.pb.go", file.GoImportPath)
+	g.P("// Code generated by protoc-gen-drpc. DO NOT EDIT.")
+	g.P("// versions:")
+	g.P("// - protoc-gen-drpc ", dmicro.Version)
+	g.P("// source: ", file.Desc.Path())
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+	for _, service := range file.Services {
+		if err := generateService(g, service, mapper); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func generateService(g *protogen.GeneratedFile, service *protogen.Service, mapper drpc.ServiceMethodMapper) error {
+	var (
+		serverName = service.GoName + "Server"
+		clientName = service.GoName + "Client"
+		ctrlName   = ctrlStructName(service.GoName)
+		methods    = make([]*protogen.Method, 0, len(service.Methods))
+	)
+	for _, method := range service.Methods {
+		if method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer() {
+			continue
+		}
+		if callCtxMethods[method.GoName] {
+			return fmt.Errorf("protoc-gen-drpc: %s.%s conflicts with the method of drpc.CallCtx", service.GoName, method.GoName)
+		}
+		methods = append(methods, method)
+	}
+	var (
+		callCtx    = g.QualifiedGoIdent(drpcPackage.Ident("CallCtx"))
+		status     = g.QualifiedGoIdent(drpcPackage.Ident("Status"))
+		msgSetting = g.QualifiedGoIdent(messagePackage.Ident("MsgSetting"))
+	)
+
+	// 路由常量
+	g.P("// ", service.GoName, " 服务的路由")
+	g.P("const (")
+	for _, method := range methods {
+		g.P(serviceMethodConst(service, method), " = ", fmt.Sprintf("%q", serviceMethod(mapper, ctrlName, method.GoName)))
+	}
+	g.P(")")
+	g.P()
+	for _, method := range service.Methods {
+		if method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer() {
+			g.P("// ", service.GoName, ".", method.GoName, " 是流式方法，暂不支持生成代码")
+			g.P()
+		}
+	}
+
+	// 服务端接口
+	g.P("// ", serverName, " ", service.GoName, " 服务端需要实现的接口")
+	if service.Comments.Leading != "" {
+		g.P("//")
+		g.P(strings.TrimSuffix(service.Comments.Leading.String(), "\n"))
+	}
+	g.P("type ", serverName, " interface {")
+	for _, method := range methods {
+		g.P(method.Comments.Leading, method.GoName, "(ctx ", callCtx, ", req *", g.QualifiedGoIdent(method.Input.GoIdent), ") (*", g.QualifiedGoIdent(method.Output.GoIdent), ", *", status, ")")
+	}
+	g.P("}")
+	g.P()
+
+	g.P("// Register", serverName, " 通过 RouteCall 注册 ", service.GoName, " 服务，返回注册的路由")
+	g.P("func Register", serverName, "(router ", drpcPackage.Ident("CallRouter"), ", srv ", serverName, ", plugin ...", drpcPackage.Ident("Plugin"), ") []string {")
+	// 每次请求使用的控制器都由工厂创建，并且持有 srv
+	g.P("return router.RouteCall(", drpcPackage.Ident("CtrlFactory"), "(func() interface{} {")
+	g.P("return &", ctrlName, "{srv: srv}")
+	g.P("}), plugin...)")
+	g.P("}")
+	g.P()
+
+	// 注册到路由的控制器，结构体的名字决定了路由的前缀
+	g.P("type ", ctrlName, " struct {")
+	g.P(callCtx)
+	g.P("srv ", serverName)
+	g.P("}")
+	g.P()
+	for _, method := range methods {
+		g.P("func (that *", ctrlName, ") ", method.GoName, "(req *", g.QualifiedGoIdent(method.Input.GoIdent), ") (*", g.QualifiedGoIdent(method.Output.GoIdent), ", *", status, ") {")
+		g.P("return that.srv.", method.GoName, "(that.CallCtx, req)")
+		g.P("}")
+		g.P()
+	}
+
+	// 客户端
+	g.P("// ", clientName, " ", service.GoName, " 服务的客户端")
+	g.P("type ", clientName, " interface {")
+	for _, method := range methods {
+		g.P(method.Comments.Leading, clientSignature(g, method, msgSetting))
+	}
+	g.P("}")
+	g.P()
+	g.P("// New", clientName, " 创建客户端，caller 可以是 drpc.Session 或者 client.RpcClient")
+	g.P("func New", clientName, "(caller ", drpcPackage.Ident("Caller"), ") ", clientName, " {")
+	g.P("return &", unexport(clientName), "{caller: caller}")
+	g.P("}")
+	g.P()
+	g.P("type ", unexport(clientName), " struct {")
+	g.P("caller ", drpcPackage.Ident("Caller"))
+	g.P("}")
+	g.P()
+	for _, method := range methods {
+		g.P("func (that *", unexport(clientName), ") ", clientSignature(g, method, msgSetting), " {")
+		g.P("return ", drpcPackage.Ident("Invoke"), "[", g.QualifiedGoIdent(method.Input.GoIdent), ", ", g.QualifiedGoIdent(method.Output.GoIdent), "](that.caller, ", serviceMethodConst(service, method), ", req, setting...)")
+		g.P("}")
+		g.P()
+	}
+
+	// 单元测试使用的 mock 客户端
+	mockName := clientName + "Mock"
+	g.P("// ", mockName, " 单元测试使用的 ", clientName, "，调用对应的 Func 字段，未设置时返回错误")
+	g.P("type ", mockName, " struct {")
+	for _, method := range methods {
+		g.P(method.GoName, "Func func(req *", g.QualifiedGoIdent(method.Input.GoIdent), ", setting ...", msgSetting, ") (*", g.QualifiedGoIdent(method.Output.GoIdent), ", *", status, ")")
+	}
+	g.P("}")
+	g.P()
+	g.P("var _ ", clientName, " = new(", mockName, ")")
+	g.P()
+	for _, method := range methods {
+		g.P("func (that *", mockName, ") ", clientSignature(g, method, msgSetting), " {")
+		g.P("if that.", method.GoName, "Func == nil {")
+		g.P("return nil, ", drpcPackage.Ident("NewStatus"), "(", drpcPackage.Ident("CodeInternalServerError"), ", ", fmt.Sprintf("%q", mockName+"."+method.GoName+"Func is nil"), ", nil)")
+		g.P("}")
+		g.P("return that.", method.GoName, "Func(req, setting...)")
+		g.P("}")
+		g.P()
+	}
+	return nil
+}
+
+// 客户端方法的签名
+func clientSignature(g *protogen.GeneratedFile, method *protogen.Method, msgSetting string) string {
+	return method.GoName + "(req *" + g.QualifiedGoIdent(method.Input.GoIdent) + ", setting ..." + msgSetting + ") (*" +
+		g.QualifiedGoIdent(method.Output.GoIdent) + ", *" + g.QualifiedGoIdent(drpcPackage.Ident("Status")) + ")"
+}
+
+// 路由常量的名字，例如 Greeter_SayHello_ServiceMethod
+func serviceMethodConst(service *protogen.Service, method *protogen.Method) string {
+	return service.GoName + "_" + method.GoName + "_ServiceMethod"
+}
+
+// 计算路由，与 RouteCall 注册控制器时的规则一致
+func serviceMethod(mapper drpc.ServiceMethodMapper, ctrlName, methodName string) string {
+	return mapper(mapper(mapper("", ""), ctrlName), methodName)
+}
+
+// 控制器的结构体名字，使用不导出的服务名，避免与消息的名字冲突
+func ctrlStructName(serviceName string) string {
+	name := unexport(serviceName)
+	if token.IsKeyword(name) {
+		name += "_"
+	}
+	return name
+}
+
+func unexport(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gogf/gf/v2/test/gtest"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// 构造 helloworld.proto 对应的文件描述
+//
+//	service Greeter {
+//	  rpc SayHello (HelloRequest) returns (HelloReply);
+//	  rpc Chat (stream HelloRequest) returns (stream HelloReply);
+//	}
+func helloworldFile() *descriptorpb.FileDescriptorProto {
+	stringField := func(name string) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(1),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+		}
+	}
+	return &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("helloworld.proto"),
+		Package: proto.String("helloworld"),
+		Syntax:  proto.String("proto3"),
+		Options: &descriptorpb.FileOptions{GoPackage: proto.String("example.com/helloworld;helloworld")},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("HelloRequest"), Field: []*descriptorpb.FieldDescriptorProto{stringField("name")}},
+			{Name: proto.String("HelloReply"), Field: []*descriptorpb.FieldDescriptorProto{stringField("message")}},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Greeter"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{
+					Name:       proto.String("SayHello"),
+					InputType:  proto.String(".helloworld.HelloRequest"),
+					OutputType: proto.String(".helloworld.HelloReply"),
+				},
+				{
+					Name:            proto.String("Chat"),
+					InputType:       proto.String(".helloworld.HelloRequest"),
+					OutputType:      proto.String(".helloworld.HelloReply"),
+					ClientStreaming: proto.Bool(true),
+					ServerStreaming: proto.Bool(true),
+				},
+			},
+		}},
+		SourceCodeInfo: &descriptorpb.SourceCodeInfo{Location: []*descriptorpb.SourceCodeInfo_Location{
+			{Path: []int32{6, 0, 2, 0}, Span: []int32{1, 0, 10}, LeadingComments: proto.String(" SayHello 打招呼\n")},
+		}},
+	}
+}
+
+func runGenerate(t *gtest.T, param string) string {
+	req := &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"helloworld.proto"},
+		Parameter:      proto.String(param),
+		ProtoFile:      []*descriptorpb.FileDescriptorProto{helloworldFile()},
+	}
+	gen, err := protogen.Options{}.New(req)
+	t.AssertNil(err)
+	mapper := mapperHTTP
+	if param != "" {
+		mapper = strings.TrimPrefix(param, "mapper=")
+	}
+	t.AssertNil(generate(gen, mapper))
+	resp := gen.Response()
+	t.Assert(resp.GetError(), "")
+	t.Assert(len(resp.File), 1)
+	t.Assert(resp.File[0].GetName(), "example.com/helloworld/helloworld_drpc.pb.go")
+	return resp.File[0].GetContent()
+}
+
+func TestGenerate(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		content := runGenerate(t, "")
+		for _, s := range []string{
+			`Greeter_SayHello_ServiceMethod = "/greeter/say_hello"`,
+			"SayHello(ctx drpc.CallCtx, req *HelloRequest) (*HelloReply, *drpc.Status)",
+			"func RegisterGreeterServer(router drpc.CallRouter, srv GreeterServer, plugin ...drpc.Plugin) []string",
+			"return router.RouteCall(drpc.CtrlFactory(func() interface{} {\n\t\treturn &greeter{srv: srv}\n\t}), plugin...)",
+			"func NewGreeterClient(caller drpc.Caller) GreeterClient",
+			"drpc.Invoke[HelloRequest, HelloReply](that.caller, Greeter_SayHello_ServiceMethod, req, setting...)",
+			"type GreeterClientMock struct",
+			"// SayHello 打招呼",
+			"Greeter.Chat 是流式方法",
+		} {
+			t.Assert(strings.Contains(content, s), true)
+		}
+		t.Assert(strings.Contains(content, "Chat(ctx"), false)
+
+		content = runGenerate(t, "mapper=rpc")
+		t.Assert(strings.Contains(content, `Greeter_SayHello_ServiceMethod = "greeter.SayHello"`), true)
+	})
+}
+
+func TestServiceMethod(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		t.Assert(ctrlStructName("Greeter"), "greeter")
+		t.Assert(ctrlStructName("Func"), "func_")
+	})
+}
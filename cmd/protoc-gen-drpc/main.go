@@ -0,0 +1,36 @@
+// protoc-gen-drpc 根据 proto 文件中的 service 定义生成 drpc 的服务端接口、客户端以及单元测试使用的 mock 客户端
+//
+// 安装:
+//  go install github.com/osgochina/dmicro/cmd/protoc-gen-drpc@latest
+// 使用:
+//  protoc --go_out=. --drpc_out=. helloworld.proto
+//  protoc --go_out=. --drpc_out=. --drpc_opt=mapper=rpc helloworld.proto
+// 参数:
+//  mapper 路由的映射规则，http 对应 drpc.HTTPServiceMethodMapper(默认)，rpc 对应 drpc.RPCServiceMethodMapper，
+//  需要与服务端 drpc.SetServiceMethodMapper 的设置保持一致
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/osgochina/dmicro"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func main() {
+	if len(os.Args) == 2 && os.Args[1] == "--version" {
+		fmt.Printf("protoc-gen-drpc %s\n", dmicro.Version)
+		return
+	}
+	var flags flag.FlagSet
+	mapper := flags.String("mapper", mapperHTTP, "service method mapper: http or rpc")
+	protogen.Options{
+		ParamFunc: flags.Set,
+	}.Run(func(gen *protogen.Plugin) error {
+		gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+		return generate(gen, *mapper)
+	})
+}
@@ -0,0 +1,116 @@
+package drpc
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/container/gmap"
+	"github.com/osgochina/dmicro/drpc/message"
+	"time"
+)
+
+// 在独立协程中发起请求时返回的命令
+//  Context、Output、TraceSession、TraceEndpoint 不会阻塞，请求完成前返回创建时传入的请求消息和会话，
+//  请求完成后返回实际请求的结果；获取结果和状态的方法会等待请求完成
+type asyncCallCmd struct {
+	sess        Session
+	output      message.Message
+	cmd         CallCmd
+	callCmdChan chan<- CallCmd
+	doneChan    chan struct{}
+}
+
+var _ CallCmd = new(asyncCallCmd)
+
+// NewAsyncCallCmd 在独立的协程中执行 call 发起请求，立即返回代表该请求的 CallCmd
+//  call 返回的命令完成后，返回的 CallCmd 随之完成，并写入 callCmdChan
+//  适用于重试、拦截器等需要在多次请求之后才能得到最终结果的异步请求，call 需要自行处理 panic
+//  sess 和 output 是请求完成前 TraceSession 和 Output 的返回值，sess 可以为nil
+func NewAsyncCallCmd(sess Session, output message.Message, callCmdChan chan<- CallCmd, call func() CallCmd) CallCmd {
+	cmd := &asyncCallCmd{sess: sess, output: output, callCmdChan: callCmdChan, doneChan: make(chan struct{})}
+	go func() {
+		c := call()
+		<-c.Done()
+		cmd.done(c)
+	}()
+	return cmd
+}
+
+func (that *asyncCallCmd) done(cmd CallCmd) {
+	that.cmd = cmd
+	close(that.doneChan)
+	that.callCmdChan <- that
+}
+
+// 返回已经完成的请求命令，请求未完成时返回nil
+func (that *asyncCallCmd) completed() CallCmd {
+	select {
+	case <-that.doneChan:
+		return that.cmd
+	default:
+		return nil
+	}
+}
+
+func (that *asyncCallCmd) TraceEndpoint() (Endpoint, bool) {
+	if cmd := that.completed(); cmd != nil {
+		return cmd.TraceEndpoint()
+	}
+	if that.sess == nil {
+		return nil, false
+	}
+	return that.sess.Endpoint(), true
+}
+
+func (that *asyncCallCmd) TraceSession() (Session, bool) {
+	if cmd := that.completed(); cmd != nil {
+		return cmd.TraceSession()
+	}
+	return that.sess, that.sess != nil
+}
+
+func (that *asyncCallCmd) Context() context.Context {
+	if cmd := that.completed(); cmd != nil {
+		return cmd.Context()
+	}
+	return that.output.Context()
+}
+
+func (that *asyncCallCmd) Output() message.Message {
+	if cmd := that.completed(); cmd != nil {
+		return cmd.Output()
+	}
+	return that.output
+}
+
+func (that *asyncCallCmd) StatusOK() bool {
+	<-that.doneChan
+	return that.cmd.StatusOK()
+}
+
+func (that *asyncCallCmd) Status() *Status {
+	<-that.doneChan
+	return that.cmd.Status()
+}
+
+func (that *asyncCallCmd) Done() <-chan struct{} {
+	return that.doneChan
+}
+
+func (that *asyncCallCmd) Reply() (interface{}, *Status) {
+	<-that.doneChan
+	return that.cmd.Reply()
+}
+
+func (that *asyncCallCmd) InputBodyCodec() byte {
+	<-that.doneChan
+	return that.cmd.InputBodyCodec()
+}
+
+func (that *asyncCallCmd) InputMeta() *gmap.Map {
+	<-that.doneChan
+	return that.cmd.InputMeta()
+}
+
+func (that *asyncCallCmd) CostTime() time.Duration {
+	<-that.doneChan
+	return that.cmd.CostTime()
+}
@@ -161,6 +161,37 @@ func (that *callCmd) cancel(reason string) {
 	that.sess.graceCallCmdWaitGroup.Done()
 }
 
+// 是否已经处理完毕
+func (that *callCmd) isDone() bool {
+	select {
+	case <-that.doneChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// 监听调用方的上下文，在收到回复之前被取消，则结束该请求并通知远端取消处理程序
+func (that *callCmd) watchContext(ctx context.Context) {
+	select {
+	case <-that.doneChan:
+		return
+	case <-ctx.Done():
+	}
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	if that.hasReply() || that.isDone() {
+		return
+	}
+	if err := ctx.Err(); err == context.DeadlineExceeded {
+		that.stat = statHandleTimeout.Copy(err)
+	} else {
+		that.stat = statCallCanceled.Copy(err)
+	}
+	that.done()
+	_ = that.sess.writeCancel(that)
+}
+
 //是否是回复消息
 func (that *callCmd) hasReply() bool {
 	return that.inputMeta != nil
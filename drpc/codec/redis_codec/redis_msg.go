@@ -70,12 +70,10 @@ func MakeBulkMsg(arg []byte) *BulkMsg {
 	}
 }
 
-var nullBulkReplyBytes = []byte("$-1")
-
 // Bytes 串化
 func (that *BulkMsg) Bytes() []byte {
-	if len(that.Arg) == 0 {
-		return nullBulkReplyBytes
+	if that.Arg == nil {
+		return nullBulkBytes
 	}
 	return []byte("$" + strconv.Itoa(len(that.Arg)) + CRLF + string(that.Arg) + CRLF)
 }
@@ -104,7 +102,8 @@ func (that *MultiBulkMsg) Bytes() []byte {
 			_, _ = bb.WriteString("$" + strconv.Itoa(len(arg)) + CRLF + string(arg) + CRLF)
 		}
 	}
-	return bb.Bytes()
+	// bb 会被放回缓存池，需要复制一份
+	return append([]byte(nil), bb.Bytes()...)
 }
 
 var emptyMultiBulkBytes = []byte("*0\r\n")
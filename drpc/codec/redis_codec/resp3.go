@@ -0,0 +1,217 @@
+package redis_codec
+
+import (
+	"math"
+	"strconv"
+)
+
+// 协议版本，客户端通过 HELLO 命令协商
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+// ProtoMsg 根据协议版本序列化的消息，客户端使用RESP2时转换成兼容的类型
+type ProtoMsg interface {
+	Msg
+	ProtoBytes(resp int) []byte
+}
+
+// Encode 按照协议版本序列化消息
+func Encode(msg Msg, resp int) []byte {
+	if pm, ok := msg.(ProtoMsg); ok {
+		return pm.ProtoBytes(resp)
+	}
+	return msg.Bytes()
+}
+
+// 序列化聚合类型的消息，元素按照同样的协议版本序列化，n 是聚合头中的数量
+func encodeAggregate(prefix byte, n int, elems []Msg, resp int) []byte {
+	b := make([]byte, 0, 16*len(elems)+16)
+	b = append(b, prefix)
+	b = strconv.AppendInt(b, int64(n), 10)
+	b = append(b, CRLF...)
+	for _, elem := range elems {
+		if elem == nil {
+			elem = MakeNullMsg()
+		}
+		b = append(b, Encode(elem, resp)...)
+	}
+	return b
+}
+
+// ArrayMsg 元素可以是任意类型的数组消息
+type ArrayMsg struct {
+	Elems []Msg
+}
+
+// MakeArrayMsg 创建数组消息
+func MakeArrayMsg(elems ...Msg) *ArrayMsg {
+	return &ArrayMsg{Elems: elems}
+}
+
+func (that *ArrayMsg) Bytes() []byte {
+	return that.ProtoBytes(RESP2)
+}
+
+func (that *ArrayMsg) ProtoBytes(resp int) []byte {
+	return encodeAggregate('*', len(that.Elems), that.Elems, resp)
+}
+
+// MapEntry 字典消息的键值对
+type MapEntry struct {
+	Key   Msg
+	Value Msg
+}
+
+// MapMsg 字典消息，RESP2时转换成键值交替的数组
+type MapMsg struct {
+	Entries []MapEntry
+}
+
+// MakeMapMsg 创建字典消息
+func MakeMapMsg(entries ...MapEntry) *MapMsg {
+	return &MapMsg{Entries: entries}
+}
+
+// Set 添加键值对，key作为字符串
+func (that *MapMsg) Set(key string, value Msg) *MapMsg {
+	that.Entries = append(that.Entries, MapEntry{Key: MakeBulkMsg([]byte(key)), Value: value})
+	return that
+}
+
+func (that *MapMsg) Bytes() []byte {
+	return that.ProtoBytes(RESP2)
+}
+
+func (that *MapMsg) ProtoBytes(resp int) []byte {
+	elems := make([]Msg, 0, 2*len(that.Entries))
+	for _, e := range that.Entries {
+		elems = append(elems, e.Key, e.Value)
+	}
+	if resp < RESP3 {
+		return encodeAggregate('*', len(elems), elems, resp)
+	}
+	// 聚合头中是键值对的数量
+	return encodeAggregate('%', len(that.Entries), elems, resp)
+}
+
+// SetMsg 集合消息，RESP2时转换成数组
+type SetMsg struct {
+	Elems []Msg
+}
+
+// MakeSetMsg 创建集合消息
+func MakeSetMsg(elems ...Msg) *SetMsg {
+	return &SetMsg{Elems: elems}
+}
+
+func (that *SetMsg) Bytes() []byte {
+	return that.ProtoBytes(RESP2)
+}
+
+func (that *SetMsg) ProtoBytes(resp int) []byte {
+	if resp < RESP3 {
+		return encodeAggregate('*', len(that.Elems), that.Elems, resp)
+	}
+	return encodeAggregate('~', len(that.Elems), that.Elems, resp)
+}
+
+// PushMsg 服务端主动推送的消息，RESP2时转换成数组，与RESP2下的订阅消息格式一致
+type PushMsg struct {
+	Elems []Msg
+}
+
+// MakePushMsg 创建推送消息，第一个元素是推送的类型，例如 message
+func MakePushMsg(elems ...Msg) *PushMsg {
+	return &PushMsg{Elems: elems}
+}
+
+func (that *PushMsg) Bytes() []byte {
+	return that.ProtoBytes(RESP2)
+}
+
+func (that *PushMsg) ProtoBytes(resp int) []byte {
+	if resp < RESP3 {
+		return encodeAggregate('*', len(that.Elems), that.Elems, resp)
+	}
+	return encodeAggregate('>', len(that.Elems), that.Elems, resp)
+}
+
+// DoubleMsg 浮点数消息，RESP2时转换成字符串
+type DoubleMsg struct {
+	Double float64
+}
+
+// MakeDoubleMsg 创建浮点数消息
+func MakeDoubleMsg(double float64) *DoubleMsg {
+	return &DoubleMsg{Double: double}
+}
+
+func (that *DoubleMsg) Bytes() []byte {
+	return that.ProtoBytes(RESP2)
+}
+
+func (that *DoubleMsg) ProtoBytes(resp int) []byte {
+	var s string
+	switch {
+	case math.IsInf(that.Double, 1):
+		s = "inf"
+	case math.IsInf(that.Double, -1):
+		s = "-inf"
+	case math.IsNaN(that.Double):
+		s = "nan"
+	default:
+		s = strconv.FormatFloat(that.Double, 'g', -1, 64)
+	}
+	if resp < RESP3 {
+		return MakeBulkMsg([]byte(s)).Bytes()
+	}
+	return []byte("," + s + CRLF)
+}
+
+// BooleanMsg 布尔消息，RESP2时转换成整数
+type BooleanMsg struct {
+	Boolean bool
+}
+
+// MakeBooleanMsg 创建布尔消息
+func MakeBooleanMsg(boolean bool) *BooleanMsg {
+	return &BooleanMsg{Boolean: boolean}
+}
+
+func (that *BooleanMsg) Bytes() []byte {
+	return that.ProtoBytes(RESP2)
+}
+
+func (that *BooleanMsg) ProtoBytes(resp int) []byte {
+	if resp < RESP3 {
+		if that.Boolean {
+			return []byte(":1" + CRLF)
+		}
+		return []byte(":0" + CRLF)
+	}
+	if that.Boolean {
+		return []byte("#t" + CRLF)
+	}
+	return []byte("#f" + CRLF)
+}
+
+// NullMsg 空消息，RESP2时转换成空字符串
+type NullMsg struct{}
+
+// MakeNullMsg 创建空消息
+func MakeNullMsg() *NullMsg {
+	return &NullMsg{}
+}
+
+func (that *NullMsg) Bytes() []byte {
+	return that.ProtoBytes(RESP2)
+}
+
+func (that *NullMsg) ProtoBytes(resp int) []byte {
+	if resp < RESP3 {
+		return nullBulkBytes
+	}
+	return []byte("_" + CRLF)
+}
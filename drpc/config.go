@@ -53,6 +53,11 @@ type EndpointConfig struct {
 	//仅限客户端角色使用 试图链接服务端时候，重试的时间间隔.
 	RedialInterval time.Duration `json:"redial_interval" comment:"仅限客户端角色使用 试图链接服务端时候，重试的时间间隔."`
 
+	//作为服务端角色时，同一个端口同时接受tls加密链接和明文链接，需要设置tls配置
+	SniffTLS bool `json:"sniff_tls" comment:"作为服务端角色时，同一个端口同时接受tls加密链接和明文链接"`
+	//作为服务端角色时，判断链接协议时等待数据的超时时间
+	SniffTimeout time.Duration `json:"sniff_timeout" comment:"作为服务端角色时，判断链接协议时等待数据的超时时间"`
+
 	//该配置是否已经初始化检查
 	checked bool
 }
@@ -124,6 +129,11 @@ func (that *EndpointConfig) check() (err error) {
 		that.RedialInterval = time.Millisecond * 100
 	}
 
+	//判断链接协议时等待数据的超时时间，默认为10秒
+	if that.SniffTimeout <= 0 {
+		that.SniffTimeout = time.Second * 10
+	}
+
 	return nil
 }
 
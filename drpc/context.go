@@ -2,6 +2,7 @@ package drpc
 
 import (
 	"context"
+	"errors"
 	"github.com/gogf/gf/v2/container/gmap"
 	"github.com/gogf/gf/v2/util/gconv"
 	"github.com/osgochina/dmicro/drpc/codec"
@@ -10,6 +11,7 @@ import (
 	"github.com/osgochina/dmicro/drpc/status"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -32,6 +34,8 @@ type EarlyCtx interface {
 	Swap() *gmap.Map
 
 	// Context 获取上下文
+	// 处理CALL消息时，该上下文继承调用方的截止时间；
+	// 处理方法返回之前通过 Session() 发起的嵌套调用默认使用该上下文，剩余的时间会继续传递下去
 	Context() context.Context
 }
 
@@ -73,6 +77,9 @@ type inputCtx interface {
 
 	// CostTime 请求的开始时间
 	CostTime() time.Duration
+
+	// Param 获取路由匹配到的路径参数，例如路由 /user/:id 匹配 /user/10 时，Param("id") 返回 "10"
+	Param(key string) string
 }
 
 // ReadCtx 读取消息使用的上下文
@@ -123,6 +130,23 @@ type CallCtx interface {
 	AddTFilterId(filterID ...byte)
 }
 
+// StreamCtx stream消息使用的上下文
+type StreamCtx interface {
+	inputCtx
+
+	// Input 获取打开流的消息
+	Input() message.Message
+
+	// GetBodyCodec 获取当前消息的编码格式
+	GetBodyCodec() byte
+
+	// Send 向流的发起方发送一帧数据
+	Send(body interface{}, setting ...message.MsgSetting) *status.Status
+
+	// Recv 读取流的发起方发送的一帧数据，发起方关闭发送端后返回 CodeStreamEOF 状态
+	Recv(v interface{}) *status.Status
+}
+
 // UnknownPushCtx 未知push消息的上下文
 type UnknownPushCtx interface {
 	inputCtx
@@ -167,6 +191,7 @@ var (
 	_ ReadCtx        = new(handlerCtx)
 	_ PushCtx        = new(handlerCtx)
 	_ CallCtx        = new(handlerCtx)
+	_ StreamCtx      = new(handlerCtx)
 	_ UnknownPushCtx = new(handlerCtx)
 	_ UnknownCallCtx = new(handlerCtx)
 )
@@ -180,12 +205,45 @@ type handlerCtx struct {
 	output          message.Message
 	handler         *Handler
 	arg             reflect.Value // 消息传入的参数
+	params          routeParams   // 路由匹配到的路径参数
 	callCmd         *callCmd
+	stream          *stream
 	swap            *gmap.Map
 	start           int64
 	pluginContainer *PluginContainer
 	stat            *status.Status
 	context         context.Context
+	scope           *callScope
+}
+
+// 处理CALL消息的作用域，处理方法返回后嵌套调用不再继承处理方法的上下文
+type callScope struct {
+	returned int32
+}
+
+// 处理CALL消息时 Session() 返回的会话
+//  处理方法返回之前发起的CALL默认使用处理方法的上下文，设置了 WithContext 时以设置的为准
+type ctxSession struct {
+	*session
+	ctx   context.Context
+	scope *callScope
+}
+
+func (that *ctxSession) withContext(setting []message.MsgSetting) []message.MsgSetting {
+	if atomic.LoadInt32(&that.scope.returned) != 0 {
+		return setting
+	}
+	return append([]message.MsgSetting{message.WithContext(that.ctx)}, setting...)
+}
+
+// AsyncCall 发送消息，并异步接收响应
+func (that *ctxSession) AsyncCall(serviceMethod string, args interface{}, result interface{}, callCmdChan chan<- CallCmd, setting ...message.MsgSetting) CallCmd {
+	return that.session.AsyncCall(serviceMethod, args, result, callCmdChan, that.withContext(setting)...)
+}
+
+// Call 发送消息并获得响应值
+func (that *ctxSession) Call(serviceMethod string, args interface{}, result interface{}, setting ...message.MsgSetting) CallCmd {
+	return that.session.Call(serviceMethod, args, result, that.withContext(setting)...)
 }
 
 //newReadHandleCtx 创建一个给request/response或push使用的上下文
@@ -215,11 +273,14 @@ func (that *handlerCtx) clean() {
 	that.sess = nil
 	that.handler = nil
 	that.arg = emptyValue
+	that.params = that.params[:0]
 	that.swap = nil
 	that.callCmd = nil
+	that.stream = nil
 	that.pluginContainer = nil
 	that.stat = nil
 	that.context = nil
+	that.scope = nil
 	that.input.Reset(message.WithNewBody(that.buildingBody))
 	that.output.Reset()
 }
@@ -231,7 +292,10 @@ func (that *handlerCtx) Endpoint() Endpoint {
 
 // Session 获取当前的会话
 func (that *handlerCtx) Session() CtxSession {
-	return that.sess
+	if that.scope == nil {
+		return that.sess
+	}
+	return &ctxSession{session: that.sess, ctx: that.Context(), scope: that.scope}
 }
 
 // Input 获取输入消息
@@ -313,6 +377,11 @@ func (that *handlerCtx) RealIP() string {
 	return that.sess.RemoteAddr().String()
 }
 
+// Param 获取路由匹配到的路径参数
+func (that *handlerCtx) Param(key string) string {
+	return that.params.get(key)
+}
+
 // Context 获取当前上下文
 func (that *handlerCtx) Context() context.Context {
 	if that.context == nil {
@@ -326,6 +395,34 @@ func (that *handlerCtx) setContext(ctx context.Context) {
 	that.context = ctx
 }
 
+// handlerCtx 在 context.Context 中存放的key
+type handlerCtxKey struct{}
+
+// 生成传递给 func(context.Context, *<T>) 形式处理方法的上下文，可以从中取回处理器上下文
+func (that *handlerCtx) handlerContext() context.Context {
+	return context.WithValue(that.Context(), handlerCtxKey{}, that)
+}
+
+// CallCtxFromContext 从传给处理方法的 context.Context 中获取 CallCtx
+// 注意：只能在处理方法返回之前使用
+func CallCtxFromContext(ctx context.Context) (CallCtx, bool) {
+	c, ok := ctx.Value(handlerCtxKey{}).(*handlerCtx)
+	if !ok || c.input.MType() != message.TypeCall {
+		return nil, false
+	}
+	return c, true
+}
+
+// PushCtxFromContext 从传给处理方法的 context.Context 中获取 PushCtx
+// 注意：只能在处理方法返回之前使用
+func PushCtxFromContext(ctx context.Context) (PushCtx, bool) {
+	c, ok := ctx.Value(handlerCtxKey{}).(*handlerCtx)
+	if !ok || c.input.MType() != message.TypePush {
+		return nil, false
+	}
+	return c, true
+}
+
 // StatusOK 判断该上下文的状态是否是ok
 func (that *handlerCtx) StatusOK() bool {
 	return that.stat.OK()
@@ -393,6 +490,14 @@ func (that *handlerCtx) buildingBody(header message.Header) (body interface{}) {
 		return that.buildPushBody(header)
 	case message.TypeCall:
 		return that.buildCallBody(header)
+	case message.TypeStreamOpen:
+		return that.buildStreamOpenBody(header)
+	case message.TypeStreamData, message.TypeStreamClose:
+		return that.buildStreamFrameBody(header, that.sess.peerStreamMap)
+	case message.TypeStreamReply, message.TypeStreamEnd:
+		return that.buildStreamFrameBody(header, that.sess.streamMap)
+	case message.TypeCancel:
+		return that.buildCancelBody(header)
 	default:
 		that.stat = statCodeMTypeNotAllowed
 		return nil
@@ -415,7 +520,7 @@ func (that *handlerCtx) buildCallBody(header message.Header) interface{} {
 	//如果请求消息的服务名没有命中处理方法，
 	//注意，这里会调用路由器匹配，如果路由器匹配不上，但是设置了默认处理方法，也是会返回默认处理方法的
 	var ok bool
-	that.handler, ok = that.sess.getCallHandler(header.ServiceMethod())
+	that.handler, ok = that.sess.getCallHandler(header.ServiceMethod(), &that.params)
 	if !ok {
 		that.stat = statNotFound
 		return nil
@@ -437,6 +542,10 @@ func (that *handlerCtx) buildCallBody(header message.Header) interface{} {
 	if !that.stat.OK() {
 		return nil
 	}
+	//记录处理程序的取消方法，收到调用方的取消消息时使用
+	ctx, cancel := context.WithCancelCause(that.input.Context())
+	that.setContext(ctx)
+	that.sess.callCancelMap.Set(header.Seq(), cancel)
 	return that.input.Body()
 }
 
@@ -457,7 +566,7 @@ func (that *handlerCtx) buildPushBody(header message.Header) interface{} {
 	//如果请求消息的服务名没有命中处理方法，
 	//注意，这里会调用路由器匹配，如果路由器匹配不上，但是设置了默认处理方法，也是会返回默认处理方法的
 	var ok bool
-	that.handler, ok = that.sess.getPushHandler(header.ServiceMethod())
+	that.handler, ok = that.sess.getPushHandler(header.ServiceMethod(), &that.params)
 	if !ok {
 		that.stat = statNotFound
 		return nil
@@ -489,6 +598,12 @@ func (that *handlerCtx) buildReplyBody(header message.Header) interface{} {
 
 	// 在handleReply方法中解锁
 	that.callCmd.mu.Lock()
+	//调用方已经取消了该请求，丢弃迟到的回复
+	if that.callCmd.isDone() {
+		that.callCmd.mu.Unlock()
+		that.callCmd = nil
+		return nil
+	}
 	//把收到的回复消息中待的服务名赋值给input消息对象，记录日志使用
 	that.input.SetServiceMethod(that.callCmd.output.ServiceMethod())
 	//回复中的交换数据
@@ -519,6 +634,10 @@ func (that *handlerCtx) buildReplyBody(header message.Header) interface{} {
 
 //处理回复消息
 func (that *handlerCtx) handleReply() {
+	//对端支持取消消息
+	if that.input.Meta().Contains(MetaCancel) {
+		atomic.StoreInt32(&that.sess.peerCancel, 1)
+	}
 	// 如果callCmd解析失败
 	if that.callCmd == nil {
 		return
@@ -556,6 +675,28 @@ func (that *handlerCtx) handleReply() {
 	}
 }
 
+// 调用方发送取消消息后，处理程序上下文的取消原因
+var errCallCanceled = errors.New("call canceled by caller")
+
+//根据消息头构建取消消息的消息体，取消消息没有消息体
+func (that *handlerCtx) buildCancelBody(_ message.Header) interface{} {
+	that.input.SetBody(new([]byte))
+	return that.input.Body()
+}
+
+//处理调用方发来的取消消息，取消对应的正在执行的处理程序
+func (that *handlerCtx) handleCancel() {
+	if !that.stat.OK() {
+		return
+	}
+	cancel := that.sess.callCancelMap.Remove(that.input.Seq())
+	if cancel == nil {
+		return
+	}
+	cancel.(context.CancelCauseFunc)(errCallCanceled)
+	that.pluginContainer.afterReadCancel(that)
+}
+
 const logFormatDisconnected = "disconnected due to unsupported message type: %d %s %s %q RECV(%s)"
 
 //收到消息，处理消息
@@ -580,6 +721,11 @@ func (that *handlerCtx) handle() {
 		that.handleCall()
 		return
 
+	case message.TypeStreamOpen:
+		// handles stream
+		that.handleStreamOpen()
+		return
+
 	default:
 	}
 E:
@@ -615,10 +761,13 @@ func (that *handlerCtx) handlePush() {
 	//消息状态正确，且有注册的处理函数
 	if that.stat.OK() && that.handler != nil && that.pluginContainer.afterReadPushBody(that) == nil {
 		//执行处理事件
-		if that.handler.IsUnknown() {
-			that.handler.unknownHandleFunc(that)
+		if that.pluginContainer.hasPushInterceptor() {
+			that.stat = that.pluginContainer.interceptPush(that, func(PushCtx) *Status {
+				that.invokeHandler()
+				return that.stat
+			})
 		} else {
-			that.handler.handleFunc(that, that.arg)
+			that.invokeHandler()
 		}
 	}
 	if !that.stat.OK() {
@@ -626,6 +775,34 @@ func (that *handlerCtx) handlePush() {
 	}
 }
 
+// 执行处理程序
+func (that *handlerCtx) invokeHandler() {
+	if that.handler.isUnknown {
+		that.handler.unknownHandleFunc(that)
+	} else {
+		that.handler.handleFunc(that, that.arg)
+	}
+}
+
+// 通过拦截器链执行call处理程序，拦截器返回的结果作为最终的响应
+func (that *handlerCtx) interceptCall() {
+	reply, stat := that.pluginContainer.interceptCall(that, func(CallCtx) (interface{}, *Status) {
+		that.invokeHandler()
+		if !that.stat.OK() {
+			return nil, that.stat
+		}
+		return that.output.Body(), nil
+	})
+	if !stat.OK() {
+		that.stat = stat
+		that.output.SetStatus(stat)
+		return
+	}
+	that.stat = nil
+	that.output.SetStatus(nil)
+	that.output.SetBody(reply)
+}
+
 // 处理call请求
 func (that *handlerCtx) handleCall() {
 	var isWrite bool
@@ -657,29 +834,61 @@ func (that *handlerCtx) handleCall() {
 	// 设置返回消息的管道处理器
 	that.output.PipeTFilter().AppendFrom(that.input.PipeTFilter())
 
+	//处理结束，移除处理程序的取消方法
+	defer func() {
+		if cancel := that.sess.callCancelMap.Remove(that.input.Seq()); cancel != nil {
+			cancel.(context.CancelCauseFunc)(nil)
+		}
+	}()
+	//处理结束后，之前取得的会话发起的调用不再继承处理方法的上下文
+	that.scope = new(callScope)
+	defer atomic.StoreInt32(&that.scope.returned, 1)
+
 	age := that.sess.ContextAge()
 	if age > 0 {
-		ctxTimout, cancel := context.WithTimeout(that.input.Context(), age)
+		ctxTimout, cancel := context.WithTimeout(that.Context(), age)
 		defer cancel()
 		//为自己和响应消息设置生存周期
 		that.setContext(ctxTimout)
 		message.WithContext(ctxTimout)(that.output)
 	}
+	//继承调用方传递的截止时间，调用方放弃的时候处理程序也随之结束
+	if timeout, ok := getTimeoutMeta(that.input); ok {
+		ctxTimout, cancel := context.WithTimeout(that.Context(), timeout)
+		defer cancel()
+		that.setContext(ctxTimout)
+	}
 	if that.stat.OK() {
 		that.stat = that.output.Status()
 	}
+	//调用方的截止时间已过或者已经取消，不再执行处理程序
+	if that.stat.OK() && that.context != nil {
+		switch err := that.context.Err(); err {
+		case context.DeadlineExceeded:
+			that.stat = statHandleTimeout.Copy(err)
+		case context.Canceled:
+			that.stat = statCallCanceled.Copy(context.Cause(that.context))
+		}
+	}
 	if that.stat.OK() {
 		//触发事件
 		that.stat = that.pluginContainer.afterReadCallBody(that)
 		if that.stat.OK() {
 			//处理
-			if that.handler.isUnknown {
-				that.handler.unknownHandleFunc(that)
+			if that.pluginContainer.hasCallInterceptor() {
+				that.interceptCall()
 			} else {
-				that.handler.handleFunc(that, that.arg)
+				that.invokeHandler()
 			}
 		}
 	}
+	//调用方已经取消了该请求，不再回复
+	if context.Cause(that.Context()) == errCallCanceled {
+		that.stat = statCallCanceled.Copy(errCallCanceled)
+		that.output.SetStatus(that.stat)
+		isWrite = true
+		return
+	}
 	//响应
 	that.setReplyBodyCodec(!that.stat.OK()) //设置响应正文的编解码器，默认使用请求消息的正文编解码器
 	//触发事件
@@ -724,6 +933,10 @@ func (that *handlerCtx) writeReply(stat *Status) *Status {
 		that.output.SetBodyCodec(codec.NilCodecID)
 	}
 
+	//调用方询问是否支持取消消息时，在回复中确认
+	if that.input.Meta().Contains(MetaCancel) {
+		that.output.Meta().Set(MetaCancel, "1")
+	}
 	serviceMethod := that.output.ServiceMethod()
 	//发送消息的时候，把服务名设置为空，因为响应的时候本来就不应该有服务名，
 	//但是记录响应消息日志的时候，又需要用到这个服务名，所以只能这里做一些妥协
@@ -0,0 +1,260 @@
+package drpc_test
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/osgochina/dmicro/drpc"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 返回上下文剩余的处理时间，没有截止时间则返回-1
+func remainTime(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return -1
+	}
+	return time.Until(deadline)
+}
+
+func TestCallDeadline(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		var peerPath string
+		endpointSvr := drpc.NewEndpoint(drpc.EndpointConfig{
+			Network:    "tcp",
+			ListenIP:   "127.0.0.1",
+			ListenPort: 9221,
+		})
+		// 返回服务端剩余的时间，以及嵌套调用客户端时客户端看到的剩余时间
+		remainPath := endpointSvr.SubRoute("/deadline").RouteCallFunc(func(ctx drpc.CallCtx, arg *int) ([]time.Duration, *drpc.Status) {
+			var (
+				remain     = remainTime(ctx.Context())
+				peerRemain time.Duration
+			)
+			stat := ctx.Session().Call(peerPath, arg, &peerRemain, drpc.WithContext(ctx.Context())).Status()
+			if !stat.OK() {
+				return nil, stat
+			}
+			return []time.Duration{remain, peerRemain}, nil
+		})
+		// 嵌套调用不传入上下文，默认继承处理方法的上下文
+		var stored drpc.CtxSession
+		autoPath := endpointSvr.SubRoute("/deadline").RouteCallFunc(func(ctx drpc.CallCtx, arg *int) ([]time.Duration, *drpc.Status) {
+			var (
+				remain     = remainTime(ctx.Context())
+				peerRemain time.Duration
+			)
+			stored = ctx.Session()
+			stat := stored.Call(peerPath, arg, &peerRemain).Status()
+			if !stat.OK() {
+				return nil, stat
+			}
+			return []time.Duration{remain, peerRemain}, nil
+		})
+		// 等待上下文结束
+		handled := make(chan error, 1)
+		waitPath := endpointSvr.SubRoute("/deadline").RouteCallFunc(func(ctx drpc.CallCtx, arg *int) (string, *drpc.Status) {
+			select {
+			case <-ctx.Context().Done():
+				handled <- ctx.Context().Err()
+			case <-time.After(3 * time.Second):
+				handled <- nil
+			}
+			return "done", nil
+		})
+		defer endpointSvr.Close()
+		go endpointSvr.ListenAndServe()
+		time.Sleep(300 * time.Millisecond)
+
+		endpointCli := drpc.NewEndpoint(drpc.EndpointConfig{})
+		peerPath = endpointCli.SubRoute("/peer").RouteCallFunc(func(ctx drpc.CallCtx, arg *int) (time.Duration, *drpc.Status) {
+			return remainTime(ctx.Context()), nil
+		})
+		defer endpointCli.Close()
+		sess, stat := endpointCli.Dial("127.0.0.1:9221")
+		t.Assert(stat.OK(), true)
+
+		// 未设置截止时间，不传递
+		var result []time.Duration
+		stat = sess.Call(remainPath, 1, &result).Status()
+		t.Assert(stat.OK(), true)
+		t.Assert(result, []time.Duration{-1, -1})
+
+		// 截止时间逐级缩短
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		stat = sess.Call(remainPath, 1, &result, drpc.WithContext(ctx)).Status()
+		t.Assert(stat.OK(), true)
+		t.Assert(len(result), 2)
+		t.AssertGT(result[0], 0)
+		t.AssertLE(result[0], time.Second)
+		t.AssertGT(result[1], 0)
+		t.AssertLE(result[1], result[0])
+
+		stat = sess.Call(autoPath, 1, &result, drpc.WithContext(ctx)).Status()
+		t.Assert(stat.OK(), true)
+		t.Assert(len(result), 2)
+		t.AssertGT(result[1], 0)
+		t.AssertLE(result[1], result[0])
+		// 处理方法返回后，保存的会话发起的调用不再继承处理方法的上下文
+		var peerRemain time.Duration
+		stat = stored.Call(peerPath, 1, &peerRemain).Status()
+		t.Assert(stat.OK(), true)
+		t.Assert(peerRemain, time.Duration(-1))
+
+		// 截止时间到达后，服务端处理程序的上下文被取消
+		ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		var reply string
+		stat = sess.Call(waitPath, 1, &reply, drpc.WithContext(ctx)).Status()
+		t.Assert(stat.Code(), drpc.CodeHandleTimeout)
+		t.AssertNE(<-handled, nil)
+
+		// CallContext 使用 ctx 作为消息的上下文
+		ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		stat = sess.CallContext(ctx, waitPath, 1, &reply).Status()
+		t.Assert(stat.Code(), drpc.CodeHandleTimeout)
+		t.AssertNE(<-handled, nil)
+
+		// ctx 已经结束时不发送消息
+		ctx, cancel = context.WithCancel(context.Background())
+		cancel()
+		stat = sess.CallContext(ctx, waitPath, 1, &reply).Status()
+		t.Assert(stat.Code(), drpc.CodeCallCanceled)
+	})
+}
+
+type cancelPlugin struct {
+	writeCancel chan int32
+	readCancel  chan int32
+}
+
+func (that *cancelPlugin) Name() string {
+	return "cancel"
+}
+
+func (that *cancelPlugin) AfterWriteCancel(ctx drpc.WriteCtx) *drpc.Status {
+	that.writeCancel <- ctx.Output().Seq()
+	return nil
+}
+
+func (that *cancelPlugin) AfterReadCancel(ctx drpc.ReadCtx) *drpc.Status {
+	that.readCancel <- ctx.Seq()
+	return nil
+}
+
+func TestCallCancel(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		plugin := &cancelPlugin{
+			writeCancel: make(chan int32, 1),
+			readCancel:  make(chan int32, 1),
+		}
+		handled := make(chan error, 1)
+		endpointSvr := drpc.NewEndpoint(drpc.EndpointConfig{
+			Network:    "tcp",
+			ListenIP:   "127.0.0.1",
+			ListenPort: 9222,
+		}, plugin)
+		waitPath := endpointSvr.SubRoute("/cancel").RouteCallFunc(func(ctx drpc.CallCtx, arg *int) (string, *drpc.Status) {
+			select {
+			case <-ctx.Context().Done():
+				handled <- ctx.Context().Err()
+			case <-time.After(3 * time.Second):
+				handled <- nil
+			}
+			return "done", nil
+		})
+		echoPath := endpointSvr.SubRoute("/cancel").RouteCallFunc(func(ctx drpc.CallCtx, arg *int) (string, *drpc.Status) {
+			return "echo", nil
+		})
+		shortHandled := make(chan error, 1)
+		shortPath := endpointSvr.SubRoute("/cancel").RouteCallFunc(func(ctx drpc.CallCtx, arg *int) (string, *drpc.Status) {
+			select {
+			case <-ctx.Context().Done():
+				shortHandled <- ctx.Context().Err()
+			case <-time.After(300 * time.Millisecond):
+				shortHandled <- nil
+			}
+			return "short", nil
+		})
+		defer endpointSvr.Close()
+		go endpointSvr.ListenAndServe()
+		time.Sleep(300 * time.Millisecond)
+
+		endpointCli := drpc.NewEndpoint(drpc.EndpointConfig{}, plugin)
+		defer endpointCli.Close()
+		sess, stat := endpointCli.Dial("127.0.0.1:9222")
+		t.Assert(stat.OK(), true)
+
+		// 对端在回复中声明支持取消消息之前，只在本端结束请求，不发送取消消息
+		ctx, cancel := context.WithCancel(context.Background())
+		var reply string
+		callCmd := sess.AsyncCall(shortPath, 1, &reply, nil, drpc.WithContext(ctx))
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+		<-callCmd.Done()
+		t.Assert(callCmd.Status().Code(), drpc.CodeCallCanceled)
+		t.AssertNil(<-shortHandled)
+		t.Assert(len(plugin.writeCancel), 0)
+		t.Assert(len(plugin.readCancel), 0)
+		var echo string
+		stat = sess.Call(echoPath, 1, &echo).Status()
+		t.Assert(stat.OK(), true)
+
+		ctx, cancel = context.WithCancel(context.Background())
+		callCmd = sess.AsyncCall(waitPath, 1, &reply, nil, drpc.WithContext(ctx))
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+		<-callCmd.Done()
+		t.Assert(callCmd.Status().Code(), drpc.CodeCallCanceled)
+		t.Assert(reply, "")
+
+		seq := callCmd.Output().Seq()
+		t.Assert(<-plugin.writeCancel, seq)
+		t.Assert(<-plugin.readCancel, seq)
+		t.Assert(<-handled, context.Canceled)
+
+		// 已经收到回复的请求，取消上下文不会发送取消消息
+		ctx, cancel = context.WithCancel(context.Background())
+		stat = sess.Call(echoPath, 1, &reply, drpc.WithContext(ctx)).Status()
+		t.Assert(stat.OK(), true)
+		t.Assert(reply, "echo")
+		cancel()
+		time.Sleep(100 * time.Millisecond)
+		t.Assert(len(plugin.writeCancel), 0)
+		t.Assert(len(plugin.readCancel), 0)
+	})
+}
+
+func TestContextParam(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		endpointSvr := drpc.NewEndpoint(drpc.EndpointConfig{
+			Network:    "tcp",
+			ListenIP:   "127.0.0.1",
+			ListenPort: 9223,
+		})
+		idPath := endpointSvr.SubRoute("/user/:id").RouteCallFunc(func(ctx drpc.CallCtx, arg *int) (string, *drpc.Status) {
+			return ctx.Param("id"), nil
+		})
+		defer endpointSvr.Close()
+		go endpointSvr.ListenAndServe()
+		time.Sleep(300 * time.Millisecond)
+
+		endpointCli := drpc.NewEndpoint(drpc.EndpointConfig{})
+		defer endpointCli.Close()
+		sess, stat := endpointCli.Dial("127.0.0.1:9223")
+		t.Assert(stat.OK(), true)
+
+		var id string
+		stat = sess.Call(strings.Replace(idPath, ":id", "10", 1), 1, &id).Status()
+		t.Assert(stat.OK(), true)
+		t.Assert(id, "10")
+		stat = sess.Call(strings.Replace(idPath, ":id", "abc", 1), 1, &id).Status()
+		t.Assert(stat.OK(), true)
+		t.Assert(id, "abc")
+		stat = sess.Call("/user/10/not_found", 1, &id).Status()
+		t.Assert(stat.Code(), drpc.CodeNotFound)
+	})
+}
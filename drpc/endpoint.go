@@ -20,6 +20,7 @@ import (
 	"net"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -59,6 +60,8 @@ type EarlyEndpoint interface {
 	// SubRoute 获取分组路由对象
 	SubRoute(pathPrefix string, plugin ...Plugin) *SubRouter
 
+	// 以下的注册方法在处理程序无效或者路径冲突时 panic(error)，并且不会注册其中任何一个处理程序
+
 	// RouteCall 通过struct注册CALL类型的处理程序，并且返回注册的路径列表
 	RouteCall(ctrlStruct interface{}, plugin ...Plugin) []string
 	// RouteCallFunc 通过func注册CALL类型的处理程序，并且返回单个注册路径
@@ -67,6 +70,12 @@ type EarlyEndpoint interface {
 	RoutePush(ctrlStruct interface{}, plugin ...Plugin) []string
 	// RoutePushFunc 通过func注册PUSH类型的处理程序，并且返回单个注册路径
 	RoutePushFunc(pushHandleFunc interface{}, plugin ...Plugin) string
+	// RouteStream 通过struct注册STREAM类型的处理程序，并且返回注册的路径列表
+	RouteStream(ctrlStruct interface{}, plugin ...Plugin) []string
+	// RouteStreamFunc 通过func注册STREAM类型的处理程序，并且返回单个注册路径
+	RouteStreamFunc(streamHandleFunc interface{}, plugin ...Plugin) string
+	// RemoveRoute 删除指定路径的处理程序，可以在服务运行期间调用
+	RemoveRoute(path string) bool
 	// SetUnknownCall 设置默认处理程序，当没有找到CALL的处理程序时将调用该处理程序。
 	SetUnknownCall(fn func(UnknownCallCtx) (interface{}, *status.Status), plugin ...Plugin)
 	// SetUnknownPush 设置默认处理程序，当没有找到PUSH的处理程序时将调用该处理程序。
@@ -110,6 +119,8 @@ type endpoint struct {
 	network           string
 	defaultBodyCodec  byte
 	printDetail       bool
+	sniffTLS          bool
+	sniffTimeout      time.Duration
 
 	//只有作为server角色时候才有该对象
 	listerAddr net.Addr
@@ -143,6 +154,8 @@ func NewEndpoint(cfg EndpointConfig, globalLeftPlugin ...Plugin) Endpoint {
 		network:           cfg.Network,
 		listerAddr:        cfg.listenAddr,
 		printDetail:       cfg.PrintDetail,
+		sniffTLS:          cfg.SniffTLS,
+		sniffTimeout:      cfg.SniffTimeout,
 		listeners:         make(map[net.Listener]struct{}),
 		dialer: &Dialer{
 			network:        cfg.Network,
@@ -261,11 +274,26 @@ func (that *endpoint) RoutePushFunc(pushHandleFunc interface{}, plugin ...Plugin
 	return that.router.RoutePushFunc(pushHandleFunc, plugin...)
 }
 
+// RouteStream 通过结构体对象注册STREAM命令的路由
+func (that *endpoint) RouteStream(streamCtrlStruct interface{}, plugin ...Plugin) []string {
+	return that.router.RouteStream(streamCtrlStruct, plugin...)
+}
+
+// RouteStreamFunc 通过对象的方法注册STREAM命令的路由
+func (that *endpoint) RouteStreamFunc(streamHandleFunc interface{}, plugin ...Plugin) string {
+	return that.router.RouteStreamFunc(streamHandleFunc, plugin...)
+}
+
 // SetUnknownCall 设置CALL命令的默认路由
 func (that *endpoint) SetUnknownCall(fn func(UnknownCallCtx) (interface{}, *Status), plugin ...Plugin) {
 	that.router.SetUnknownCall(fn, plugin...)
 }
 
+// RemoveRoute 删除指定路径的处理程序
+func (that *endpoint) RemoveRoute(path string) bool {
+	return that.router.RemoveRoute(path)
+}
+
 // SetUnknownPush 设置PUSH命令的默认路由
 func (that *endpoint) SetUnknownPush(fn func(UnknownPushCtx) *Status, plugin ...Plugin) {
 	that.router.SetUnknownPush(fn, plugin...)
@@ -342,6 +370,9 @@ func (that *endpoint) Dial(addr string, protoFunc ...proto.ProtoFunc) (Session,
 				}
 				//更改会话状态为初始状态
 				sess.changeStatus(statusPreparing)
+				//重新链接的对端需要重新声明是否支持取消消息
+				atomic.StoreInt32(&sess.peerCancel, 0)
+				atomic.StoreInt32(&sess.cancelOffered, 0)
 				//执行事件
 				stat = that.pluginContainer.afterDial(sess, true)
 				//如果执行事件返回的状态不是ok，则把当前链接关闭，把状态修改为重试中，继续重试。
@@ -482,6 +513,14 @@ func (that *endpoint) serveListener(lis net.Listener, protoFunc ...proto.ProtoFu
 		tempDelay = 0
 
 		dgpool.FILOAnywayGo(func() {
+			//同时接受tls加密链接和明文链接时，先判断链接的类型
+			if c, ok := conn.(*sniffConn); ok {
+				if conn, err = c.Sniff(); err != nil {
+					internal.Warningf(context.TODO(), "sniff tls error from %s: %s", c.RemoteAddr(), err.Error())
+					_ = c.Close()
+					return
+				}
+			}
 			//如果链接是tls加密链接，则设置超时时间，并进行握手
 			if c, ok := conn.(*tls.Conn); ok {
 				if that.defaultSessionAge > 0 {
@@ -515,7 +554,20 @@ func (that *endpoint) serveListener(lis net.Listener, protoFunc ...proto.ProtoFu
 
 // ListenAndServe 端点启动并监听，对外提供服务
 func (that *endpoint) ListenAndServe(protoFunc ...proto.ProtoFunc) error {
-	lis, err := NewInheritedListener(that.listerAddr, that.tlsConfig)
+	var (
+		lis net.Listener
+		err error
+	)
+	//同一个端口同时接受tls加密链接和明文链接，quic和kcp不支持
+	network := that.listerAddr.Network()
+	if that.sniffTLS && that.tlsConfig != nil && asQUIC(network) == "" && asKCP(network) == "" {
+		lis, err = NewInheritedListener(that.listerAddr, nil)
+		if err == nil {
+			lis = NewSniffTLSListener(lis, that.tlsConfig, that.sniffTimeout)
+		}
+	} else {
+		lis, err = NewInheritedListener(that.listerAddr, that.tlsConfig)
+	}
 	if err != nil {
 		internal.Fatalf(context.TODO(), "%v", err)
 	}
@@ -0,0 +1,217 @@
+package drpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/osgochina/dmicro/drpc/message"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AsyncCaller 可以发起异步call请求的对象，Session 以及 client.RpcClient 都实现了该接口
+type AsyncCaller interface {
+	AsyncCall(serviceMethod string, args interface{}, result interface{}, callCmdChan chan<- CallCmd, setting ...message.MsgSetting) CallCmd
+}
+
+// Waiter 可以等待完成的异步结果，CallCmd 以及 Future 都实现了该接口
+type Waiter interface {
+	// Done 完成时关闭
+	Done() <-chan struct{}
+	// Status 完成后的状态
+	Status() *Status
+}
+
+// Future 异步请求的结果，不需要调用方处理 callCmdChan 以及 interface{} 类型的结果
+// 例如:
+//  f1 := drpc.Go[HelloResp](sess1, "/hello/say", &HelloReq{Name: "a"})
+//  f2 := drpc.Go[HelloResp](sess2, "/hello/say", &HelloReq{Name: "b"})
+//  results, stat := drpc.Collect(ctx, f1, f2)
+type Future[T any] struct {
+	done <-chan struct{}
+	get  func() (*T, *Status)
+}
+
+// Go 发起异步请求
+func Go[T any](caller AsyncCaller, serviceMethod string, args interface{}, setting ...message.MsgSetting) *Future[T] {
+	return FromCallCmd[T](caller.AsyncCall(serviceMethod, args, new(T), make(chan CallCmd, 1), setting...))
+}
+
+// FromCallCmd 把 CallCmd 包装成 Future，CallCmd 的结果必须是 *T 类型
+func FromCallCmd[T any](cmd CallCmd) *Future[T] {
+	return &Future[T]{
+		done: cmd.Done(),
+		get: func() (*T, *Status) {
+			reply, stat := cmd.Reply()
+			if !stat.OK() {
+				return nil, stat
+			}
+			result, ok := reply.(*T)
+			if !ok {
+				return nil, statBadMessage.Copy(fmt.Sprintf("reply type %T is not %T", reply, result))
+			}
+			return result, nil
+		},
+	}
+}
+
+// Done 完成时关闭
+func (that *Future[T]) Done() <-chan struct{} {
+	return that.done
+}
+
+// Result 等待完成并返回结果
+func (that *Future[T]) Result() (*T, *Status) {
+	<-that.done
+	return that.get()
+}
+
+// Status 等待完成并返回状态
+func (that *Future[T]) Status() *Status {
+	_, stat := that.Result()
+	return stat
+}
+
+// Wait 等待完成并返回结果，ctx 先结束时返回超时或者取消的状态
+func (that *Future[T]) Wait(ctx context.Context) (*T, *Status) {
+	select {
+	case <-that.done:
+		return that.get()
+	case <-ctx.Done():
+		return nil, StatusFromError(ctx.Err())
+	}
+}
+
+// Timeout 返回新的 Future，超过 d 没有完成时以超时状态完成
+//  只是不再等待，不会取消请求，需要取消请求时使用 WithContext 设置请求的上下文
+func (that *Future[T]) Timeout(d time.Duration) *Future[T] {
+	var (
+		done     = make(chan struct{})
+		timedOut bool
+	)
+	go func() {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-that.done:
+		case <-timer.C:
+			timedOut = true
+		}
+		close(done)
+	}()
+	return &Future[T]{
+		done: done,
+		get: func() (*T, *Status) {
+			if timedOut {
+				return nil, StatusFromError(context.DeadlineExceeded)
+			}
+			return that.get()
+		},
+	}
+}
+
+// Map 返回新的 Future，成功时使用 fn 转换结果，失败时保持原来的状态
+func Map[T, U any](f *Future[T], fn func(*T) (*U, *Status)) *Future[U] {
+	var (
+		once   sync.Once
+		result *U
+		stat   *Status
+	)
+	return &Future[U]{
+		done: f.done,
+		get: func() (*U, *Status) {
+			once.Do(func() {
+				var r *T
+				if r, stat = f.get(); stat.OK() {
+					result, stat = fn(r)
+				}
+			})
+			return result, stat
+		},
+	}
+}
+
+// WaitAll 等待全部完成，返回按照传入顺序第一个失败的状态，全部成功时返回nil
+//  ctx 先结束时返回超时或者取消的状态
+func WaitAll(ctx context.Context, ws ...Waiter) *Status {
+	for _, w := range ws {
+		select {
+		case <-w.Done():
+		case <-ctx.Done():
+			return StatusFromError(ctx.Err())
+		}
+	}
+	for _, w := range ws {
+		if stat := w.Status(); !stat.OK() {
+			return stat
+		}
+	}
+	return nil
+}
+
+// WaitAny 等待第一个成功的结果，返回它的下标
+//  全部失败时返回-1以及按照传入顺序第一个失败的状态，ctx 先结束时返回-1以及超时或者取消的状态
+func WaitAny(ctx context.Context, ws ...Waiter) (int, *Status) {
+	indexes, stat := WaitN(ctx, 1, ws...)
+	if len(indexes) == 0 {
+		return -1, stat
+	}
+	return indexes[0], nil
+}
+
+// WaitN 等待 n 个成功的结果，返回它们的下标，按照从小到大排序
+//  失败的数量使得不可能有 n 个成功时，返回已经成功的下标以及按照传入顺序第一个失败的状态
+//  ctx 先结束时返回已经成功的下标以及超时或者取消的状态
+func WaitN(ctx context.Context, n int, ws ...Waiter) ([]int, *Status) {
+	if n > len(ws) {
+		return nil, statBadMessage.Copy(fmt.Sprintf("wait %d of %d", n, len(ws)))
+	}
+	var (
+		doneCh  = make(chan int, len(ws))
+		success []int
+		failed  []int
+	)
+	for i, w := range ws {
+		go func(i int, w Waiter) {
+			<-w.Done()
+			doneCh <- i
+		}(i, w)
+	}
+	for len(success) < n {
+		select {
+		case i := <-doneCh:
+			if ws[i].Status().OK() {
+				success = append(success, i)
+				continue
+			}
+			failed = append(failed, i)
+			if len(ws)-len(failed) < n {
+				sort.Ints(success)
+				sort.Ints(failed)
+				return success, ws[failed[0]].Status()
+			}
+		case <-ctx.Done():
+			sort.Ints(success)
+			return success, StatusFromError(ctx.Err())
+		}
+	}
+	sort.Ints(success)
+	return success, nil
+}
+
+// Collect 等待全部完成，按照传入的顺序返回结果
+//  任意一个失败时返回按照传入顺序第一个失败的状态，ctx 先结束时返回超时或者取消的状态
+func Collect[T any](ctx context.Context, fs ...*Future[T]) ([]*T, *Status) {
+	ws := make([]Waiter, len(fs))
+	for i, f := range fs {
+		ws[i] = f
+	}
+	if stat := WaitAll(ctx, ws...); stat != nil {
+		return nil, stat
+	}
+	results := make([]*T, len(fs))
+	for i, f := range fs {
+		results[i], _ = f.get()
+	}
+	return results, nil
+}
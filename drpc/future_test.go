@@ -0,0 +1,113 @@
+package drpc_test
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/osgochina/dmicro/drpc"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type SleepReq struct {
+	Delay time.Duration
+	Fail  bool
+}
+
+func TestFuture(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		var (
+			sessions  []drpc.Session
+			sleepPath string
+		)
+		endpointCli := drpc.NewEndpoint(drpc.EndpointConfig{})
+		defer endpointCli.Close()
+		for _, port := range []uint16{9261, 9262} {
+			port := port
+			endpointSvr := drpc.NewEndpoint(drpc.EndpointConfig{
+				Network:    "tcp",
+				ListenIP:   "127.0.0.1",
+				ListenPort: port,
+			})
+			// 等待指定的时间后返回端口号
+			sleepPath = endpointSvr.SubRoute("/future").RouteCallFunc(func(ctx drpc.CallCtx, req *SleepReq) (int, *drpc.Status) {
+				time.Sleep(req.Delay)
+				if req.Fail {
+					return 0, drpc.NewStatus(1001, "fail", "")
+				}
+				return int(port), nil
+			})
+			defer endpointSvr.Close()
+			go endpointSvr.ListenAndServe()
+		}
+		time.Sleep(300 * time.Millisecond)
+		for _, port := range []int{9261, 9262} {
+			sess, stat := endpointCli.Dial("127.0.0.1:" + strconv.Itoa(port))
+			t.Assert(stat.OK(), true)
+			sessions = append(sessions, sess)
+		}
+		call := func(sess drpc.Session, delay time.Duration, fail bool) *drpc.Future[int] {
+			return drpc.Go[int](sess, sleepPath, &SleepReq{Delay: delay, Fail: fail})
+		}
+		ctx := context.Background()
+
+		// 按照传入的顺序返回结果
+		results, stat := drpc.Collect(ctx,
+			call(sessions[0], 200*time.Millisecond, false),
+			call(sessions[1], 0, false),
+		)
+		t.AssertNil(stat)
+		t.Assert(*results[0], 9261)
+		t.Assert(*results[1], 9262)
+
+		// 任意一个失败时返回失败的状态
+		_, stat = drpc.Collect(ctx, call(sessions[0], 0, false), call(sessions[1], 0, true))
+		t.Assert(stat.Code(), 1001)
+
+		// 第一个成功的结果
+		i, stat := drpc.WaitAny(ctx,
+			call(sessions[0], 300*time.Millisecond, false),
+			call(sessions[1], 0, true),
+			call(sessions[1], 50*time.Millisecond, false),
+		)
+		t.AssertNil(stat)
+		t.Assert(i, 2)
+		i, stat = drpc.WaitAny(ctx, call(sessions[0], 0, true), call(sessions[1], 0, true))
+		t.Assert(i, -1)
+		t.Assert(stat.Code(), 1001)
+
+		// 等待n个成功的结果
+		indexes, stat := drpc.WaitN(ctx, 2,
+			call(sessions[0], time.Second, false),
+			call(sessions[1], 0, false),
+			call(sessions[0], 50*time.Millisecond, false),
+		)
+		t.AssertNil(stat)
+		t.Assert(indexes, []int{1, 2})
+
+		// 超时
+		timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		stat = drpc.WaitAll(timeoutCtx, call(sessions[0], 0, false), call(sessions[1], 300*time.Millisecond, false))
+		t.Assert(stat.Code(), drpc.CodeHandleTimeout)
+		_, stat = call(sessions[0], 300*time.Millisecond, false).Timeout(100 * time.Millisecond).Result()
+		t.Assert(stat.Code(), drpc.CodeHandleTimeout)
+		r, stat := call(sessions[0], 0, false).Timeout(time.Second).Result()
+		t.AssertNil(stat)
+		t.Assert(*r, 9261)
+
+		// 转换结果
+		s, stat := drpc.Map(call(sessions[1], 0, false), func(port *int) (*string, *drpc.Status) {
+			s := "port:" + strconv.Itoa(*port)
+			return &s, nil
+		}).Result()
+		t.AssertNil(stat)
+		t.Assert(*s, "port:9262")
+
+		// 兼容已有的 CallCmd
+		var reply int
+		r, stat = drpc.FromCallCmd[int](sessions[1].AsyncCall(sleepPath, &SleepReq{}, &reply, nil)).Wait(ctx)
+		t.AssertNil(stat)
+		t.Assert(*r, 9262)
+	})
+}
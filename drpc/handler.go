@@ -27,7 +27,7 @@ type Handler struct {
 	isUnknown bool
 }
 
-// RouterTypeName 获取处理器的路由方法名 pnPush/pnCall/pnUnknownPush/pnUnknownCall
+// RouterTypeName 获取处理器的路由方法名 pnPush/pnCall/pnStream/pnUnknownPush/pnUnknownCall
 func (that *Handler) RouterTypeName() string {
 	return that.routerTypeName
 }
@@ -62,6 +62,11 @@ func (that *Handler) IsPush() bool {
 	return that.routerTypeName == pnPush || that.routerTypeName == pnUnknownPush
 }
 
+// IsStream 处理程序是否是STREAM
+func (that *Handler) IsStream() bool {
+	return that.routerTypeName == pnStream
+}
+
 // IsUnknown 处理程序是否未找到
 func (that *Handler) IsUnknown() bool {
 	return that.isUnknown
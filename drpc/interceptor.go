@@ -0,0 +1,172 @@
+package drpc
+
+import (
+	"github.com/osgochina/dmicro/drpc/message"
+)
+
+// 拦截器
+//  拦截器是一种特殊的插件，它包裹整个处理过程，可以在处理前后执行逻辑，也可以修改结果或者不调用 next 直接返回
+//  与其他插件一样，可以在创建端点时、SubRoute 时、注册路由时传入，按照 PluginContainer 中的顺序组成调用链，排在前面的拦截器在外层
+//  服务端的拦截器在 AfterReadCallBody/AfterReadPushBody 之后执行，客户端的拦截器包裹 Session.Call/AsyncCall
+
+type (
+	// CallHandler 处理CALL请求，返回响应内容和状态
+	CallHandler func(ctx CallCtx) (interface{}, *Status)
+	// CallInterceptor CALL请求的拦截器，调用 next 执行后续的拦截器和处理程序
+	CallInterceptor func(ctx CallCtx, next CallHandler) (interface{}, *Status)
+	// PushHandler 处理PUSH请求
+	PushHandler func(ctx PushCtx) *Status
+	// PushInterceptor PUSH请求的拦截器，调用 next 执行后续的拦截器和处理程序
+	PushInterceptor func(ctx PushCtx, next PushHandler) *Status
+	// CallInvoker 客户端发起CALL请求，返回已经完成的 CallCmd
+	CallInvoker func(serviceMethod string, args interface{}, result interface{}, setting ...message.MsgSetting) CallCmd
+	// ClientCallInterceptor 客户端CALL请求的拦截器，调用 next 发起请求
+	ClientCallInterceptor func(sess Session, serviceMethod string, args interface{}, result interface{}, next CallInvoker, setting ...message.MsgSetting) CallCmd
+)
+
+type (
+	// CallInterceptPlugin 服务端拦截CALL请求的插件
+	CallInterceptPlugin interface {
+		Plugin
+		InterceptCall(ctx CallCtx, next CallHandler) (interface{}, *Status)
+	}
+	// PushInterceptPlugin 服务端拦截PUSH请求的插件
+	PushInterceptPlugin interface {
+		Plugin
+		InterceptPush(ctx PushCtx, next PushHandler) *Status
+	}
+	// ClientCallInterceptPlugin 客户端拦截CALL请求的插件，只在端点级别生效
+	ClientCallInterceptPlugin interface {
+		Plugin
+		InterceptClientCall(sess Session, serviceMethod string, args interface{}, result interface{}, next CallInvoker, setting ...message.MsgSetting) CallCmd
+	}
+)
+
+// NewCallInterceptor 把拦截函数包装成插件
+func NewCallInterceptor(name string, fn CallInterceptor) Plugin {
+	return &callInterceptor{name: name, fn: fn}
+}
+
+// NewPushInterceptor 把拦截函数包装成插件
+func NewPushInterceptor(name string, fn PushInterceptor) Plugin {
+	return &pushInterceptor{name: name, fn: fn}
+}
+
+// NewClientCallInterceptor 把拦截函数包装成插件
+func NewClientCallInterceptor(name string, fn ClientCallInterceptor) Plugin {
+	return &clientCallInterceptor{name: name, fn: fn}
+}
+
+type callInterceptor struct {
+	name string
+	fn   CallInterceptor
+}
+
+var _ CallInterceptPlugin = new(callInterceptor)
+
+func (that *callInterceptor) Name() string {
+	return that.name
+}
+
+func (that *callInterceptor) InterceptCall(ctx CallCtx, next CallHandler) (interface{}, *Status) {
+	return that.fn(ctx, next)
+}
+
+type pushInterceptor struct {
+	name string
+	fn   PushInterceptor
+}
+
+var _ PushInterceptPlugin = new(pushInterceptor)
+
+func (that *pushInterceptor) Name() string {
+	return that.name
+}
+
+func (that *pushInterceptor) InterceptPush(ctx PushCtx, next PushHandler) *Status {
+	return that.fn(ctx, next)
+}
+
+type clientCallInterceptor struct {
+	name string
+	fn   ClientCallInterceptor
+}
+
+var _ ClientCallInterceptPlugin = new(clientCallInterceptor)
+
+func (that *clientCallInterceptor) Name() string {
+	return that.name
+}
+
+func (that *clientCallInterceptor) InterceptClientCall(sess Session, serviceMethod string, args interface{}, result interface{}, next CallInvoker, setting ...message.MsgSetting) CallCmd {
+	return that.fn(sess, serviceMethod, args, result, next, setting...)
+}
+
+// 是否存在CALL拦截器
+func (that *pluginSingleContainer) hasCallInterceptor() bool {
+	for _, plugin := range that.plugins {
+		if _, ok := plugin.(CallInterceptPlugin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 使用拦截器链包裹处理程序并执行
+func (that *pluginSingleContainer) interceptCall(ctx CallCtx, handler CallHandler) (interface{}, *Status) {
+	for i := len(that.plugins) - 1; i >= 0; i-- {
+		if plugin, ok := that.plugins[i].(CallInterceptPlugin); ok {
+			next := handler
+			handler = func(ctx CallCtx) (interface{}, *Status) {
+				return plugin.InterceptCall(ctx, next)
+			}
+		}
+	}
+	return handler(ctx)
+}
+
+// 是否存在PUSH拦截器
+func (that *pluginSingleContainer) hasPushInterceptor() bool {
+	for _, plugin := range that.plugins {
+		if _, ok := plugin.(PushInterceptPlugin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 使用拦截器链包裹处理程序并执行
+func (that *pluginSingleContainer) interceptPush(ctx PushCtx, handler PushHandler) *Status {
+	for i := len(that.plugins) - 1; i >= 0; i-- {
+		if plugin, ok := that.plugins[i].(PushInterceptPlugin); ok {
+			next := handler
+			handler = func(ctx PushCtx) *Status {
+				return plugin.InterceptPush(ctx, next)
+			}
+		}
+	}
+	return handler(ctx)
+}
+
+// 是否存在客户端CALL拦截器
+func (that *pluginSingleContainer) hasClientCallInterceptor() bool {
+	for _, plugin := range that.plugins {
+		if _, ok := plugin.(ClientCallInterceptPlugin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 使用拦截器链包裹客户端请求并执行
+func (that *pluginSingleContainer) interceptClientCall(sess Session, serviceMethod string, args interface{}, result interface{}, invoker CallInvoker, setting ...message.MsgSetting) CallCmd {
+	for i := len(that.plugins) - 1; i >= 0; i-- {
+		if plugin, ok := that.plugins[i].(ClientCallInterceptPlugin); ok {
+			next := invoker
+			invoker = func(serviceMethod string, args interface{}, result interface{}, setting ...message.MsgSetting) CallCmd {
+				return plugin.InterceptClientCall(sess, serviceMethod, args, result, next, setting...)
+			}
+		}
+	}
+	return invoker(serviceMethod, args, result, setting...)
+}
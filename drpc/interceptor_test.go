@@ -0,0 +1,214 @@
+package drpc_test
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/container/garray"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/osgochina/dmicro/drpc"
+	"github.com/osgochina/dmicro/drpc/message"
+	"testing"
+	"time"
+)
+
+func interceptEcho(ctx drpc.CallCtx, arg *string) (string, *drpc.Status) {
+	if *arg == "" {
+		return "", drpc.NewStatus(1001, "empty arg", nil)
+	}
+	return *arg, nil
+}
+
+func interceptNotify(ctx drpc.PushCtx, arg *string) *drpc.Status {
+	return nil
+}
+
+// 按照名字记录调用顺序的拦截器
+func newOrderInterceptor(name string, order *garray.StrArray) drpc.Plugin {
+	return drpc.NewCallInterceptor(name, func(ctx drpc.CallCtx, next drpc.CallHandler) (interface{}, *drpc.Status) {
+		order.Append(name + ":before")
+		reply, stat := next(ctx)
+		order.Append(name + ":after")
+		return reply, stat
+	})
+}
+
+func TestCallInterceptor(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		order := garray.NewStrArray(true)
+		endpointSvr := drpc.NewEndpoint(drpc.EndpointConfig{
+			Network:    "tcp",
+			ListenIP:   "127.0.0.1",
+			ListenPort: 9233,
+		}, newOrderInterceptor("endpoint", order))
+		group := endpointSvr.SubRoute("/group", newOrderInterceptor("group", order))
+		echo := group.RouteCallFunc(interceptEcho, newOrderInterceptor("route", order))
+		// 出错时返回默认值
+		fallback := endpointSvr.RouteCallFunc(interceptEcho, drpc.NewCallInterceptor("fallback", func(ctx drpc.CallCtx, next drpc.CallHandler) (interface{}, *drpc.Status) {
+			reply, stat := next(ctx)
+			if !stat.OK() {
+				return "fallback", nil
+			}
+			return reply, stat
+		}))
+		// 不调用 next 直接拒绝
+		reject := endpointSvr.SubRoute("/reject", drpc.NewCallInterceptor("reject", func(ctx drpc.CallCtx, next drpc.CallHandler) (interface{}, *drpc.Status) {
+			return nil, drpc.NewStatus(403, "forbidden", nil)
+		})).RouteCallFunc(interceptEcho)
+		defer endpointSvr.Close()
+		go endpointSvr.ListenAndServe()
+		time.Sleep(300 * time.Millisecond)
+
+		endpointCli := drpc.NewEndpoint(drpc.EndpointConfig{})
+		defer endpointCli.Close()
+		sess, stat := endpointCli.Dial("127.0.0.1:9233")
+		t.Assert(stat.OK(), true)
+
+		var result string
+		stat = sess.Call(echo, "hello", &result).Status()
+		t.Assert(stat.OK(), true)
+		t.Assert(result, "hello")
+		t.Assert(order.Slice(), []string{
+			"endpoint:before", "group:before", "route:before",
+			"route:after", "group:after", "endpoint:after",
+		})
+
+		order.Clear()
+		stat = sess.Call(echo, "", &result).Status()
+		t.Assert(stat.Code(), 1001)
+		t.Assert(order.Len(), 6)
+
+		result = ""
+		stat = sess.Call(fallback, "", &result).Status()
+		t.Assert(stat.OK(), true)
+		t.Assert(result, "fallback")
+
+		stat = sess.Call(reject, "hello", &result).Status()
+		t.Assert(stat.Code(), 403)
+	})
+}
+
+func TestPushInterceptor(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		received := make(chan string, 1)
+		endpointSvr := drpc.NewEndpoint(drpc.EndpointConfig{
+			Network:    "tcp",
+			ListenIP:   "127.0.0.1",
+			ListenPort: 9234,
+		})
+		notify := endpointSvr.RoutePushFunc(interceptNotify, drpc.NewPushInterceptor("push", func(ctx drpc.PushCtx, next drpc.PushHandler) *drpc.Status {
+			stat := next(ctx)
+			received <- ctx.ServiceMethod()
+			return stat
+		}))
+		defer endpointSvr.Close()
+		go endpointSvr.ListenAndServe()
+		time.Sleep(300 * time.Millisecond)
+
+		endpointCli := drpc.NewEndpoint(drpc.EndpointConfig{})
+		defer endpointCli.Close()
+		sess, stat := endpointCli.Dial("127.0.0.1:9234")
+		t.Assert(stat.OK(), true)
+		t.Assert(sess.Push(notify, "hello").OK(), true)
+		select {
+		case name := <-received:
+			t.Assert(name, notify)
+		case <-time.After(3 * time.Second):
+			t.Error("push interceptor not called")
+		}
+	})
+}
+
+func TestClientCallInterceptor(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		endpointSvr := drpc.NewEndpoint(drpc.EndpointConfig{
+			Network:    "tcp",
+			ListenIP:   "127.0.0.1",
+			ListenPort: 9235,
+		})
+		echo := endpointSvr.RouteCallFunc(interceptEcho)
+		defer endpointSvr.Close()
+		go endpointSvr.ListenAndServe()
+		time.Sleep(300 * time.Millisecond)
+
+		var attempts int
+		// 失败时重试一次，并把空参数替换成默认值
+		retry := drpc.NewClientCallInterceptor("retry", func(sess drpc.Session, serviceMethod string, args interface{}, result interface{}, next drpc.CallInvoker, setting ...message.MsgSetting) drpc.CallCmd {
+			attempts++
+			cmd := next(serviceMethod, args, result, setting...)
+			if cmd.StatusOK() {
+				return cmd
+			}
+			attempts++
+			return next(serviceMethod, "retry", result, setting...)
+		})
+		endpointCli := drpc.NewEndpoint(drpc.EndpointConfig{}, retry)
+		defer endpointCli.Close()
+		sess, stat := endpointCli.Dial("127.0.0.1:9235")
+		t.Assert(stat.OK(), true)
+
+		var result string
+		cmd := sess.Call(echo, "", &result)
+		t.Assert(cmd.StatusOK(), true)
+		t.Assert(result, "retry")
+		t.Assert(attempts, 2)
+
+		attempts = 0
+		callCmdChan := make(chan drpc.CallCmd, 1)
+		cmd = sess.AsyncCall(echo, "hello", &result, callCmdChan)
+		t.Assert(<-callCmdChan == cmd, true)
+		<-cmd.Done()
+		t.Assert(cmd.StatusOK(), true)
+		t.Assert(result, "hello")
+		t.Assert(attempts, 1)
+	})
+}
+
+func TestClientCallInterceptorAsync(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		endpointSvr := drpc.NewEndpoint(drpc.EndpointConfig{
+			Network:    "tcp",
+			ListenIP:   "127.0.0.1",
+			ListenPort: 9236,
+		})
+		echo := endpointSvr.RouteCallFunc(interceptEcho)
+		defer endpointSvr.Close()
+		go endpointSvr.ListenAndServe()
+		time.Sleep(300 * time.Millisecond)
+
+		// 拦截器阻塞到放行后才发起请求
+		release := make(chan struct{})
+		hold := drpc.NewClientCallInterceptor("hold", func(sess drpc.Session, serviceMethod string, args interface{}, result interface{}, next drpc.CallInvoker, setting ...message.MsgSetting) drpc.CallCmd {
+			<-release
+			return next(serviceMethod, args, result, setting...)
+		})
+		endpointCli := drpc.NewEndpoint(drpc.EndpointConfig{}, hold)
+		defer endpointCli.Close()
+		sess, stat := endpointCli.Dial("127.0.0.1:9236")
+		t.Assert(stat.OK(), true)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var result string
+		cmd := sess.AsyncCall(echo, "hello", &result, nil, drpc.WithContext(ctx))
+		// 请求完成之前，获取请求信息的方法不会阻塞
+		t.Assert(cmd.Output().ServiceMethod(), echo)
+		t.Assert(cmd.Context() == ctx, true)
+		traceSess, ok := cmd.TraceSession()
+		t.Assert(ok, true)
+		t.Assert(traceSess == sess, true)
+		traceEndpoint, ok := cmd.TraceEndpoint()
+		t.Assert(ok, true)
+		t.Assert(traceEndpoint == endpointCli, true)
+		select {
+		case <-cmd.Done():
+			t.Fatal("call finished before the interceptor released it")
+		default:
+		}
+
+		close(release)
+		<-cmd.Done()
+		t.Assert(cmd.StatusOK(), true)
+		t.Assert(result, "hello")
+		t.Assert(cmd.Output().ServiceMethod(), echo)
+		t.Assert(cmd.Output().Seq() > 0, true)
+	})
+}
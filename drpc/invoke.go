@@ -0,0 +1,26 @@
+package drpc
+
+import (
+	"context"
+	"github.com/osgochina/dmicro/drpc/message"
+)
+
+// Caller 可以发起call请求的对象，Session 以及 client.RpcClient 都实现了该接口
+type Caller interface {
+	Call(serviceMethod string, args interface{}, result interface{}, setting ...message.MsgSetting) CallCmd
+}
+
+// Invoke 发起call请求，并把响应解析成 *Resp 返回，不需要调用方自行处理 interface{} 类型的结果
+// 例如: resp, stat := drpc.Invoke[HelloReq, HelloResp](sess, "/hello/say", &HelloReq{Name: "world"})
+func Invoke[Req, Resp any](sess Caller, serviceMethod string, req *Req, setting ...message.MsgSetting) (*Resp, *Status) {
+	resp := new(Resp)
+	if stat := sess.Call(serviceMethod, req, resp, setting...).Status(); !stat.OK() {
+		return nil, stat
+	}
+	return resp, nil
+}
+
+// InvokeContext 与 Invoke 相同，ctx 的截止时间会传递给远端，ctx 被取消时会同时取消远端的处理程序
+func InvokeContext[Req, Resp any](ctx context.Context, sess Caller, serviceMethod string, req *Req, setting ...message.MsgSetting) (*Resp, *Status) {
+	return Invoke[Req, Resp](sess, serviceMethod, req, append([]message.MsgSetting{message.WithContext(ctx)}, setting...)...)
+}
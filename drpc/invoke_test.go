@@ -0,0 +1,139 @@
+package drpc_test
+
+import (
+	"context"
+	"errors"
+	"github.com/gogf/gf/v2/errors/gcode"
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/osgochina/dmicro/drpc"
+	"testing"
+	"time"
+)
+
+type HelloReq struct {
+	Name string
+}
+
+type HelloResp struct {
+	Greeting string
+	RealIP   string
+}
+
+func Hello(ctx context.Context, req *HelloReq) (*HelloResp, error) {
+	callCtx, ok := drpc.CallCtxFromContext(ctx)
+	if !ok {
+		return nil, errors.New("call ctx not found")
+	}
+	switch req.Name {
+	case "":
+		return nil, gerror.NewCode(gcode.New(1001, "empty name", nil), "name is required")
+	case "panic":
+		return nil, errors.New("bad name")
+	}
+	return &HelloResp{Greeting: "hello " + req.Name, RealIP: callCtx.RealIP()}, nil
+}
+
+func TestTypedHandler(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		pushed := make(chan string, 1)
+		endpointSvr := drpc.NewEndpoint(drpc.EndpointConfig{
+			Network:    "tcp",
+			ListenIP:   "127.0.0.1",
+			ListenPort: 9231,
+		})
+		helloPath := endpointSvr.RouteCallFunc(Hello)
+		t.Assert(helloPath, "/hello")
+		pushPath := endpointSvr.SubRoute("/typed").RoutePushFunc(func(ctx context.Context, req *HelloReq) error {
+			_, ok := drpc.PushCtxFromContext(ctx)
+			_, isCall := drpc.CallCtxFromContext(ctx)
+			if ok && !isCall {
+				pushed <- req.Name
+			}
+			return nil
+		})
+		defer endpointSvr.Close()
+		go endpointSvr.ListenAndServe()
+		time.Sleep(300 * time.Millisecond)
+
+		endpointCli := drpc.NewEndpoint(drpc.EndpointConfig{})
+		defer endpointCli.Close()
+		sess, stat := endpointCli.Dial("127.0.0.1:9231")
+		t.Assert(stat.OK(), true)
+
+		resp, stat := drpc.Invoke[HelloReq, HelloResp](sess, helloPath, &HelloReq{Name: "world"}, drpc.WithRealIP("1.1.1.1"))
+		t.Assert(stat.OK(), true)
+		t.Assert(resp.Greeting, "hello world")
+		t.Assert(resp.RealIP, "1.1.1.1")
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		resp, stat = drpc.InvokeContext[HelloReq, HelloResp](ctx, sess, helloPath, &HelloReq{})
+		t.Assert(stat.Code(), 1001)
+		t.Assert(resp, nil)
+
+		_, stat = drpc.Invoke[HelloReq, HelloResp](sess, helloPath, &HelloReq{Name: "panic"})
+		t.Assert(stat.Code(), drpc.CodeInternalServerError)
+
+		t.Assert(sess.Push(pushPath, &HelloReq{Name: "push"}).OK(), true)
+		t.Assert(<-pushed, "push")
+	})
+}
+
+func TestStatusFromError(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		t.Assert(drpc.StatusFromError(nil).OK(), true)
+		t.Assert(drpc.StatusFromError(context.DeadlineExceeded).Code(), drpc.CodeHandleTimeout)
+		t.Assert(drpc.StatusFromError(gerror.Wrap(context.Canceled, "wrap")).Code(), drpc.CodeCallCanceled)
+		t.Assert(drpc.StatusFromError(gerror.NewCode(gcode.CodeNotFound)).Code(), gcode.CodeNotFound.Code())
+		stat := drpc.StatusFromError(errors.New("oops"))
+		t.Assert(stat.Code(), drpc.CodeInternalServerError)
+		t.Assert(stat.Cause().Error(), "oops")
+	})
+}
+
+type Greeter struct {
+	drpc.CallCtx
+	prefix string
+}
+
+func (that *Greeter) Say(req *HelloReq) (*HelloResp, *drpc.Status) {
+	return &HelloResp{Greeting: that.prefix + req.Name}, nil
+}
+
+func TestRouteCallInject(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		endpointSvr := drpc.NewEndpoint(drpc.EndpointConfig{
+			Network:    "tcp",
+			ListenIP:   "127.0.0.1",
+			ListenPort: 9232,
+		})
+		// 每次请求使用的实例由工厂创建
+		var router drpc.CallRouter = endpointSvr
+		names := router.RouteCall(drpc.CtrlFactory(func() interface{} {
+			return &Greeter{prefix: "hi "}
+		}))
+		t.Assert(names, []string{"/greeter/say"})
+		// 直接传入实例时只使用它的类型，字段不会复制
+		names = endpointSvr.SubRoute("/plain").RouteCall(&Greeter{prefix: "ignored "})
+		t.Assert(names, []string{"/plain/greeter/say"})
+		defer endpointSvr.Close()
+		go endpointSvr.ListenAndServe()
+		time.Sleep(300 * time.Millisecond)
+
+		endpointCli := drpc.NewEndpoint(drpc.EndpointConfig{})
+		defer endpointCli.Close()
+		sess, stat := endpointCli.Dial("127.0.0.1:9232")
+		t.Assert(stat.OK(), true)
+
+		var caller drpc.Caller = sess
+		for i := 0; i < 3; i++ {
+			resp, stat := drpc.Invoke[HelloReq, HelloResp](caller, "/greeter/say", &HelloReq{Name: "drpc"})
+			t.Assert(stat.OK(), true)
+			t.Assert(resp.Greeting, "hi drpc")
+		}
+		resp, stat := drpc.Invoke[HelloReq, HelloResp](caller, "/plain/greeter/say", &HelloReq{Name: "drpc"})
+		t.Assert(stat.OK(), true)
+		t.Assert(resp.Greeting, "drpc")
+	})
+}
@@ -7,7 +7,10 @@ import (
 	"github.com/osgochina/dmicro/drpc/netproto/normal"
 	"github.com/osgochina/dmicro/drpc/netproto/quic"
 	"github.com/osgochina/dmicro/utils"
+	"io"
 	"net"
+	"sync"
+	"time"
 )
 
 var testTLSConfig = utils.GenerateTLSConfigForServer()
@@ -35,3 +38,93 @@ func NewInheritedListener(addr net.Addr, tlsConfig *tls.Config) (lis net.Listene
 	}
 	return
 }
+
+// NewSniffTLSListener 创建一个同时接受tls加密链接和明文链接的监听器
+//  根据链接的第一个字节判断是否是tls握手(ClientHello)，是则使用 tlsConfig 建立tls链接
+//  timeout 等待第一个字节的超时时间，为0时不限制
+func NewSniffTLSListener(lis net.Listener, tlsConfig *tls.Config, timeout time.Duration) net.Listener {
+	return &sniffTLSListener{Listener: lis, tlsConfig: tlsConfig, timeout: timeout}
+}
+
+type sniffTLSListener struct {
+	net.Listener
+	tlsConfig *tls.Config
+	timeout   time.Duration
+}
+
+// Accept 接受链接，不在这里读取数据，避免阻塞监听
+func (that *sniffTLSListener) Accept() (net.Conn, error) {
+	conn, err := that.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &sniffConn{Conn: conn, tlsConfig: that.tlsConfig, timeout: that.timeout}, nil
+}
+
+// tls记录层握手消息的类型
+const recordTypeHandshake = 0x16
+
+// 第一次读写时判断是否是tls链接的链接
+type sniffConn struct {
+	net.Conn
+	tlsConfig *tls.Config
+	timeout   time.Duration
+	once      sync.Once
+	conn      net.Conn
+	err       error
+}
+
+// Sniff 读取第一个字节，返回tls链接或者明文链接
+func (that *sniffConn) Sniff() (net.Conn, error) {
+	that.once.Do(func() {
+		if that.timeout > 0 {
+			_ = that.Conn.SetReadDeadline(time.Now().Add(that.timeout))
+		}
+		var first [1]byte
+		_, that.err = io.ReadFull(that.Conn, first[:])
+		if that.timeout > 0 {
+			_ = that.Conn.SetReadDeadline(time.Time{})
+		}
+		if that.err != nil {
+			return
+		}
+		conn := &prefixConn{Conn: that.Conn, prefix: first[:]}
+		if first[0] == recordTypeHandshake {
+			that.conn = tls.Server(conn, that.tlsConfig)
+		} else {
+			that.conn = conn
+		}
+	})
+	return that.conn, that.err
+}
+
+func (that *sniffConn) Read(b []byte) (int, error) {
+	conn, err := that.Sniff()
+	if err != nil {
+		return 0, err
+	}
+	return conn.Read(b)
+}
+
+func (that *sniffConn) Write(b []byte) (int, error) {
+	conn, err := that.Sniff()
+	if err != nil {
+		return 0, err
+	}
+	return conn.Write(b)
+}
+
+// 先读取已经读出的数据，再读取原始链接
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (that *prefixConn) Read(b []byte) (int, error) {
+	if len(that.prefix) > 0 {
+		n := copy(b, that.prefix)
+		that.prefix = that.prefix[n:]
+		return n, nil
+	}
+	return that.Conn.Read(b)
+}
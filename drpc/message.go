@@ -11,6 +11,8 @@ var (
 
 	MetaRealIP          = message.MetaRealIP
 	MetaAcceptBodyCodec = message.MetaAcceptBodyCodec
+	MetaTimeout         = message.MetaTimeout
+	MetaCancel          = message.MetaCancel
 
 	TypeUndefined = message.TypeUndefined
 	TypeCall      = message.TypeCall
@@ -18,6 +20,13 @@ var (
 	TypePush      = message.TypePush
 	TypeAuthCall  = message.TypeAuthCall
 	TypeAuthReply = message.TypeAuthReply
+
+	TypeStreamOpen  = message.TypeStreamOpen
+	TypeStreamData  = message.TypeStreamData
+	TypeStreamReply = message.TypeStreamReply
+	TypeStreamClose = message.TypeStreamClose
+	TypeStreamEnd   = message.TypeStreamEnd
+	TypeCancel      = message.TypeCancel
 )
 
 var (
@@ -17,8 +17,21 @@ const (
 	TypePush      byte = 3
 	TypeAuthCall  byte = 4
 	TypeAuthReply byte = 5
+
+	TypeStreamOpen  byte = 6  // 打开流，由流的发起方发送
+	TypeStreamData  byte = 7  // 流数据帧，由流的发起方发送
+	TypeStreamReply byte = 8  // 流数据帧，由流的处理方发送
+	TypeStreamClose byte = 9  // 关闭流的发送端，由流的发起方发送
+	TypeStreamEnd   byte = 10 // 流处理结束，由流的处理方发送，携带最终状态
+
+	TypeCancel byte = 11 // 取消正在执行的call请求，由调用方发送
 )
 
+// IsStreamType 判断消息类型是否属于流消息
+func IsStreamType(typ byte) bool {
+	return typ >= TypeStreamOpen && typ <= TypeStreamEnd
+}
+
 func TypeText(typ byte) string {
 	switch typ {
 	case TypeCall:
@@ -31,6 +44,18 @@ func TypeText(typ byte) string {
 		return "AUTH_CALL"
 	case TypeAuthReply:
 		return "AUTH_REPLY"
+	case TypeStreamOpen:
+		return "STREAM_OPEN"
+	case TypeStreamData:
+		return "STREAM_DATA"
+	case TypeStreamReply:
+		return "STREAM_REPLY"
+	case TypeStreamClose:
+		return "STREAM_CLOSE"
+	case TypeStreamEnd:
+		return "STREAM_END"
+	case TypeCancel:
+		return "CANCEL"
 	default:
 		return "Undefined"
 	}
@@ -41,6 +66,10 @@ const (
 	MetaRealIP = "X-Real-IP"
 	// MetaAcceptBodyCodec the key of body codec that the sender wishes to accept
 	MetaAcceptBodyCodec = "X-Accept-Body-Codec"
+	// MetaTimeout the remaining handling time of the caller, e.g. "1.5s"
+	MetaTimeout = "X-Timeout"
+	// MetaCancel set in the first reply of a session, indicating that the sender accepts TypeCancel
+	MetaCancel = "X-Cancel"
 )
 
 var (
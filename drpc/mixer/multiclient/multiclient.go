@@ -83,6 +83,49 @@ func (that *MultiClient) Call(uri string, arg interface{}, result interface{}, s
 	return callCmd
 }
 
+// CallContext 使用 ctx 发起阻塞请求，ctx 结束时返回超时或者取消的状态
+//  建立链接的过程中 ctx 结束时不再等待，建立好的链接仍然放回连接池
+func (that *MultiClient) CallContext(ctx context.Context, uri string, arg interface{}, result interface{}, setting ...message.MsgSetting) drpc.CallCmd {
+	if err := ctx.Err(); err != nil {
+		return drpc.NewFakeCallCmd(uri, arg, result, drpc.StatusFromError(err))
+	}
+	sess, stat := that.getContext(ctx)
+	if stat != nil {
+		return drpc.NewFakeCallCmd(uri, arg, result, stat)
+	}
+	callCmd := sess.AsyncCall(uri, arg, result, make(chan drpc.CallCmd, 1), append([]message.MsgSetting{message.WithContext(ctx)}, setting...)...)
+	_ = that.pool.Put(sess)
+	<-callCmd.Done()
+	return callCmd
+}
+
+// 从连接池获取链接，ctx 结束时直接返回
+func (that *MultiClient) getContext(ctx context.Context) (drpc.Session, *drpc.Status) {
+	type got struct {
+		sess interface{}
+		err  error
+	}
+	ch := make(chan got, 1)
+	go func() {
+		sess, err := that.pool.Get()
+		ch <- got{sess, err}
+	}()
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return nil, drpc.NewStatusByCodeText(drpc.CodeWrongConn, r.err, false)
+		}
+		return r.sess.(drpc.Session), nil
+	case <-ctx.Done():
+		go func() {
+			if r := <-ch; r.err == nil {
+				_ = that.pool.Put(r.sess)
+			}
+		}()
+		return nil, drpc.StatusFromError(ctx.Err())
+	}
+}
+
 // Push 发送push消息
 func (that *MultiClient) Push(uri string, arg interface{}, setting ...message.MsgSetting) *drpc.Status {
 	_sess, err := that.pool.Get()
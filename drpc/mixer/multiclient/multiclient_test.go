@@ -1,6 +1,7 @@
 package multiclient_test
 
 import (
+	"context"
 	"github.com/gogf/gf/v2/test/gtest"
 	"github.com/osgochina/dmicro/drpc"
 	"github.com/osgochina/dmicro/drpc/mixer/multiclient"
@@ -59,3 +60,23 @@ func TestMultiClient(t *testing.T) {
 		time.Sleep(time.Second * 3)
 	})
 }
+
+// 建立链接的过程中 ctx 结束时不再等待
+func TestMultiClientCallContextDial(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		cli := multiclient.New(
+			drpc.NewEndpoint(drpc.EndpointConfig{RedialTimes: 20, RedialInterval: 100 * time.Millisecond}),
+			"127.0.0.1:9108",
+			time.Second*5,
+		)
+		defer cli.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		var result int
+		stat := cli.CallContext(ctx, "/p/divide", &Arg{A: 1, B: 2}, &result).Status()
+		t.Assert(stat.Code(), drpc.CodeHandleTimeout)
+		t.AssertLT(time.Since(start), time.Second)
+	})
+}
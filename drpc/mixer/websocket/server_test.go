@@ -163,3 +163,58 @@ func TestCustomizedWebsocket(t *testing.T) {
 	})
 
 }
+
+type S struct {
+	drpc.StreamCtx
+}
+
+// Echo 把收到的每一帧数据原样返回，发起方关闭发送端后结束
+func (s *S) Echo(_ *Arg) *drpc.Status {
+	for {
+		var n int
+		stat := s.Recv(&n)
+		if drpc.IsStreamEOF(stat) {
+			return nil
+		}
+		if !stat.OK() {
+			return stat
+		}
+		if stat = s.Send(n); !stat.OK() {
+			return stat
+		}
+	}
+}
+
+// 测试websocket上的双向流，数据帧的数量超过流的接收窗口
+func TestWebsocketStream(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		srv := websocket.NewServer("/", drpc.EndpointConfig{ListenPort: 9263})
+		srv.RouteStream(new(S))
+		defer srv.Close()
+		go srv.ListenAndServeJSON()
+		time.Sleep(time.Second * 1)
+		cli := websocket.NewClient("/", drpc.EndpointConfig{})
+		sess, stat := cli.Dial(":9263")
+		t.Assert(stat.OK(), true)
+
+		stream, stat := sess.OpenStream("/s/echo", &Arg{})
+		t.Assert(stat.OK(), true)
+		go func() {
+			for i := 0; i < 200; i++ {
+				if !stream.Send(i).OK() {
+					return
+				}
+			}
+			stream.CloseSend()
+		}()
+		for i := 0; i < 200; i++ {
+			var n int
+			t.Assert(stream.Recv(&n).OK(), true)
+			t.Assert(n, i)
+		}
+		var n int
+		t.Assert(drpc.IsStreamEOF(stream.Recv(&n)), true)
+		<-stream.Done()
+		t.Assert(stream.Status().OK(), true)
+	})
+}
@@ -0,0 +1,85 @@
+package openapi
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// 从源码中读取的注释，key 为 "包名.类型名" 或 "包名.类型名.字段名"
+type comments map[string]string
+
+// 解析目录下的源码文件，收集结构体以及字段的注释
+func parseComments(dirs []string) (comments, error) {
+	c := make(comments)
+	fset := token.NewFileSet()
+	for _, dir := range dirs {
+		files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if strings.HasSuffix(file, "_test.go") {
+				continue
+			}
+			f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+			if err != nil {
+				return nil, err
+			}
+			c.collect(f)
+		}
+	}
+	return c, nil
+}
+
+func (that comments) collect(f *ast.File) {
+	pkg := f.Name.Name
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			ts := spec.(*ast.TypeSpec)
+			st, ok := ts.Type.(*ast.StructType)
+			if !ok {
+				continue
+			}
+			key := pkg + "." + ts.Name.Name
+			doc := ts.Doc
+			//单独声明的类型，注释在 type 关键字之前
+			if doc == nil && len(gen.Specs) == 1 {
+				doc = gen.Doc
+			}
+			that.set(key, doc)
+			for _, field := range st.Fields.List {
+				doc = field.Doc
+				if doc == nil {
+					doc = field.Comment
+				}
+				for _, name := range field.Names {
+					that.set(key+"."+name.Name, doc)
+				}
+			}
+		}
+	}
+}
+
+func (that comments) set(key string, doc *ast.CommentGroup) {
+	if text := strings.TrimSpace(doc.Text()); text != "" {
+		that[key] = text
+	}
+}
+
+// 结构体的注释
+func (that comments) describeType(t reflect.Type) string {
+	return that[t.String()]
+}
+
+// 字段的注释
+func (that comments) describeField(t reflect.Type, field reflect.StructField) string {
+	return that[t.String()+"."+field.Name]
+}
@@ -0,0 +1,84 @@
+package openapi
+
+import (
+	"encoding/json"
+	"github.com/osgochina/dmicro/utils/jsonschema"
+)
+
+// Version 生成的文档遵循的 OpenAPI 版本
+const Version = "3.0.3"
+
+// Document OpenAPI 文档
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Servers    []Server             `json:"servers,omitempty"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+// Info 文档的基础信息
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Server 服务地址
+type Server struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem 路径对应的操作，httpproto 的所有请求都使用 POST 方法
+type PathItem struct {
+	Post *Operation `json:"post,omitempty"`
+}
+
+// Operation 操作，对应一个 CALL 或 PUSH 处理程序
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+	// DrpcType 处理程序的类型 call/push
+	DrpcType string `json:"x-drpc-type"`
+}
+
+// Parameter 请求参数
+type Parameter struct {
+	Name        string             `json:"name"`
+	In          string             `json:"in"`
+	Description string             `json:"description,omitempty"`
+	Required    bool               `json:"required,omitempty"`
+	Schema      *jsonschema.Schema `json:"schema"`
+}
+
+// RequestBody 请求体
+type RequestBody struct {
+	Required bool                  `json:"required,omitempty"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response 响应
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// MediaType 指定内容格式的结构描述
+type MediaType struct {
+	Schema *jsonschema.Schema `json:"schema"`
+}
+
+// Components 可复用的结构定义
+type Components struct {
+	Schemas map[string]*jsonschema.Schema `json:"schemas,omitempty"`
+}
+
+// JSON 把文档编码成格式化后的json
+func (that *Document) JSON() ([]byte, error) {
+	return json.MarshalIndent(that, "", "  ")
+}
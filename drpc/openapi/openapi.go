@@ -0,0 +1,214 @@
+// Package openapi 根据路由器中注册的 CALL、PUSH 处理程序生成 OpenAPI 3 文档
+//  文档描述的是 httpproto 协议下的接口:
+//  所有请求都使用 POST 方法，请求路径即处理程序的路径，带参数的路径 /user/:id 转换成 /user/{id}
+//  请求体与响应体的格式由 Content-Type 决定，对应关系与 httpproto 的编解码器映射一致
+//  业务错误返回 "299 Business Error"，响应体为json格式的 Status
+//  PUSH 请求需要携带 X-MType 头，服务端不返回响应
+package openapi
+
+import (
+	"github.com/gogf/gf/v2/os/gfile"
+	"github.com/osgochina/dmicro/drpc"
+	"github.com/osgochina/dmicro/drpc/codec"
+	"github.com/osgochina/dmicro/drpc/message"
+	"github.com/osgochina/dmicro/drpc/proto/httpproto"
+	"github.com/osgochina/dmicro/utils/jsonschema"
+	"strconv"
+	"strings"
+)
+
+const (
+	schemaRefPrefix = "#/components/schemas/"
+	// StatusSchemaName 业务错误响应体在 components 中的名字
+	StatusSchemaName = "drpc.Status"
+	// BusinessErrorCode httpproto 返回业务错误时使用的状态码
+	BusinessErrorCode = "299"
+)
+
+// Generate 生成路由器中处理程序的 OpenAPI 文档
+// STREAM 处理程序不支持 http 协议，文档插件注册的 ServiceMethod 路由也不写入文档
+func Generate(router *drpc.Router, opts ...Option) (*Document, error) {
+	o := newOptions(opts...)
+	contentTypes, err := getContentTypes(o.BodyCodecs)
+	if err != nil {
+		return nil, err
+	}
+	r := jsonschema.NewReflector(schemaRefPrefix)
+	if len(o.SourceDirs) > 0 {
+		c, err := parseComments(o.SourceDirs)
+		if err != nil {
+			return nil, err
+		}
+		r.Describe = c.describeField
+		r.DescribeType = c.describeType
+	}
+	doc := &Document{
+		OpenAPI: Version,
+		Info: Info{
+			Title:       o.Title,
+			Description: o.Description,
+			Version:     o.Version,
+		},
+		Servers: o.Servers,
+		Paths:   make(map[string]*PathItem),
+	}
+	for _, h := range router.Handlers() {
+		if (!h.IsCall() && !h.IsPush()) || h.Name() == ServiceMethod {
+			continue
+		}
+		if o.Filter != nil && !o.Filter(h) {
+			continue
+		}
+		p, params := convertPath(h.Name())
+		//同一路径同时注册了 CALL 和 PUSH 时，http 协议下无法区分，只保留先出现的
+		if _, ok := doc.Paths[p]; ok {
+			continue
+		}
+		doc.Paths[p] = &PathItem{Post: newOperation(h, params, r, contentTypes)}
+	}
+	r.Definitions[StatusSchemaName] = statusSchema()
+	doc.Components.Schemas = r.Definitions
+	return doc, nil
+}
+
+// WriteFile 生成文档并写入文件，可以在 go generate 中调用
+func WriteFile(router *drpc.Router, filename string, opts ...Option) error {
+	doc, err := Generate(router, opts...)
+	if err != nil {
+		return err
+	}
+	b, err := doc.JSON()
+	if err != nil {
+		return err
+	}
+	return gfile.PutBytes(filename, b)
+}
+
+// 生成处理程序对应的操作
+func newOperation(h *drpc.Handler, params []*Parameter, r *jsonschema.Reflector, contentTypes []string) *Operation {
+	op := &Operation{
+		OperationID: operationID(h.Name()),
+		Summary:     h.Name(),
+		Parameters:  params,
+		RequestBody: &RequestBody{
+			Required: true,
+			Content:  newContent(r.Reflect(h.ArgElemType()), contentTypes),
+		},
+		Responses: make(map[string]*Response),
+	}
+	if tag := pathTag(h.Name()); tag != "" {
+		op.Tags = []string{tag}
+	}
+	if h.IsPush() {
+		op.DrpcType = "push"
+		op.Parameters = append(op.Parameters, &Parameter{
+			Name:        "X-MType",
+			In:          "header",
+			Description: "消息类型，PUSH消息固定为 " + strconv.Itoa(int(message.TypePush)),
+			Required:    true,
+			Schema:      &jsonschema.Schema{Type: "integer", Enum: []interface{}{message.TypePush}},
+		})
+		op.Responses["default"] = &Response{Description: "PUSH消息没有响应"}
+		return op
+	}
+	op.DrpcType = "call"
+	op.Responses["200"] = &Response{
+		Description: "OK",
+		Content:     newContent(r.Reflect(h.ReplyType()), contentTypes),
+	}
+	op.Responses[BusinessErrorCode] = &Response{
+		Description: "Business Error",
+		Content: map[string]*MediaType{
+			"application/json": {Schema: &jsonschema.Schema{Ref: schemaRefPrefix + StatusSchemaName}},
+		},
+	}
+	return op
+}
+
+func newContent(schema *jsonschema.Schema, contentTypes []string) map[string]*MediaType {
+	content := make(map[string]*MediaType, len(contentTypes))
+	for _, ct := range contentTypes {
+		content[ct] = &MediaType{Schema: schema}
+	}
+	return content
+}
+
+// 通过 httpproto 的映射关系，把编解码器名字转换成 Content-Type
+func getContentTypes(codecNames []string) ([]string, error) {
+	contentTypes := make([]string, 0, len(codecNames))
+	for _, name := range codecNames {
+		c, err := codec.GetByName(name)
+		if err != nil {
+			return nil, err
+		}
+		ct := httpproto.GetContentType(c.ID(), "text/plain")
+		if idx := strings.Index(ct, ";"); idx != -1 {
+			ct = ct[:idx]
+		}
+		contentTypes = append(contentTypes, ct)
+	}
+	return contentTypes, nil
+}
+
+// 把路由路径转换成 OpenAPI 的路径，并返回路径参数
+//  /user/:id/profile -> /user/{id}/profile
+//  /files/*path -> /files/{path}
+func convertPath(name string) (string, []*Parameter) {
+	segments := strings.Split(name, "/")
+	var params []*Parameter
+	for i, seg := range segments {
+		if len(seg) < 2 || (seg[0] != ':' && seg[0] != '*') {
+			continue
+		}
+		param := &Parameter{
+			Name:     seg[1:],
+			In:       "path",
+			Required: true,
+			Schema:   &jsonschema.Schema{Type: "string"},
+		}
+		if seg[0] == '*' {
+			param.Description = "匹配剩余的全部路径"
+		}
+		params = append(params, param)
+		segments[i] = "{" + seg[1:] + "}"
+	}
+	p := strings.Join(segments, "/")
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return p, params
+}
+
+// 使用路径的第一段作为分组标签
+func pathTag(name string) string {
+	seg := strings.SplitN(strings.TrimLeft(name, "/"), "/", 2)[0]
+	if strings.ContainsAny(seg, ":*") {
+		return ""
+	}
+	return seg
+}
+
+// 生成操作的唯一标识，只保留字母和数字，其他字符转换成下划线
+func operationID(name string) string {
+	id := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+	return strings.Trim(id, "_")
+}
+
+// 业务错误时响应体的结构，与 Status 的json编码一致
+func statusSchema() *jsonschema.Schema {
+	return &jsonschema.Schema{
+		Type: "object",
+		Properties: map[string]*jsonschema.Schema{
+			"code":  {Type: "integer", Format: "int32", Description: "错误码"},
+			"msg":   {Type: "string", Description: "错误信息"},
+			"cause": {Type: "string", Description: "错误原因"},
+		},
+		Required: []string{"code", "msg"},
+	}
+}
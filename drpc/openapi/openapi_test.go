@@ -0,0 +1,130 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/osgochina/dmicro/drpc"
+	"github.com/osgochina/dmicro/drpc/openapi"
+	"github.com/osgochina/dmicro/drpc/proto/httpproto"
+)
+
+// 注释解析时使用的源码，与下面的类型定义保持一致
+const itemSource = `package openapi_test
+
+// Item 商品
+type Item struct {
+	// 商品编号
+	Id   int64  ` + "`json:\"id\"`" + `
+	Name string ` + "`json:\"name,omitempty\"`" + ` // 商品名称
+}
+`
+
+// Item 商品
+type Item struct {
+	// 商品编号
+	Id   int64  `json:"id"`
+	Name string `json:"name,omitempty"` // 商品名称
+}
+
+type Shop struct {
+	drpc.CallCtx
+}
+
+func (that *Shop) Get(arg *int64) (*Item, *drpc.Status) {
+	return &Item{Id: *arg}, nil
+}
+
+type Notify struct {
+	drpc.PushCtx
+}
+
+func (that *Notify) Item(arg *Item) *drpc.Status {
+	return nil
+}
+
+func TestGenerate(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		dir := t.TempDir()
+		t.AssertNil(os.WriteFile(filepath.Join(dir, "item.go"), []byte(itemSource), 0644))
+
+		endpoint := drpc.NewEndpoint(drpc.EndpointConfig{})
+		defer endpoint.Close()
+		endpoint.RouteCall(new(Shop))
+		endpoint.SubRoute("/user/:uid").RouteCall(new(Shop))
+		endpoint.RoutePush(new(Notify))
+
+		doc, err := openapi.Generate(endpoint.Router(),
+			openapi.OptTitle("shop"),
+			openapi.OptServer("http://127.0.0.1:9251"),
+			openapi.OptBodyCodec("json", "protobuf"),
+			openapi.OptSourceDir(dir),
+		)
+		t.AssertNil(err)
+		t.Assert(doc.OpenAPI, openapi.Version)
+		t.Assert(doc.Info.Title, "shop")
+		t.Assert(len(doc.Paths), 3)
+
+		get := doc.Paths["/shop/get"].Post
+		t.Assert(get.DrpcType, "call")
+		t.Assert(get.Tags, []string{"shop"})
+		t.Assert(get.RequestBody.Content["application/json"].Schema.Type, "integer")
+		t.Assert(get.RequestBody.Content["application/x-protobuf"].Schema.Type, "integer")
+		t.Assert(get.Responses["200"].Content["application/json"].Schema.Ref, "#/components/schemas/Item")
+		t.Assert(get.Responses[openapi.BusinessErrorCode].Content["application/json"].Schema.Ref, "#/components/schemas/"+openapi.StatusSchemaName)
+
+		param := doc.Paths["/user/{uid}/shop/get"].Post
+		t.Assert(len(param.Parameters), 1)
+		t.Assert(param.Parameters[0].Name, "uid")
+		t.Assert(param.Parameters[0].In, "path")
+
+		push := doc.Paths["/notify/item"].Post
+		t.Assert(push.DrpcType, "push")
+		t.Assert(push.Parameters[0].Name, "X-MType")
+		_, ok := push.Responses["default"]
+		t.Assert(ok, true)
+
+		item := doc.Components.Schemas["Item"]
+		t.Assert(item.Description, "Item 商品")
+		t.Assert(item.Properties["id"].Description, "商品编号")
+		t.Assert(item.Properties["name"].Description, "商品名称")
+
+		file := filepath.Join(dir, "openapi.json")
+		t.AssertNil(openapi.WriteFile(endpoint.Router(), file))
+		b, err := os.ReadFile(file)
+		t.AssertNil(err)
+		t.Assert(json.Valid(b), true)
+	})
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		svr := drpc.NewEndpoint(drpc.EndpointConfig{ListenPort: 9251}, openapi.NewOpenAPI(openapi.OptTitle("shop")))
+		defer svr.Close()
+		svr.RouteCall(new(Shop))
+		go svr.ListenAndServe(httpproto.NewHTTProtoFunc())
+		time.Sleep(300 * time.Millisecond)
+
+		resp, err := http.Post("http://127.0.0.1:9251"+openapi.ServiceMethod, "application/json", strings.NewReader("{}"))
+		t.AssertNil(err)
+		b, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		t.AssertNil(err)
+		t.Assert(resp.StatusCode, http.StatusOK)
+
+		var doc openapi.Document
+		t.AssertNil(json.Unmarshal(b, &doc))
+		t.Assert(doc.Info.Title, "shop")
+		_, ok := doc.Paths["/shop/get"]
+		t.Assert(ok, true)
+		_, ok = doc.Paths[openapi.ServiceMethod]
+		t.Assert(ok, false)
+	})
+}
@@ -0,0 +1,84 @@
+package openapi
+
+import "github.com/osgochina/dmicro/drpc"
+
+// Options 文档生成配置
+type Options struct {
+	Title       string                   // 文档标题
+	Description string                   // 文档描述
+	Version     string                   // 接口版本
+	Servers     []Server                 // 服务地址列表
+	BodyCodecs  []string                 // 请求和响应支持的编码格式，通过 httpproto 的映射转换成 Content-Type
+	SourceDirs  []string                 // 源码目录，用于读取结构体以及字段的注释
+	Filter      func(*drpc.Handler) bool // 过滤处理程序，返回false的不生成文档
+}
+
+// Option 文档生成配置函数
+type Option func(*Options)
+
+// OptTitle 设置文档标题
+func OptTitle(title string) Option {
+	return func(o *Options) {
+		o.Title = title
+	}
+}
+
+// OptDescription 设置文档描述
+func OptDescription(desc string) Option {
+	return func(o *Options) {
+		o.Description = desc
+	}
+}
+
+// OptVersion 设置接口版本
+func OptVersion(version string) Option {
+	return func(o *Options) {
+		o.Version = version
+	}
+}
+
+// OptServer 添加服务地址，例如 http://127.0.0.1:9090
+func OptServer(url string, desc ...string) Option {
+	return func(o *Options) {
+		s := Server{URL: url}
+		if len(desc) > 0 {
+			s.Description = desc[0]
+		}
+		o.Servers = append(o.Servers, s)
+	}
+}
+
+// OptBodyCodec 设置支持的编码格式，例如 json、protobuf、form，默认使用 drpc.DefaultBodyCodec()
+func OptBodyCodec(codecNames ...string) Option {
+	return func(o *Options) {
+		o.BodyCodecs = codecNames
+	}
+}
+
+// OptSourceDir 设置源码目录，结构体及字段的注释会写入文档的描述中
+func OptSourceDir(dirs ...string) Option {
+	return func(o *Options) {
+		o.SourceDirs = append(o.SourceDirs, dirs...)
+	}
+}
+
+// OptFilter 设置处理程序的过滤函数
+func OptFilter(filter func(*drpc.Handler) bool) Option {
+	return func(o *Options) {
+		o.Filter = filter
+	}
+}
+
+func newOptions(opts ...Option) *Options {
+	o := &Options{
+		Title:   "drpc",
+		Version: "1.0.0",
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if len(o.BodyCodecs) == 0 {
+		o.BodyCodecs = []string{drpc.DefaultBodyCodec().Name()}
+	}
+	return o
+}
@@ -0,0 +1,48 @@
+package openapi
+
+import (
+	"github.com/osgochina/dmicro/drpc"
+)
+
+// ServiceMethod 获取文档的路由
+const ServiceMethod = "/openapi"
+
+const pluginName = "openapi"
+
+var _ drpc.AfterNewEndpointPlugin = new(openapiPlugin)
+
+// NewOpenAPI 创建文档插件，端点启动时注册 ServiceMethod 路由，调用该路由返回当前端点的 OpenAPI 文档
+//  例如使用 httpproto 时: curl -X POST http://127.0.0.1:9090/openapi
+func NewOpenAPI(opts ...Option) drpc.Plugin {
+	return &openapiPlugin{opts: opts}
+}
+
+type openapiPlugin struct {
+	opts []Option
+}
+
+func (that *openapiPlugin) Name() string {
+	return pluginName
+}
+
+// AfterNewEndpoint 端点创建后注册文档路由
+func (that *openapiPlugin) AfterNewEndpoint(endpoint drpc.EarlyEndpoint) error {
+	endpoint.RouteCallFunc((*openapiCall).openapi)
+	return nil
+}
+
+type openapiCall struct {
+	drpc.CallCtx
+}
+
+func (that *openapiCall) openapi(_ *struct{}) (*Document, *drpc.Status) {
+	var opts []Option
+	if p, ok := that.Endpoint().PluginContainer().GetByName(pluginName).(*openapiPlugin); ok {
+		opts = p.opts
+	}
+	doc, err := Generate(that.Endpoint().Router(), opts...)
+	if err != nil {
+		return nil, drpc.StatusFromError(err)
+	}
+	return doc, nil
+}
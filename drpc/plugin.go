@@ -190,6 +190,8 @@ func warnInvalidHandlerHooks(plugin []Plugin) {
 			internal.Debugf(ctx, "invalid AfterReadCallHeaderPlugin in router: %s", p.Name())
 		case AfterReadPushHeaderPlugin:
 			internal.Debugf(ctx, "invalid AfterReadPushHeaderPlugin in router: %s", p.Name())
+		case ClientCallInterceptPlugin:
+			internal.Debugf(ctx, "invalid ClientCallInterceptPlugin in router: %s", p.Name())
 		}
 	}
 }
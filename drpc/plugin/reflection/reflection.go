@@ -0,0 +1,120 @@
+// Package reflection 反射服务插件，客户端可以在运行期间查询端点提供了哪些处理程序
+package reflection
+
+import (
+	"github.com/osgochina/dmicro/drpc"
+	"github.com/osgochina/dmicro/utils/jsonschema"
+	"strings"
+)
+
+// ServicePrefix 反射服务的路由前缀
+const ServicePrefix = "/reflection"
+
+// ListServiceMethod 查询处理程序列表的路由
+const ListServiceMethod = ServicePrefix + "/list"
+
+// 处理程序的类型
+const (
+	KindCall   = "call"
+	KindPush   = "push"
+	KindStream = "stream"
+)
+
+// ListArgs 查询参数
+type ListArgs struct {
+	// Prefix 只返回以该前缀开头的路由，为空则返回全部
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// HandlerDesc 处理程序的描述
+type HandlerDesc struct {
+	Name  string             `json:"name"`
+	Kind  string             `json:"kind"`
+	Arg   *jsonschema.Schema `json:"arg,omitempty"`
+	Reply *jsonschema.Schema `json:"reply,omitempty"`
+}
+
+// ListReply 查询结果，参数和返回值中引用的结构体定义统一放在 Defs 中
+type ListReply struct {
+	Handlers []*HandlerDesc                `json:"handlers"`
+	Defs     map[string]*jsonschema.Schema `json:"$defs,omitempty"`
+}
+
+var _ drpc.AfterNewEndpointPlugin = new(reflectionPlugin)
+
+// NewReflection 创建反射服务插件，端点启动时注册 ListServiceMethod 路由
+func NewReflection() drpc.Plugin {
+	return new(reflectionPlugin)
+}
+
+type reflectionPlugin struct{}
+
+func (that *reflectionPlugin) Name() string {
+	return "reflection"
+}
+
+// AfterNewEndpoint 端点创建后注册反射服务
+func (that *reflectionPlugin) AfterNewEndpoint(endpoint drpc.EarlyEndpoint) error {
+	endpoint.SubRoute(ServicePrefix).RouteCallFunc((*reflectionCall).list)
+	return nil
+}
+
+type reflectionCall struct {
+	drpc.CallCtx
+}
+
+func (that *reflectionCall) list(args *ListArgs) (*ListReply, *drpc.Status) {
+	handlers := that.Endpoint().Router().Handlers()
+	if args.Prefix != "" {
+		filtered := handlers[:0]
+		for _, h := range handlers {
+			if strings.HasPrefix(h.Name(), args.Prefix) {
+				filtered = append(filtered, h)
+			}
+		}
+		handlers = filtered
+	}
+	return Describe(handlers), nil
+}
+
+// Describe 生成处理程序的描述
+func Describe(handlers []*drpc.Handler) *ListReply {
+	r := jsonschema.NewReflector()
+	reply := &ListReply{Handlers: make([]*HandlerDesc, 0, len(handlers))}
+	for _, h := range handlers {
+		desc := &HandlerDesc{Name: h.Name(), Kind: Kind(h)}
+		if h.ArgElemType() != nil {
+			desc.Arg = r.Reflect(h.ArgElemType())
+		}
+		if h.ReplyType() != nil {
+			desc.Reply = r.Reflect(h.ReplyType())
+		}
+		reply.Handlers = append(reply.Handlers, desc)
+	}
+	if len(r.Definitions) > 0 {
+		reply.Defs = r.Definitions
+	}
+	return reply
+}
+
+// Kind 获取处理程序的类型
+func Kind(h *drpc.Handler) string {
+	switch {
+	case h.IsCall():
+		return KindCall
+	case h.IsPush():
+		return KindPush
+	case h.IsStream():
+		return KindStream
+	}
+	return ""
+}
+
+// List 查询远端提供的处理程序
+func List(sess drpc.CtxSession, prefix ...string) (*ListReply, *drpc.Status) {
+	args := new(ListArgs)
+	if len(prefix) > 0 {
+		args.Prefix = prefix[0]
+	}
+	return drpc.Invoke[ListArgs, ListReply](sess, ListServiceMethod, args)
+}
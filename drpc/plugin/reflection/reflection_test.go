@@ -0,0 +1,71 @@
+package reflection_test
+
+import (
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/osgochina/dmicro/drpc"
+	"github.com/osgochina/dmicro/drpc/plugin/reflection"
+	"testing"
+	"time"
+)
+
+type Item struct {
+	Id   int64  `json:"id" dc:"编号"`
+	Name string `json:"name,omitempty"`
+	Next *Item  `json:"next"`
+}
+
+type Shop struct {
+	drpc.CallCtx
+}
+
+func (that *Shop) Get(arg *int64) (*Item, *drpc.Status) {
+	return &Item{Id: *arg}, nil
+}
+
+type Notify struct {
+	drpc.PushCtx
+}
+
+func (that *Notify) Item(arg *Item) *drpc.Status {
+	return nil
+}
+
+func TestReflection(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		endpointSvr := drpc.NewEndpoint(drpc.EndpointConfig{
+			Network:    "tcp",
+			ListenIP:   "127.0.0.1",
+			ListenPort: 9241,
+		}, reflection.NewReflection())
+		endpointSvr.RouteCall(new(Shop))
+		endpointSvr.RoutePush(new(Notify))
+		defer endpointSvr.Close()
+		go endpointSvr.ListenAndServe()
+		time.Sleep(300 * time.Millisecond)
+
+		endpointCli := drpc.NewEndpoint(drpc.EndpointConfig{})
+		defer endpointCli.Close()
+		sess, stat := endpointCli.Dial("127.0.0.1:9241")
+		t.Assert(stat.OK(), true)
+
+		reply, stat := reflection.List(sess)
+		t.Assert(stat.OK(), true)
+		var names []string
+		for _, h := range reply.Handlers {
+			names = append(names, h.Kind+" "+h.Name)
+		}
+		t.Assert(names, []string{"push /notify/item", "call /reflection/list", "call /shop/get"})
+
+		reply, stat = reflection.List(sess, "/shop")
+		t.Assert(stat.OK(), true)
+		t.Assert(len(reply.Handlers), 1)
+		get := reply.Handlers[0]
+		t.Assert(get.Arg.Type, "integer")
+		t.Assert(get.Reply.Ref, "#/$defs/Item")
+		item := reply.Defs["Item"]
+		t.Assert(item.Type, "object")
+		t.Assert(item.Properties["id"].Description, "编号")
+		t.Assert(item.Properties["next"].Ref, "#/$defs/Item")
+		t.Assert(item.Required, []string{"id"})
+	})
+}
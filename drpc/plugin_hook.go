@@ -107,6 +107,25 @@ func (that *pluginSingleContainer) afterRegRouter(h *Handler) {
 	}
 }
 
+// AfterUnregRouterPlugin 路由删除成功触发该事件
+type AfterUnregRouterPlugin interface {
+	Plugin
+	AfterUnregRouter(*Handler) error
+}
+
+// afterUnregRouter 路由删除成功触发该事件
+func (that *pluginSingleContainer) afterUnregRouter(h *Handler) {
+	var err error
+	for _, plugin := range that.plugins {
+		if _plugin, ok := plugin.(AfterUnregRouterPlugin); ok {
+			if err = _plugin.AfterUnregRouter(h); err != nil {
+				internal.Errorf(context.TODO(), "[AfterUnregRouter:%s] unregister handler:%s %s, error:%s", plugin.Name(), h.RouterTypeName(), h.Name(), err.Error())
+				return
+			}
+		}
+	}
+}
+
 // AfterListenPlugin 服务端监听以后触发该事件
 type AfterListenPlugin interface {
 	Plugin
@@ -559,6 +578,106 @@ func (that *pluginSingleContainer) afterReadReplyBody(ctx ReadCtx) *Status {
 	return nil
 }
 
+// BeforeWriteStreamFramePlugin 写入每一个流消息帧之前触发该事件
+type BeforeWriteStreamFramePlugin interface {
+	Plugin
+	BeforeWriteStreamFrame(WriteCtx) *Status
+}
+
+// 写入流消息帧之前执行该事件，帧的类型可以通过 ctx.Output().MType() 获取
+func (that *pluginSingleContainer) beforeWriteStreamFrame(ctx WriteCtx) *Status {
+	var stat *Status
+	for _, plugin := range that.plugins {
+		if _plugin, ok := plugin.(BeforeWriteStreamFramePlugin); ok {
+			if stat = _plugin.BeforeWriteStreamFrame(ctx); !stat.OK() {
+				internal.Debugf(context.TODO(), "[BeforeWriteStreamFramePlugin:%s] %s", plugin.Name(), stat.String())
+				return stat
+			}
+		}
+	}
+	return nil
+}
+
+// AfterWriteStreamFramePlugin 写入每一个流消息帧成功之后触发该事件
+type AfterWriteStreamFramePlugin interface {
+	Plugin
+	AfterWriteStreamFrame(WriteCtx) *Status
+}
+
+// 写入流消息帧之后执行该事件
+func (that *pluginSingleContainer) afterWriteStreamFrame(ctx WriteCtx) *Status {
+	var stat *Status
+	for _, plugin := range that.plugins {
+		if _plugin, ok := plugin.(AfterWriteStreamFramePlugin); ok {
+			if stat = _plugin.AfterWriteStreamFrame(ctx); !stat.OK() {
+				internal.Errorf(context.TODO(), "[AfterWriteStreamFramePlugin:%s] %s", plugin.Name(), stat.String())
+				return stat
+			}
+		}
+	}
+	return nil
+}
+
+// AfterReadStreamFramePlugin 读取每一个流消息帧之后触发该事件
+type AfterReadStreamFramePlugin interface {
+	Plugin
+	AfterReadStreamFrame(ReadCtx) *Status
+}
+
+// 读取流消息帧之后执行该事件，帧的类型可以通过 ctx.Input().MType() 获取
+func (that *pluginSingleContainer) afterReadStreamFrame(ctx ReadCtx) *Status {
+	var stat *Status
+	for _, plugin := range that.plugins {
+		if _plugin, ok := plugin.(AfterReadStreamFramePlugin); ok {
+			if stat = _plugin.AfterReadStreamFrame(ctx); !stat.OK() {
+				internal.Errorf(context.TODO(), "[AfterReadStreamFramePlugin:%s] %s", plugin.Name(), stat.String())
+				return stat
+			}
+		}
+	}
+	return nil
+}
+
+// AfterWriteCancelPlugin 调用方的上下文被取消，发送取消消息之后触发该事件
+type AfterWriteCancelPlugin interface {
+	Plugin
+	AfterWriteCancel(WriteCtx) *Status
+}
+
+// 发送取消消息之后执行该事件，ctx 为被取消的 call 命令
+func (that *pluginSingleContainer) afterWriteCancel(ctx WriteCtx) *Status {
+	var stat *Status
+	for _, plugin := range that.plugins {
+		if _plugin, ok := plugin.(AfterWriteCancelPlugin); ok {
+			if stat = _plugin.AfterWriteCancel(ctx); !stat.OK() {
+				internal.Errorf(context.TODO(), "[AfterWriteCancelPlugin:%s] %s", plugin.Name(), stat.String())
+				return stat
+			}
+		}
+	}
+	return nil
+}
+
+// AfterReadCancelPlugin 收到取消消息，取消正在执行的处理程序之后触发该事件
+type AfterReadCancelPlugin interface {
+	Plugin
+	AfterReadCancel(ReadCtx) *Status
+}
+
+// 读取取消消息之后执行该事件，被取消的请求序列号可以通过 ctx.Seq() 获取
+func (that *pluginSingleContainer) afterReadCancel(ctx ReadCtx) *Status {
+	var stat *Status
+	for _, plugin := range that.plugins {
+		if _plugin, ok := plugin.(AfterReadCancelPlugin); ok {
+			if stat = _plugin.AfterReadCancel(ctx); !stat.OK() {
+				internal.Errorf(context.TODO(), "[AfterReadCancelPlugin:%s] %s", plugin.Name(), stat.String())
+				return stat
+			}
+		}
+	}
+	return nil
+}
+
 // AfterDisconnectPlugin 断开会话以后触发该事件
 type AfterDisconnectPlugin interface {
 	Plugin
@@ -12,17 +12,21 @@ import (
 )
 
 const (
-	typePushLaunch int8 = 1
-	typePushHandle int8 = 2
-	typeCallLaunch int8 = 3
-	typeCallHandle int8 = 4
+	typePushLaunch   int8 = 1
+	typePushHandle   int8 = 2
+	typeCallLaunch   int8 = 3
+	typeCallHandle   int8 = 4
+	typeStreamLaunch int8 = 5
+	typeStreamHandle int8 = 6
 )
 
 const (
-	logFormatPushLaunch = "PUSH-> %s %s %q SEND(%s)"
-	logFormatPushHandle = "PUSH<- %s %s %q RECV(%s)"
-	logFormatCallLaunch = "CALL-> %s %s %q SEND(%s) RECV(%s)"
-	logFormatCallHandle = "CALL<- %s %s %q RECV(%s) SEND(%s)"
+	logFormatPushLaunch   = "PUSH-> %s %s %q SEND(%s)"
+	logFormatPushHandle   = "PUSH<- %s %s %q RECV(%s)"
+	logFormatCallLaunch   = "CALL-> %s %s %q SEND(%s) RECV(%s)"
+	logFormatCallHandle   = "CALL<- %s %s %q RECV(%s) SEND(%s)"
+	logFormatStreamLaunch = "STREAM-> %s %s %q SEND(%s)"
+	logFormatStreamHandle = "STREAM<- %s %s %q RECV(%s) END(%s)"
 )
 
 func enablePrintRunLog() bool {
@@ -68,6 +72,10 @@ func (that *session) printRunLog(realIP string, costTime time.Duration, input, o
 		printFunc(context.TODO(), logFormatCallLaunch, addr, costTimeStr, output.ServiceMethod(), messageLogBytes(output, that.endpoint.printDetail), messageLogBytes(input, that.endpoint.printDetail))
 	case typeCallHandle:
 		printFunc(context.TODO(), logFormatCallHandle, addr, costTimeStr, input.ServiceMethod(), messageLogBytes(input, that.endpoint.printDetail), messageLogBytes(output, that.endpoint.printDetail))
+	case typeStreamLaunch:
+		printFunc(context.TODO(), logFormatStreamLaunch, addr, costTimeStr, output.ServiceMethod(), messageLogBytes(output, that.endpoint.printDetail))
+	case typeStreamHandle:
+		printFunc(context.TODO(), logFormatStreamHandle, addr, costTimeStr, input.ServiceMethod(), messageLogBytes(input, that.endpoint.printDetail), messageLogBytes(output, that.endpoint.printDetail))
 	}
 }
 
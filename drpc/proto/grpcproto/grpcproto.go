@@ -0,0 +1,803 @@
+package grpcproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/osgochina/dmicro/drpc"
+	"github.com/osgochina/dmicro/drpc/codec"
+	"github.com/osgochina/dmicro/drpc/message"
+	"github.com/osgochina/dmicro/drpc/proto"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 注意，grpc协议基于http2(明文h2c，加密时使用tls链接)，兼容标准的grpc客户端和服务端，
+// 只支持 CALL 类型的一元请求以及取消请求，消息体固定使用protobuf编码，不支持tfilter以及grpc的消息压缩。
+// 作为服务端时，grpc的方法名(/pkg.Service/Method)使用 ServiceMethodMapper 转换成drpc的路由路径，
+// 作为客户端时，请求的路径原样作为grpc的方法名发送，例如: sess.Call("/helloworld.Greeter/SayHello", req, resp)
+// 元数据与http2的头互相转换，key统一使用小写，grpc保留的头不会放入元数据。
+
+const (
+	contentType = "application/grpc"
+	userAgent   = "drpc-grpcproto/1.0"
+	// 失败时额外携带的drpc原始状态
+	drpcStatusKey = "drpc-status-bin"
+	// 消息前缀的长度，{1 byte 是否压缩}{4 bytes 消息长度}
+	prefixLen = 5
+)
+
+var (
+	errBadPreface  = errors.New("grpcproto: bad client preface")
+	errConnClosed  = errors.New("grpcproto: connection closed")
+	errStreamReset = errors.New("grpcproto: stream reset")
+	errServerCall  = errors.New("grpcproto: server side can not send call")
+)
+
+// ServiceMethodMapper 把grpc的方法名转换成drpc的路由路径
+type ServiceMethodMapper func(fullMethod string) string
+
+// HTTPServiceMethodMapper 使用 drpc.HTTPServiceMethodMapper 的规则转换，与默认的路由规则一致
+//  例如: /helloworld.Greeter/SayHello -> /helloworld/greeter/say_hello
+//  对应的路由注册方式: endpoint.SubRoute("helloworld").RouteCall(new(Greeter))
+func HTTPServiceMethodMapper(fullMethod string) string {
+	return mapServiceMethod(fullMethod, drpc.HTTPServiceMethodMapper)
+}
+
+// RPCServiceMethodMapper 使用 drpc.RPCServiceMethodMapper 的规则转换
+//  例如: /helloworld.Greeter/SayHello -> helloworld.Greeter.SayHello
+func RPCServiceMethodMapper(fullMethod string) string {
+	return mapServiceMethod(fullMethod, drpc.RPCServiceMethodMapper)
+}
+
+func mapServiceMethod(fullMethod string, mapper drpc.ServiceMethodMapper) string {
+	i := strings.LastIndexByte(fullMethod, '/')
+	service, method := strings.TrimPrefix(fullMethod[:i+1], "/"), fullMethod[i+1:]
+	service = strings.TrimSuffix(service, "/")
+	if service == "" || method == "" {
+		return fullMethod
+	}
+	var serviceMethod string
+	for _, name := range strings.Split(service, ".") {
+		serviceMethod = mapper(serviceMethod, name)
+	}
+	return mapper(serviceMethod, method)
+}
+
+// NewGRPCProtoFunc 创建grpc协议，mapper 为空时使用 HTTPServiceMethodMapper
+func NewGRPCProtoFunc(mapper ...ServiceMethodMapper) proto.ProtoFunc {
+	var m ServiceMethodMapper = HTTPServiceMethodMapper
+	if len(mapper) > 0 && mapper[0] != nil {
+		m = mapper[0]
+	}
+	return func(rw proto.IOWithReadBuffer) proto.Proto {
+		p := &grpcProto{
+			id:            'g',
+			name:          "grpc",
+			rw:            rw,
+			mapper:        m,
+			br:            bufio.NewReader(rw),
+			streams:       make(map[uint32]*stream),
+			nextID:        1,
+			connWindow:    initialWindowSize,
+			initialWindow: initialWindowSize,
+			maxFrameSize:  initialMaxFrameSize,
+		}
+		p.cond = sync.NewCond(&p.mu)
+		p.framer = http2.NewFramer(rw, p.br)
+		p.framer.ReadMetaHeaders = hpack.NewDecoder(initialHeaderTableSize, nil)
+		p.henc = hpack.NewEncoder(&p.hbuf)
+		return p
+	}
+}
+
+// http2的默认设置
+const (
+	initialWindowSize      = 65535
+	initialMaxFrameSize    = 16384
+	initialHeaderTableSize = 4096
+	// 作为服务端时允许客户端同时打开的流的数量
+	maxConcurrentStreams = 100
+)
+
+// 一个http2的流对应一次请求
+type stream struct {
+	id uint32
+	// seq 作为客户端时请求的序列号
+	seq int32
+	// path 作为服务端时请求的grpc方法名
+	path string
+	// headers 收到的头，trailers 收到的trailers
+	headers  []hpack.HeaderField
+	trailers []hpack.HeaderField
+	data     bytes.Buffer
+	// window 发送窗口
+	window int64
+	reset  bool
+	// exceeded 收到的消息超过大小限制
+	exceeded bool
+}
+
+type grpcProto struct {
+	id     byte
+	name   string
+	rw     proto.IOWithReadBuffer
+	mapper ServiceMethodMapper
+	br     *bufio.Reader
+	framer *http2.Framer
+
+	rMu        sync.Mutex
+	handshaked bool
+	server     int32
+	client     int32
+
+	// 写入帧的锁，同一个头的 HEADERS 以及 CONTINUATION 帧必须连续写入
+	wMu         sync.Mutex
+	prefaceSent bool
+	henc        *hpack.Encoder
+	hbuf        bytes.Buffer
+
+	// 流以及流量控制的锁
+	mu            sync.Mutex
+	cond          *sync.Cond
+	streams       map[uint32]*stream
+	nextID        uint32
+	connWindow    int64
+	initialWindow int64
+	maxFrameSize  uint32
+	closed        bool
+}
+
+var _ proto.Proto = new(grpcProto)
+
+// Version 协议版本
+func (that *grpcProto) Version() (byte, string) {
+	return that.id, that.name
+}
+
+// Pack 打包
+func (that *grpcProto) Pack(m proto.Message) error {
+	switch m.MType() {
+	case message.TypeCall:
+		return that.packRequest(m)
+	case message.TypeReply:
+		return that.packResponse(m)
+	case message.TypeCancel:
+		return that.packCancel(m)
+	default:
+		return fmt.Errorf("unsupport message type: %d(%s)", m.MType(), message.TypeText(m.MType()))
+	}
+}
+
+// 作为客户端发送请求，第一次请求时发送链接前言
+func (that *grpcProto) packRequest(m proto.Message) error {
+	if atomic.LoadInt32(&that.server) == 1 {
+		return errServerCall
+	}
+	m.SetBodyCodec(codec.ProtobufId)
+	body, err := m.MarshalBody()
+	if err != nil {
+		return err
+	}
+	_ = m.SetSize(uint32(len(body)))
+	fields := []hpack.HeaderField{
+		field(":method", "POST"),
+		field(":scheme", "http"),
+		field(":path", m.ServiceMethod()),
+		field(":authority", that.authority()),
+		field("content-type", contentType),
+		field("te", "trailers"),
+		field("user-agent", userAgent),
+	}
+	if deadline, ok := m.Context().Deadline(); ok {
+		fields = append(fields, field("grpc-timeout", encodeTimeout(time.Until(deadline))))
+	}
+	fields = appendMeta(fields, m)
+
+	atomic.StoreInt32(&that.client, 1)
+	that.wMu.Lock()
+	if !that.prefaceSent {
+		if _, err = io.WriteString(that.rw, http2.ClientPreface); err == nil {
+			err = that.framer.WriteSettings(http2.Setting{ID: http2.SettingEnablePush})
+		}
+		if err != nil {
+			that.wMu.Unlock()
+			return err
+		}
+		that.prefaceSent = true
+	}
+	//流的id必须递增，分配id和写入头需要在同一个锁中完成
+	that.mu.Lock()
+	if that.closed {
+		that.mu.Unlock()
+		that.wMu.Unlock()
+		return errConnClosed
+	}
+	s := &stream{id: that.nextID, seq: m.Seq(), window: that.initialWindow}
+	that.nextID += 2
+	that.streams[s.id] = s
+	that.mu.Unlock()
+	err = that.writeHeaders(s.id, fields, false)
+	that.wMu.Unlock()
+	if err != nil {
+		that.removeStream(s.id)
+		return err
+	}
+	if err = that.writeData(s, frameMessage(body), true); err != nil {
+		that.removeStream(s.id)
+	}
+	return err
+}
+
+// 作为服务端发送响应，失败时只发送trailers
+func (that *grpcProto) packResponse(m proto.Message) error {
+	that.mu.Lock()
+	s := that.streams[uint32(m.Seq())]
+	that.mu.Unlock()
+	//流已经被客户端重置
+	if s == nil {
+		return nil
+	}
+	defer that.removeStream(s.id)
+
+	headers := appendMeta([]hpack.HeaderField{
+		field(":status", "200"),
+		field("content-type", contentType),
+	}, m)
+	stat := m.Status()
+	var body []byte
+	if stat.OK() {
+		var err error
+		m.SetBodyCodec(codec.ProtobufId)
+		if body, err = m.MarshalBody(); err != nil {
+			stat = drpc.NewStatus(drpc.CodeInternalServerError, err.Error(), "")
+		}
+	}
+	if !stat.OK() {
+		that.wMu.Lock()
+		defer that.wMu.Unlock()
+		return that.writeHeaders(s.id, append(headers, statusFields(stat)...), true)
+	}
+	_ = m.SetSize(uint32(len(body)))
+	that.wMu.Lock()
+	err := that.writeHeaders(s.id, headers, false)
+	that.wMu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err = that.writeData(s, frameMessage(body), false); err != nil {
+		return err
+	}
+	that.wMu.Lock()
+	defer that.wMu.Unlock()
+	return that.writeHeaders(s.id, statusFields(stat), true)
+}
+
+// 作为客户端取消请求，重置对应的流
+func (that *grpcProto) packCancel(m proto.Message) error {
+	that.mu.Lock()
+	var s *stream
+	for _, v := range that.streams {
+		if v.seq == m.Seq() {
+			s = v
+			break
+		}
+	}
+	that.mu.Unlock()
+	if s == nil {
+		return nil
+	}
+	that.removeStream(s.id)
+	that.wMu.Lock()
+	defer that.wMu.Unlock()
+	return that.framer.WriteRSTStream(s.id, http2.ErrCodeCancel)
+}
+
+// Unpack 解包，读取帧直到一个请求或者响应完整
+func (that *grpcProto) Unpack(m proto.Message) error {
+	that.rMu.Lock()
+	defer that.rMu.Unlock()
+	if err := that.handshake(); err != nil {
+		return that.fail(err)
+	}
+	for {
+		f, err := that.framer.ReadFrame()
+		if err != nil {
+			return that.fail(err)
+		}
+		var s *stream
+		switch f := f.(type) {
+		case *http2.SettingsFrame:
+			err = that.onSettings(f)
+		case *http2.PingFrame:
+			if !f.IsAck() {
+				that.wMu.Lock()
+				err = that.framer.WritePing(true, f.Data)
+				that.wMu.Unlock()
+			}
+		case *http2.WindowUpdateFrame:
+			that.onWindowUpdate(f)
+		case *http2.GoAwayFrame:
+			err = fmt.Errorf("grpcproto: goaway: %s", f.ErrCode)
+		case *http2.RSTStreamFrame:
+			if s = that.onReset(f, m); s != nil {
+				return nil
+			}
+		case *http2.MetaHeadersFrame:
+			s, err = that.onHeaders(f)
+		case *http2.DataFrame:
+			s, err = that.onData(f)
+		}
+		if err != nil {
+			return that.fail(err)
+		}
+		if s == nil {
+			continue
+		}
+		if atomic.LoadInt32(&that.server) == 1 {
+			if ok, err := that.unpackRequest(s, m); ok || err != nil {
+				return err
+			}
+			continue
+		}
+		that.removeStream(s.id)
+		return that.unpackResponse(s, m)
+	}
+}
+
+// 第一次读取时区分角色，已经发送过请求的是客户端，否则读取客户端的链接前言并发送服务端的设置
+func (that *grpcProto) handshake() error {
+	if that.handshaked {
+		return nil
+	}
+	if _, err := that.br.Peek(1); err != nil {
+		return err
+	}
+	that.handshaked = true
+	if atomic.LoadInt32(&that.client) == 1 {
+		return nil
+	}
+	preface := make([]byte, len(http2.ClientPreface))
+	if _, err := io.ReadFull(that.br, preface); err != nil {
+		return err
+	}
+	if string(preface) != http2.ClientPreface {
+		return errBadPreface
+	}
+	atomic.StoreInt32(&that.server, 1)
+	that.wMu.Lock()
+	defer that.wMu.Unlock()
+	return that.framer.WriteSettings(http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: maxConcurrentStreams})
+}
+
+// 应用对端的设置并确认
+func (that *grpcProto) onSettings(f *http2.SettingsFrame) error {
+	if f.IsAck() {
+		return nil
+	}
+	that.mu.Lock()
+	_ = f.ForeachSetting(func(s http2.Setting) error {
+		switch s.ID {
+		case http2.SettingInitialWindowSize:
+			delta := int64(s.Val) - that.initialWindow
+			that.initialWindow = int64(s.Val)
+			for _, st := range that.streams {
+				st.window += delta
+			}
+		case http2.SettingMaxFrameSize:
+			that.maxFrameSize = s.Val
+		}
+		return nil
+	})
+	that.cond.Broadcast()
+	that.mu.Unlock()
+	that.wMu.Lock()
+	defer that.wMu.Unlock()
+	if v, ok := f.Value(http2.SettingHeaderTableSize); ok {
+		that.henc.SetMaxDynamicTableSizeLimit(v)
+	}
+	return that.framer.WriteSettingsAck()
+}
+
+// 增加发送窗口
+func (that *grpcProto) onWindowUpdate(f *http2.WindowUpdateFrame) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	if f.StreamID == 0 {
+		that.connWindow += int64(f.Increment)
+	} else if s := that.streams[f.StreamID]; s != nil {
+		s.window += int64(f.Increment)
+	}
+	that.cond.Broadcast()
+}
+
+// 流被对端重置，服务端转换成取消请求，客户端转换成失败的响应
+func (that *grpcProto) onReset(f *http2.RSTStreamFrame, m proto.Message) *stream {
+	that.mu.Lock()
+	s := that.streams[f.StreamID]
+	if s != nil {
+		s.reset = true
+		delete(that.streams, f.StreamID)
+		that.cond.Broadcast()
+	}
+	that.mu.Unlock()
+	if s == nil {
+		return nil
+	}
+	if atomic.LoadInt32(&that.server) == 1 {
+		m.SetMType(message.TypeCancel)
+		m.SetSeq(int32(s.id))
+		m.SetServiceMethod(that.mapper(s.path))
+		return s
+	}
+	m.SetMType(message.TypeReply)
+	m.SetSeq(s.seq)
+	_ = m.UnmarshalBody(nil)
+	code := drpc.CodeBadGateway
+	if f.ErrCode == http2.ErrCodeCancel {
+		code = drpc.CodeCallCanceled
+	}
+	m.SetStatus(drpc.NewStatus(code, "stream reset", f.ErrCode.String()))
+	return s
+}
+
+// 收到头，流结束时返回流
+func (that *grpcProto) onHeaders(f *http2.MetaHeadersFrame) (*stream, error) {
+	that.mu.Lock()
+	s := that.streams[f.StreamID]
+	if atomic.LoadInt32(&that.server) == 1 && s == nil && len(that.streams) >= maxConcurrentStreams {
+		that.mu.Unlock()
+		//超过同时打开的流的数量，拒绝该流
+		that.wMu.Lock()
+		defer that.wMu.Unlock()
+		return nil, that.framer.WriteRSTStream(f.StreamID, http2.ErrCodeRefusedStream)
+	}
+	defer that.mu.Unlock()
+	if atomic.LoadInt32(&that.server) == 1 {
+		if s != nil {
+			//请求的trailers，grpc的请求不会携带
+			return that.ended(s, f.StreamEnded()), nil
+		}
+		s = &stream{
+			id:      f.StreamID,
+			path:    f.PseudoValue("path"),
+			headers: f.RegularFields(),
+			window:  that.initialWindow,
+		}
+		that.streams[s.id] = s
+		return that.ended(s, f.StreamEnded()), nil
+	}
+	if s == nil {
+		return nil, nil
+	}
+	if s.headers == nil && f.PseudoValue("status") != "" {
+		s.headers = append(f.RegularFields(), field(":status", f.PseudoValue("status")))
+		if !f.StreamEnded() {
+			return nil, nil
+		}
+	}
+	s.trailers = f.RegularFields()
+	return that.ended(s, f.StreamEnded()), nil
+}
+
+// 收到数据，确认流量后返回结束的流
+//  数据读入流的缓冲区之后才归还窗口，缓冲区的大小受 message.MsgSizeLimit() 限制，超过时重置流并丢弃数据
+func (that *grpcProto) onData(f *http2.DataFrame) (*stream, error) {
+	that.mu.Lock()
+	s := that.streams[f.StreamID]
+	if s != nil {
+		s.data.Write(f.Data())
+		if exceedSizeLimit(s.data.Bytes()) {
+			s.exceeded = true
+			s.data.Reset()
+			delete(that.streams, s.id)
+			that.cond.Broadcast()
+		}
+	}
+	that.mu.Unlock()
+	if s != nil && s.exceeded {
+		if err := that.rejectExceeded(s, f.Header().Length); err != nil {
+			return nil, err
+		}
+		//作为客户端时需要把失败的响应交给调用方
+		if atomic.LoadInt32(&that.server) == 1 {
+			return nil, nil
+		}
+		return s, nil
+	}
+	if n := f.Header().Length; n > 0 {
+		that.wMu.Lock()
+		//没有对应的流时数据直接丢弃，只归还链接的窗口
+		err := that.framer.WriteWindowUpdate(0, n)
+		if err == nil && s != nil && !f.StreamEnded() {
+			err = that.framer.WriteWindowUpdate(s.id, n)
+		}
+		that.wMu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s == nil {
+		return nil, nil
+	}
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return that.ended(s, f.StreamEnded()), nil
+}
+
+// 消息超过大小限制，归还链接的窗口并重置流
+//  作为服务端时先回复 RESOURCE_EXHAUSTED 的trailers，再通知客户端停止发送
+func (that *grpcProto) rejectExceeded(s *stream, n uint32) error {
+	that.wMu.Lock()
+	defer that.wMu.Unlock()
+	if n > 0 {
+		if err := that.framer.WriteWindowUpdate(0, n); err != nil {
+			return err
+		}
+	}
+	if atomic.LoadInt32(&that.server) == 1 {
+		fields := append([]hpack.HeaderField{field(":status", "200"), field("content-type", contentType)}, exceededStatusFields()...)
+		if err := that.writeHeaders(s.id, fields, true); err != nil {
+			return err
+		}
+		return that.framer.WriteRSTStream(s.id, http2.ErrCodeNo)
+	}
+	return that.framer.WriteRSTStream(s.id, http2.ErrCodeCancel)
+}
+
+// 收到的数据是否超过消息的大小限制，一元请求只有一个消息，消息之后还有数据也视为超过
+func exceedSizeLimit(b []byte) bool {
+	limit := uint64(message.MsgSizeLimit())
+	if len(b) < prefixLen {
+		return false
+	}
+	size := uint64(binary.BigEndian.Uint32(b[1:prefixLen]))
+	return size > limit || uint64(len(b)) > prefixLen+size
+}
+
+func (that *grpcProto) ended(s *stream, end bool) *stream {
+	if end {
+		return s
+	}
+	return nil
+}
+
+// 把完整的请求写入消息，请求无法处理时直接响应失败，返回false继续读取
+func (that *grpcProto) unpackRequest(s *stream, m proto.Message) (bool, error) {
+	payload, stat := readMessage(s.data.Bytes())
+	if stat == nil && !strings.HasPrefix(headerValue(s.headers, "content-type"), contentType) {
+		stat = drpc.NewStatus(drpc.CodeBadMessage, "invalid content-type", headerValue(s.headers, "content-type"))
+	}
+	if stat != nil {
+		that.removeStream(s.id)
+		that.wMu.Lock()
+		defer that.wMu.Unlock()
+		fields := append([]hpack.HeaderField{field(":status", "200"), field("content-type", contentType)}, statusFields(stat)...)
+		return false, that.writeHeaders(s.id, fields, true)
+	}
+	m.SetMType(message.TypeCall)
+	m.SetSeq(int32(s.id))
+	m.SetServiceMethod(that.mapper(s.path))
+	setMeta(m, s.headers)
+	// 调用方的截止时间转换成剩余的处理时间，处理方法通过 ctx.Context() 获取
+	if d, ok := decodeTimeout(headerValue(s.headers, "grpc-timeout")); ok {
+		m.Meta().Set(message.MetaTimeout, d.String())
+	}
+	m.SetBodyCodec(codec.ProtobufId)
+	if err := m.SetSize(uint32(len(payload))); err != nil {
+		return true, err
+	}
+	return true, m.UnmarshalBody(payload)
+}
+
+// 把完整的响应写入消息
+func (that *grpcProto) unpackResponse(s *stream, m proto.Message) error {
+	m.SetMType(message.TypeReply)
+	m.SetSeq(s.seq)
+	if s.exceeded {
+		_ = m.UnmarshalBody(nil)
+		m.SetStatus(drpc.NewStatus(drpc.CodeBadMessage, message.ErrExceedMessageSizeLimit.Error(), ""))
+		return nil
+	}
+	setMeta(m, s.headers)
+	//grpc的服务端都支持使用 RST_STREAM 取消请求
+	m.Meta().Set(message.MetaCancel, "1")
+	m.SetBodyCodec(codec.ProtobufId)
+	var stat *drpc.Status
+	if status := headerValue(s.headers, ":status"); status != "" && status != "200" {
+		stat = drpc.NewStatus(drpc.CodeBadGateway, "http status "+status, "")
+	} else if s.headers == nil {
+		stat = statusFromFields(s.trailers)
+	} else {
+		stat = statusFromFields(append(s.trailers, s.headers...))
+	}
+	var payload []byte
+	if stat == nil {
+		payload, stat = readMessage(s.data.Bytes())
+	}
+	if stat != nil {
+		_ = m.UnmarshalBody(nil)
+		m.SetStatus(stat)
+		return nil
+	}
+	_ = m.SetSize(uint32(len(payload)))
+	return m.UnmarshalBody(payload)
+}
+
+// 链接出错，唤醒所有等待发送窗口的请求
+func (that *grpcProto) fail(err error) error {
+	that.mu.Lock()
+	that.closed = true
+	that.cond.Broadcast()
+	that.mu.Unlock()
+	return err
+}
+
+func (that *grpcProto) removeStream(id uint32) {
+	that.mu.Lock()
+	delete(that.streams, id)
+	that.mu.Unlock()
+}
+
+// 写入头，超过对端帧大小的部分使用 CONTINUATION 帧，调用前需要持有 wMu
+func (that *grpcProto) writeHeaders(id uint32, fields []hpack.HeaderField, endStream bool) error {
+	that.hbuf.Reset()
+	for _, f := range fields {
+		if err := that.henc.WriteField(f); err != nil {
+			return err
+		}
+	}
+	that.mu.Lock()
+	maxSize := int(that.maxFrameSize)
+	that.mu.Unlock()
+	block := that.hbuf.Bytes()
+	for first := true; first || len(block) > 0; first = false {
+		chunk := block
+		if len(chunk) > maxSize {
+			chunk = chunk[:maxSize]
+		}
+		block = block[len(chunk):]
+		var err error
+		if first {
+			err = that.framer.WriteHeaders(http2.HeadersFrameParam{
+				StreamID:      id,
+				BlockFragment: chunk,
+				EndStream:     endStream,
+				EndHeaders:    len(block) == 0,
+			})
+		} else {
+			err = that.framer.WriteContinuation(id, len(block) == 0, chunk)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 按照发送窗口分段写入数据，等待窗口时不持有 wMu，避免阻塞读取协程发送确认
+func (that *grpcProto) writeData(s *stream, data []byte, endStream bool) error {
+	for len(data) > 0 {
+		n, err := that.reserve(s, len(data))
+		if err != nil {
+			return err
+		}
+		that.wMu.Lock()
+		err = that.framer.WriteData(s.id, endStream && n == len(data), data[:n])
+		that.wMu.Unlock()
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// 等待并占用发送窗口
+func (that *grpcProto) reserve(s *stream, size int) (int, error) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	for !that.closed && !s.reset && (that.connWindow <= 0 || s.window <= 0) {
+		that.cond.Wait()
+	}
+	if that.closed {
+		return 0, errConnClosed
+	}
+	if s.reset {
+		return 0, errStreamReset
+	}
+	n := min(int64(size), that.connWindow, s.window, int64(that.maxFrameSize))
+	that.connWindow -= n
+	s.window -= n
+	return int(n), nil
+}
+
+// 请求的 :authority，使用对端的地址
+func (that *grpcProto) authority() string {
+	if c, ok := that.rw.(interface{ RemoteAddr() net.Addr }); ok && c.RemoteAddr() != nil {
+		return c.RemoteAddr().String()
+	}
+	return "localhost"
+}
+
+// 消息加上grpc的长度前缀
+func frameMessage(body []byte) []byte {
+	b := make([]byte, prefixLen+len(body))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(body)))
+	copy(b[prefixLen:], body)
+	return b
+}
+
+// 读取grpc的长度前缀消息，一元请求只有一个消息
+func readMessage(b []byte) ([]byte, *drpc.Status) {
+	if len(b) < prefixLen {
+		return nil, drpc.NewStatus(drpc.CodeBadMessage, "grpc message too short", "")
+	}
+	if b[0] != 0 {
+		return nil, drpc.NewStatus(drpc.CodeMTypeNotAllowed, "grpc compression is not supported", "")
+	}
+	size := binary.BigEndian.Uint32(b[1:prefixLen])
+	if int(size) != len(b)-prefixLen {
+		return nil, drpc.NewStatus(drpc.CodeBadMessage, "grpc message length mismatch", "")
+	}
+	return b[prefixLen:], nil
+}
+
+// 元数据中剩余的处理时间以及是否支持取消对应的头
+var (
+	timeoutHeader = strings.ToLower(message.MetaTimeout)
+	cancelHeader  = strings.ToLower(message.MetaCancel)
+)
+
+// grpc以及http2保留的头，不放入元数据
+//  剩余的处理时间使用 grpc-timeout 传递，取消请求使用 RST_STREAM
+func reservedHeader(name string) bool {
+	switch name {
+	case "content-type", "te", "user-agent", "connection", "keep-alive", "proxy-connection",
+		"transfer-encoding", "upgrade", "host", drpcStatusKey, timeoutHeader, cancelHeader:
+		return true
+	}
+	return strings.HasPrefix(name, ":") || strings.HasPrefix(name, "grpc-")
+}
+
+// 元数据转换成头
+func appendMeta(fields []hpack.HeaderField, m proto.Message) []hpack.HeaderField {
+	m.Meta().Iterator(func(k interface{}, v interface{}) bool {
+		name := strings.ToLower(fmt.Sprint(k))
+		if !reservedHeader(name) {
+			fields = append(fields, field(name, fmt.Sprint(v)))
+		}
+		return true
+	})
+	return fields
+}
+
+// 头转换成元数据
+func setMeta(m proto.Message, fields []hpack.HeaderField) {
+	for _, f := range fields {
+		if !reservedHeader(f.Name) {
+			m.Meta().Set(f.Name, f.Value)
+		}
+	}
+}
+
+func headerValue(fields []hpack.HeaderField, name string) string {
+	for _, f := range fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+func field(name, value string) hpack.HeaderField {
+	return hpack.HeaderField{Name: name, Value: value}
+}
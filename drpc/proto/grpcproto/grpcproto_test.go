@@ -0,0 +1,242 @@
+package grpcproto_test
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/osgochina/dmicro/drpc"
+	"github.com/osgochina/dmicro/drpc/message"
+	"github.com/osgochina/dmicro/drpc/proto/grpcproto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Greeter struct {
+	drpc.CallCtx
+}
+
+func (g *Greeter) SayHello(arg *wrapperspb.StringValue) (*wrapperspb.StringValue, *drpc.Status) {
+	if arg.Value == "" {
+		return nil, drpc.NewStatus(drpc.CodeBadMessage, "name is empty", "")
+	}
+	return wrapperspb.String("hello " + arg.Value + gconv.String(g.PeekMeta("x-tenant"))), nil
+}
+
+// 返回剩余的处理时间
+func (g *Greeter) Remain(_ *wrapperspb.StringValue) (*wrapperspb.StringValue, *drpc.Status) {
+	deadline, ok := g.Context().Deadline()
+	if !ok {
+		return wrapperspb.String(""), nil
+	}
+	return wrapperspb.String(time.Until(deadline).String()), nil
+}
+
+// grpc-go客户端请求drpc服务端
+func TestGRPCClientToDrpc(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		srv := drpc.NewEndpoint(drpc.EndpointConfig{ListenPort: 9291})
+		srv.SubRoute("helloworld").RouteCall(new(Greeter))
+		go srv.ListenAndServe(grpcproto.NewGRPCProtoFunc())
+		defer srv.Close()
+		time.Sleep(1e9)
+
+		conn, err := grpc.NewClient("127.0.0.1:9291", grpc.WithTransportCredentials(insecure.NewCredentials()))
+		t.AssertNil(err)
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-tenant", "@acme")
+		var reply wrapperspb.StringValue
+		err = conn.Invoke(ctx, "/helloworld.Greeter/SayHello", wrapperspb.String("grpc"), &reply)
+		t.AssertNil(err)
+		t.Assert(reply.Value, "hello grpc@acme")
+
+		err = conn.Invoke(ctx, "/helloworld.Greeter/SayHello", wrapperspb.String(""), &reply)
+		t.Assert(status.Code(err), codes.InvalidArgument)
+		t.Assert(status.Convert(err).Message(), "name is empty")
+
+		// grpc-timeout 转换成处理方法的截止时间
+		err = conn.Invoke(ctx, "/helloworld.Greeter/Remain", wrapperspb.String(""), &reply)
+		t.AssertNil(err)
+		remain, err := time.ParseDuration(reply.Value)
+		t.AssertNil(err)
+		t.AssertGT(remain, 0)
+		t.AssertLE(remain, 3*time.Second)
+		err = conn.Invoke(context.Background(), "/helloworld.Greeter/Remain", wrapperspb.String(""), &reply)
+		t.AssertNil(err)
+		t.Assert(reply.Value, "")
+
+		// 超过流量控制窗口的消息
+		big := strings.Repeat("x", 200000)
+		err = conn.Invoke(ctx, "/helloworld.Greeter/SayHello", wrapperspb.String(big), &reply)
+		t.AssertNil(err)
+		t.Assert(reply.Value, "hello "+big+"@acme")
+
+		err = conn.Invoke(ctx, "/helloworld.Greeter/NotFound", wrapperspb.String("grpc"), &reply)
+		t.Assert(status.Code(err), codes.Unimplemented)
+
+		// drpc客户端使用grpc协议请求drpc服务端，失败时可以还原drpc的状态
+		cli := drpc.NewEndpoint(drpc.EndpointConfig{})
+		defer cli.Close()
+		sess, stat := cli.Dial(":9291", grpcproto.NewGRPCProtoFunc())
+		t.Assert(stat.OK(), true)
+		var result wrapperspb.StringValue
+		stat = sess.Call("/helloworld.Greeter/SayHello", wrapperspb.String("drpc"), &result,
+			message.WithSetMeta("X-Tenant", "@acme"),
+		).Status()
+		t.Assert(stat.OK(), true)
+		t.Assert(result.Value, "hello drpc@acme")
+		stat = sess.Call("/helloworld.Greeter/SayHello", wrapperspb.String(""), &result).Status()
+		t.Assert(stat.Code(), drpc.CodeBadMessage)
+		t.Assert(stat.Msg(), "name is empty")
+	})
+}
+
+type greeterServer struct{}
+
+func sayHello(_ interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(wrapperspb.StringValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if in.Value == "" {
+		return nil, status.Error(codes.NotFound, "no such user")
+	}
+	var tenant string
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("x-tenant")) > 0 {
+		tenant = md.Get("x-tenant")[0]
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs("x-server", "grpc-go"))
+	return wrapperspb.String("hello " + in.Value + tenant), nil
+}
+
+// drpc客户端请求grpc-go服务端
+func TestDrpcToGRPCServer(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		lis, err := net.Listen("tcp", "127.0.0.1:9292")
+		t.AssertNil(err)
+		gs := grpc.NewServer()
+		gs.RegisterService(&grpc.ServiceDesc{
+			ServiceName: "helloworld.Greeter",
+			HandlerType: (*interface{})(nil),
+			Methods:     []grpc.MethodDesc{{MethodName: "SayHello", Handler: sayHello}},
+		}, greeterServer{})
+		go gs.Serve(lis)
+		defer gs.Stop()
+
+		cli := drpc.NewEndpoint(drpc.EndpointConfig{})
+		defer cli.Close()
+		sess, stat := cli.Dial("127.0.0.1:9292", grpcproto.NewGRPCProtoFunc())
+		t.Assert(stat.OK(), true)
+
+		var result wrapperspb.StringValue
+		cmd := sess.Call("/helloworld.Greeter/SayHello", wrapperspb.String("drpc"), &result,
+			message.WithSetMeta("x-tenant", "@acme"),
+		)
+		t.Assert(cmd.Status().OK(), true)
+		t.Assert(result.Value, "hello drpc@acme")
+		t.Assert(cmd.InputMeta().Get("x-server"), "grpc-go")
+
+		stat = sess.Call("/helloworld.Greeter/SayHello", wrapperspb.String(""), &result).Status()
+		t.Assert(stat.Code(), drpc.CodeNotFound)
+		t.Assert(stat.Msg(), "no such user")
+
+		big := strings.Repeat("x", 200000)
+		stat = sess.Call("/helloworld.Greeter/SayHello", wrapperspb.String(big), &result).Status()
+		t.Assert(stat.OK(), true)
+		t.Assert(result.Value, "hello "+big)
+
+		stat = sess.Call("/helloworld.Greeter/Unknown", wrapperspb.String("drpc"), &result).Status()
+		t.Assert(stat.Code(), drpc.CodeNotFound)
+
+		// 并发请求
+		for i := 0; i < 20; i++ {
+			go func() {
+				var r wrapperspb.StringValue
+				_ = sess.Call("/helloworld.Greeter/SayHello", wrapperspb.String("drpc"), &r).Status()
+			}()
+		}
+		var results = make([]wrapperspb.StringValue, 20)
+		var cmds = make([]drpc.CallCmd, 20)
+		for i := range cmds {
+			cmds[i] = sess.AsyncCall("/helloworld.Greeter/SayHello", wrapperspb.String(gconv.String(i)), &results[i], make(chan drpc.CallCmd, 1))
+		}
+		for i, c := range cmds {
+			_, stat = c.Reply()
+			t.Assert(stat.OK(), true)
+			t.Assert(results[i].Value, "hello "+gconv.String(i))
+		}
+	})
+}
+
+func TestServiceMethodMapper(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		t.Assert(grpcproto.HTTPServiceMethodMapper("/helloworld.Greeter/SayHello"), "/helloworld/greeter/say_hello")
+		t.Assert(grpcproto.HTTPServiceMethodMapper("/a.b.Greeter/SayHello"), "/a/b/greeter/say_hello")
+		t.Assert(grpcproto.RPCServiceMethodMapper("/helloworld.Greeter/SayHello"), "helloworld.Greeter.SayHello")
+		t.Assert(grpcproto.ToGRPCCode(drpc.CodeNotFound), uint32(codes.Unimplemented))
+		t.Assert(grpcproto.FromGRPCCode(uint32(codes.Unavailable)), drpc.CodeBadGateway)
+	})
+}
+
+// 消息超过大小限制时重置流，链接可以继续使用
+func TestGRPCSizeLimit(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		srv := drpc.NewEndpoint(drpc.EndpointConfig{ListenPort: 9293})
+		srv.SubRoute("helloworld").RouteCall(new(Greeter))
+		go srv.ListenAndServe(grpcproto.NewGRPCProtoFunc())
+		defer srv.Close()
+
+		lis, err := net.Listen("tcp", "127.0.0.1:9294")
+		t.AssertNil(err)
+		gs := grpc.NewServer()
+		gs.RegisterService(&grpc.ServiceDesc{
+			ServiceName: "helloworld.Greeter",
+			HandlerType: (*interface{})(nil),
+			Methods:     []grpc.MethodDesc{{MethodName: "SayHello", Handler: sayHello}},
+		}, greeterServer{})
+		go gs.Serve(lis)
+		defer gs.Stop()
+		time.Sleep(1e9)
+
+		message.SetMsgSizeLimit(100000)
+		defer message.SetMsgSizeLimit(0)
+		big := strings.Repeat("x", 200000)
+
+		// grpc-go客户端发送超过限制的请求
+		conn, err := grpc.NewClient("127.0.0.1:9293", grpc.WithTransportCredentials(insecure.NewCredentials()))
+		t.AssertNil(err)
+		defer conn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		var reply wrapperspb.StringValue
+		err = conn.Invoke(ctx, "/helloworld.Greeter/SayHello", wrapperspb.String(big), &reply)
+		t.Assert(status.Code(err), codes.ResourceExhausted)
+		err = conn.Invoke(ctx, "/helloworld.Greeter/SayHello", wrapperspb.String("grpc"), &reply)
+		t.AssertNil(err)
+		t.Assert(reply.Value, "hello grpc")
+
+		// drpc客户端收到超过限制的响应
+		cli := drpc.NewEndpoint(drpc.EndpointConfig{})
+		defer cli.Close()
+		sess, stat := cli.Dial("127.0.0.1:9294", grpcproto.NewGRPCProtoFunc())
+		t.Assert(stat.OK(), true)
+		var result wrapperspb.StringValue
+		// 请求没有超过限制，响应多了 "hello " 之后超过
+		stat = sess.Call("/helloworld.Greeter/SayHello", wrapperspb.String(big[:99995]), &result).Status()
+		t.Assert(stat.Code(), drpc.CodeBadMessage)
+		stat = sess.Call("/helloworld.Greeter/SayHello", wrapperspb.String("drpc"), &result).Status()
+		t.Assert(stat.OK(), true)
+		t.Assert(result.Value, "hello drpc")
+		t.Assert(sess.Health(), true)
+	})
+}
@@ -0,0 +1,234 @@
+package grpcproto
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/osgochina/dmicro/drpc"
+	"github.com/osgochina/dmicro/drpc/message"
+	"golang.org/x/net/http2/hpack"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// grpc的状态码
+const (
+	grpcOK                 uint32 = 0
+	grpcCanceled           uint32 = 1
+	grpcUnknown            uint32 = 2
+	grpcInvalidArgument    uint32 = 3
+	grpcDeadlineExceeded   uint32 = 4
+	grpcNotFound           uint32 = 5
+	grpcAlreadyExists      uint32 = 6
+	grpcPermissionDenied   uint32 = 7
+	grpcResourceExhausted  uint32 = 8
+	grpcFailedPrecondition uint32 = 9
+	grpcAborted            uint32 = 10
+	grpcUnimplemented      uint32 = 12
+	grpcInternal           uint32 = 13
+	grpcUnavailable        uint32 = 14
+	grpcUnauthenticated    uint32 = 16
+)
+
+// ToGRPCCode 把drpc的状态码转换成grpc的状态码
+func ToGRPCCode(code int32) uint32 {
+	switch code {
+	case drpc.CodeOK:
+		return grpcOK
+	case drpc.CodeCallCanceled, drpc.CodeStreamCanceled:
+		return grpcCanceled
+	case drpc.CodeBadMessage:
+		return grpcInvalidArgument
+	case drpc.CodeHandleTimeout:
+		return grpcDeadlineExceeded
+	case drpc.CodeNotFound, drpc.CodeMTypeNotAllowed:
+		return grpcUnimplemented
+	case drpc.CodeConflict:
+		return grpcAlreadyExists
+	case drpc.CodeUnauthorized:
+		return grpcUnauthenticated
+	case drpc.CodeInternalServerError:
+		return grpcInternal
+	case drpc.CodeBadGateway, drpc.CodeDialFailed, drpc.CodeConnClosed, drpc.CodeWrongConn, drpc.CodeWriteFailed:
+		return grpcUnavailable
+	default:
+		return grpcUnknown
+	}
+}
+
+// FromGRPCCode 把grpc的状态码转换成drpc的状态码
+func FromGRPCCode(code uint32) int32 {
+	switch code {
+	case grpcOK:
+		return drpc.CodeOK
+	case grpcCanceled:
+		return drpc.CodeCallCanceled
+	case grpcInvalidArgument, grpcFailedPrecondition:
+		return drpc.CodeBadMessage
+	case grpcDeadlineExceeded:
+		return drpc.CodeHandleTimeout
+	case grpcNotFound, grpcUnimplemented:
+		return drpc.CodeNotFound
+	case grpcAlreadyExists, grpcAborted:
+		return drpc.CodeConflict
+	case grpcPermissionDenied, grpcUnauthenticated:
+		return drpc.CodeUnauthorized
+	case grpcInternal, grpcResourceExhausted:
+		return drpc.CodeInternalServerError
+	case grpcUnavailable:
+		return drpc.CodeBadGateway
+	default:
+		return drpc.CodeUnknownError
+	}
+}
+
+// 状态转换成trailers，失败时同时带上drpc的原始状态，对端是drpc时可以完整的还原
+func statusFields(stat *drpc.Status) []hpack.HeaderField {
+	fields := []hpack.HeaderField{field("grpc-status", strconv.FormatUint(uint64(ToGRPCCode(stat.Code())), 10))}
+	if stat.OK() {
+		return fields
+	}
+	fields = append(fields, field("grpc-message", encodeGrpcMessage(stat.Msg())))
+	if b, err := stat.MarshalJSON(); err == nil {
+		fields = append(fields, field(drpcStatusKey, base64.RawStdEncoding.EncodeToString(b)))
+	}
+	return fields
+}
+
+// 消息超过大小限制时的状态
+func exceededStatusFields() []hpack.HeaderField {
+	return []hpack.HeaderField{
+		field("grpc-status", strconv.FormatUint(uint64(grpcResourceExhausted), 10)),
+		field("grpc-message", encodeGrpcMessage(message.ErrExceedMessageSizeLimit.Error())),
+	}
+}
+
+// 从trailers中解析状态
+func statusFromFields(fields []hpack.HeaderField) *drpc.Status {
+	var (
+		code    = grpcUnknown
+		msg     string
+		hasCode bool
+	)
+	for _, f := range fields {
+		switch f.Name {
+		case "grpc-status":
+			c, err := strconv.ParseUint(f.Value, 10, 32)
+			if err != nil {
+				return drpc.NewStatus(drpc.CodeBadMessage, "invalid grpc-status", f.Value)
+			}
+			code, hasCode = uint32(c), true
+		case "grpc-message":
+			msg = decodeGrpcMessage(f.Value)
+		case drpcStatusKey:
+			if b, err := decodeBinHeader(f.Value); err == nil {
+				stat := new(drpc.Status)
+				if stat.UnmarshalJSON(b) == nil {
+					return stat
+				}
+			}
+		}
+	}
+	if !hasCode {
+		return drpc.NewStatus(drpc.CodeBadMessage, "missing grpc-status", "")
+	}
+	if code == grpcOK {
+		return nil
+	}
+	return drpc.NewStatus(FromGRPCCode(code), msg, fmt.Sprintf("grpc status: %d", code))
+}
+
+// grpc-message 使用百分号编码不可见字符以及'%'
+func encodeGrpcMessage(msg string) string {
+	var sb strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			sb.WriteByte(c)
+			continue
+		}
+		_, _ = fmt.Fprintf(&sb, "%%%02X", c)
+	}
+	return sb.String()
+}
+
+func decodeGrpcMessage(msg string) string {
+	if !strings.Contains(msg, "%") {
+		return msg
+	}
+	var sb strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if msg[i] == '%' && i+2 < len(msg) {
+			if c, err := strconv.ParseUint(msg[i+1:i+3], 16, 8); err == nil {
+				sb.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		sb.WriteByte(msg[i])
+	}
+	return sb.String()
+}
+
+// -bin 结尾的元数据使用base64编码，兼容有没有填充的两种格式
+func decodeBinHeader(v string) ([]byte, error) {
+	if len(v)%4 == 0 {
+		return base64.StdEncoding.DecodeString(v)
+	}
+	return base64.RawStdEncoding.DecodeString(v)
+}
+
+// grpc-timeout 的格式为最多8位数字加上单位
+func encodeTimeout(d time.Duration) string {
+	if d <= 0 {
+		return "1n"
+	}
+	units := []struct {
+		unit string
+		d    time.Duration
+	}{
+		{"n", time.Nanosecond},
+		{"u", time.Microsecond},
+		{"m", time.Millisecond},
+		{"S", time.Second},
+		{"M", time.Minute},
+	}
+	for _, u := range units {
+		if v := d / u.d; v < 1e8 {
+			if d%u.d != 0 {
+				v++
+			}
+			return strconv.FormatInt(int64(v), 10) + u.unit
+		}
+	}
+	return strconv.FormatInt(int64(d/time.Hour)+1, 10) + "H"
+}
+
+// 解析 grpc-timeout，格式错误时返回false
+func decodeTimeout(s string) (time.Duration, bool) {
+	if len(s) < 2 || len(s) > 9 {
+		return 0, false
+	}
+	var unit time.Duration
+	switch s[len(s)-1] {
+	case 'n':
+		unit = time.Nanosecond
+	case 'u':
+		unit = time.Microsecond
+	case 'm':
+		unit = time.Millisecond
+	case 'S':
+		unit = time.Second
+	case 'M':
+		unit = time.Minute
+	case 'H':
+		unit = time.Hour
+	default:
+		return 0, false
+	}
+	v, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil || v < 0 {
+		return 0, false
+	}
+	return time.Duration(v) * unit, true
+}
@@ -1,28 +1,36 @@
 package jsonrpcproto
 
 import (
-	"github.com/gogf/gf/v2/encoding/gjson"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"github.com/gogf/gf/v2/errors/gerror"
 	"github.com/gogf/gf/v2/util/gconv"
 	"github.com/osgochina/dmicro/drpc"
 	"github.com/osgochina/dmicro/drpc/codec"
 	"github.com/osgochina/dmicro/drpc/message"
 	"github.com/osgochina/dmicro/drpc/proto"
-	"github.com/osgochina/dmicro/utils/dbuffer"
 	"io"
+	"strconv"
+	"strings"
 	"sync"
 )
 
-// 注意，json rpc协议实现了标准的jsonrpc协议，
-// 不支持tfilter和其他drpc支持的一些标准，使用的时候需要注意
+// 注意，json rpc协议实现了标准的jsonrpc 2.0协议，每个请求或者响应占用一行，
+// 支持批量请求，批量请求中的每个请求并发执行，全部完成后按照请求的顺序返回一个数组，
+// 没有id的请求是通知，转换成PUSH消息，不返回响应。
+// 作为服务端时同时支持通过 HTTP POST 发送jsonrpc请求，请求体是单个请求或者批量请求，不支持 HTTP pipelining。
+// 不支持tfilter，元数据使用扩展的 context 字段传输。
 
 func NewJSONRPCProtoFunc() proto.ProtoFunc {
 	return func(rw proto.IOWithReadBuffer) proto.Proto {
 		return &jsonRPCProto{
-			id:   3,
-			name: "jsonrpc",
-			r:    rw,
-			w:    rw,
+			id:    3,
+			name:  "jsonrpc",
+			r:     bufio.NewReader(rw),
+			w:     rw,
+			calls: make(map[int32]*call),
 		}
 	}
 }
@@ -30,17 +38,64 @@ func NewJSONRPCProtoFunc() proto.ProtoFunc {
 var _ proto.Proto = new(jsonRPCProto)
 
 type jsonRPCProto struct {
-	r    io.Reader
+	r    *bufio.Reader
 	w    io.Writer
 	rMu  sync.Mutex
+	wMu  sync.Mutex
 	name string
 	id   byte
+
+	// 已经读取还没有解包的请求，批量请求读取后逐个解包
+	queue []*item
+	// 作为服务端时正在执行的请求，使用服务端生成的序列号，响应时还原请求的id
+	mu      sync.Mutex
+	calls   map[int32]*call
+	lastSeq int32
 }
 
 func (that *jsonRPCProto) Version() (byte, string) {
 	return that.id, that.name
 }
 
+// jsonrpc标准的错误码
+const (
+	CodeParseError     int32 = -32700
+	CodeInvalidRequest int32 = -32600
+	CodeMethodNotFound int32 = -32601
+	CodeInvalidParams  int32 = -32602
+	CodeInternalError  int32 = -32603
+)
+
+// ToJSONRPCCode 把drpc的状态码转换成jsonrpc的错误码，没有对应关系的状态码保持不变
+func ToJSONRPCCode(code int32) int32 {
+	switch code {
+	case drpc.CodeBadMessage:
+		return CodeInvalidParams
+	case drpc.CodeNotFound:
+		return CodeMethodNotFound
+	case drpc.CodeMTypeNotAllowed:
+		return CodeInvalidRequest
+	case drpc.CodeInternalServerError:
+		return CodeInternalError
+	default:
+		return code
+	}
+}
+
+// FromJSONRPCCode 把jsonrpc的错误码转换成drpc的状态码，没有对应关系的错误码保持不变
+func FromJSONRPCCode(code int32) int32 {
+	switch code {
+	case CodeParseError, CodeInvalidRequest, CodeInvalidParams:
+		return drpc.CodeBadMessage
+	case CodeMethodNotFound:
+		return drpc.CodeNotFound
+	case CodeInternalError:
+		return drpc.CodeInternalServerError
+	default:
+		return code
+	}
+}
+
 type Request struct {
 	Jsonrpc string      `json:"jsonrpc"`
 	Method  string      `json:"method"`
@@ -75,140 +130,407 @@ type ErrorResponse struct {
 	Error   ErrorStruct `json:"error"`
 }
 
+// 传输中的请求或者响应，id原样保留，没有id的请求是通知
+type wireMessage struct {
+	Jsonrpc string                 `json:"jsonrpc"`
+	Method  string                 `json:"method,omitempty"`
+	Params  json.RawMessage        `json:"params,omitempty"`
+	Id      json.RawMessage        `json:"id,omitempty"`
+	Result  json.RawMessage        `json:"result,omitempty"`
+	Error   *ErrorStruct           `json:"error,omitempty"`
+	Context map[string]interface{} `json:"context,omitempty"`
+}
+
+// 一次读取的请求，单个请求或者批量请求，所有请求都响应后一起写入
+type unit struct {
+	batch bool
+	http  bool
+	// replies 按照请求顺序保存的响应，通知没有响应
+	replies []json.RawMessage
+	pending int
+}
+
+// 服务端正在执行的请求
+type call struct {
+	id    json.RawMessage
+	unit  *unit
+	index int
+}
+
+// 读取到的单个请求或者响应
+type item struct {
+	msg  *wireMessage
+	size int
+	unit *unit
+	// index 请求的响应在 unit.replies 中的位置
+	index int
+}
+
+var nullID = json.RawMessage("null")
+
 // Pack 打包
 func (that *jsonRPCProto) Pack(m proto.Message) error {
-	bb := dbuffer.GetByteBuffer()
-	defer dbuffer.ReleaseByteBuffer(bb)
+	switch m.MType() {
+	case message.TypeCall, message.TypePush:
+		return that.packRequest(m)
+	case message.TypeReply:
+		return that.packResponse(m)
+	default:
+		return fmt.Errorf("unsupport message type: %d(%s)", m.MType(), message.TypeText(m.MType()))
+	}
+}
+
+// 打包请求，PUSH消息作为通知发送
+func (that *jsonRPCProto) packRequest(m proto.Message) error {
+	m.SetBodyCodec(codec.JsonId)
+	params, err := m.MarshalBody()
+	if err != nil {
+		return err
+	}
+	w := &wireMessage{
+		Jsonrpc: "2.0",
+		Method:  m.ServiceMethod(),
+		Params:  rawJSON(params),
+		Context: meta(m),
+	}
 	if m.MType() == message.TypeCall {
-		body := &Request{
-			Jsonrpc: "2.0",
-			Method:  m.ServiceMethod(),
-			Params:  m.Body(),
-			Id:      gconv.Int64(m.Seq()),
-			Context: m.Meta(),
-		}
-		m.SetBody(body)
-		bodyBytes, err := m.MarshalBody()
-		if err != nil {
-			return err
-		}
-		_, err = bb.Write(bodyBytes)
-		_ = bb.WriteByte('\r')
-		_ = bb.WriteByte('\n')
-		if err != nil {
-			return err
-		}
+		w.Id = json.RawMessage(strconv.FormatInt(int64(m.Seq()), 10))
+	}
+	b, err := json.Marshal(w)
+	if err != nil {
+		return err
 	}
-	if m.MType() == message.TypeReply {
+	return that.writeLine(b)
+}
+
+// 打包响应，批量请求等待所有请求都完成后一起写入
+func (that *jsonRPCProto) packResponse(m proto.Message) error {
+	that.mu.Lock()
+	c := that.calls[m.Seq()]
+	delete(that.calls, m.Seq())
+	that.mu.Unlock()
+	id := json.RawMessage(strconv.FormatInt(int64(m.Seq()), 10))
+	if c != nil {
+		id = c.id
+	}
+	w := &wireMessage{Jsonrpc: "2.0", Id: id, Context: meta(m)}
+	if stat := m.Status(); stat.OK() {
 		m.SetBodyCodec(codec.JsonId)
-		if m.StatusOK() {
-			body := &Response{
-				Jsonrpc: "2.0",
-				Id:      gconv.Int64(m.Seq()),
-				Result:  m.Body(),
-				Context: m.Meta(),
-			}
-			m.SetBody(body)
-		} else {
-			body := &ErrorResponse{
-				Jsonrpc: "2.0",
-				Id:      gconv.Int64(m.Seq()),
-				Context: m.Meta(),
-				Error: ErrorStruct{
-					Code:    m.Status().Code(),
-					Message: m.Status().Msg(),
-					Data:    m.Body(),
-				},
-			}
-			m.SetBody(body)
-		}
-		bodyBytes, err := m.MarshalBody()
+		result, err := m.MarshalBody()
 		if err != nil {
 			return err
 		}
-		_, err = bb.Write(bodyBytes)
-		_ = bb.WriteByte('\r')
-		_ = bb.WriteByte('\n')
-		if err != nil {
-			return err
+		w.Result = rawJSON(result)
+		if w.Result == nil {
+			w.Result = nullID
+		}
+	} else {
+		w.Error = &ErrorStruct{
+			Code:    ToJSONRPCCode(stat.Code()),
+			Message: stat.Msg(),
+			Data:    m.Body(),
 		}
 	}
-	// 写入消息
-	_, err := that.w.Write(bb.B)
+	b, err := json.Marshal(w)
 	if err != nil {
 		return err
 	}
-	return err
+	if c == nil {
+		return that.writeLine(b)
+	}
+	that.mu.Lock()
+	c.unit.replies[c.index] = b
+	c.unit.pending--
+	done := c.unit.pending == 0
+	that.mu.Unlock()
+	if !done {
+		return nil
+	}
+	return that.flush(c.unit)
 }
 
-// Unpack 解包
+// Unpack 解包，批量请求中的请求逐个解包，由会话并发执行
 func (that *jsonRPCProto) Unpack(m proto.Message) error {
-	bb := dbuffer.GetByteBuffer()
-	defer dbuffer.ReleaseByteBuffer(bb)
 	that.rMu.Lock()
 	defer that.rMu.Unlock()
-	data, err := that.RecvLine()
+	for {
+		for len(that.queue) == 0 {
+			if err := that.read(); err != nil {
+				return err
+			}
+		}
+		it := that.queue[0]
+		that.queue = that.queue[1:]
+		if ok, err := that.unpack(it, m); ok || err != nil {
+			return err
+		}
+	}
+}
+
+// 把单个请求或者响应写入消息，无法处理的响应返回false
+func (that *jsonRPCProto) unpack(it *item, m proto.Message) (bool, error) {
+	w := it.msg
+	if err := m.SetSize(gconv.Uint32(it.size)); err != nil {
+		return true, err
+	}
+	for k, v := range w.Context {
+		m.Meta().Set(k, v)
+	}
+	m.SetBodyCodec(codec.JsonId)
+	if m.Status(false) == nil {
+		m.Status(true)
+	}
+	if len(w.Method) > 0 {
+		m.SetServiceMethod(w.Method)
+		if w.Id == nil {
+			m.SetMType(message.TypePush)
+			m.SetSeq(that.nextSeq())
+		} else {
+			seq := that.nextSeq()
+			that.mu.Lock()
+			that.calls[seq] = &call{id: w.Id, unit: it.unit, index: it.index}
+			that.mu.Unlock()
+			m.SetMType(message.TypeCall)
+			m.SetSeq(seq)
+		}
+		return true, m.UnmarshalBody(w.Params)
+	}
+	// 响应的id是请求时的序列号
+	seq, err := strconv.ParseInt(string(w.Id), 10, 32)
+	if err != nil {
+		return false, nil
+	}
+	m.SetMType(message.TypeReply)
+	m.SetSeq(int32(seq))
+	if w.Error != nil {
+		m.SetStatus(drpc.NewStatus(FromJSONRPCCode(w.Error.Code), w.Error.Message))
+		data, _ := json.Marshal(w.Error.Data)
+		return true, m.UnmarshalBody(data)
+	}
+	return true, m.UnmarshalBody(w.Result)
+}
+
+// 读取一行请求或者一个http请求，放入待解包的队列，格式错误的请求直接响应错误
+func (that *jsonRPCProto) read() error {
+	line, err := that.RecvLine()
 	if err != nil {
 		return err
 	}
-	if err = m.SetSize(gconv.Uint32(len(data))); err != nil {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return nil
+	}
+	if a := strings.Fields(string(line)); len(a) != 3 || !strings.HasPrefix(a[2], "HTTP/") {
+		return that.parse(line, &unit{})
+	}
+	body, err := that.readHTTP(line)
+	if err != nil || body == nil {
 		return err
 	}
-	json := gjson.New(data)
-	if json.IsNil() {
-		return gerror.New("解包失败")
+	return that.parse(body, &unit{http: true})
+}
+
+// 读取http请求，只支持POST，返回请求体，不支持的请求直接响应错误并返回nil
+//  Content-Length 无效或者超过 message.MsgSizeLimit() 时，响应错误并返回error断开链接，不分配请求体
+func (that *jsonRPCProto) readHTTP(requestLine []byte) ([]byte, error) {
+	var (
+		contentLength int64
+		badLength     bool
+	)
+	for {
+		line, err := that.RecvLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			break
+		}
+		kv := strings.SplitN(string(line), ":", 2)
+		if len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "Content-Length") {
+			if contentLength, err = strconv.ParseInt(strings.TrimSpace(kv[1]), 10, 64); err != nil || contentLength < 0 {
+				badLength = true
+			}
+		}
 	}
-	m.SetSeq(json.Get("id").Int32())
-	for k, v := range json.Get("context").Map() {
-		m.Meta().Set(k, v)
+	if badLength {
+		_ = that.writeHTTP("400 Bad Request", nil)
+		return nil, gerror.New("解包失败，无效的Content-Length")
 	}
-	method := json.Get("method").String()
-	if len(method) > 0 {
-		m.SetMType(message.TypeCall)
-		m.SetServiceMethod(method)
-	} else {
-		m.SetMType(message.TypeReply)
+	if contentLength > int64(message.MsgSizeLimit()) {
+		_ = that.writeHTTP("413 Request Entity Too Large", nil)
+		return nil, gerror.Newf("解包失败，请求体长度 %d 超过限制 %d", contentLength, message.MsgSizeLimit())
 	}
-	m.SetBodyCodec(codec.JsonId)
-	if m.Status(false) == nil {
-		m.Status(true)
+	body := make([]byte, contentLength)
+	if _, err := io.ReadFull(that.r, body); err != nil {
+		return nil, err
 	}
+	if !bytes.HasPrefix(requestLine, []byte("POST ")) {
+		return nil, that.writeHTTP("405 Method Not Allowed", nil)
+	}
+	return body, nil
+}
 
-	if m.MType() == message.TypeCall {
-		bt, _ := json.GetJson("params").ToJson()
-		return m.UnmarshalBody(bt)
-	} else if m.MType() == message.TypeReply {
-		if json.GetJson("result").IsNil() {
-			errorJson := json.GetJson("error")
-			m.SetStatus(drpc.NewStatus(errorJson.Get("code").Int32(), errorJson.Get("message").String()))
-			return m.UnmarshalBody(errorJson.Get("data").Bytes())
-		} else {
-			bt, _ := json.GetJson("result").ToJson()
-			return m.UnmarshalBody(bt)
+// 解析单个请求或者批量请求
+func (that *jsonRPCProto) parse(data []byte, u *unit) error {
+	var raws []json.RawMessage
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		u.batch = true
+		if err := json.Unmarshal(data, &raws); err != nil {
+			return that.flushError(u, CodeParseError, err)
 		}
+		if len(raws) == 0 {
+			u.batch = false
+			return that.flushError(u, CodeInvalidRequest, gerror.New("empty batch"))
+		}
+	} else {
+		raws = []json.RawMessage{data}
+	}
+	var items []*item
+	that.mu.Lock()
+	for _, raw := range raws {
+		w := new(wireMessage)
+		if err := json.Unmarshal(raw, w); err != nil {
+			code := CodeInvalidRequest
+			if !u.batch {
+				code = CodeParseError
+			}
+			u.replies = append(u.replies, errorReply(nullID, code, err.Error()))
+			continue
+		}
+		//响应不需要再回复
+		if len(w.Method) == 0 && (w.Result != nil || w.Error != nil) {
+			items = append(items, &item{msg: w, size: len(raw), unit: u})
+			continue
+		}
+		if len(w.Method) == 0 {
+			u.replies = append(u.replies, errorReply(orNull(w.Id), CodeInvalidRequest, "method is required"))
+			continue
+		}
+		it := &item{msg: w, size: len(raw), unit: u, index: -1}
+		if w.Id != nil {
+			it.index = len(u.replies)
+			u.replies = append(u.replies, nil)
+			u.pending++
+		}
+		items = append(items, it)
+	}
+	pending := u.pending
+	that.mu.Unlock()
+	that.queue = append(that.queue, items...)
+	if pending == 0 && (len(u.replies) > 0 || u.http) {
+		return that.flush(u)
 	}
 	return nil
 }
 
-func (that *jsonRPCProto) RecvLine() ([]byte, error) {
-	var err error
-	var buffer []byte
-	var n int
-	data := make([]byte, 0)
-	buffer = make([]byte, 1)
-	for {
-		n, err = io.ReadFull(that.r, buffer)
-		if n > 0 {
-			if buffer[0] == '\n' {
-				data = append(data, buffer[:len(buffer)-1]...)
-				break
-			} else {
-				data = append(data, buffer...)
+// 写入所有响应，批量请求使用数组，没有响应的http请求返回204
+func (that *jsonRPCProto) flush(u *unit) error {
+	var b []byte
+	if u.batch {
+		var replies []json.RawMessage
+		for _, r := range u.replies {
+			if r != nil {
+				replies = append(replies, r)
 			}
 		}
-		if err != nil {
-			break
+		if len(replies) > 0 {
+			b, _ = json.Marshal(replies)
+		}
+	} else if len(u.replies) > 0 {
+		b = u.replies[0]
+	}
+	if u.http {
+		if b == nil {
+			return that.writeHTTP("204 No Content", nil)
 		}
+		return that.writeHTTP("200 OK", b)
+	}
+	if b == nil {
+		return nil
+	}
+	return that.writeLine(b)
+}
+
+// 整个请求无法解析时直接响应错误
+func (that *jsonRPCProto) flushError(u *unit, code int32, err error) error {
+	u.replies = []json.RawMessage{errorReply(nullID, code, err.Error())}
+	u.batch = false
+	return that.flush(u)
+}
+
+func (that *jsonRPCProto) writeLine(b []byte) error {
+	that.wMu.Lock()
+	defer that.wMu.Unlock()
+	_, err := that.w.Write(append(b, '\r', '\n'))
+	return err
+}
+
+func (that *jsonRPCProto) writeHTTP(status string, body []byte) error {
+	var bb bytes.Buffer
+	bb.WriteString("HTTP/1.1 " + status + "\r\n")
+	if body != nil {
+		bb.WriteString("Content-Type: application/json\r\n")
+	}
+	if status != "204 No Content" {
+		bb.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
+	}
+	bb.WriteString("\r\n")
+	bb.Write(body)
+	that.wMu.Lock()
+	defer that.wMu.Unlock()
+	_, err := that.w.Write(bb.Bytes())
+	return err
+}
+
+// 服务端生成的序列号，不使用请求的id，避免不同批次的id重复
+func (that *jsonRPCProto) nextSeq() int32 {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	that.lastSeq++
+	if that.lastSeq <= 0 {
+		that.lastSeq = 1
+	}
+	return that.lastSeq
+}
+
+// RecvLine 读取一行，不包含行尾的换行符
+func (that *jsonRPCProto) RecvLine() ([]byte, error) {
+	line, err := that.r.ReadBytes('\n')
+	return bytes.TrimRight(line, "\r\n"), err
+}
+
+func errorReply(id json.RawMessage, code int32, msg string) json.RawMessage {
+	b, _ := json.Marshal(&wireMessage{
+		Jsonrpc: "2.0",
+		Id:      id,
+		Error:   &ErrorStruct{Code: code, Message: msg},
+	})
+	return b
+}
+
+func meta(m proto.Message) map[string]interface{} {
+	if m.Meta().Size() == 0 {
+		return nil
+	}
+	md := make(map[string]interface{}, m.Meta().Size())
+	m.Meta().Iterator(func(k interface{}, v interface{}) bool {
+		md[gconv.String(k)] = v
+		return true
+	})
+	return md
+}
+
+func rawJSON(b []byte) json.RawMessage {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+func orNull(id json.RawMessage) json.RawMessage {
+	if id == nil {
+		return nullID
 	}
-	return data, err
+	return id
 }
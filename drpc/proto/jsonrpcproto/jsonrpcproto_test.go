@@ -1,12 +1,19 @@
 package jsonrpcproto_test
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"github.com/gogf/gf/v2/test/gtest"
 	"github.com/gogf/gf/v2/util/gconv"
 	"github.com/osgochina/dmicro/drpc"
 	"github.com/osgochina/dmicro/drpc/internal"
 	"github.com/osgochina/dmicro/drpc/message"
 	"github.com/osgochina/dmicro/drpc/proto/jsonrpcproto"
+	"io"
+	"net"
+	"net/http"
+	"strings"
 	"testing"
 	"time"
 )
@@ -63,3 +70,136 @@ func (p *Push) Test(arg *map[string]string) *drpc.Status {
 	internal.Infof(context.TODO(), "receive push(%s):\narg: %#v\n", p.IP(), arg)
 	return nil
 }
+
+type Math struct {
+	drpc.CallCtx
+}
+
+func (m *Math) Add(arg *[]int) (int, *drpc.Status) {
+	var sum int
+	for _, v := range *arg {
+		sum += v
+	}
+	return sum, nil
+}
+
+var notified = make(chan string, 1)
+
+type Notify struct {
+	drpc.PushCtx
+}
+
+func (n *Notify) Log(arg *string) *drpc.Status {
+	notified <- *arg
+	return nil
+}
+
+func TestJSONRPCBatch(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		srv := drpc.NewEndpoint(drpc.EndpointConfig{ListenPort: 9301})
+		srv.RouteCall(new(Math))
+		srv.RoutePush(new(Notify))
+		go srv.ListenAndServe(jsonrpcproto.NewJSONRPCProtoFunc())
+		defer srv.Close()
+		time.Sleep(1e9)
+
+		conn, err := net.Dial("tcp", ":9301")
+		t.AssertNil(err)
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		call := func(req string) string {
+			_, err := conn.Write([]byte(req + "\r\n"))
+			t.AssertNil(err)
+			_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+			line, err := r.ReadString('\n')
+			t.AssertNil(err)
+			return strings.TrimSpace(line)
+		}
+
+		// 单个请求，id原样返回
+		t.Assert(call(`{"jsonrpc":"2.0","method":"/math/add","params":[1,2],"id":"a"}`),
+			`{"jsonrpc":"2.0","id":"a","result":3}`)
+
+		// 通知没有响应，转换成PUSH
+		_, err = conn.Write([]byte(`{"jsonrpc":"2.0","method":"/notify/log","params":"hello"}` + "\r\n"))
+		t.AssertNil(err)
+		select {
+		case v := <-notified:
+			t.Assert(v, "hello")
+		case <-time.After(3 * time.Second):
+			t.Fatal("notification timeout")
+		}
+
+		// 批量请求，按照请求的顺序返回，通知不返回
+		var replies []map[string]interface{}
+		t.AssertNil(json.Unmarshal([]byte(call(`[`+
+			`{"jsonrpc":"2.0","method":"/math/add","params":[1,2],"id":1},`+
+			`{"jsonrpc":"2.0","method":"/notify/log","params":"batch"},`+
+			`{"jsonrpc":"2.0","method":"/math/sub","params":[1,2],"id":2},`+
+			`{"jsonrpc":"2.0","method":"/math/add","params":"x","id":3},`+
+			`1]`)), &replies))
+		t.Assert(len(replies), 4)
+		t.Assert(replies[0]["result"], 3)
+		t.Assert(replies[1]["error"].(map[string]interface{})["code"], jsonrpcproto.CodeMethodNotFound)
+		t.Assert(replies[2]["error"].(map[string]interface{})["code"], jsonrpcproto.CodeInvalidParams)
+		t.Assert(replies[3]["error"].(map[string]interface{})["code"], jsonrpcproto.CodeInvalidRequest)
+		t.Assert(replies[3]["id"], nil)
+		t.Assert(<-notified, "batch")
+
+		// 格式错误
+		t.Assert(call(`{"jsonrpc":"2.0",`), `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"unexpected end of JSON input","data":null}}`)
+		t.Assert(call(`[]`), `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"empty batch","data":null}}`)
+
+		// HTTP POST
+		resp, err := http.Post("http://127.0.0.1:9301/", "application/json",
+			strings.NewReader(`[{"jsonrpc":"2.0","method":"/math/add","params":[3,4],"id":7}]`))
+		t.AssertNil(err)
+		b, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		t.AssertNil(err)
+		t.Assert(resp.StatusCode, http.StatusOK)
+		t.Assert(string(b), `[{"jsonrpc":"2.0","id":7,"result":7}]`)
+
+		resp, err = http.Post("http://127.0.0.1:9301/", "application/json",
+			strings.NewReader(`{"jsonrpc":"2.0","method":"/notify/log","params":"http"}`))
+		t.AssertNil(err)
+		_ = resp.Body.Close()
+		t.Assert(resp.StatusCode, http.StatusNoContent)
+		t.Assert(<-notified, "http")
+	})
+}
+
+func TestJSONRPCContentLength(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		message.SetMsgSizeLimit(1024)
+		defer message.SetMsgSizeLimit(0)
+		srv := drpc.NewEndpoint(drpc.EndpointConfig{ListenPort: 9302})
+		srv.RouteCall(new(Math))
+		go srv.ListenAndServe(jsonrpcproto.NewJSONRPCProtoFunc())
+		defer srv.Close()
+		time.Sleep(1e9)
+
+		// 返回响应的状态行，响应之后链接会被断开
+		post := func(contentLength string) string {
+			conn, err := net.Dial("tcp", ":9302")
+			t.AssertNil(err)
+			defer conn.Close()
+			_, err = conn.Write([]byte("POST / HTTP/1.1\r\nContent-Length: " + contentLength + "\r\n\r\n"))
+			t.AssertNil(err)
+			_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+			r := bufio.NewReader(conn)
+			line, err := r.ReadString('\n')
+			t.AssertNil(err)
+			for {
+				if _, err = r.ReadString('\n'); err != nil {
+					break
+				}
+			}
+			t.Assert(err, io.EOF)
+			return strings.TrimSpace(line)
+		}
+		t.Assert(post("1099511627776"), "HTTP/1.1 413 Request Entity Too Large")
+		t.Assert(post("-1"), "HTTP/1.1 400 Bad Request")
+		t.Assert(post("abc"), "HTTP/1.1 400 Bad Request")
+	})
+}
@@ -0,0 +1,283 @@
+package mqttproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/gogf/gf/v2/encoding/gjson"
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/osgochina/dmicro/drpc/codec"
+	"github.com/osgochina/dmicro/drpc/message"
+	"github.com/osgochina/dmicro/drpc/proto"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 与 heartbeat 插件的服务名以及元数据保持一致，PINGREQ 转换成心跳的PUSH消息
+const (
+	heartbeatServiceMethod = "/heartbeat"
+	heartbeatMetaKey       = "hb_"
+)
+
+// Options mqtt协议的配置
+type Options struct {
+	// CheckAuth 是否使用 auth 插件检查 CONNECT，开启后必须安装 auth.NewCheckerPlugin
+	CheckAuth bool
+	// BodyCodec PUBLISH 的有效载荷的编码格式，默认为 plain
+	BodyCodec byte
+}
+
+type Option func(*Options)
+
+// OptCheckAuth 使用 auth 插件检查 CONNECT
+func OptCheckAuth(t bool) Option {
+	return func(o *Options) {
+		o.CheckAuth = t
+	}
+}
+
+// OptBodyCodec 设置 PUBLISH 的有效载荷的编码格式
+func OptBodyCodec(id byte) Option {
+	return func(o *Options) {
+		o.BodyCodec = id
+	}
+}
+
+// NewMQTTProtoFunc 创建 mqtt 3.1.1 协议，作为服务端接入设备
+//  CONNECT 开启 OptCheckAuth 时转换成认证消息，由 auth 插件的检票方法检查，票据是 *Connect，否则直接接受
+//  QoS 0 以及 QoS 1 的 PUBLISH 转换成PUSH消息，主题作为服务名，例如 sensors/temp 为 /sensors/temp，QoS 1 收到后立即回复 PUBACK
+//  SUBSCRIBE 记录会话订阅的主题，服务端发送的PUSH消息只有主题匹配时才转换成 QoS 0 的 PUBLISH 发给设备，其他的丢弃
+//  PINGREQ 转换成 /heartbeat 的PUSH消息，配合 heartbeat.NewPong 插件，超过两倍 KeepAlive 没有消息时关闭会话
+//  不支持 QoS 2 以及遗嘱消息的投递
+func NewMQTTProtoFunc(opts ...Option) proto.ProtoFunc {
+	options := Options{BodyCodec: codec.PlainId}
+	for _, o := range opts {
+		o(&options)
+	}
+	return func(rw proto.IOWithReadBuffer) proto.Proto {
+		return &mqttProto{
+			id:      'm',
+			name:    "mqtt",
+			r:       bufio.NewReader(rw),
+			w:       rw,
+			options: options,
+			subs:    make(map[string]byte),
+		}
+	}
+}
+
+var _ proto.Proto = new(mqttProto)
+
+type mqttProto struct {
+	r         *bufio.Reader
+	w         io.Writer
+	rMu       sync.Mutex
+	wMu       sync.Mutex
+	name      string
+	id        byte
+	options   Options
+	connected bool
+	keepAlive uint16
+	seq       int32
+
+	subMu sync.RWMutex
+	subs  map[string]byte // 订阅的主题过滤器以及授予的QoS
+}
+
+func (that *mqttProto) Version() (byte, string) {
+	return that.id, that.name
+}
+
+func (that *mqttProto) Pack(m proto.Message) error {
+	switch m.MType() {
+	case message.TypeAuthReply:
+		return that.write(encodePacket(packetConnAck, 0, []byte{0, ConnAckCode(m.Status())}))
+	case message.TypePush:
+		if m.ServiceMethod() == heartbeatServiceMethod {
+			// 设备使用 PINGREQ 心跳，不需要服务端发起
+			return nil
+		}
+		topic := strings.TrimPrefix(m.ServiceMethod(), "/")
+		if !that.subscribed(topic) {
+			return nil
+		}
+		var payload []byte
+		switch v := m.Body().(type) {
+		case []byte:
+			payload = v
+		case string:
+			payload = []byte(v)
+		default:
+			b, err := m.MarshalBody()
+			if err != nil {
+				return err
+			}
+			payload = b
+		}
+		return that.write(encodePacket(packetPublish, 0, append(appendString(nil, topic), payload...)))
+	case message.TypeReply:
+		// PUBLISH 没有回复
+		return nil
+	default:
+		return gerror.Newf("mqttproto: message type %s is not supported", message.TypeText(m.MType()))
+	}
+}
+
+func (that *mqttProto) write(b []byte) error {
+	that.wMu.Lock()
+	defer that.wMu.Unlock()
+	_, err := that.w.Write(b)
+	return err
+}
+
+// 主题是否被订阅
+func (that *mqttProto) subscribed(topic string) bool {
+	that.subMu.RLock()
+	defer that.subMu.RUnlock()
+	for filter := range that.subs {
+		if matchTopic(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (that *mqttProto) Unpack(m proto.Message) error {
+	that.rMu.Lock()
+	defer that.rMu.Unlock()
+	for {
+		p, err := readPacket(that.r, message.MsgSizeLimit())
+		if err != nil {
+			return err
+		}
+		if !that.connected && p.typ != packetConnect {
+			return gerror.Newf("mqttproto: expect CONNECT, but received packet type %d", p.typ)
+		}
+		switch p.typ {
+		case packetConnect:
+			if that.connected {
+				return gerror.New("mqttproto: duplicate CONNECT")
+			}
+			c, code, err := parseConnect(p.payload)
+			if err != nil {
+				return err
+			}
+			if code != ConnAccepted {
+				_ = that.write(encodePacket(packetConnAck, 0, []byte{0, code}))
+				return gerror.New("mqttproto: unsupported protocol level")
+			}
+			that.connected = true
+			that.keepAlive = c.KeepAlive
+			if !that.options.CheckAuth {
+				if err = that.write(encodePacket(packetConnAck, 0, []byte{0, ConnAccepted})); err != nil {
+					return err
+				}
+				continue
+			}
+			// 由 auth 插件检票后回复 CONNACK
+			b, err := gjson.Encode(c)
+			if err != nil {
+				return err
+			}
+			that.seq++
+			m.SetMType(message.TypeAuthCall)
+			m.SetSeq(that.seq)
+			m.SetBodyCodec(codec.JsonId)
+			return m.UnmarshalBody(b)
+
+		case packetPublish:
+			qos := p.flags >> 1 & 0x03
+			if qos > 1 {
+				return gerror.Newf("mqttproto: QoS %d is not supported", qos)
+			}
+			d := &decoder{b: p.payload}
+			topic := d.string()
+			var packetID uint16
+			if qos == 1 {
+				packetID = d.uint16()
+			}
+			if d.err != nil {
+				return d.err
+			}
+			if qos == 1 {
+				if err = that.write(encodePacket(packetPubAck, 0, binary.BigEndian.AppendUint16(nil, packetID))); err != nil {
+					return err
+				}
+			}
+			that.seq++
+			m.SetMType(message.TypePush)
+			m.SetSeq(that.seq)
+			m.SetServiceMethod("/" + topic)
+			m.SetBodyCodec(that.options.BodyCodec)
+			if err = m.SetSize(uint32(len(p.payload))); err != nil {
+				return err
+			}
+			return m.UnmarshalBody(d.b)
+
+		case packetSubscribe, packetUnsubscribe:
+			if err = that.subscribe(p); err != nil {
+				return err
+			}
+
+		case packetPingReq:
+			if err = that.write(encodePacket(packetPingResp, 0, nil)); err != nil {
+				return err
+			}
+			that.seq++
+			m.SetMType(message.TypePush)
+			m.SetSeq(that.seq)
+			m.SetServiceMethod(heartbeatServiceMethod)
+			if that.keepAlive > 0 {
+				m.Meta().Set(heartbeatMetaKey, strconv.Itoa(int(that.keepAlive)))
+			}
+			// 没有消息体也需要调用，由会话绑定处理方法
+			return m.UnmarshalBody(nil)
+
+		case packetPubAck:
+			// 服务端只发送 QoS 0 的消息，忽略
+
+		case packetDisconnect:
+			return io.EOF
+
+		default:
+			return gerror.Newf("mqttproto: packet type %d is not supported", p.typ)
+		}
+	}
+}
+
+// 处理 SUBSCRIBE 以及 UNSUBSCRIBE，授予的QoS最大为1
+func (that *mqttProto) subscribe(p *packet) error {
+	d := &decoder{b: p.payload}
+	packetID := d.uint16()
+	ack := binary.BigEndian.AppendUint16(nil, packetID)
+	that.subMu.Lock()
+	for d.err == nil && len(d.b) > 0 {
+		filter := d.string()
+		if p.typ == packetUnsubscribe {
+			delete(that.subs, filter)
+			continue
+		}
+		qos := d.byte()
+		if d.err != nil {
+			break
+		}
+		if filter == "" || qos > 2 {
+			ack = append(ack, 0x80)
+			continue
+		}
+		if qos > 1 {
+			qos = 1
+		}
+		that.subs[filter] = qos
+		ack = append(ack, qos)
+	}
+	that.subMu.Unlock()
+	if d.err != nil {
+		return d.err
+	}
+	if p.typ == packetUnsubscribe {
+		return that.write(encodePacket(packetUnsubAck, 0, ack))
+	}
+	return that.write(encodePacket(packetSubAck, 0, ack))
+}
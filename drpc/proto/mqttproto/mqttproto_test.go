@@ -0,0 +1,127 @@
+package mqttproto_test
+
+import (
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/osgochina/dmicro/drpc"
+	"github.com/osgochina/dmicro/drpc/plugin/auth"
+	"github.com/osgochina/dmicro/drpc/plugin/heartbeat"
+	"github.com/osgochina/dmicro/drpc/proto/mqttproto"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+var received = make(chan string, 10)
+
+type Sensors struct {
+	drpc.PushCtx
+}
+
+func (s *Sensors) Temp(arg *string) *drpc.Status {
+	received <- s.Session().ID() + ":" + *arg
+	return nil
+}
+
+var authChecker = auth.NewCheckerPlugin(func(sess auth.Session, fn auth.ReCvOnce) (interface{}, *drpc.Status) {
+	var c mqttproto.Connect
+	if stat := fn(&c); !stat.OK() {
+		return nil, stat
+	}
+	if c.Username != "device" || c.Password != "secret" {
+		return nil, drpc.NewStatus(drpc.CodeUnauthorized, "bad user name or password", "")
+	}
+	sess.SetID(c.ClientID)
+	return nil, nil
+})
+
+func newClient(clientID, password string) mqtt.Client {
+	opts := mqtt.NewClientOptions().
+		AddBroker("tcp://127.0.0.1:9321").
+		SetClientID(clientID).
+		SetUsername("device").
+		SetPassword(password).
+		SetKeepAlive(2 * time.Second).
+		SetAutoReconnect(false).
+		SetConnectRetry(false)
+	return mqtt.NewClient(opts)
+}
+
+func TestMQTTProto(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		srv := drpc.NewEndpoint(drpc.EndpointConfig{ListenPort: 9321}, authChecker, heartbeat.NewPong())
+		srv.RoutePush(new(Sensors))
+		go srv.ListenAndServe(mqttproto.NewMQTTProtoFunc(mqttproto.OptCheckAuth(true)))
+		defer srv.Close()
+		time.Sleep(1e9)
+
+		// 认证失败
+		bad := newClient("dev-0", "wrong")
+		token := bad.Connect()
+		t.Assert(token.WaitTimeout(3*time.Second), true)
+		t.AssertNE(token.Error(), nil)
+
+		cli := newClient("dev-1", "secret")
+		token = cli.Connect()
+		t.Assert(token.WaitTimeout(3*time.Second), true)
+		t.AssertNil(token.Error())
+		defer cli.Disconnect(100)
+
+		// QoS 0 以及 QoS 1 的 PUBLISH 转换成PUSH消息
+		for _, qos := range []byte{0, 1} {
+			token = cli.Publish("sensors/temp", qos, false, "21.5")
+			t.Assert(token.WaitTimeout(3*time.Second), true)
+			t.AssertNil(token.Error())
+			select {
+			case v := <-received:
+				t.Assert(v, "dev-1:21.5")
+			case <-time.After(3 * time.Second):
+				t.Fatal("push not received")
+			}
+		}
+
+		// 订阅后服务端的PUSH消息按照主题转发
+		messages := make(chan mqtt.Message, 10)
+		token = cli.Subscribe("alerts/+/fire", 1, func(_ mqtt.Client, msg mqtt.Message) {
+			messages <- msg
+		})
+		t.Assert(token.WaitTimeout(3*time.Second), true)
+		t.AssertNil(token.Error())
+		sess, ok := srv.GetSession("dev-1")
+		t.Assert(ok, true)
+		t.AssertNil(sess.Push("/alerts/room1/smoke", "ignored"))
+		t.AssertNil(sess.Push("/alerts/room1/fire", "hot"))
+		select {
+		case msg := <-messages:
+			t.Assert(msg.Topic(), "alerts/room1/fire")
+			t.Assert(string(msg.Payload()), "hot")
+		case <-time.After(3 * time.Second):
+			t.Fatal("publish not received")
+		}
+
+		// PINGREQ 维持心跳，超过 KeepAlive 之后会话依然存在
+		time.Sleep(5 * time.Second)
+		t.Assert(cli.IsConnectionOpen(), true)
+		_, ok = srv.GetSession("dev-1")
+		t.Assert(ok, true)
+
+		// 没有 PINGREQ 时由 heartbeat 插件关闭会话
+		conn, err := net.Dial("tcp", "127.0.0.1:9321")
+		t.AssertNil(err)
+		defer conn.Close()
+		connect := []byte{0x10, 33, 0, 4, 'M', 'Q', 'T', 'T', 4, 0xc2, 0, 1,
+			0, 5, 'd', 'e', 'v', '-', '2',
+			0, 6, 'd', 'e', 'v', 'i', 'c', 'e',
+			0, 6, 's', 'e', 'c', 'r', 'e', 't'}
+		_, err = conn.Write(append(connect, 0xc0, 0))
+		t.AssertNil(err)
+		buf := make([]byte, 6)
+		_, err = io.ReadFull(conn, buf)
+		t.AssertNil(err)
+		t.Assert(buf, []byte{0x20, 2, 0, 0, 0xd0, 0})
+		_ = conn.SetReadDeadline(time.Now().Add(8 * time.Second))
+		_, err = conn.Read(buf)
+		t.Assert(err, io.EOF)
+	})
+}
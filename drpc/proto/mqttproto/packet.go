@@ -0,0 +1,236 @@
+package mqttproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/osgochina/dmicro/drpc"
+	"io"
+	"strings"
+)
+
+// mqtt 3.1.1 的控制报文类型
+const (
+	packetConnect     byte = 1
+	packetConnAck     byte = 2
+	packetPublish     byte = 3
+	packetPubAck      byte = 4
+	packetSubscribe   byte = 8
+	packetSubAck      byte = 9
+	packetUnsubscribe byte = 10
+	packetUnsubAck    byte = 11
+	packetPingReq     byte = 12
+	packetPingResp    byte = 13
+	packetDisconnect  byte = 14
+)
+
+// CONNACK 的返回码
+const (
+	ConnAccepted                     byte = 0
+	ConnRefusedProtocolVersion       byte = 1
+	ConnRefusedIdentifierRejected    byte = 2
+	ConnRefusedServerUnavailable     byte = 3
+	ConnRefusedBadUsernameOrPassword byte = 4
+	ConnRefusedNotAuthorized         byte = 5
+)
+
+// 协议级别，4 表示 mqtt 3.1.1
+const protocolLevel = 4
+
+// Connect CONNECT 报文的内容，开启认证时作为票据交给 auth 插件的检票方法
+type Connect struct {
+	ClientID     string `json:"client_id"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	KeepAlive    uint16 `json:"keep_alive"` // 心跳间隔，单位秒
+	CleanSession bool   `json:"clean_session"`
+	WillTopic    string `json:"will_topic"`
+	WillMessage  []byte `json:"will_message"`
+	WillQoS      byte   `json:"will_qos"`
+	WillRetain   bool   `json:"will_retain"`
+}
+
+// ConnAckCode 把检票的状态转换成 CONNACK 的返回码
+func ConnAckCode(stat *drpc.Status) byte {
+	switch {
+	case stat.OK():
+		return ConnAccepted
+	case stat.Code() == drpc.CodeBadMessage:
+		return ConnRefusedIdentifierRejected
+	case stat.Code() == drpc.CodeUnauthorized:
+		return ConnRefusedBadUsernameOrPassword
+	case stat.Code() == drpc.CodeInternalServerError:
+		return ConnRefusedServerUnavailable
+	default:
+		return ConnRefusedNotAuthorized
+	}
+}
+
+// 控制报文
+type packet struct {
+	typ     byte
+	flags   byte
+	payload []byte
+}
+
+// 读取一个控制报文，剩余长度超过 limit 时返回错误
+func readPacket(r *bufio.Reader, limit uint32) (*packet, error) {
+	h, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		length     uint32
+		multiplier uint32 = 1
+	)
+	// 剩余长度最多使用4个字节
+	for i := 0; ; i++ {
+		if i == 4 {
+			return nil, gerror.New("mqttproto: malformed remaining length")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length += uint32(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	if limit > 0 && length > limit {
+		return nil, gerror.Newf("mqttproto: packet size %d exceeds the limit %d", length, limit)
+	}
+	p := &packet{typ: h >> 4, flags: h & 0x0f, payload: make([]byte, length)}
+	if _, err = io.ReadFull(r, p.payload); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// 序列化控制报文
+func encodePacket(typ, flags byte, payload []byte) []byte {
+	b := make([]byte, 0, len(payload)+5)
+	b = append(b, typ<<4|flags)
+	length := len(payload)
+	for {
+		d := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if length == 0 {
+			break
+		}
+	}
+	return append(b, payload...)
+}
+
+// 报文可变头以及有效载荷的读取器
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (that *decoder) uint16() uint16 {
+	if that.err != nil {
+		return 0
+	}
+	if len(that.b) < 2 {
+		that.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := binary.BigEndian.Uint16(that.b)
+	that.b = that.b[2:]
+	return v
+}
+
+func (that *decoder) byte() byte {
+	if that.err != nil {
+		return 0
+	}
+	if len(that.b) < 1 {
+		that.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := that.b[0]
+	that.b = that.b[1:]
+	return v
+}
+
+// 两个字节长度前缀的数据
+func (that *decoder) bytes() []byte {
+	n := int(that.uint16())
+	if that.err != nil {
+		return nil
+	}
+	if len(that.b) < n {
+		that.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	v := that.b[:n]
+	that.b = that.b[n:]
+	return v
+}
+
+func (that *decoder) string() string {
+	return string(that.bytes())
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// 解析 CONNECT 报文，协议级别不支持时返回 ConnRefusedProtocolVersion
+func parseConnect(payload []byte) (*Connect, byte, error) {
+	d := &decoder{b: payload}
+	name := d.string()
+	level := d.byte()
+	flags := d.byte()
+	c := &Connect{KeepAlive: d.uint16()}
+	if d.err != nil {
+		return nil, 0, d.err
+	}
+	if name != "MQTT" || level != protocolLevel {
+		return nil, ConnRefusedProtocolVersion, nil
+	}
+	c.CleanSession = flags&0x02 != 0
+	c.ClientID = d.string()
+	if flags&0x04 != 0 {
+		c.WillTopic = d.string()
+		c.WillMessage = append([]byte(nil), d.bytes()...)
+		c.WillQoS = flags >> 3 & 0x03
+		c.WillRetain = flags&0x20 != 0
+	}
+	if flags&0x80 != 0 {
+		c.Username = d.string()
+	}
+	if flags&0x40 != 0 {
+		c.Password = d.string()
+	}
+	if d.err != nil {
+		return nil, 0, d.err
+	}
+	return c, ConnAccepted, nil
+}
+
+// 订阅的主题过滤器是否匹配主题，支持 + 以及 # 通配符
+//  以 $ 开头的主题不匹配第一级的通配符
+func matchTopic(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	fs := strings.Split(filter, "/")
+	ts := strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ts) || (f != "+" && f != ts[i]) {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
@@ -6,32 +6,55 @@ import (
 	"github.com/osgochina/dmicro/drpc/codec/redis_codec"
 	"github.com/osgochina/dmicro/drpc/message"
 	"github.com/osgochina/dmicro/drpc/proto"
-	"github.com/osgochina/dmicro/utils/dbuffer"
 	"io"
+	"path"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 func NewRedisProtoFunc() proto.ProtoFunc {
 	return RedisProtoFunc
 }
 
+// 链接的编号，HELLO 命令返回给客户端
+var connID int64
+
+// RedisProtoFunc redis协议
+//  支持管道，同一个链接上的命令按照接收的顺序回复
+//  客户端通过 HELLO 3 切换到RESP3协议，默认使用RESP2协议，RESP3的消息类型会转换成RESP2的兼容类型
+//  drpc的PUSH消息转换成RESP3的推送消息，RESP2时转换成数组
 var RedisProtoFunc = func(rw proto.IOWithReadBuffer) proto.Proto {
 	return &redisProto{
-		id:   'r',
-		name: "redis",
-		r:    rw,
-		w:    rw,
+		id:       'r',
+		name:     "redis",
+		r:        bufio.NewReader(rw),
+		w:        rw,
+		resp:     redis_codec.RESP2,
+		connID:   atomic.AddInt64(&connID, 1),
+		next:     1,
+		pending:  make(map[int32][]byte),
+		versions: make(map[int32]int),
 	}
 }
 
 var _ proto.Proto = new(redisProto)
 
 type redisProto struct {
-	r    io.Reader
-	w    io.Writer
-	rMu  sync.Mutex
-	name string
-	id   byte
+	r      *bufio.Reader
+	w      io.Writer
+	rMu    sync.Mutex
+	name   string
+	id     byte
+	resp   int32 // 当前协商的协议版本
+	connID int64
+	seq    int32 // 最后一个收到的命令的序号
+
+	wMu      sync.Mutex
+	next     int32            // 下一个需要回复的命令的序号
+	pending  map[int32][]byte // 先完成的命令的回复，等待前面的命令回复后再写入
+	versions map[int32]int    // 命令接收时的协议版本，回复时使用
 }
 
 func (that *redisProto) Version() (byte, string) {
@@ -39,64 +62,159 @@ func (that *redisProto) Version() (byte, string) {
 }
 
 func (that *redisProto) Pack(m proto.Message) error {
-	bb := dbuffer.GetByteBuffer()
-	defer dbuffer.ReleaseByteBuffer(bb)
-
-	if m.MType() == message.TypeReply {
+	switch m.MType() {
+	case message.TypeReply:
+		that.wMu.Lock()
+		defer that.wMu.Unlock()
+		resp := that.versions[m.Seq()]
+		var b []byte
 		if !m.StatusOK() {
-			_, _ = bb.Write(redis_codec.MakeErrorMsg(m.Status().Msg()).Bytes())
-			goto write
+			b = redis_codec.MakeErrorMsg(m.Status().Msg()).Bytes()
+		} else if msg, ok := m.Body().(redis_codec.Msg); ok {
+			b = redis_codec.Encode(msg, resp)
+		} else if m.Body() == nil {
+			b = redis_codec.MakeNullMsg().ProtoBytes(resp)
+		} else {
+			bodyBytes, err := m.MarshalBody()
+			if err != nil {
+				// 必须回复，否则后面的命令都会阻塞
+				bodyBytes = redis_codec.MakeErrorMsg("ERR " + err.Error()).Bytes()
+			}
+			b = bodyBytes
 		}
-		bodyBytes, err := m.MarshalBody()
+		return that.reply(m.Seq(), b)
+	case message.TypePush:
+		b, err := that.packPush(m)
 		if err != nil {
 			return err
 		}
-		_, _ = bb.Write(bodyBytes)
-		goto write
+		that.wMu.Lock()
+		defer that.wMu.Unlock()
+		_, err = that.w.Write(b)
+		return err
 	}
+	return nil
+}
 
-write:
-	//写入数据到链接
-	_, err := that.w.Write(bb.B)
-	if err != nil {
-		return err
+// 推送消息转换成RESP3的推送消息，第一个元素是服务名的最后一段，例如 /redis/message 为 message
+//  消息体是 *redis_codec.PushMsg 时原样发送
+func (that *redisProto) packPush(m proto.Message) ([]byte, error) {
+	resp := int(atomic.LoadInt32(&that.resp))
+	var body redis_codec.Msg
+	switch v := m.Body().(type) {
+	case *redis_codec.PushMsg:
+		return v.ProtoBytes(resp), nil
+	case redis_codec.Msg:
+		body = v
+	case nil:
+		body = redis_codec.MakeNullMsg()
+	default:
+		bodyBytes, err := m.MarshalBody()
+		if err != nil {
+			return nil, err
+		}
+		body = redis_codec.MakeBulkMsg(bodyBytes)
 	}
+	kind := redis_codec.MakeBulkMsg([]byte(path.Base(m.ServiceMethod())))
+	return redis_codec.MakePushMsg(kind, body).ProtoBytes(resp), nil
+}
 
-	return nil
+// 按照命令的顺序写入回复，调用方需要持有 wMu
+func (that *redisProto) reply(seq int32, b []byte) error {
+	that.pending[seq] = b
+	for {
+		b, ok := that.pending[that.next]
+		if !ok {
+			return nil
+		}
+		delete(that.pending, that.next)
+		delete(that.versions, that.next)
+		that.next++
+		if _, err := that.w.Write(b); err != nil {
+			return err
+		}
+	}
 }
 
 func (that *redisProto) Unpack(m proto.Message) error {
+	that.rMu.Lock()
+	defer that.rMu.Unlock()
+	for {
+		request, err := parseMsgByIO(that.r)
+		if err != nil {
+			return err
+		}
+		switch v := request.(type) {
+		case *redis_codec.SuccessMsg:
+			m.SetMType(message.TypeReply)
+		case *redis_codec.ErrorMsg:
+			m.SetMType(message.TypeReply)
+		case *redis_codec.NumberMsg:
+			m.SetMType(message.TypeReply)
+		case *redis_codec.BulkMsg:
+		case *redis_codec.MultiBulkMsg:
+			that.seq++
+			resp := int(atomic.LoadInt32(&that.resp))
+			if strings.EqualFold(string(v.Args[0]), "hello") {
+				// HELLO 命令在协议内部处理，处理完成后继续读取下一个命令
+				if err = that.hello(that.seq, v.Args[1:]); err != nil {
+					return err
+				}
+				continue
+			}
+			that.wMu.Lock()
+			that.versions[that.seq] = resp
+			that.wMu.Unlock()
+			m.SetMType(message.TypeCall)
+			m.SetSeq(that.seq)
+			m.SetBodyCodec('r')
+			m.SetServiceMethod(string(v.Args[0]))
+			s, _ := gjson.Encode(v.Args[1:])
+			_ = m.UnmarshalBody(s)
+		case *redis_codec.EmptyMultiBulkMsg:
+		case *redis_codec.NullBulkMsg:
+		default:
 
-	bb := dbuffer.GetByteBuffer()
-	defer dbuffer.ReleaseByteBuffer(bb)
-	request, err := parseMsgByIO(bufio.NewReader(that.r))
-	if err != nil {
-		return err
+		}
+		return nil
 	}
-	//m.SetSeq(0)
-	//m.SetServiceMethod("")
-	//_ = m.Status(true).SetMsg("")
-	//m.SetBodyCodec('s')
-	//m.UnmarshalBody([]byte(""))
-	switch v := request.(type) {
-	case *redis_codec.SuccessMsg:
-		m.SetMType(message.TypeReply)
-	case *redis_codec.ErrorMsg:
-		m.SetMType(message.TypeReply)
-	case *redis_codec.NumberMsg:
-		m.SetMType(message.TypeReply)
-	case *redis_codec.BulkMsg:
-	case *redis_codec.MultiBulkMsg:
-		m.SetMType(message.TypeCall)
-		m.SetBodyCodec('r')
-		m.SetServiceMethod(string(v.Args[0]))
-		s, _ := gjson.Encode(v.Args[1:])
-		_ = m.UnmarshalBody(s)
-	case *redis_codec.EmptyMultiBulkMsg:
-	case *redis_codec.NullBulkMsg:
-	default:
+}
 
+// 处理 HELLO [protover [AUTH username password] [SETNAME clientname]] 命令
+//  协商成功后返回服务端信息，之后的命令使用新的协议版本回复
+//  不支持 AUTH 选项，需要认证时请使用 AUTH 命令
+func (that *redisProto) hello(seq int32, args [][]byte) error {
+	resp := int(atomic.LoadInt32(&that.resp))
+	var errMsg string
+	if len(args) > 0 {
+		v, err := strconv.Atoi(string(args[0]))
+		if err != nil || (v != redis_codec.RESP2 && v != redis_codec.RESP3) {
+			errMsg = "NOPROTO unsupported protocol version"
+		} else {
+			for _, arg := range args[1:] {
+				if strings.EqualFold(string(arg), "auth") {
+					errMsg = "ERR AUTH option of HELLO is not supported, use AUTH command"
+					break
+				}
+			}
+			if errMsg == "" {
+				resp = v
+				atomic.StoreInt32(&that.resp, int32(v))
+			}
+		}
 	}
-
-	return nil
+	that.wMu.Lock()
+	defer that.wMu.Unlock()
+	if errMsg != "" {
+		return that.reply(seq, redis_codec.MakeErrorMsg(errMsg).Bytes())
+	}
+	info := redis_codec.MakeMapMsg().
+		Set("server", redis_codec.MakeBulkMsg([]byte("dmicro"))).
+		Set("version", redis_codec.MakeBulkMsg([]byte("1.0.0"))).
+		Set("proto", redis_codec.MakeNumberMsg(int64(resp))).
+		Set("id", redis_codec.MakeNumberMsg(that.connID)).
+		Set("mode", redis_codec.MakeBulkMsg([]byte("standalone"))).
+		Set("role", redis_codec.MakeBulkMsg([]byte("master"))).
+		Set("modules", redis_codec.MakeArrayMsg())
+	return that.reply(seq, info.ProtoBytes(resp))
 }
@@ -0,0 +1,132 @@
+package redisproto_test
+
+import (
+	"bufio"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/osgochina/dmicro/drpc"
+	"github.com/osgochina/dmicro/drpc/codec/redis_codec"
+	"github.com/osgochina/dmicro/drpc/plugin/redis"
+	"github.com/osgochina/dmicro/drpc/proto/redisproto"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Redis struct {
+	drpc.CallCtx
+}
+
+func (m *Redis) Slow(_ *redis_codec.CmdLine) (redis_codec.Msg, *drpc.Status) {
+	time.Sleep(300 * time.Millisecond)
+	return redis_codec.MakeSuccessMsg("slow"), nil
+}
+
+func (m *Redis) Ping(_ *redis_codec.CmdLine) (redis_codec.Msg, *drpc.Status) {
+	return redis_codec.MakeSuccessMsg("PONG"), nil
+}
+
+func (m *Redis) Info(_ *redis_codec.CmdLine) (redis_codec.Msg, *drpc.Status) {
+	return redis_codec.MakeMapMsg().
+		Set("ratio", redis_codec.MakeDoubleMsg(1.5)).
+		Set("tags", redis_codec.MakeSetMsg(redis_codec.MakeBulkMsg([]byte("a")))), nil
+}
+
+func (m *Redis) Subscribe(arg *redis_codec.CmdLine) (redis_codec.Msg, *drpc.Status) {
+	channel := (*arg)[0]
+	sess := m.Session()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		sess.Push("/redis/message", redis_codec.MakeBulkMsg([]byte("hi")))
+	}()
+	return redis_codec.MakePushMsg(
+		redis_codec.MakeBulkMsg([]byte("subscribe")),
+		redis_codec.MakeBulkMsg(channel),
+		redis_codec.MakeNumberMsg(1),
+	), nil
+}
+
+func readN(t *gtest.T, r *bufio.Reader, n int) string {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r, buf)
+	t.AssertNil(err)
+	return string(buf)
+}
+
+// 读取一个简单的值，字符串返回内容，其他类型返回整行
+func readValue(t *gtest.T, r *bufio.Reader) string {
+	line, err := r.ReadString('\n')
+	t.AssertNil(err)
+	line = strings.TrimSuffix(line, "\r\n")
+	if line[0] != '$' {
+		return line
+	}
+	value, err := r.ReadString('\n')
+	t.AssertNil(err)
+	return strings.TrimSuffix(value, "\r\n")
+}
+
+func TestRedisProto(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		srv := drpc.NewEndpoint(drpc.EndpointConfig{ListenPort: 9311}, redis.NewRedisPlugin())
+		srv.RouteCall(new(Redis))
+		go srv.ListenAndServe(redisproto.NewRedisProtoFunc())
+		defer srv.Close()
+		time.Sleep(1e9)
+
+		conn, err := net.Dial("tcp", "127.0.0.1:9311")
+		t.AssertNil(err)
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		r := bufio.NewReader(conn)
+
+		// 管道中的命令按照顺序回复，内联命令也可以
+		_, err = conn.Write([]byte("*1\r\n$4\r\nSLOW\r\n*1\r\n$4\r\nPING\r\nPING\r\n*1\r\n$4\r\nNOPE\r\n"))
+		t.AssertNil(err)
+		want := "+slow\r\n+PONG\r\n+PONG\r\n-ERR unknown command '/redis/nope'\r\n"
+		t.Assert(readN(t, r, len(want)), want)
+
+		// RESP2时转换成兼容的类型
+		_, err = conn.Write([]byte("*1\r\n$4\r\nINFO\r\n"))
+		t.AssertNil(err)
+		want = "*4\r\n$5\r\nratio\r\n$3\r\n1.5\r\n$4\r\ntags\r\n*1\r\n$1\r\na\r\n"
+		t.Assert(readN(t, r, len(want)), want)
+
+		_, err = conn.Write([]byte("*2\r\n$5\r\nHELLO\r\n$1\r\n4\r\n"))
+		t.AssertNil(err)
+		want = "-NOPROTO unsupported protocol version\r\n"
+		t.Assert(readN(t, r, len(want)), want)
+
+		// 切换到RESP3，HELLO 与前后的命令同样按照顺序回复
+		_, err = conn.Write([]byte("*1\r\n$4\r\nSLOW\r\n*2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n*1\r\n$4\r\nINFO\r\n"))
+		t.AssertNil(err)
+		t.Assert(readN(t, r, len("+slow\r\n%7\r\n")), "+slow\r\n%7\r\n")
+		var hello = make(map[string]string)
+		for i := 0; i < 7; i++ {
+			key, value := readValue(t, r), readValue(t, r)
+			hello[key] = value
+		}
+		t.Assert(hello["proto"], ":3")
+		t.Assert(hello["server"], "dmicro")
+		want = "%2\r\n$5\r\nratio\r\n,1.5\r\n$4\r\ntags\r\n~1\r\n$1\r\na\r\n"
+		t.Assert(readN(t, r, len(want)), want)
+
+		// PUSH消息转换成RESP3的推送消息
+		_, err = conn.Write([]byte("*2\r\n$9\r\nSUBSCRIBE\r\n$2\r\nch\r\n"))
+		t.AssertNil(err)
+		want = ">3\r\n$9\r\nsubscribe\r\n$2\r\nch\r\n:1\r\n>2\r\n$7\r\nmessage\r\n$2\r\nhi\r\n"
+		t.Assert(readN(t, r, len(want)), want)
+	})
+}
+
+func TestRESP3Msg(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		t.Assert(string(redis_codec.MakeBooleanMsg(true).ProtoBytes(redis_codec.RESP3)), "#t\r\n")
+		t.Assert(string(redis_codec.MakeBooleanMsg(false).Bytes()), ":0\r\n")
+		t.Assert(string(redis_codec.MakeNullMsg().ProtoBytes(redis_codec.RESP3)), "_\r\n")
+		t.Assert(string(redis_codec.MakeNullMsg().Bytes()), "$-1\r\n")
+		t.Assert(string(redis_codec.MakeBulkMsg([]byte{}).Bytes()), "$0\r\n\r\n")
+		t.Assert(string(redis_codec.MakeArrayMsg(redis_codec.MakeNumberMsg(1), nil).ProtoBytes(redis_codec.RESP3)), "*2\r\n:1\r\n_\r\n")
+	})
+}
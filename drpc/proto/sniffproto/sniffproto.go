@@ -0,0 +1,219 @@
+package sniffproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/osgochina/dmicro/drpc/proto"
+	"github.com/osgochina/dmicro/drpc/proto/httpproto"
+	"github.com/osgochina/dmicro/drpc/proto/jsonrpcproto"
+	"github.com/osgochina/dmicro/drpc/proto/rawproto"
+	"github.com/osgochina/dmicro/drpc/proto/redisproto"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 注意，嗅探协议只能在服务端使用，根据客户端发送的前几个字节选择协议，
+// 同一个端口可以同时为 rawproto,httpproto,jsonrpcproto,redisproto 的客户端提供服务。
+// tls加密链接的判断在链接层完成，参考 drpc.NewSniffTLSListener 以及 EndpointConfig.SniffTLS
+
+// Rule 协议的判断规则
+type Rule struct {
+	// Name 协议名称
+	Name string
+	// Size 判断需要的最少字节数
+	Size int
+	// Match 根据链接的前 Size 个字节判断是否是该协议
+	Match func(head []byte) bool
+	// ProtoFunc 判断成功后使用的协议
+	ProtoFunc proto.ProtoFunc
+}
+
+// RawRule rawproto的判断规则，4个字节的消息长度之后是协议版本
+func RawRule() Rule {
+	return Rule{
+		Name: "raw",
+		Size: 5,
+		Match: func(head []byte) bool {
+			return binary.BigEndian.Uint32(head) > 5 && head[4] == 1
+		},
+		ProtoFunc: rawproto.RawProtoFunc,
+	}
+}
+
+// http请求的方法
+var httpMethods = [][]byte{
+	[]byte("GET "), []byte("POST "), []byte("PUT "), []byte("DELETE "), []byte("HEAD "),
+	[]byte("PATCH "), []byte("OPTIONS "), []byte("CONNECT "), []byte("TRACE "),
+}
+
+// HTTPRule httpproto的判断规则，以http请求的方法开头
+//  注意，创建httpproto会把路由路径的生成函数设置为 drpc.HTTPServiceMethodMapper
+func HTTPRule(printMessage ...bool) Rule {
+	return Rule{
+		Name: "http",
+		Size: 8,
+		Match: func(head []byte) bool {
+			for _, method := range httpMethods {
+				if bytes.HasPrefix(head, method) {
+					return true
+				}
+			}
+			return false
+		},
+		ProtoFunc: httpproto.NewHTTProtoFunc(printMessage...),
+	}
+}
+
+// JSONRPCRule jsonrpcproto的判断规则，以 { 或者 [ 开头
+func JSONRPCRule() Rule {
+	return Rule{
+		Name: "jsonrpc",
+		Size: 1,
+		Match: func(head []byte) bool {
+			return head[0] == '{' || head[0] == '['
+		},
+		ProtoFunc: jsonrpcproto.NewJSONRPCProtoFunc(),
+	}
+}
+
+// RedisRule redisproto的判断规则，以 * 开头
+func RedisRule() Rule {
+	return Rule{
+		Name: "redis",
+		Size: 1,
+		Match: func(head []byte) bool {
+			return head[0] == '*'
+		},
+		ProtoFunc: redisproto.RedisProtoFunc,
+	}
+}
+
+// DefaultRules 默认的判断规则，包括 rawproto,httpproto,jsonrpcproto,redisproto
+func DefaultRules() []Rule {
+	return []Rule{RedisRule(), JSONRPCRule(), HTTPRule(), RawRule()}
+}
+
+// ErrUnknownProto 前几个字节不满足任何一个规则
+var ErrUnknownProto = errors.New("sniffproto: unknown protocol")
+
+// NewSniffProtoFunc 创建嗅探协议，按照顺序使用规则判断链接的协议，不传规则时使用 DefaultRules
+//  timeout 等待数据的超时时间，超时后关闭链接，为0时不限制
+func NewSniffProtoFunc(timeout time.Duration, rules ...Rule) proto.ProtoFunc {
+	if len(rules) == 0 {
+		rules = DefaultRules()
+	}
+	var size int
+	for _, rule := range rules {
+		if rule.Size > size {
+			size = rule.Size
+		}
+	}
+	return func(rw proto.IOWithReadBuffer) proto.Proto {
+		return &sniffProto{
+			rw:      rw,
+			rules:   rules,
+			size:    size,
+			timeout: timeout,
+		}
+	}
+}
+
+var _ proto.Proto = new(sniffProto)
+
+type sniffProto struct {
+	rw      proto.IOWithReadBuffer
+	rules   []Rule
+	size    int
+	timeout time.Duration
+	once    sync.Once
+	done    int32
+	proto   proto.Proto
+	err     error
+}
+
+// Version 判断协议之前返回嗅探协议的版本，之后返回选中协议的版本
+func (that *sniffProto) Version() (byte, string) {
+	if p, err := that.sniff(); err == nil {
+		return p.Version()
+	}
+	return 0, "sniff"
+}
+
+// Pack 打包，判断协议之前会等待客户端发送数据
+func (that *sniffProto) Pack(m proto.Message) error {
+	p, err := that.detect()
+	if err != nil {
+		return err
+	}
+	return p.Pack(m)
+}
+
+// Unpack 解包
+func (that *sniffProto) Unpack(m proto.Message) error {
+	p, err := that.detect()
+	if err != nil {
+		return err
+	}
+	return p.Unpack(m)
+}
+
+// 不等待，返回已经判断出的协议
+func (that *sniffProto) sniff() (proto.Proto, error) {
+	if atomic.LoadInt32(&that.done) == 0 {
+		return nil, ErrUnknownProto
+	}
+	return that.proto, that.err
+}
+
+// 读取链接的前几个字节，判断协议
+func (that *sniffProto) detect() (proto.Proto, error) {
+	that.once.Do(func() {
+		defer atomic.StoreInt32(&that.done, 1)
+		if closer, ok := that.rw.(io.Closer); ok && that.timeout > 0 {
+			timer := time.AfterFunc(that.timeout, func() {
+				_ = closer.Close()
+			})
+			defer timer.Stop()
+		}
+		var (
+			head = make([]byte, 0, that.size)
+			n    int
+		)
+		for {
+			n, that.err = that.rw.Read(head[len(head):cap(head)])
+			head = head[:len(head)+n]
+			if that.err != nil {
+				return
+			}
+			for _, rule := range that.rules {
+				if len(head) >= rule.Size && rule.Match(head) {
+					that.proto = rule.ProtoFunc(&replayReadWriter{head: head, ReadWriter: that.rw})
+					return
+				}
+			}
+			if len(head) == cap(head) {
+				that.err = ErrUnknownProto
+				return
+			}
+		}
+	})
+	return that.proto, that.err
+}
+
+// 先读取判断协议时读出的数据，再读取链接
+type replayReadWriter struct {
+	io.ReadWriter
+	head []byte
+}
+
+func (that *replayReadWriter) Read(b []byte) (int, error) {
+	if len(that.head) > 0 {
+		n := copy(b, that.head)
+		that.head = that.head[n:]
+		return n, nil
+	}
+	return that.ReadWriter.Read(b)
+}
@@ -0,0 +1,97 @@
+package sniffproto_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/osgochina/dmicro/drpc"
+	"github.com/osgochina/dmicro/drpc/proto/jsonrpcproto"
+	"github.com/osgochina/dmicro/drpc/proto/sniffproto"
+	"github.com/osgochina/dmicro/utils"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type Home struct {
+	drpc.CallCtx
+}
+
+func (h *Home) Test(arg *map[string]string) (map[string]string, *drpc.Status) {
+	return *arg, nil
+}
+
+func TestSniffProto(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		srv := drpc.NewEndpoint(drpc.EndpointConfig{ListenPort: 9281, SniffTLS: true, SniffTimeout: time.Second})
+		srv.SetTLSConfig(utils.GenerateTLSConfigForServer())
+		srv.RouteCall(new(Home))
+		go srv.ListenAndServe(sniffproto.NewSniffProtoFunc(time.Second))
+		defer srv.Close()
+		time.Sleep(1e9)
+
+		var arg = map[string]string{"author": "liuzhiming"}
+
+		// rawproto
+		cli := drpc.NewEndpoint(drpc.EndpointConfig{})
+		sess, stat := cli.Dial(":9281")
+		t.Assert(stat.OK(), true)
+		var result map[string]string
+		stat = sess.Call("/home/test", arg, &result).Status()
+		t.Assert(stat.OK(), true)
+		t.Assert(result, arg)
+		_ = cli.Close()
+
+		// rawproto over tls
+		tlsCli := drpc.NewEndpoint(drpc.EndpointConfig{})
+		tlsCli.SetTLSConfig(utils.GenerateTLSConfigForClient())
+		sess, stat = tlsCli.Dial(":9281")
+		t.Assert(stat.OK(), true)
+		result = nil
+		stat = sess.Call("/home/test", arg, &result).Status()
+		t.Assert(stat.OK(), true)
+		t.Assert(result, arg)
+		_ = tlsCli.Close()
+
+		// jsonrpcproto
+		jsonCli := drpc.NewEndpoint(drpc.EndpointConfig{})
+		sess, stat = jsonCli.Dial(":9281", jsonrpcproto.NewJSONRPCProtoFunc())
+		t.Assert(stat.OK(), true)
+		result = nil
+		stat = sess.Call("/home/test", arg, &result).Status()
+		t.Assert(stat.OK(), true)
+		t.Assert(result, arg)
+		_ = jsonCli.Close()
+
+		// httpproto
+		b, _ := json.Marshal(arg)
+		resp, err := http.Post("http://localhost:9281/home/test", "application/json;charset=utf-8", bytes.NewReader(b))
+		t.AssertNil(err)
+		b, err = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		t.AssertNil(err)
+		result = nil
+		t.AssertNil(json.Unmarshal(b, &result))
+		t.Assert(result, arg)
+
+		// 不能识别的协议，服务端关闭链接
+		conn, err := net.Dial("tcp", ":9281")
+		t.AssertNil(err)
+		_, err = conn.Write([]byte("hello world"))
+		t.AssertNil(err)
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, err = conn.Read(make([]byte, 16))
+		t.Assert(err, io.EOF)
+		_ = conn.Close()
+
+		// 不发送数据，超时后服务端关闭链接
+		conn, err = net.Dial("tcp", ":9281")
+		t.AssertNil(err)
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Read(make([]byte, 16))
+		t.Assert(err, io.EOF)
+		_ = conn.Close()
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"path"
 	"reflect"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"unicode"
@@ -94,10 +95,22 @@ func toServiceMethods(name string, sep rune, toSnake bool) string {
 const (
 	pnPush        = "PUSH"
 	pnCall        = "CALL"
+	pnStream      = "STREAM"
 	pnUnknownPush = "UNKNOWN_PUSH"
 	pnUnknownCall = "UNKNOWN_CALL"
 )
 
+// CallRouter 可以通过struct注册 CALL 处理程序的对象
+// *Router、*SubRouter、Endpoint 以及 server.RpcServer 都实现了该接口，代码生成的服务注册函数使用它
+type CallRouter interface {
+	RouteCall(callCtrlStruct interface{}, plugin ...Plugin) []string
+}
+
+// CtrlFactory 创建处理请求的结构体指针，每次返回新的实例
+// 通过 RouteCall、RoutePush、RouteStream 注册时代替结构体，池中的实例都由它创建，可以用来注入依赖
+// 例如: router.RouteCall(drpc.CtrlFactory(func() interface{} { return &Home{db: db} }))
+type CtrlFactory func() interface{}
+
 // Router 路由器
 type Router struct {
 	subRouter *SubRouter
@@ -108,6 +121,11 @@ type SubRouter struct {
 	root            *Router
 	callHandlers    map[string]*Handler
 	pushHandlers    map[string]*Handler
+	streamHandlers  map[string]*Handler
+	callTree        *routeTree    // 带参数的CALL路由
+	pushTree        *routeTree    // 带参数的PUSH路由
+	streamTree      *routeTree    // 带参数的STREAM路由
+	lock            *sync.RWMutex // 运行期间注册和删除路由时保护路由表
 	unknownCall     **Handler
 	unknownPush     **Handler
 	prefix          string
@@ -121,6 +139,11 @@ func newRouter(pluginContainer *PluginContainer) *Router {
 		subRouter: &SubRouter{
 			callHandlers:    make(map[string]*Handler),
 			pushHandlers:    make(map[string]*Handler),
+			streamHandlers:  make(map[string]*Handler),
+			callTree:        newRouteTree(),
+			pushTree:        newRouteTree(),
+			streamTree:      newRouteTree(),
+			lock:            new(sync.RWMutex),
 			unknownCall:     new(*Handler),
 			unknownPush:     new(*Handler),
 			prefix:          rootGroup,
@@ -156,6 +179,26 @@ func (that *Router) RoutePushFunc(pushHandleFunc interface{}, plugin ...Plugin)
 	return that.subRouter.RoutePushFunc(pushHandleFunc, plugin...)
 }
 
+// Handlers 获取路由器中已经注册的全部处理程序
+func (that *Router) Handlers() []*Handler {
+	return that.subRouter.Handlers()
+}
+
+// RemoveRoute 从路由器中删除指定路径的处理程序
+func (that *Router) RemoveRoute(path string) bool {
+	return that.subRouter.RemoveRoute(path)
+}
+
+// RouteStream 注册 STREAM 类型的处理程序到路由器
+func (that *Router) RouteStream(streamCtrlStruct interface{}, plugin ...Plugin) []string {
+	return that.subRouter.RouteStream(streamCtrlStruct, plugin...)
+}
+
+// RouteStreamFunc 通过func注册 STREAM 类型的处理程序到路由器
+func (that *Router) RouteStreamFunc(streamHandleFunc interface{}, plugin ...Plugin) string {
+	return that.subRouter.RouteStreamFunc(streamHandleFunc, plugin...)
+}
+
 // SetUnknownCall 注册默认的未知CALL处理方法
 func (that *Router) SetUnknownCall(fn func(UnknownCallCtx) (interface{}, *status.Status), plugin ...Plugin) {
 	pluginContainer := that.subRouter.pluginContainer.cloneAndAppendMiddle(plugin...)
@@ -218,6 +261,7 @@ func (that *SubRouter) ToRouter() *Router {
 }
 
 // SubRoute 添加处理程序组
+// prefix 中可以包含路径参数，例如 /user/:id 或者 /files/*path，处理程序中通过 ctx.Param 获取参数值
 func (that *SubRouter) SubRoute(prefix string, plugin ...Plugin) *SubRouter {
 	pluginContainer := that.pluginContainer.cloneAndAppendMiddle(plugin...)
 	warnInvalidHandlerHooks(plugin)
@@ -225,6 +269,11 @@ func (that *SubRouter) SubRoute(prefix string, plugin ...Plugin) *SubRouter {
 		root:            that.root,
 		callHandlers:    that.callHandlers,
 		pushHandlers:    that.pushHandlers,
+		streamHandlers:  that.streamHandlers,
+		callTree:        that.callTree,
+		pushTree:        that.pushTree,
+		streamTree:      that.streamTree,
+		lock:            that.lock,
 		unknownPush:     that.unknownPush,
 		unknownCall:     that.unknownCall,
 		prefix:          globalServiceMethodMapper(that.prefix, prefix),
@@ -233,11 +282,13 @@ func (that *SubRouter) SubRoute(prefix string, plugin ...Plugin) *SubRouter {
 }
 
 // RouteCall 通过struct注册多个 CALL 类型的处理程序，并返回它们的注册路径
+// 传入 CtrlFactory 时每次请求使用的实例由它创建，可以用来注入依赖
 func (that *SubRouter) RouteCall(callCtrlStruct interface{}, plugin ...Plugin) []string {
 	return that.reg(pnCall, makeCallHandlersFromStruct, callCtrlStruct, plugin)
 }
 
 // RouteCallFunc 通过func注册单个 CALL 类型的处理程序，并返回它的注册路径
+// 支持 func(CallCtx, *<T>) (reply, *Status) 以及 func(context.Context, *<T>) (reply, error) 两种形式
 func (that *SubRouter) RouteCallFunc(callHandleFunc interface{}, plugin ...Plugin) string {
 	return that.reg(pnCall, makeCallHandlersFromFunc, callHandleFunc, plugin)[0]
 }
@@ -248,11 +299,23 @@ func (that *SubRouter) RoutePush(pushCtrlStruct interface{}, plugin ...Plugin) [
 }
 
 // RoutePushFunc 通过func注册PUSH类型的处理程序，并返回它的路径
+// 支持 func(PushCtx, *<T>) *Status 以及 func(context.Context, *<T>) error 两种形式
 func (that *SubRouter) RoutePushFunc(pushHandleFunc interface{}, plugin ...Plugin) string {
 	return that.reg(pnPush, makePushHandlersFromFunc, pushHandleFunc, plugin)[0]
 }
 
+// RouteStream 通过struct批量注册 STREAM 类型的处理程序，并返回它们的路径
+func (that *SubRouter) RouteStream(streamCtrlStruct interface{}, plugin ...Plugin) []string {
+	return that.reg(pnStream, makeStreamHandlersFromStruct, streamCtrlStruct, plugin)
+}
+
+// RouteStreamFunc 通过func注册 STREAM 类型的处理程序，并返回它的路径
+func (that *SubRouter) RouteStreamFunc(streamHandleFunc interface{}, plugin ...Plugin) string {
+	return that.reg(pnStream, makeStreamHandlersFromFunc, streamHandleFunc, plugin)[0]
+}
+
 //注册路由器
+//  处理程序无效或者路径冲突时 panic(error)，此时不会注册其中任何一个处理程序，运行期间注册时调用方可以 recover
 func (that *SubRouter) reg(
 	routerTypeName string,
 	handlerMaker func(string, interface{}, *PluginContainer) ([]*Handler, error),
@@ -268,23 +331,36 @@ func (that *SubRouter) reg(
 		pluginContainer,
 	)
 	if err != nil {
-		internal.Fatalf(context.TODO(), "%v", err)
+		panic(err)
 	}
 	var names []string
 	var hadHandlers map[string]*Handler
+	var tree *routeTree
 
-	if routerTypeName == pnCall {
-		hadHandlers = that.callHandlers
-	} else {
-		hadHandlers = that.pushHandlers
+	switch routerTypeName {
+	case pnCall:
+		hadHandlers, tree = that.callHandlers, that.callTree
+	case pnStream:
+		hadHandlers, tree = that.streamHandlers, that.streamTree
+	default:
+		hadHandlers, tree = that.pushHandlers, that.pushTree
 	}
 
+	that.lock.Lock()
+	if err = checkConflict(hadHandlers, handlers); err != nil {
+		that.lock.Unlock()
+		panic(err)
+	}
 	for _, h := range handlers {
-		if _, ok := hadHandlers[h.name]; ok {
-			internal.Fatalf(context.TODO(), "there is a handler conflict: %s", h.name)
+		//带参数的路径同时加入基数树，例如 /user/:id/profile、/files/*path
+		if isPatternPath(h.name) {
+			_ = tree.add(h.name, h)
 		}
 		h.routerTypeName = routerTypeName
 		hadHandlers[h.name] = h
+	}
+	that.lock.Unlock()
+	for _, h := range handlers {
 		//触发路由注册事件
 		pluginContainer.afterRegRouter(h)
 		internal.Printf(context.TODO(), "注册 %s 路由名: %s", routerTypeName, h.name)
@@ -293,10 +369,108 @@ func (that *SubRouter) reg(
 	return names
 }
 
+// 检查新的处理程序与已经注册的处理程序以及它们之间是否冲突，调用方需要持有锁
+func checkConflict(hadHandlers map[string]*Handler, handlers []*Handler) error {
+	var tree *routeTree
+	for i, h := range handlers {
+		if _, ok := hadHandlers[h.name]; ok {
+			return gerror.Newf("there is a handler conflict: %s", h.name)
+		}
+		for _, h2 := range handlers[:i] {
+			if h2.name == h.name {
+				return gerror.Newf("there is a handler conflict: %s", h.name)
+			}
+		}
+		if !isPatternPath(h.name) {
+			continue
+		}
+		//在临时的基数树中检查带参数的路径
+		if tree == nil {
+			tree = newRouteTree()
+			tree.rebuild(hadHandlers)
+		}
+		if err := tree.add(h.name, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveRoute 删除指定路径的处理程序，path 为注册时返回的完整路径
+// 同名的 CALL、PUSH、STREAM 处理程序都会被删除，正在执行的请求不受影响
+func (that *SubRouter) RemoveRoute(path string) bool {
+	var removed []*Handler
+	that.lock.Lock()
+	for _, r := range []struct {
+		handlers map[string]*Handler
+		tree     *routeTree
+	}{
+		{that.callHandlers, that.callTree},
+		{that.pushHandlers, that.pushTree},
+		{that.streamHandlers, that.streamTree},
+	} {
+		h, ok := r.handlers[path]
+		if !ok {
+			continue
+		}
+		delete(r.handlers, path)
+		if isPatternPath(path) {
+			r.tree.rebuild(r.handlers)
+		}
+		removed = append(removed, h)
+	}
+	that.lock.Unlock()
+	for _, h := range removed {
+		//触发路由删除事件
+		h.pluginContainer.afterUnregRouter(h)
+		internal.Printf(context.TODO(), "删除 %s 路由名: %s", h.routerTypeName, h.name)
+	}
+	return len(removed) > 0
+}
+
+// Handlers 获取路由器中已经注册的全部 CALL、PUSH、STREAM 处理程序，按路径排序
+// 分组路由与根路由共享路由表，返回的结果是相同的
+func (that *SubRouter) Handlers() []*Handler {
+	that.lock.RLock()
+	handlers := make([]*Handler, 0, len(that.callHandlers)+len(that.pushHandlers)+len(that.streamHandlers))
+	for _, m := range []map[string]*Handler{that.callHandlers, that.pushHandlers, that.streamHandlers} {
+		for _, h := range m {
+			handlers = append(handlers, h)
+		}
+	}
+	that.lock.RUnlock()
+	sort.Slice(handlers, func(i, j int) bool {
+		if handlers[i].name != handlers[j].name {
+			return handlers[i].name < handlers[j].name
+		}
+		return handlers[i].routerTypeName < handlers[j].routerTypeName
+	})
+	return handlers
+}
+
 // 获取路由器中指定路径CALL的处理方法
 func (that *SubRouter) getCall(uriPath string) (*Handler, bool) {
-	t, ok := that.callHandlers[uriPath]
-	if ok {
+	return that.matchCall(uriPath, nil)
+}
+
+// 获取路由器中指定路径的PUSH处理方法，未找到则使用注册的默认方法
+func (that *SubRouter) getPush(uriPath string) (*Handler, bool) {
+	return that.matchPush(uriPath, nil)
+}
+
+// 获取路由器中指定路径的STREAM处理方法
+func (that *SubRouter) getStream(uriPath string) (*Handler, bool) {
+	return that.matchStream(uriPath, nil)
+}
+
+// 匹配CALL的处理方法，优先精确匹配，其次匹配带参数的路径，最后使用默认的处理方法
+func (that *SubRouter) matchCall(uriPath string, params *routeParams) (*Handler, bool) {
+	that.lock.RLock()
+	defer that.lock.RUnlock()
+	if t, ok := that.callHandlers[uriPath]; ok {
+		return t, true
+	}
+	if t, ok := that.callTree.find(uriPath, params); ok {
 		return t, true
 	}
 	if unknown := *that.unknownCall; unknown != nil {
@@ -305,10 +479,14 @@ func (that *SubRouter) getCall(uriPath string) (*Handler, bool) {
 	return nil, false
 }
 
-// 获取路由器中指定路径的PUSH处理方法，未找到则使用注册的默认方法
-func (that *SubRouter) getPush(uriPath string) (*Handler, bool) {
-	t, ok := that.pushHandlers[uriPath]
-	if ok {
+// 匹配PUSH的处理方法，优先精确匹配，其次匹配带参数的路径，最后使用默认的处理方法
+func (that *SubRouter) matchPush(uriPath string, params *routeParams) (*Handler, bool) {
+	that.lock.RLock()
+	defer that.lock.RUnlock()
+	if t, ok := that.pushHandlers[uriPath]; ok {
+		return t, true
+	}
+	if t, ok := that.pushTree.find(uriPath, params); ok {
 		return t, true
 	}
 	if unknown := *that.unknownPush; unknown != nil {
@@ -317,9 +495,19 @@ func (that *SubRouter) getPush(uriPath string) (*Handler, bool) {
 	return nil, false
 }
 
+// 匹配STREAM的处理方法，优先精确匹配，其次匹配带参数的路径
+func (that *SubRouter) matchStream(uriPath string, params *routeParams) (*Handler, bool) {
+	that.lock.RLock()
+	defer that.lock.RUnlock()
+	if t, ok := that.streamHandlers[uriPath]; ok {
+		return t, true
+	}
+	return that.streamTree.find(uriPath, params)
+}
+
 // callCtrlStruct 需要实现 CallCtx 接口
 func makeCallHandlersFromStruct(prefix string, callCtrlStruct interface{}, pluginContainer *PluginContainer) ([]*Handler, error) {
-
+	callCtrlStruct, factory := unwrapCtrlFactory(callCtrlStruct)
 	var (
 		cType    = reflect.TypeOf(callCtrlStruct)
 		handlers = make([]*Handler, 0, 1)
@@ -353,7 +541,7 @@ func makeCallHandlersFromStruct(prefix string, callCtrlStruct interface{}, plugi
 
 	var pool = &sync.Pool{
 		New: func() interface{} {
-			ctrl := reflect.New(cTypeElem)
+			ctrl := newCtrl(cType, factory)
 			return &CallCtrlValue{
 				ctrl: ctrl,
 				//这种写法参考https://blog.csdn.net/u010853261/article/details/103826830中的模式三
@@ -496,6 +684,28 @@ func makeCallHandlersFromFunc(prefix string, callHandleFunc interface{}, pluginC
 	if !isExportedOrBuiltinType(replyType) {
 		return nil, gerror.Newf("call-handler: %s first reply type not exported: %s", typeString, replyType)
 	}
+	//func(context.Context, *<T>) (reply, error) 形式的处理方法
+	if cType.In(0) == typeOfContext && cType.Out(1) == typeOfError {
+		if pluginContainer == nil {
+			pluginContainer = newPluginContainer()
+		}
+		return []*Handler{{
+			name: globalServiceMethodMapper(prefix, handlerFuncName(cValue)),
+			handleFunc: func(ctx *handlerCtx, argValue reflect.Value) {
+				rets := cValue.Call([]reflect.Value{reflect.ValueOf(ctx.handlerContext()), argValue})
+				if err, _ := rets[1].Interface().(error); err != nil {
+					stat := StatusFromError(err)
+					ctx.stat = stat
+					ctx.output.SetStatus(stat)
+				} else {
+					ctx.output.SetBody(rets[0].Interface())
+				}
+			},
+			argElem:         argType.Elem(),
+			reply:           replyType,
+			pluginContainer: pluginContainer,
+		}}, nil
+	}
 	//第二个返回值必须是*Status类型
 	if returnType := cType.Out(1); !isStatusType(returnType.String()) {
 		return nil, gerror.Newf("call-handler: %s second out argument %s is not *drcp.status.Status", typeString, returnType)
@@ -579,47 +789,121 @@ func makeCallHandlersFromFunc(prefix string, callHandleFunc interface{}, pluginC
 
 // 创建push消息的处理器，传入的参数是struct
 func makePushHandlersFromStruct(prefix string, pushCtrlStruct interface{}, pluginContainer *PluginContainer) ([]*Handler, error) {
+	return pushHandlerKind.makeHandlersFromStruct(prefix, pushCtrlStruct, pluginContainer)
+}
+
+// 创建push消息的处理器，传入的参数是func
+func makePushHandlersFromFunc(prefix string, pushHandleFunc interface{}, pluginContainer *PluginContainer) ([]*Handler, error) {
+	return pushHandlerKind.makeHandlersFromFunc(prefix, pushHandleFunc, pluginContainer)
+}
+
+// 创建stream消息的处理器，传入的参数是struct
+func makeStreamHandlersFromStruct(prefix string, streamCtrlStruct interface{}, pluginContainer *PluginContainer) ([]*Handler, error) {
+	return streamHandlerKind.makeHandlersFromStruct(prefix, streamCtrlStruct, pluginContainer)
+}
+
+// 创建stream消息的处理器，传入的参数是func
+func makeStreamHandlersFromFunc(prefix string, streamHandleFunc interface{}, pluginContainer *PluginContainer) ([]*Handler, error) {
+	return streamHandlerKind.makeHandlersFromFunc(prefix, streamHandleFunc, pluginContainer)
+}
+
+// 只接收一个参数并且只返回 *Status 的处理程序种类
+//  push 和 stream 处理程序的创建逻辑相同，只有上下文的类型不同
+type ctxHandlerKind struct {
+	name    string                                    // 错误信息中的处理程序类型，例如 push-handler
+	ctxName string                                    // 上下文接口的名称，控制器中需要匿名嵌入该字段
+	ctxType reflect.Type                              // 上下文接口的类型
+	setCtx  func(ptr unsafe.Pointer, ctx *handlerCtx) // 把上下文写入控制器中的上下文字段
+	ctxFunc bool                                      // 是否支持 func(context.Context, *<T>) error 形式的处理方法
+}
 
+var (
+	pushHandlerKind = &ctxHandlerKind{
+		name:    "push-handler",
+		ctxName: "PushCtx",
+		ctxType: typeOfPushCtx,
+		setCtx: func(ptr unsafe.Pointer, ctx *handlerCtx) {
+			*(*PushCtx)(ptr) = ctx
+		},
+		ctxFunc: true,
+	}
+	streamHandlerKind = &ctxHandlerKind{
+		name:    "stream-handler",
+		ctxName: "StreamCtx",
+		ctxType: typeOfStreamCtx,
+		setCtx: func(ptr unsafe.Pointer, ctx *handlerCtx) {
+			*(*StreamCtx)(ptr) = ctx
+		},
+	}
+)
+
+// 对象池中的控制器，保存上下文字段的地址，避免每次请求都通过反射写入上下文
+type ctxCtrlValue struct {
+	ctrl   reflect.Value
+	ctxPtr unsafe.Pointer
+}
+
+// 判断方法是否属于上下文接口
+func (that *ctxHandlerKind) isBelongToCtx(name string) bool {
+	_, ok := that.ctxType.MethodByName(name)
+	return ok
+}
+
+// 创建控制器对象池，上下文字段位于控制器的 ctxOffset 偏移处
+func (that *ctxHandlerKind) ctrlPool(newCtrl func() reflect.Value, ctxOffset uintptr) *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} {
+			ctrl := newCtrl()
+			return &ctxCtrlValue{
+				ctrl: ctrl,
+				//这种写法参考https://blog.csdn.net/u010853261/article/details/103826830中的模式三
+				//将非类型安全指针转换为一个uintptr值，然后此uintptr值参与各种算术运算，再将算术运算的结果uintptr值转回非类型安全指针
+				ctxPtr: unsafe.Pointer(uintptr(unsafe.Pointer(ctrl.Pointer())) + ctxOffset),
+			}
+		},
+	}
+}
+
+// 从对象池中取出控制器，写入上下文后作为第一个参数调用处理方法
+func (that *ctxHandlerKind) pooledHandleFunc(pool *sync.Pool, fn reflect.Value) func(*handlerCtx, reflect.Value) {
+	return func(ctx *handlerCtx, argValue reflect.Value) {
+		obj := pool.Get().(*ctxCtrlValue)
+		that.setCtx(obj.ctxPtr, ctx)
+		rets := fn.Call([]reflect.Value{obj.ctrl, argValue})
+		ctx.stat = (*status.Status)(unsafe.Pointer(rets[0].Pointer()))
+		pool.Put(obj)
+	}
+}
+
+// 创建处理器，传入的参数是struct
+func (that *ctxHandlerKind) makeHandlersFromStruct(prefix string, ctrlStruct interface{}, pluginContainer *PluginContainer) ([]*Handler, error) {
+	ctrlStruct, factory := unwrapCtrlFactory(ctrlStruct)
 	var (
-		cType    = reflect.TypeOf(pushCtrlStruct)
+		cType    = reflect.TypeOf(ctrlStruct)
 		handlers = make([]*Handler, 0, 1)
 	)
 	//判断传入的必须是指针类型
 	if cType.Kind() != reflect.Ptr {
-		return nil, gerror.Newf("push-handler: the type is not struct point: %s", cType.String())
+		return nil, gerror.Newf("%s: the type is not struct point: %s", that.name, cType.String())
 	}
 	//必须是struct类型
 	var cTypeElem = cType.Elem()
 	if cTypeElem.Kind() != reflect.Struct {
-		return nil, gerror.Newf("push-handler: the type is not struct point: %s", cType.String())
+		return nil, gerror.Newf("%s: the type is not struct point: %s", that.name, cType.String())
 	}
-	//必须实现了PushCtx接口
-	iType, ok := cTypeElem.FieldByName("PushCtx")
+	//必须匿名嵌入了上下文接口
+	iType, ok := cTypeElem.FieldByName(that.ctxName)
 	if !ok || !iType.Anonymous {
-		return nil, gerror.Newf("push-handler: the struct do not have anonymous field drpc.PushCtx: %s", cType.String())
+		return nil, gerror.Newf("%s: the struct do not have anonymous field drpc.%s: %s", that.name, that.ctxName, cType.String())
 	}
 
-	var pushCtxOffset = iType.Offset
-
 	if pluginContainer == nil {
 		pluginContainer = newPluginContainer()
 	}
 
-	type PushCtrlValue struct {
-		ctrl   reflect.Value
-		ctxPtr *PushCtx
-	}
-	var pool = &sync.Pool{
-		New: func() interface{} {
-			ctrl := reflect.New(cTypeElem)
-			return &PushCtrlValue{
-				ctrl: ctrl,
-				//这种写法参考https://blog.csdn.net/u010853261/article/details/103826830中的模式三
-				//将非类型安全指针转换为一个uintptr值，然后此uintptr值参与各种算术运算，再将算术运算的结果uintptr值转回非类型安全指针
-				ctxPtr: (*PushCtx)(unsafe.Pointer(uintptr(unsafe.Pointer(ctrl.Pointer())) + pushCtxOffset)),
-			}
-		},
-	}
+	var pool = that.ctrlPool(func() reflect.Value {
+		return newCtrl(cType, factory)
+	}, iType.Offset)
 
 	for m := 0; m < cType.NumMethod(); m++ {
 		method := cType.Method(m)
@@ -630,46 +914,38 @@ func makePushHandlersFromStruct(prefix string, pushCtrlStruct interface{}, plugi
 		if method.PkgPath != "" {
 			continue
 		}
-		//如果是CallCtx接口的基础方法，则跳过
-		if isBelongToPushCtx(mName) {
+		//如果是上下文接口的基础方法，则跳过
+		if that.isBelongToCtx(mName) {
 			continue
 		}
 
 		// Method needs two ins: receiver, *<T>.
 		if mType.NumIn() != 2 {
-			return nil, gerror.Newf("push-handler: %s.%s needs one in argument, but have %d", cType.String(), mName, mType.NumIn())
+			return nil, gerror.Newf("%s: %s.%s needs one in argument, but have %d", that.name, cType.String(), mName, mType.NumIn())
 		}
 		//第一个参数必须是struct类型
 		structType := mType.In(0)
 		if structType.Kind() != reflect.Ptr || structType.Elem().Kind() != reflect.Struct {
-			return nil, gerror.Newf("push-handler: %s.%s receiver need be a struct pointer: %s", cType.String(), mName, structType)
+			return nil, gerror.Newf("%s: %s.%s receiver need be a struct pointer: %s", that.name, cType.String(), mName, structType)
 		}
 		//第二个参数必须是可以导出的或基础类型
 		argType := mType.In(1)
 		if !isExportedOrBuiltinType(argType) {
-			return nil, gerror.Newf("push-handler: %s.%s arg type not exported: %s", cType.String(), mName, argType)
+			return nil, gerror.Newf("%s: %s.%s arg type not exported: %s", that.name, cType.String(), mName, argType)
 		}
 		if argType.Kind() != reflect.Ptr {
-			return nil, gerror.Newf("push-handler: %s.%s arg type need be a pointer: %s", cType.String(), mName, argType)
+			return nil, gerror.Newf("%s: %s.%s arg type need be a pointer: %s", that.name, cType.String(), mName, argType)
 		}
 		//返回参数如果不是一个
 		if mType.NumOut() != 1 {
-			return nil, gerror.Newf("push-handler: %s.%s needs one out arguments, but have %d", cType.String(), mName, mType.NumOut())
+			return nil, gerror.Newf("%s: %s.%s needs one out arguments, but have %d", that.name, cType.String(), mName, mType.NumOut())
 		}
 		//返回参数必须是*Status类型
 		if returnType := mType.Out(0); !isStatusType(returnType.String()) {
-			return nil, gerror.Newf("push-handler: %s.%s out argument %s is not *drpc.Status", cType.String(), mName, returnType)
-		}
-		var methodFunc = method.Func
-		var handleFunc = func(ctx *handlerCtx, argValue reflect.Value) {
-			obj := pool.Get().(*PushCtrlValue)
-			*obj.ctxPtr = ctx
-			rets := methodFunc.Call([]reflect.Value{obj.ctrl, argValue})
-			ctx.stat = (*status.Status)(unsafe.Pointer(rets[0].Pointer()))
-			pool.Put(obj)
+			return nil, gerror.Newf("%s: %s.%s out argument %s is not *drpc.Status", that.name, cType.String(), mName, returnType)
 		}
 		handlers = append(handlers, &Handler{
-			handleFunc:      handleFunc,
+			handleFunc:      that.pooledHandleFunc(pool, method.Func),
 			argElem:         argType.Elem(),
 			pluginContainer: pluginContainer,
 			name: globalServiceMethodMapper(
@@ -681,105 +957,101 @@ func makePushHandlersFromStruct(prefix string, pushCtrlStruct interface{}, plugi
 	return handlers, nil
 }
 
-// 创建push消息的处理器，传入的参数是func
-func makePushHandlersFromFunc(prefix string, pushHandleFunc interface{}, pluginContainer *PluginContainer) ([]*Handler, error) {
+// 创建处理器，传入的参数是func
+func (that *ctxHandlerKind) makeHandlersFromFunc(prefix string, handleFunc interface{}, pluginContainer *PluginContainer) ([]*Handler, error) {
 
 	var (
-		cType      = reflect.TypeOf(pushHandleFunc)
-		cValue     = reflect.ValueOf(pushHandleFunc)
+		cType      = reflect.TypeOf(handleFunc)
+		cValue     = reflect.ValueOf(handleFunc)
 		typeString = objectName(cValue)
 	)
 	if cType.Kind() != reflect.Func {
-		return nil, gerror.Newf("push-handler: the type is not function: %s", typeString)
+		return nil, gerror.Newf("%s: the type is not function: %s", that.name, typeString)
 	}
 
 	// needs one out: *Status.
 	if cType.NumOut() != 1 {
-		return nil, gerror.Newf("push-handler: %s needs one out arguments, but have %d", typeString, cType.NumOut())
+		return nil, gerror.Newf("%s: %s needs one out arguments, but have %d", that.name, typeString, cType.NumOut())
 	}
-	if returnType := cType.Out(0); !isStatusType(returnType.String()) {
-		return nil, gerror.Newf("push-handler: %s out argument %s is not *drpc.Status", typeString, returnType)
-	}
-	// needs two ins: PushCtx, *<T>.
+	// needs two ins: ctx, *<T>.
 	if cType.NumIn() != 2 {
-		return nil, gerror.Newf("push-handler: %s needs two in argument, but have %d", typeString, cType.NumIn())
+		return nil, gerror.Newf("%s: %s needs two in argument, but have %d", that.name, typeString, cType.NumIn())
 	}
 	argType := cType.In(1)
 	if !isExportedOrBuiltinType(argType) {
-		return nil, gerror.Newf("push-handler: %s arg type not exported: %s", typeString, argType)
+		return nil, gerror.Newf("%s: %s arg type not exported: %s", that.name, typeString, argType)
 	}
 	if argType.Kind() != reflect.Ptr {
-		return nil, gerror.Newf("push-handler: %s arg type need be a pointer: %s", typeString, argType)
+		return nil, gerror.Newf("%s: %s arg type need be a pointer: %s", that.name, typeString, argType)
+	}
+	if pluginContainer == nil {
+		pluginContainer = newPluginContainer()
+	}
+	// func(context.Context, *<T>) error 形式的处理方法
+	if that.ctxFunc && cType.In(0) == typeOfContext && cType.Out(0) == typeOfError {
+		return []*Handler{{
+			name: globalServiceMethodMapper(prefix, handlerFuncName(cValue)),
+			handleFunc: func(ctx *handlerCtx, argValue reflect.Value) {
+				rets := cValue.Call([]reflect.Value{reflect.ValueOf(ctx.handlerContext()), argValue})
+				if err, _ := rets[0].Interface().(error); err != nil {
+					ctx.stat = StatusFromError(err)
+				}
+			},
+			argElem:         argType.Elem(),
+			pluginContainer: pluginContainer,
+		}}, nil
+	}
+	if returnType := cType.Out(0); !isStatusType(returnType.String()) {
+		return nil, gerror.Newf("%s: %s out argument %s is not *drpc.Status", that.name, typeString, returnType)
 	}
-	// first agr need be a PushCtx (struct pointer or PushCtx).
+	// first agr need be a ctx (struct pointer or ctx interface).
 	ctxType := cType.In(0)
 
-	var handleFunc func(*handlerCtx, reflect.Value)
+	var fn func(*handlerCtx, reflect.Value)
 	switch ctxType.Kind() {
 	default:
-		return nil, gerror.Newf("push-handler: %s's first arg must be drpc.PushCtx type or struct pointer: %s", typeString, ctxType)
+		return nil, gerror.Newf("%s: %s's first arg must be drpc.%s type or struct pointer: %s", that.name, typeString, that.ctxName, ctxType)
 	case reflect.Interface:
-		iFace := reflect.TypeOf((*PushCtx)(nil)).Elem()
-		if !ctxType.Implements(iFace) ||
-			!iFace.Implements(reflect.New(ctxType).Type().Elem()) {
-			return nil, gerror.Newf("push-handler: %s's first arg need implement drpc.PushCtx: %s", typeString, ctxType)
+		if !ctxType.Implements(that.ctxType) ||
+			!that.ctxType.Implements(reflect.New(ctxType).Type().Elem()) {
+			return nil, gerror.Newf("%s: %s's first arg need implement drpc.%s: %s", that.name, typeString, that.ctxName, ctxType)
 		}
 
-		handleFunc = func(ctx *handlerCtx, argValue reflect.Value) {
+		fn = func(ctx *handlerCtx, argValue reflect.Value) {
 			rets := cValue.Call([]reflect.Value{reflect.ValueOf(ctx), argValue})
 			ctx.stat = (*status.Status)(unsafe.Pointer(rets[0].Pointer()))
 		}
 	case reflect.Ptr:
 		var ctxTypeElem = ctxType.Elem()
 		if ctxTypeElem.Kind() != reflect.Struct {
-			return nil, gerror.Newf("push-handler: %s's first arg must be erpc.PushCtx type or struct pointer: %s", typeString, ctxType)
+			return nil, gerror.Newf("%s: %s's first arg must be drpc.%s type or struct pointer: %s", that.name, typeString, that.ctxName, ctxType)
 		}
 
-		iType, ok := ctxTypeElem.FieldByName("PushCtx")
+		iType, ok := ctxTypeElem.FieldByName(that.ctxName)
 		if !ok || !iType.Anonymous {
-			return nil, gerror.Newf("push-handler: %s's first arg do not have anonymous field drpc.PushCtx: %s", typeString, ctxType)
-		}
-
-		type PushCtrlValue struct {
-			ctrl   reflect.Value
-			ctxPtr *PushCtx
-		}
-		var pushCtxOffset = iType.Offset
-		var pool = &sync.Pool{
-			New: func() interface{} {
-				ctrl := reflect.New(ctxTypeElem)
-				return &PushCtrlValue{
-					ctrl: ctrl,
-					//这种写法参考https://blog.csdn.net/u010853261/article/details/103826830中的模式三
-					//将非类型安全指针转换为一个uintptr值，然后此uintptr值参与各种算术运算，再将算术运算的结果uintptr值转回非类型安全指针
-					ctxPtr: (*PushCtx)(unsafe.Pointer(uintptr(unsafe.Pointer(ctrl.Pointer())) + pushCtxOffset)),
-				}
-			},
+			return nil, gerror.Newf("%s: %s's first arg do not have anonymous field drpc.%s: %s", that.name, typeString, that.ctxName, ctxType)
 		}
 
-		handleFunc = func(ctx *handlerCtx, argValue reflect.Value) {
-			obj := pool.Get().(*PushCtrlValue)
-			*obj.ctxPtr = ctx
-			rets := cValue.Call([]reflect.Value{obj.ctrl, argValue})
-			ctx.stat = (*status.Status)(unsafe.Pointer(rets[0].Pointer()))
-			pool.Put(obj)
-		}
+		var pool = that.ctrlPool(func() reflect.Value {
+			return reflect.New(ctxTypeElem)
+		}, iType.Offset)
+		fn = that.pooledHandleFunc(pool, cValue)
 	}
 
-	if pluginContainer == nil {
-		pluginContainer = newPluginContainer()
-	}
 	return []*Handler{{
 		name:            globalServiceMethodMapper(prefix, handlerFuncName(cValue)),
-		handleFunc:      handleFunc,
+		handleFunc:      fn,
 		argElem:         argType.Elem(),
 		pluginContainer: pluginContainer,
 	}}, nil
 }
 
 var (
-	typeOfCallCtx = reflect.TypeOf((*CallCtx)(nil)).Elem()
-	typeOfPushCtx = reflect.TypeOf((*PushCtx)(nil)).Elem()
+	typeOfCallCtx   = reflect.TypeOf((*CallCtx)(nil)).Elem()
+	typeOfPushCtx   = reflect.TypeOf((*PushCtx)(nil)).Elem()
+	typeOfStreamCtx = reflect.TypeOf((*StreamCtx)(nil)).Elem()
+	typeOfContext   = reflect.TypeOf((*context.Context)(nil)).Elem()
+	typeOfError     = reflect.TypeOf((*error)(nil)).Elem()
 )
 
 //判断方法是否属于 CallCtx
@@ -792,16 +1064,6 @@ func isBelongToCallCtx(name string) bool {
 	return false
 }
 
-// 判断方法是否属于 PushCtx
-func isBelongToPushCtx(name string) bool {
-	for m := 0; m < typeOfPushCtx.NumMethod(); m++ {
-		if name == typeOfPushCtx.Method(m).Name {
-			return true
-		}
-	}
-	return false
-}
-
 // 判断返回值类型是否是 *Status 类型
 func isStatusType(s string) bool {
 	return strings.HasPrefix(s, "*") && strings.HasSuffix(s, ".Status")
@@ -813,6 +1075,28 @@ func ctrlStructName(cType reflect.Type) string {
 	return split[len(split)-1]
 }
 
+// 注册时传入的是 CtrlFactory 时，返回它创建的实例用来解析结构体的类型
+func unwrapCtrlFactory(ctrlStruct interface{}) (interface{}, CtrlFactory) {
+	if factory, ok := ctrlStruct.(CtrlFactory); ok && factory != nil {
+		if ctrl := factory(); ctrl != nil {
+			return ctrl, factory
+		}
+	}
+	return ctrlStruct, nil
+}
+
+// 创建处理请求的结构体实例
+func newCtrl(cType reflect.Type, factory CtrlFactory) reflect.Value {
+	if factory == nil {
+		return reflect.New(cType.Elem())
+	}
+	ctrl := reflect.ValueOf(factory())
+	if !ctrl.IsValid() || ctrl.Type() != cType || ctrl.IsNil() {
+		panic(gerror.Newf("drpc: the CtrlFactory must return a non-nil %s", cType.String()))
+	}
+	return ctrl
+}
+
 // 获取处理器方法的名字
 func handlerFuncName(v reflect.Value) string {
 	str := objectName(v)
@@ -86,6 +86,187 @@ func TestRouteCall(t *testing.T) {
 	})
 }
 
+func TestRouteTree(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		tree := newRouteTree()
+		for _, p := range []string{
+			"/user/:id",
+			"/user/:id/profile",
+			"/user/list",
+			"/files/*path",
+			"/files/static/:name",
+		} {
+			t.AssertNil(tree.add(p, &Handler{name: p}))
+		}
+		t.AssertNE(tree.add("/user/:uid/friends", &Handler{}), nil)
+		t.AssertNE(tree.add("/user/:id", &Handler{}), nil)
+		t.AssertNE(tree.add("/x/*path/more", &Handler{}), nil)
+		t.AssertNE(tree.add("/x/a:b", &Handler{}), nil)
+
+		var cases = []struct {
+			path    string
+			pattern string
+			params  map[string]string
+		}{
+			{"/user/10", "/user/:id", map[string]string{"id": "10"}},
+			{"/user/10/profile", "/user/:id/profile", map[string]string{"id": "10"}},
+			{"/user/list", "/user/list", map[string]string{}},
+			{"/user/list/profile", "/user/:id/profile", map[string]string{"id": "list"}},
+			{"/files/a/b/c.txt", "/files/*path", map[string]string{"path": "a/b/c.txt"}},
+			{"/files/", "/files/*path", map[string]string{"path": ""}},
+			{"/files/static/logo", "/files/static/:name", map[string]string{"name": "logo"}},
+			{"/files/static/logo/big", "/files/*path", map[string]string{"path": "static/logo/big"}},
+		}
+		for _, c := range cases {
+			var params routeParams
+			h, ok := tree.find(c.path, &params)
+			t.Assert(ok, true)
+			t.Assert(h.name, c.pattern)
+			t.Assert(len(params), len(c.params))
+			for k, v := range c.params {
+				t.Assert(params.get(k), v)
+			}
+		}
+		for _, p := range []string{"/user", "/user/10/other", "/files", "/other"} {
+			_, ok := tree.find(p, nil)
+			t.Assert(ok, false)
+		}
+	})
+}
+
+func TestRouteParam(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		root := newRouter(newPluginContainer())
+		names := root.SubRoute("/user/:id").RouteCall(new(Math))
+		t.AssertIN("/user/:id/math/add", names)
+		root.RouteCall(new(Math))
+
+		ctx := newReadHandleCtx()
+		h, found := root.subRouter.matchCall("/user/10/math/add", &ctx.params)
+		t.Assert(found, true)
+		t.Assert(h.Name(), "/user/:id/math/add")
+		t.Assert(ctx.Param("id"), "10")
+
+		ctx.params = ctx.params[:0]
+		h, found = root.subRouter.matchCall("/math/add", &ctx.params)
+		t.Assert(found, true)
+		t.Assert(h.Name(), "/math/add")
+		t.Assert(ctx.Param("id"), "")
+
+		_, found = root.subRouter.getCall("/user/10/math/sub")
+		t.Assert(found, false)
+	})
+}
+
+type unregPlugin struct {
+	names []string
+}
+
+func (that *unregPlugin) Name() string {
+	return "unreg"
+}
+
+func (that *unregPlugin) AfterUnregRouter(h *Handler) error {
+	that.names = append(that.names, h.Name())
+	return nil
+}
+
+func TestRemoveRoute(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		plugin := new(unregPlugin)
+		root := newRouter(newPluginContainer())
+		root.subRouter.pluginContainer.AppendRight(plugin)
+		names := root.RouteCall(new(Math))
+		t.AssertIN("/math/add", names)
+		root.SubRoute("/user/:id").RouteCall(new(Math))
+		root.SubRoute("/file").RoutePushFunc((*MathPush).Add)
+
+		// 删除静态路由
+		t.Assert(root.RemoveRoute("/math/add"), true)
+		t.Assert(root.RemoveRoute("/math/add"), false)
+		_, found := root.subRouter.getCall("/math/add")
+		t.Assert(found, false)
+		_, found = root.subRouter.getCall("/math/add_func")
+		t.Assert(found, true)
+
+		// 删除带参数的路由，其他带参数的路由不受影响
+		t.Assert(root.RemoveRoute("/user/:id/math/add"), true)
+		_, found = root.subRouter.getCall("/user/10/math/add")
+		t.Assert(found, false)
+		h, found := root.subRouter.getCall("/user/10/math/add_func")
+		t.Assert(found, true)
+		t.Assert(h.Name(), "/user/:id/math/add_func")
+
+		// 删除以后可以用不同的参数名重新注册
+		t.Assert(root.RemoveRoute("/user/:id/math/add_func"), true)
+		root.SubRoute("/user/:uid").RouteCall(new(Math))
+		ctx := newReadHandleCtx()
+		_, found = root.subRouter.matchCall("/user/10/math/add", &ctx.params)
+		t.Assert(found, true)
+		t.Assert(ctx.Param("uid"), "10")
+
+		t.Assert(root.RemoveRoute("/file/add"), true)
+		_, found = root.subRouter.getPush("/file/add")
+		t.Assert(found, false)
+
+		t.Assert(plugin.names, []string{"/math/add", "/user/:id/math/add", "/user/:id/math/add_func", "/file/add"})
+	})
+}
+
+// 注册冲突的路由时 panic，并且不会注册其中任何一个处理程序
+func TestRouteConflict(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		root := newRouter(newPluginContainer())
+		root.SubRoute("/user/:id/math").RouteCallFunc((*Math).AddFunc)
+		count := len(root.Handlers())
+
+		regPanic := func(fn func()) (err error) {
+			defer func() {
+				err, _ = recover().(error)
+			}()
+			fn()
+			return nil
+		}
+		// 同一个struct中后注册的处理程序冲突
+		err := regPanic(func() { root.SubRoute("/user/:id").RouteCall(new(Math)) })
+		t.AssertNE(err, nil)
+		t.Assert(len(root.Handlers()), count)
+		_, found := root.subRouter.getCall("/user/:id/math/add")
+		t.Assert(found, false)
+
+		// 参数名冲突
+		err = regPanic(func() { root.SubRoute("/user/:name").RouteCall(new(Math)) })
+		t.AssertNE(err, nil)
+		t.Assert(len(root.Handlers()), count)
+
+		// 冲突之后仍然可以正常注册
+		t.Assert(regPanic(func() { root.SubRoute("/user/:id").RoutePush(new(MathPush)) }), nil)
+		t.Assert(len(root.Handlers()), count+2)
+	})
+}
+
+func TestRouteConcurrent(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		root := newRouter(newPluginContainer())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 100; i++ {
+				names := root.SubRoute(fmt.Sprintf("/g%d", i)).RouteCall(new(Math))
+				for _, name := range names {
+					root.RemoveRoute(name)
+				}
+			}
+		}()
+		for i := 0; i < 1000; i++ {
+			root.subRouter.getCall(fmt.Sprintf("/g%d/math/add", i%100))
+		}
+		<-done
+		_, found := root.subRouter.getCall("/g99/math/add")
+		t.Assert(found, false)
+	})
+}
+
 type Math struct {
 	CallCtx
 }
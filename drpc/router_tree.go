@@ -0,0 +1,211 @@
+package drpc
+
+import (
+	"github.com/gogf/gf/v2/errors/gerror"
+	"strings"
+)
+
+// 路由参数，例如 /user/:id 匹配 /user/10 时得到 {id 10}
+type routeParam struct {
+	key   string
+	value string
+}
+
+type routeParams []routeParam
+
+// 获取指定名字的路由参数
+func (that routeParams) get(key string) string {
+	for _, p := range that {
+		if p.key == key {
+			return p.value
+		}
+	}
+	return ""
+}
+
+// 路由匹配使用的基数树(radix tree)
+// 支持三种路径片段：
+//  静态路径 /user/profile
+//  命名参数 /user/:id/profile，匹配到下一个 "/" 为止
+//  通配参数 /files/*path，匹配剩余的全部路径，只能出现在最后
+// 匹配的优先级依次为 静态路径 > 命名参数 > 通配参数
+type routeTree struct {
+	root *routeNode
+}
+
+type routeNode struct {
+	prefix     string       // 静态路径片段
+	children   []*routeNode // 静态子节点，首字节各不相同
+	paramChild *routeNode   // 命名参数子节点
+	anyChild   *routeNode   // 通配参数子节点
+	paramName  string       // 参数节点的参数名
+	handler    *Handler
+}
+
+func newRouteTree() *routeTree {
+	return &routeTree{root: new(routeNode)}
+}
+
+// 判断路径中是否包含参数
+func isPatternPath(p string) bool {
+	return strings.ContainsAny(p, ":*")
+}
+
+// 添加路由
+func (that *routeTree) add(pattern string, h *Handler) error {
+	n := that.root
+	for s := pattern; len(s) > 0; {
+		i := strings.IndexAny(s, ":*")
+		if i < 0 {
+			n = n.insertStatic(s)
+			break
+		}
+		if i > 0 && s[i-1] != '/' {
+			return gerror.Newf("router: the parameter must follow '/': %s", pattern)
+		}
+		n = n.insertStatic(s[:i])
+		wildcard := s[i]
+		s = s[i+1:]
+		end := strings.IndexByte(s, '/')
+		if end < 0 {
+			end = len(s)
+		}
+		name := s[:end]
+		if len(name) == 0 || strings.ContainsAny(name, ":*") {
+			return gerror.Newf("router: invalid parameter name in path: %s", pattern)
+		}
+		s = s[end:]
+		if wildcard == '*' {
+			if len(s) > 0 {
+				return gerror.Newf("router: the catch-all parameter must be at the end of path: %s", pattern)
+			}
+			if n.anyChild == nil {
+				n.anyChild = &routeNode{paramName: name}
+			} else if n.anyChild.paramName != name {
+				return gerror.Newf("router: the catch-all parameter *%s conflicts with *%s: %s", name, n.anyChild.paramName, pattern)
+			}
+			n = n.anyChild
+			break
+		}
+		if n.paramChild == nil {
+			n.paramChild = &routeNode{paramName: name}
+		} else if n.paramChild.paramName != name {
+			return gerror.Newf("router: the parameter :%s conflicts with :%s: %s", name, n.paramChild.paramName, pattern)
+		}
+		n = n.paramChild
+	}
+	if n.handler != nil {
+		return gerror.Newf("there is a handler conflict: %s", pattern)
+	}
+	n.handler = h
+	return nil
+}
+
+// 根据剩余的处理程序重建基数树，删除带参数的路由之后使用
+func (that *routeTree) rebuild(handlers map[string]*Handler) {
+	that.root = new(routeNode)
+	for name, h := range handlers {
+		if isPatternPath(name) {
+			_ = that.add(name, h)
+		}
+	}
+}
+
+// 查找路由，匹配到的参数追加到params中
+func (that *routeTree) find(path string, params *routeParams) (*Handler, bool) {
+	n := that.root.match(path, params)
+	if n == nil {
+		return nil, false
+	}
+	return n.handler, true
+}
+
+// 插入静态路径片段，返回该片段结尾所在的节点
+func (that *routeNode) insertStatic(s string) *routeNode {
+	n := that
+	for len(s) > 0 {
+		var child *routeNode
+		var idx int
+		for i, c := range n.children {
+			if c.prefix[0] == s[0] {
+				child, idx = c, i
+				break
+			}
+		}
+		if child == nil {
+			child = &routeNode{prefix: s}
+			n.children = append(n.children, child)
+			return child
+		}
+		l := commonPrefixLen(child.prefix, s)
+		if l < len(child.prefix) {
+			//拆分节点
+			mid := &routeNode{prefix: child.prefix[:l], children: []*routeNode{child}}
+			child.prefix = child.prefix[l:]
+			n.children[idx] = mid
+			child = mid
+		}
+		n = child
+		s = s[l:]
+	}
+	return n
+}
+
+// 匹配剩余的路径
+func (that *routeNode) match(path string, params *routeParams) *routeNode {
+	if len(path) == 0 {
+		if that.handler != nil {
+			return that
+		}
+		//通配参数可以匹配空路径
+		if that.anyChild != nil && that.anyChild.handler != nil {
+			if params != nil {
+				*params = append(*params, routeParam{key: that.anyChild.paramName})
+			}
+			return that.anyChild
+		}
+		return nil
+	}
+	for _, c := range that.children {
+		if c.prefix[0] == path[0] && strings.HasPrefix(path, c.prefix) {
+			if n := c.match(path[len(c.prefix):], params); n != nil {
+				return n
+			}
+			break
+		}
+	}
+	if that.paramChild != nil {
+		end := strings.IndexByte(path, '/')
+		if end < 0 {
+			end = len(path)
+		}
+		if end > 0 {
+			var l int
+			if params != nil {
+				l = len(*params)
+				*params = append(*params, routeParam{key: that.paramChild.paramName, value: path[:end]})
+			}
+			if n := that.paramChild.match(path[end:], params); n != nil {
+				return n
+			}
+			if params != nil {
+				*params = (*params)[:l]
+			}
+		}
+	}
+	if that.anyChild != nil && that.anyChild.handler != nil {
+		if params != nil {
+			*params = append(*params, routeParam{key: that.anyChild.paramName, value: path})
+		}
+		return that.anyChild
+	}
+	return nil
+}
+
+func commonPrefixLen(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/gogf/gf/v2/container/gmap"
 	"github.com/gogf/gf/v2/container/gtype"
 	"github.com/gogf/gf/v2/os/grpool"
+	"github.com/gogf/gf/v2/util/gconv"
 	"github.com/osgochina/dmicro/drpc/codec"
 	"github.com/osgochina/dmicro/drpc/internal"
 	"github.com/osgochina/dmicro/drpc/message"
@@ -116,9 +117,15 @@ type CtxSession interface {
 	// Call 发送消息并获得响应值
 	Call(serviceMethod string, args interface{}, result interface{}, setting ...message.MsgSetting) CallCmd
 
+	// CallContext 使用 ctx 发送消息并获得响应值，ctx 结束时返回超时或者取消的状态
+	CallContext(ctx context.Context, serviceMethod string, args interface{}, result interface{}, setting ...message.MsgSetting) CallCmd
+
 	// Push 发送消息，不接收响应，只返回发送状态
 	Push(serviceMethod string, args interface{}, setting ...message.MsgSetting) *status.Status
 
+	// OpenStream 打开一个双向流，返回可以收发数据帧的流对象
+	OpenStream(serviceMethod string, args interface{}, setting ...message.MsgSetting) (Stream, *status.Status)
+
 	// SessionAge 获取session最大的生存周期
 	SessionAge() time.Duration
 
@@ -153,10 +160,14 @@ const (
 
 type session struct {
 	endpoint              *endpoint
-	getCallHandler        func(serviceMethodPath string) (*Handler, bool)
-	getPushHandler        func(serviceMethodPath string) (*Handler, bool)
+	getCallHandler        func(serviceMethodPath string, params *routeParams) (*Handler, bool)
+	getPushHandler        func(serviceMethodPath string, params *routeParams) (*Handler, bool)
+	getStreamHandler      func(serviceMethodPath string, params *routeParams) (*Handler, bool)
 	timeNow               func() int64
 	callCmdMap            *gmap.Map
+	callCancelMap         *gmap.Map // 正在执行的call处理程序的取消方法
+	streamMap             *gmap.Map // 本端发起的流
+	peerStreamMap         *gmap.Map // 对端发起的流
 	protoFuncList         []proto.ProtoFunc
 	socket                socket.Socket
 	closeNotifyCh         chan struct{}
@@ -172,6 +183,8 @@ type session struct {
 	seq                   int32
 	status                int32
 	didCloseNotify        int32
+	peerCancel            int32 // 对端是否支持取消消息，收到携带 MetaCancel 的回复后设置
+	cancelOffered         int32 // 是否已经在调用中询问对端是否支持取消消息
 
 	//链接如果断开，重新拨号，只有作为客户端角色的时候才有效果
 	redialForClientLocked func() bool
@@ -188,17 +201,21 @@ var (
 
 func newSession(e *endpoint, conn net.Conn, protoFunc []proto.ProtoFunc) *session {
 	var s = &session{
-		endpoint:       e,
-		getCallHandler: e.router.subRouter.getCall,
-		getPushHandler: e.router.subRouter.getPush,
-		timeNow:        e.timeNow,
-		protoFuncList:  protoFunc,
-		status:         statusPreparing,
-		socket:         socket.NewSocket(conn, protoFunc...),
-		closeNotifyCh:  make(chan struct{}),
-		callCmdMap:     gmap.New(true),
-		sessionAge:     e.defaultSessionAge,
-		contextAge:     e.defaultContextAge,
+		endpoint:         e,
+		getCallHandler:   e.router.subRouter.matchCall,
+		getPushHandler:   e.router.subRouter.matchPush,
+		getStreamHandler: e.router.subRouter.matchStream,
+		timeNow:          e.timeNow,
+		protoFuncList:    protoFunc,
+		status:           statusPreparing,
+		socket:           socket.NewSocket(conn, protoFunc...),
+		closeNotifyCh:    make(chan struct{}),
+		callCmdMap:       gmap.New(true),
+		callCancelMap:    gmap.New(true),
+		streamMap:        gmap.New(true),
+		peerStreamMap:    gmap.New(true),
+		sessionAge:       e.defaultSessionAge,
+		contextAge:       e.defaultContextAge,
 	}
 	return s
 }
@@ -656,6 +673,28 @@ func (that *session) AsyncCall(serviceMethod string, args interface{}, result in
 			internal.Panicf(context.TODO(), "*session.AsyncCall(): callCmdChan channel is unbuffered")
 		}
 	}
+	if !that.endpoint.pluginContainer.hasClientCallInterceptor() {
+		return that.asyncCall(serviceMethod, args, result, callCmdChan, setting...)
+	}
+	//存在客户端拦截器时，在独立的协程中执行拦截器链
+	output := message.NewMessage(append([]message.MsgSetting{
+		message.WithMType(TypeCall),
+		message.WithServiceMethod(serviceMethod),
+		message.WithBody(args),
+	}, setting...)...)
+	return NewAsyncCallCmd(that, output, callCmdChan, func() (cmd CallCmd) {
+		defer func() {
+			if p := recover(); p != nil {
+				internal.Errorf(context.TODO(), "panic:%v\n%s", p, status.PanicStackTrace())
+				cmd = NewFakeCallCmd(serviceMethod, args, result, statInternalServerError.Copy(p))
+			}
+		}()
+		return that.interceptCall(serviceMethod, args, result, setting...)
+	})
+}
+
+// 发送call消息，返回的 CallCmd 完成后写入 callCmdChan
+func (that *session) asyncCall(serviceMethod string, args interface{}, result interface{}, callCmdChan chan<- CallCmd, setting ...message.MsgSetting) CallCmd {
 	output := message.NewMessage()
 	output.SetServiceMethod(serviceMethod)
 	output.SetBody(args)
@@ -665,6 +704,8 @@ func (that *session) AsyncCall(serviceMethod string, args interface{}, result in
 			fn(output)
 		}
 	}
+	//调用方设置的上下文，被取消的时候通知远端
+	callCtx := output.Context()
 	seq := atomic.AddInt32(&that.seq, 1)
 	output.SetSeq(seq)
 	if output.BodyCodec() == codec.NilCodecID {
@@ -675,6 +716,12 @@ func (that *session) AsyncCall(serviceMethod string, args interface{}, result in
 		defer cancel()
 		message.WithContext(ctxTimout)(output)
 	}
+	//把调用方剩余的处理时间传递给服务端
+	setTimeoutMeta(output)
+	//每个链接的第一个调用询问对端是否支持取消消息
+	if atomic.CompareAndSwapInt32(&that.cancelOffered, 0, 1) {
+		output.Meta().Set(MetaCancel, "1")
+	}
 	cmd := &callCmd{
 		sess:        that,
 		output:      output,
@@ -716,12 +763,39 @@ W:
 	}
 	//发送call消息之后，执行插件
 	that.endpoint.pluginContainer.afterWriteCall(cmd)
+	if callCtx.Done() != nil {
+		go cmd.watchContext(callCtx)
+	}
 	return cmd
 }
 
 // Call 发送call消息，并且同步返回结果
 func (that *session) Call(serviceMethod string, args interface{}, result interface{}, setting ...message.MsgSetting) CallCmd {
-	cCmd := that.AsyncCall(serviceMethod, args, result, make(chan CallCmd, 1), setting...)
+	if that.endpoint.pluginContainer.hasClientCallInterceptor() {
+		return that.interceptCall(serviceMethod, args, result, setting...)
+	}
+	return that.call(serviceMethod, args, result, setting...)
+}
+
+// CallContext 与 Call 相同，ctx 作为消息的上下文，在 setting 之前设置
+//  ctx 已经结束时不发送消息，直接返回超时或者取消的状态
+func (that *session) CallContext(ctx context.Context, serviceMethod string, args interface{}, result interface{}, setting ...message.MsgSetting) CallCmd {
+	if err := ctx.Err(); err != nil {
+		return NewFakeCallCmd(serviceMethod, args, result, StatusFromError(err))
+	}
+	return that.Call(serviceMethod, args, result, append([]message.MsgSetting{message.WithContext(ctx)}, setting...)...)
+}
+
+// 同步发送call消息，不经过客户端拦截器
+func (that *session) call(serviceMethod string, args interface{}, result interface{}, setting ...message.MsgSetting) CallCmd {
+	cCmd := that.asyncCall(serviceMethod, args, result, make(chan CallCmd, 1), setting...)
+	<-cCmd.Done()
+	return cCmd
+}
+
+// 执行客户端拦截器链，链的末端同步发送call消息
+func (that *session) interceptCall(serviceMethod string, args interface{}, result interface{}, setting ...message.MsgSetting) CallCmd {
+	cCmd := that.endpoint.pluginContainer.interceptClientCall(that, serviceMethod, args, result, that.call, setting...)
 	<-cCmd.Done()
 	return cCmd
 }
@@ -732,7 +806,7 @@ func (that *session) write(msg message.Message) (net.Conn, *Status) {
 	sta := that.getStatus()
 
 	//当前会话的状态必须 可用状态 或者 主动关闭中的情况下，需要发送的消息是回复消息
-	if !(sta == statusOk || (sta == statusActiveClosing && msg.MType() == message.TypeReply)) {
+	if !(sta == statusOk || (sta == statusActiveClosing && isReplyMType(msg.MType()))) {
 		return usedConn, statConnClosed
 	}
 	var (
@@ -774,6 +848,56 @@ ERR:
 	return usedConn, statWriteFailed.Copy(err)
 }
 
+//判断消息类型是否是回复类的消息，会话主动关闭中仍然允许发送
+func isReplyMType(mType byte) bool {
+	return mType == message.TypeReply || mType == message.TypeStreamEnd
+}
+
+//发送取消消息，通知远端取消正在执行的处理程序
+//  只有对端在回复中确认支持取消消息时才发送，旧版本的对端收到不支持的消息类型会断开链接
+func (that *session) writeCancel(cmd *callCmd) *Status {
+	if atomic.LoadInt32(&that.peerCancel) == 0 {
+		return nil
+	}
+	output := message.GetMessage()
+	defer message.PutMessage(output)
+	output.SetMType(message.TypeCancel)
+	output.SetSeq(cmd.output.Seq())
+	output.SetServiceMethod(cmd.output.ServiceMethod())
+	output.SetBodyCodec(cmd.output.BodyCodec())
+	if age := that.ContextAge(); age > 0 {
+		ctxTimout, cancel := context.WithTimeout(context.Background(), age)
+		defer cancel()
+		message.WithContext(ctxTimout)(output)
+	}
+	if _, stat := that.write(output); !stat.OK() {
+		return stat
+	}
+	//发送取消消息之后，执行插件
+	that.endpoint.pluginContainer.afterWriteCancel(cmd)
+	return nil
+}
+
+//如果消息的上下文设置了截止时间，则把剩余的处理时间写入元数据
+func setTimeoutMeta(msg message.Message) {
+	if deadline, ok := msg.Context().Deadline(); ok {
+		msg.Meta().Set(message.MetaTimeout, time.Until(deadline).String())
+	}
+}
+
+//从元数据中解析调用方剩余的处理时间
+func getTimeoutMeta(msg message.Message) (time.Duration, bool) {
+	v := gconv.String(msg.Meta().Get(message.MetaTimeout))
+	if len(v) == 0 {
+		return 0, false
+	}
+	timeout, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, false
+	}
+	return timeout, true
+}
+
 //重新链接
 func (that *session) redialForClient(oldConn net.Conn) bool {
 	if that.redialForClientLocked == nil {
@@ -804,6 +928,8 @@ func (that *session) closeLocked() error {
 	that.endpoint.sessHub.delete(that.ID())
 	//发送会话准备关闭通知
 	that.notifyClosed()
+	//结束所有的流，流处理程序才能返回
+	that.finishStreams(statConnClosed)
 	// 优雅的结束会话
 	that.graceCtxWait()
 	// 优雅的等待会话中的链接关闭
@@ -863,6 +989,18 @@ func (that *session) startReadAndHandle(c context.Context) {
 		if err != nil {
 			ctx.stat = statBadMessage.Copy(err)
 		}
+		//流的数据帧需要在读取协程中按顺序处理
+		if mType := ctx.input.MType(); message.IsStreamType(mType) && mType != message.TypeStreamOpen {
+			ctx.handleStreamFrame()
+			that.endpoint.putHandleCtx(ctx, false)
+			continue
+		}
+		//取消消息直接在读取协程中处理，不占用协程池
+		if ctx.input.MType() == message.TypeCancel {
+			ctx.handleCancel()
+			that.endpoint.putHandleCtx(ctx, false)
+			continue
+		}
 		// 给优雅处理器添加一次记录,优雅的结束会话之前，需要等待改协程处理完毕
 		that.graceCtxWaitGroup.Add(1)
 
@@ -901,6 +1039,12 @@ func (that *session) readDisconnected(oldConn net.Conn, err error) {
 			internal.Warningf(context.TODO(), "disconnect when reading: %T %s", err, errStr)
 		}
 	}
+	//结束所有的流，流处理程序才能返回
+	if reason != "" {
+		that.finishStreams(statConnClosed.Copy(reason))
+	} else {
+		that.finishStreams(statConnClosed)
+	}
 	//优化的等待所有处理程序结束
 	that.graceCtxWait()
 	// 循环处理该会话中的各个请求
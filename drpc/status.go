@@ -1,6 +1,12 @@
 package drpc
 
-import "github.com/osgochina/dmicro/drpc/status"
+import (
+	"context"
+	"errors"
+	"github.com/gogf/gf/v2/errors/gcode"
+	"github.com/gogf/gf/v2/errors/gerror"
+	"github.com/osgochina/dmicro/drpc/status"
+)
 
 type Status = status.Status
 
@@ -23,6 +29,9 @@ const (
 	CodeConnClosed          int32 = 102
 	CodeWriteFailed         int32 = 104
 	CodeDialFailed          int32 = 105
+	CodeStreamEOF           int32 = 106
+	CodeStreamCanceled      int32 = 107
+	CodeCallCanceled        int32 = 108
 	CodeBadMessage          int32 = 400
 	CodeUnauthorized        int32 = 401
 	CodeNotFound            int32 = 404
@@ -70,6 +79,12 @@ func CodeText(statCode int32) string {
 		return "Connection Closed"
 	case CodeWriteFailed:
 		return "Write Failed"
+	case CodeStreamEOF:
+		return "Stream EOF"
+	case CodeStreamCanceled:
+		return "Stream Canceled"
+	case CodeCallCanceled:
+		return "Call Canceled"
 	case CodeNotFound:
 		return "Not Found"
 	case CodeHandleTimeout:
@@ -98,6 +113,9 @@ var (
 	statCodeMTypeNotAllowed = NewStatus(CodeMTypeNotAllowed, CodeText(CodeMTypeNotAllowed), "")
 	statHandleTimeout       = NewStatus(CodeHandleTimeout, CodeText(CodeHandleTimeout), "")
 	statInternalServerError = NewStatus(CodeInternalServerError, CodeText(CodeInternalServerError), "")
+	statStreamEOF           = NewStatus(CodeStreamEOF, CodeText(CodeStreamEOF), "")
+	statStreamCanceled      = NewStatus(CodeStreamCanceled, CodeText(CodeStreamCanceled), "")
+	statCallCanceled        = NewStatus(CodeCallCanceled, CodeText(CodeCallCanceled), "")
 	// 必须要在 post dial和post accept阶段调用，不然就报错
 	statUnpreparedError = statInvalidOpError.Copy("Cannot be called during the Non-PostDial and Non-PostAccept phase")
 )
@@ -113,3 +131,27 @@ func IsConnError(stat *Status) bool {
 	}
 	return false
 }
+
+// StatusFromError 把处理方法返回的 error 转换成 *Status
+// 上下文超时对应 CodeHandleTimeout，上下文取消对应 CodeCallCanceled，
+// 带有错误码的 gerror 使用其错误码，其他错误对应 CodeInternalServerError
+func StatusFromError(err error) *Status {
+	if err == nil {
+		return nil
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return statHandleTimeout.Copy(err)
+	case errors.Is(err, context.Canceled):
+		return statCallCanceled.Copy(err)
+	}
+	if code := gerror.Code(err); code != gcode.CodeNil {
+		return NewStatus(int32(code.Code()), code.Message(), err)
+	}
+	return statInternalServerError.Copy(err)
+}
+
+// IsStreamEOF 判断是否是流正常结束
+func IsStreamEOF(stat *Status) bool {
+	return stat != nil && stat.Code() == CodeStreamEOF
+}
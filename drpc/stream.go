@@ -0,0 +1,584 @@
+package drpc
+
+import (
+	"context"
+	"github.com/gogf/gf/v2/container/gmap"
+	"github.com/gogf/gf/v2/util/gconv"
+	"github.com/osgochina/dmicro/drpc/codec"
+	"github.com/osgochina/dmicro/drpc/internal"
+	"github.com/osgochina/dmicro/drpc/message"
+	"github.com/osgochina/dmicro/drpc/status"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stream 通过 OpenStream 打开的双向流
+// 流的所有消息帧都使用打开流时的序列号，通过序列号把消息帧对应到流上
+type Stream interface {
+	// Seq 流的序列号
+	Seq() int32
+	// ServiceMethod 流请求的服务方法名
+	ServiceMethod() string
+	// Context 流的上下文，流结束或者被关闭后会被取消
+	Context() context.Context
+	// Send 向流的处理方发送一帧数据
+	Send(body interface{}, setting ...message.MsgSetting) *Status
+	// Recv 读取流的处理方发送的一帧数据，处理方正常结束后返回 CodeStreamEOF 状态
+	Recv(v interface{}) *Status
+	// CloseSend 关闭发送端，通知处理方已经没有更多的数据帧
+	CloseSend() *Status
+	// Close 主动关闭流，处理方的上下文会被取消
+	Close() *Status
+	// Done 返回指示流是否已经结束的chan
+	Done() <-chan struct{}
+	// Status 流结束后的最终状态
+	Status() *Status
+}
+
+// 流量控制，双方的接收窗口都是 streamWindow 个数据帧
+//  发送方每发送一个数据帧消耗一个额度，额度用完之后 Send 阻塞
+//  接收方每读取 streamWindow/2 个数据帧，通过携带 metaStreamWindow 元数据的空数据帧把额度归还给发送方
+const (
+	streamWindow     = 64
+	metaStreamWindow = "X-Stream-Window"
+)
+
+// 流中缓存的消息帧
+type streamFrame struct {
+	body      []byte
+	bodyCodec byte
+	// 结束帧的状态，不为nil表示这是最后一帧
+	stat *Status
+}
+
+// stream 是 Stream 和 StreamCtx 的底层公共实例
+type stream struct {
+	sess            *session
+	seq             int32
+	serviceMethod   string
+	isOpener        bool // 是否是流的发起方
+	bodyCodec       byte
+	pluginContainer *PluginContainer
+	swap            *gmap.Map
+	start           int64
+
+	mu         sync.Mutex
+	ctx        context.Context
+	cancel     context.CancelFunc
+	frames     []*streamFrame
+	notify     chan struct{}
+	consumed   int           // 已经读取但是还没有归还额度的数据帧数量
+	sendWindow int           // 发送数据帧的剩余额度
+	sendNotify chan struct{} // 收到归还的额度时通知等待中的 Send
+	recvStat   *Status       // 接收端结束时的状态，不为nil表示不会再有新的数据帧
+	sendClosed bool
+	stat       *Status // 流的最终状态
+	doneChan   chan struct{}
+	finished   int32
+}
+
+var _ Stream = new(stream)
+
+func newStream(sess *session, seq int32, serviceMethod string, isOpener bool, pluginContainer *PluginContainer) *stream {
+	s := &stream{
+		sess:            sess,
+		seq:             seq,
+		serviceMethod:   serviceMethod,
+		isOpener:        isOpener,
+		bodyCodec:       sess.endpoint.defaultBodyCodec,
+		pluginContainer: pluginContainer,
+		swap:            gmap.New(true),
+		start:           sess.timeNow(),
+		notify:          make(chan struct{}, 1),
+		sendWindow:      streamWindow,
+		sendNotify:      make(chan struct{}, 1),
+		doneChan:        make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	if sess.socket.SwapLen() > 0 {
+		s.swap = sess.socket.Swap().Clone(true)
+	}
+	return s
+}
+
+// 根据是流的发起方还是处理方，返回本端保存流的容器
+func (that *stream) streamMap() *gmap.Map {
+	if that.isOpener {
+		return that.sess.streamMap
+	}
+	return that.sess.peerStreamMap
+}
+
+// 替换流的父上下文
+func (that *stream) setParentContext(parent context.Context) {
+	that.mu.Lock()
+	that.cancel()
+	that.ctx, that.cancel = context.WithCancel(parent)
+	that.mu.Unlock()
+}
+
+// Seq 流的序列号
+func (that *stream) Seq() int32 {
+	return that.seq
+}
+
+// ServiceMethod 流请求的服务方法名
+func (that *stream) ServiceMethod() string {
+	return that.serviceMethod
+}
+
+// Context 流的上下文
+func (that *stream) Context() context.Context {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return that.ctx
+}
+
+// Done 流是否已经结束
+func (that *stream) Done() <-chan struct{} {
+	return that.doneChan
+}
+
+// Status 流的最终状态
+func (that *stream) Status() *Status {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return that.stat
+}
+
+// Send 发送一帧数据，对方的接收窗口已满时阻塞，直到对方读取了数据帧或者流结束
+func (that *stream) Send(body interface{}, setting ...message.MsgSetting) *Status {
+	for {
+		that.mu.Lock()
+		if that.sendClosed || atomic.LoadInt32(&that.finished) == 1 {
+			that.mu.Unlock()
+			return statStreamEOF.Copy("stream send direction is closed")
+		}
+		if that.sendWindow > 0 {
+			that.sendWindow--
+			that.mu.Unlock()
+			break
+		}
+		ctx := that.ctx
+		that.mu.Unlock()
+
+		select {
+		case <-that.sendNotify:
+		case <-that.doneChan:
+		case <-ctx.Done():
+			if atomic.LoadInt32(&that.finished) == 1 {
+				continue
+			}
+			return statStreamCanceled.Copy(ctx.Err())
+		}
+	}
+	return that.write(that.dataType(), body, nil, setting)
+}
+
+// 本端发送的数据帧的消息类型
+func (that *stream) dataType() byte {
+	if that.isOpener {
+		return message.TypeStreamData
+	}
+	return message.TypeStreamReply
+}
+
+// Recv 读取一帧数据，并使用该帧的编码格式解码到v中
+func (that *stream) Recv(v interface{}) *Status {
+	for {
+		that.mu.Lock()
+		if len(that.frames) > 0 {
+			frame := that.frames[0]
+			that.frames[0] = nil
+			that.frames = that.frames[1:]
+			that.consumed++
+			var credit int
+			if that.consumed >= streamWindow/2 && that.recvStat == nil {
+				credit, that.consumed = that.consumed, 0
+			}
+			that.mu.Unlock()
+			if credit > 0 {
+				//归还额度失败说明会话已经断开，流会随着会话一起结束
+				_ = that.write(that.dataType(), nil, nil, []message.MsgSetting{
+					message.WithSetMeta(metaStreamWindow, strconv.Itoa(credit)),
+				})
+			}
+			return frame.decode(v)
+		}
+		if that.recvStat != nil {
+			stat := that.recvStat
+			that.mu.Unlock()
+			return stat
+		}
+		ctx := that.ctx
+		that.mu.Unlock()
+
+		select {
+		case <-that.notify:
+		case <-ctx.Done():
+			that.mu.Lock()
+			// 上下文被取消之前如果已经收到了数据帧，则优先返回数据帧
+			if len(that.frames) > 0 || that.recvStat != nil {
+				that.mu.Unlock()
+				continue
+			}
+			that.mu.Unlock()
+			return statStreamCanceled.Copy(ctx.Err())
+		}
+	}
+}
+
+// CloseSend 关闭发送端，只有流的发起方可以调用
+func (that *stream) CloseSend() *Status {
+	if !that.isOpener {
+		return statInvalidOpError.Copy("only the stream opener can close the send direction")
+	}
+	that.mu.Lock()
+	if that.sendClosed {
+		that.mu.Unlock()
+		return nil
+	}
+	that.sendClosed = true
+	that.mu.Unlock()
+	return that.write(message.TypeStreamClose, nil, nil, nil)
+}
+
+// Close 主动关闭流，并通知处理方取消处理
+func (that *stream) Close() *Status {
+	if !that.isOpener {
+		return statInvalidOpError.Copy("only the stream opener can close the stream")
+	}
+	if atomic.LoadInt32(&that.finished) == 1 {
+		return nil
+	}
+	that.mu.Lock()
+	that.sendClosed = true
+	that.mu.Unlock()
+	stat := that.write(message.TypeStreamClose, nil, statStreamCanceled, nil)
+	that.finish(statStreamCanceled)
+	return stat
+}
+
+// 往流中添加一帧收到的数据，唤醒等待中的 Recv
+//  对方发送的数据帧超过接收窗口时返回false
+func (that *stream) pushFrame(frame *streamFrame) bool {
+	that.mu.Lock()
+	if that.recvStat != nil {
+		that.mu.Unlock()
+		return true
+	}
+	if frame.stat != nil {
+		that.recvStat = frame.stat
+	} else if len(that.frames) >= streamWindow {
+		that.mu.Unlock()
+		return false
+	} else {
+		that.frames = append(that.frames, frame)
+	}
+	that.mu.Unlock()
+	select {
+	case that.notify <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// 收到对方归还的额度，唤醒等待中的 Send
+func (that *stream) addSendWindow(n int) {
+	if n <= 0 {
+		return
+	}
+	that.mu.Lock()
+	that.sendWindow += n
+	that.mu.Unlock()
+	select {
+	case that.sendNotify <- struct{}{}:
+	default:
+	}
+}
+
+// 以错误状态结束流，并通知对方
+func (that *stream) abort(stat *Status) {
+	if atomic.LoadInt32(&that.finished) == 1 {
+		return
+	}
+	mType := message.TypeStreamEnd
+	if that.isOpener {
+		mType = message.TypeStreamClose
+	}
+	_ = that.write(mType, nil, stat, nil)
+	that.finish(stat)
+}
+
+// 结束流，把流从会话中移除并取消上下文
+func (that *stream) finish(stat *Status) {
+	if !atomic.CompareAndSwapInt32(&that.finished, 0, 1) {
+		return
+	}
+	that.streamMap().Remove(that.seq)
+	if stat.OK() {
+		stat = nil
+	}
+	that.mu.Lock()
+	that.stat = stat
+	that.sendClosed = true
+	if that.recvStat == nil {
+		if stat == nil {
+			that.recvStat = statStreamEOF
+		} else {
+			that.recvStat = stat
+		}
+	}
+	cancel := that.cancel
+	that.mu.Unlock()
+	cancel()
+	close(that.doneChan)
+	select {
+	case that.notify <- struct{}{}:
+	default:
+	}
+}
+
+// 写入一帧流消息
+func (that *stream) write(mType byte, body interface{}, stat *Status, setting []message.MsgSetting) (opStat *Status) {
+	//从池子中获取上下文对象
+	ctx := that.sess.endpoint.getHandleCtx(that.sess, true)
+	defer func() {
+		//把上下文对象放回池子
+		that.sess.endpoint.putHandleCtx(ctx, true)
+		if p := recover(); p != nil {
+			internal.Errorf(context.TODO(), "panic:%v\n%s", p, status.PanicStackTrace())
+			opStat = statBadMessage.Copy(p, 3)
+		}
+	}()
+	ctx.start = that.sess.timeNow()
+	ctx.swap = that.swap
+	ctx.stream = that
+	output := ctx.output
+	output.SetMType(mType)
+	output.SetSeq(that.seq)
+	//只有打开流的消息需要携带服务名
+	if mType == message.TypeStreamOpen {
+		output.SetServiceMethod(that.serviceMethod)
+	}
+	output.SetBody(body)
+	for _, fn := range setting {
+		if fn != nil {
+			fn(output)
+		}
+	}
+	if mType == message.TypeStreamOpen {
+		//打开流时设置的上下文作为流的父上下文
+		that.setParentContext(output.Context())
+		if output.BodyCodec() != codec.NilCodecID {
+			that.bodyCodec = output.BodyCodec()
+		}
+	}
+	if output.BodyCodec() == codec.NilCodecID {
+		output.SetBodyCodec(that.bodyCodec)
+	}
+	if !stat.OK() {
+		output.SetStatus(stat)
+	}
+	//设置单个消息帧的生存时间
+	if age := that.sess.ContextAge(); age > 0 {
+		ctxTimout, cancel := context.WithTimeout(context.Background(), age)
+		defer cancel()
+		message.WithContext(ctxTimout)(output)
+	} else {
+		message.WithContext(nil)(output)
+	}
+	//归还额度的控制帧不执行插件
+	if output.Meta().Contains(metaStreamWindow) {
+		_, opStat = that.sess.write(output)
+		return opStat
+	}
+	//写入流消息帧之前执行插件
+	opStat = that.pluginContainer.beforeWriteStreamFrame(ctx)
+	if !opStat.OK() {
+		return opStat
+	}
+	if _, opStat = that.sess.write(output); !opStat.OK() {
+		return opStat
+	}
+	if mType == message.TypeStreamOpen && enablePrintRunLog() {
+		output.SetServiceMethod(that.serviceMethod)
+		that.sess.printRunLog("", time.Duration(that.sess.timeNow()-ctx.start), nil, output, typeStreamLaunch)
+	}
+	//写入流消息帧之后执行插件
+	that.pluginContainer.afterWriteStreamFrame(ctx)
+	return nil
+}
+
+// 把数据帧解码到v中
+func (that *streamFrame) decode(v interface{}) *Status {
+	m := message.GetMessage(message.WithBody(v))
+	defer message.PutMessage(m)
+	m.SetBodyCodec(that.bodyCodec)
+	if err := m.UnmarshalBody(that.body); err != nil {
+		return statBadMessage.Copy(err)
+	}
+	return nil
+}
+
+// OpenStream 打开一个双向流，arg 会作为打开流消息的消息体传递给处理方
+func (that *session) OpenStream(serviceMethod string, arg interface{}, setting ...message.MsgSetting) (Stream, *Status) {
+	if !that.checkStatus(statusOk) {
+		return nil, statConnClosed
+	}
+	s := newStream(that, atomic.AddInt32(&that.seq, 1), serviceMethod, true, that.endpoint.pluginContainer)
+	//在发送消息之前登记，防止处理方的回复先于登记到达
+	that.streamMap.Set(s.seq, s)
+	stat := s.write(message.TypeStreamOpen, arg, nil, setting)
+	if !stat.OK() {
+		s.finish(stat)
+		return nil, stat
+	}
+	return s, nil
+}
+
+// 会话关闭时，结束所有未完成的流
+func (that *session) finishStreams(stat *Status) {
+	for _, v := range that.streamMap.Values() {
+		v.(*stream).finish(stat)
+	}
+	for _, v := range that.peerStreamMap.Values() {
+		v.(*stream).finish(stat)
+	}
+}
+
+// 根据消息头构建打开流的消息体，并且登记处理方的流
+func (that *handlerCtx) buildStreamOpenBody(header message.Header) interface{} {
+	//流需要在读取协程中登记，保证后续的数据帧可以找到它
+	that.stream = newStream(that.sess, header.Seq(), header.ServiceMethod(), false, that.pluginContainer)
+	that.sess.peerStreamMap.Set(header.Seq(), that.stream)
+
+	//传入的消息如果没有服务方法
+	if len(header.ServiceMethod()) == 0 {
+		that.stat = statBadMessage.Copy("invalid service method for message")
+		return nil
+	}
+	var ok bool
+	that.handler, ok = that.sess.getStreamHandler(header.ServiceMethod(), &that.params)
+	if !ok {
+		that.stat = statNotFound
+		return nil
+	}
+	//使用消息处理方法的插件容器来处理消息
+	that.pluginContainer = that.handler.pluginContainer
+	that.stream.pluginContainer = that.pluginContainer
+
+	that.arg = that.handler.NewArgValue()
+	that.input.SetBody(that.arg.Interface())
+	return that.input.Body()
+}
+
+// 根据消息头找到对应的流，数据帧的消息体保留原始字节，由 Recv 解码
+func (that *handlerCtx) buildStreamFrameBody(header message.Header, streams *gmap.Map) interface{} {
+	s, ok := streams.Search(header.Seq())
+	if !ok {
+		internal.Debugf(context.TODO(), "not found stream: seq=%d, mtype=%s", header.Seq(), message.TypeText(header.MType()))
+		return nil
+	}
+	that.stream = s.(*stream)
+	that.swap = that.stream.swap
+	that.pluginContainer = that.stream.pluginContainer
+	that.input.SetServiceMethod(that.stream.serviceMethod)
+	that.input.SetBody(new([]byte))
+	return that.input.Body()
+}
+
+// 处理打开流的消息，执行流处理程序，处理程序返回后结束该流
+func (that *handlerCtx) handleStreamOpen() {
+	s := that.stream
+	if s == nil {
+		return
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			internal.Errorf(that.Context(), "panic:%v\n%s", p, status.PanicStackTrace())
+			if that.stat.OK() {
+				that.stat = statInternalServerError.Copy(p)
+			}
+		}
+		//如果流没有被发起方取消或者因为断线结束，则发送流结束消息，携带处理程序返回的状态
+		if atomic.LoadInt32(&s.finished) == 0 {
+			_ = s.write(message.TypeStreamEnd, nil, that.stat, nil)
+			s.finish(that.stat)
+		}
+		if enablePrintRunLog() {
+			that.output.SetStatus(that.stat)
+			that.sess.printRunLog(that.RealIP(), that.CostTime(), that.input, that.output, typeStreamHandle)
+		}
+	}()
+	//流的回复数据帧默认使用请求的编码格式
+	s.bodyCodec = that.ReplyBodyCodec()
+	that.setContext(s.Context())
+	if that.stat.OK() {
+		that.stat = that.pluginContainer.afterReadStreamFrame(that)
+	}
+	if that.stat.OK() {
+		that.handler.handleFunc(that, that.arg)
+	}
+}
+
+// 处理流的数据帧和关闭帧，该方法在读取协程中执行以保证帧的顺序
+func (that *handlerCtx) handleStreamFrame() {
+	s := that.stream
+	if s == nil {
+		return
+	}
+	//对方归还的发送额度
+	if mType := that.input.MType(); mType == message.TypeStreamData || mType == message.TypeStreamReply {
+		if v := that.input.Meta().Get(metaStreamWindow); v != nil {
+			s.addSendWindow(gconv.Int(v))
+			return
+		}
+	}
+	if stat := that.pluginContainer.afterReadStreamFrame(that); !stat.OK() {
+		internal.Warningf(context.TODO(), "discard stream frame: seq=%d, mtype=%s, %s", that.Seq(), message.TypeText(that.input.MType()), stat.String())
+		return
+	}
+	var body []byte
+	if b, ok := that.input.Body().(*[]byte); ok && b != nil {
+		body = *b
+	}
+	frame := &streamFrame{body: body, bodyCodec: that.input.BodyCodec()}
+	if !that.stat.OK() {
+		frame.stat = that.stat
+	}
+	switch that.input.MType() {
+	case message.TypeStreamData, message.TypeStreamReply:
+		if !s.pushFrame(frame) {
+			s.abort(statBadMessage.Copy("stream receive window exceeded"))
+		}
+	case message.TypeStreamClose:
+		//发起方关闭了发送端，或者取消了流
+		if stat := that.input.Status(); !stat.OK() {
+			s.finish(stat)
+			return
+		}
+		frame.stat = statStreamEOF
+		s.pushFrame(frame)
+	case message.TypeStreamEnd:
+		s.finish(that.input.Status())
+	}
+}
+
+// Send 流处理程序向流的发起方发送一帧数据
+func (that *handlerCtx) Send(body interface{}, setting ...message.MsgSetting) *Status {
+	if that.stream == nil {
+		return statInvalidOpError.Copy("not a stream context")
+	}
+	return that.stream.Send(body, setting...)
+}
+
+// Recv 流处理程序读取发起方发送的一帧数据
+func (that *handlerCtx) Recv(v interface{}) *Status {
+	if that.stream == nil {
+		return statInvalidOpError.Copy("not a stream context")
+	}
+	return that.stream.Recv(v)
+}
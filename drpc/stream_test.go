@@ -0,0 +1,219 @@
+package drpc_test
+
+import (
+	"fmt"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/osgochina/dmicro/drpc"
+	"github.com/osgochina/dmicro/drpc/proto"
+	"github.com/osgochina/dmicro/drpc/proto/pbproto"
+	"github.com/osgochina/dmicro/drpc/proto/rawproto"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type EchoArg struct {
+	Prefix string
+	Times  int
+}
+
+type EchoStream struct {
+	drpc.StreamCtx
+}
+
+// Echo 把收到的每一帧数据加上前缀返回，发起方关闭发送端后结束
+func (that *EchoStream) Echo(arg *EchoArg) *drpc.Status {
+	for {
+		var s string
+		stat := that.Recv(&s)
+		if drpc.IsStreamEOF(stat) {
+			return nil
+		}
+		if !stat.OK() {
+			return stat
+		}
+		if stat = that.Send(arg.Prefix + s); !stat.OK() {
+			return stat
+		}
+	}
+}
+
+// Count 服务端流，按照参数连续发送多帧数据
+func (that *EchoStream) Count(arg *EchoArg) *drpc.Status {
+	for i := 0; i < arg.Times; i++ {
+		if stat := that.Send(i); !stat.OK() {
+			return stat
+		}
+	}
+	return nil
+}
+
+// 已经发送成功的数据帧数量
+var floodSent int32
+
+// Flood 服务端流，尽可能快的发送数据帧
+func (that *EchoStream) Flood(arg *EchoArg) *drpc.Status {
+	for i := 0; i < arg.Times; i++ {
+		if stat := that.Send(i); !stat.OK() {
+			return stat
+		}
+		atomic.AddInt32(&floodSent, 1)
+	}
+	return nil
+}
+
+// Wait 一直等到发起方取消
+func (that *EchoStream) Wait(_ *EchoArg) *drpc.Status {
+	<-that.Context().Done()
+	return drpc.NewStatus(1001, "canceled", "")
+}
+
+func testStream(t *gtest.T, port uint16, protoFunc proto.ProtoFunc) {
+	srv := drpc.NewEndpoint(drpc.EndpointConfig{ListenPort: port})
+	srv.RouteStream(new(EchoStream))
+	defer srv.Close()
+	go srv.ListenAndServe(protoFunc)
+	time.Sleep(500 * time.Millisecond)
+
+	cli := drpc.NewEndpoint(drpc.EndpointConfig{})
+	defer cli.Close()
+	sess, stat := cli.Dial(fmt.Sprintf(":%d", port), protoFunc)
+	t.Assert(stat.OK(), true)
+
+	// 双向流
+	stream, stat := sess.OpenStream("/echo_stream/echo", &EchoArg{Prefix: "echo:"})
+	t.Assert(stat.OK(), true)
+	for i := 0; i < 5; i++ {
+		t.Assert(stream.Send(fmt.Sprintf("%d", i)).OK(), true)
+	}
+	t.Assert(stream.CloseSend().OK(), true)
+	for i := 0; i < 5; i++ {
+		var s string
+		t.Assert(stream.Recv(&s).OK(), true)
+		t.Assert(s, fmt.Sprintf("echo:%d", i))
+	}
+	var s string
+	t.Assert(drpc.IsStreamEOF(stream.Recv(&s)), true)
+	<-stream.Done()
+	t.Assert(stream.Status().OK(), true)
+
+	// 服务端流
+	stream, stat = sess.OpenStream("/echo_stream/count", &EchoArg{Times: 3})
+	t.Assert(stat.OK(), true)
+	for i := 0; i < 3; i++ {
+		var n int
+		t.Assert(stream.Recv(&n).OK(), true)
+		t.Assert(n, i)
+	}
+	t.Assert(drpc.IsStreamEOF(stream.Recv(&s)), true)
+
+	// 未注册的流
+	stream, stat = sess.OpenStream("/echo_stream/not_found", &EchoArg{})
+	t.Assert(stat.OK(), true)
+	t.Assert(stream.Recv(&s).Code(), drpc.CodeNotFound)
+
+	// 发起方取消流
+	stream, stat = sess.OpenStream("/echo_stream/wait", &EchoArg{})
+	t.Assert(stat.OK(), true)
+	time.Sleep(100 * time.Millisecond)
+	t.Assert(stream.Close().OK(), true)
+	t.Assert(stream.Status().Code(), drpc.CodeStreamCanceled)
+}
+
+func TestStreamRawProto(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		testStream(t, 9211, rawproto.NewRawProtoFunc())
+	})
+}
+
+func TestStreamPbProto(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		testStream(t, 9212, pbproto.NewPbProtoFunc())
+	})
+}
+
+// 接收方不读取时，发送方被接收窗口阻塞
+func TestStreamBackpressure(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		srv := drpc.NewEndpoint(drpc.EndpointConfig{ListenPort: 9214})
+		srv.RouteStream(new(EchoStream))
+		defer srv.Close()
+		go srv.ListenAndServe()
+		time.Sleep(500 * time.Millisecond)
+
+		cli := drpc.NewEndpoint(drpc.EndpointConfig{})
+		defer cli.Close()
+		sess, stat := cli.Dial(":9214")
+		t.Assert(stat.OK(), true)
+		atomic.StoreInt32(&floodSent, 0)
+		stream, stat := sess.OpenStream("/echo_stream/flood", &EchoArg{Times: 1000})
+		t.Assert(stat.OK(), true)
+		time.Sleep(300 * time.Millisecond)
+		t.AssertLE(atomic.LoadInt32(&floodSent), int32(64))
+
+		for i := 0; i < 1000; i++ {
+			var n int
+			t.Assert(stream.Recv(&n).OK(), true)
+			t.Assert(n, i)
+		}
+		var s string
+		t.Assert(drpc.IsStreamEOF(stream.Recv(&s)), true)
+		t.Assert(atomic.LoadInt32(&floodSent), int32(1000))
+	})
+}
+
+func TestStreamFramePlugin(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		var reads, writes int32
+		ended := make(chan struct{})
+		plugin := &streamFramePlugin{
+			read: func(drpc.ReadCtx) { atomic.AddInt32(&reads, 1) },
+			write: func(ctx drpc.WriteCtx) {
+				atomic.AddInt32(&writes, 1)
+				if ctx.Output().MType() == drpc.TypeStreamEnd {
+					close(ended)
+				}
+			},
+		}
+		srv := drpc.NewEndpoint(drpc.EndpointConfig{ListenPort: 9213})
+		srv.RouteStream(new(EchoStream), plugin)
+		defer srv.Close()
+		go srv.ListenAndServe()
+		time.Sleep(500 * time.Millisecond)
+
+		cli := drpc.NewEndpoint(drpc.EndpointConfig{})
+		defer cli.Close()
+		sess, stat := cli.Dial(":9213")
+		t.Assert(stat.OK(), true)
+		stream, stat := sess.OpenStream("/echo_stream/count", &EchoArg{Times: 2})
+		t.Assert(stat.OK(), true)
+		<-stream.Done()
+		select {
+		case <-ended:
+		case <-time.After(3 * time.Second):
+			t.Fatal("stream end frame not written")
+		}
+		// 服务端读取了打开流的消息，写入了两个数据帧和一个结束帧
+		t.Assert(atomic.LoadInt32(&reads), 1)
+		t.Assert(atomic.LoadInt32(&writes), 3)
+	})
+}
+
+type streamFramePlugin struct {
+	read  func(drpc.ReadCtx)
+	write func(drpc.WriteCtx)
+}
+
+func (that *streamFramePlugin) Name() string {
+	return "stream_frame"
+}
+
+func (that *streamFramePlugin) AfterReadStreamFrame(ctx drpc.ReadCtx) *drpc.Status {
+	that.read(ctx)
+	return nil
+}
+
+func (that *streamFramePlugin) AfterWriteStreamFrame(ctx drpc.WriteCtx) *drpc.Status {
+	that.write(ctx)
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/osgochina/dmicro/drpc"
 	"github.com/osgochina/dmicro/drpc/proto/pbproto"
 	"github.com/osgochina/dmicro/logger"
+	"github.com/osgochina/dmicro/selector"
 	"github.com/osgochina/dmicro/supervisor/process"
 	"os"
 	"time"
@@ -188,6 +189,48 @@ func (that *Ctl) Reload(name *string) (*Result, *drpc.Status) {
 	return &Result{}, nil
 }
 
+// BreakerInfos 客户端节点熔断器的状态列表
+type BreakerInfos struct {
+	List []*BreakerInfo
+}
+
+// BreakerInfo 客户端节点熔断器的状态
+type BreakerInfo struct {
+	Service             string
+	Node                string
+	State               string
+	ConsecutiveFailures int
+	Requests            int
+	Failures            int
+	Trips               int64
+	OpenedAt            string
+}
+
+// Breaker 查看当前进程中客户端节点熔断器的状态
+func (that *Ctl) Breaker(_ *string) (*BreakerInfos, *drpc.Status) {
+	// 多进程模式下客户端运行在子进程中，无法获取
+	if defaultServer.procModel == ProcessModelMulti {
+		return nil, drpc.NewStatus(100, "多进程模式不支持查看熔断器状态")
+	}
+	var infos = new(BreakerInfos)
+	for _, stat := range selector.BreakerStats() {
+		info := &BreakerInfo{
+			Service:             stat.Service,
+			Node:                stat.Node,
+			State:               stat.State.String(),
+			ConsecutiveFailures: stat.ConsecutiveFailures,
+			Requests:            stat.Requests,
+			Failures:            stat.Failures,
+			Trips:               stat.Trips,
+		}
+		if !stat.OpenedAt.IsZero() {
+			info.OpenedAt = gtime.New(stat.OpenedAt).String()
+		}
+		infos.List = append(infos.List, info)
+	}
+	return infos, nil
+}
+
 // Debug 设置debug模式
 func (that *Ctl) Debug(debug *bool) (*Result, *drpc.Status) {
 	if *debug {
@@ -202,6 +202,31 @@ func (that *DServer) initCtrlGrumble() {
 		},
 	})
 
+	that.grumbleApp.AddCommand(&grumble.Command{
+		Name: "breaker",
+		Help: "查看客户端节点熔断器的状态",
+		Run: func(c *grumble.Context) error {
+			var result *BreakerInfos
+			sess, err := that.getCtrlSession()
+			if err != nil {
+				return err
+			}
+			stat := sess.Call("/ctl/breaker",
+				nil,
+				&result,
+			).Status()
+			if !stat.OK() {
+				return stat.Cause()
+			}
+			if len(result.List) == 0 {
+				fmt.Println("没有节点熔断器的记录")
+				return nil
+			}
+			table.Output(result.List)
+			return nil
+		},
+	})
+
 	that.grumbleApp.AddCommand(&grumble.Command{
 		Name: "log",
 		Help: "打印出服务的运行日志",
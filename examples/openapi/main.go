@@ -0,0 +1,52 @@
+// 生成 OpenAPI 文档的示例
+//  go generate 生成文档: go generate ./examples/openapi
+//  启动服务后通过接口获取文档: curl -X POST http://127.0.0.1:9090/openapi
+package main
+
+//go:generate go run . -o openapi.json
+
+import (
+	"context"
+	"flag"
+	"github.com/osgochina/dmicro/drpc"
+	"github.com/osgochina/dmicro/drpc/openapi"
+	"github.com/osgochina/dmicro/drpc/proto/httpproto"
+	"github.com/osgochina/dmicro/logger"
+)
+
+// Item 商品
+type Item struct {
+	// 商品编号
+	Id int64 `json:"id"`
+	// 商品名称
+	Name string `json:"name"`
+}
+
+type Shop struct {
+	drpc.CallCtx
+}
+
+// Get 注册方法名为"/shop/get"
+func (that *Shop) Get(id *int64) (*Item, *drpc.Status) {
+	return &Item{Id: *id, Name: "apple"}, nil
+}
+
+func main() {
+	output := flag.String("o", "", "文档的输出文件，为空则启动服务")
+	flag.Parse()
+
+	opts := []openapi.Option{
+		openapi.OptTitle("shop"),
+		openapi.OptServer("http://127.0.0.1:9090"),
+		openapi.OptSourceDir("."),
+	}
+	endpoint := drpc.NewEndpoint(drpc.EndpointConfig{ListenPort: 9090}, openapi.NewOpenAPI(opts...))
+	endpoint.RouteCall(new(Shop))
+	if *output != "" {
+		if err := openapi.WriteFile(endpoint.Router(), *output, opts...); err != nil {
+			logger.Fatal(context.TODO(), err)
+		}
+		return
+	}
+	_ = endpoint.ListenAndServe(httpproto.NewHTTProtoFunc())
+}
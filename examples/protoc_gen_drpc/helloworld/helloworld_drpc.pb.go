@@ -0,0 +1,73 @@
+// Code generated by protoc-gen-drpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-drpc v1.1.0
+// source: helloworld.proto
+
+package helloworld
+
+import (
+	drpc "github.com/osgochina/dmicro/drpc"
+	message "github.com/osgochina/dmicro/drpc/message"
+)
+
+// Greeter 服务的路由
+const (
+	Greeter_SayHello_ServiceMethod = "/greeter/say_hello"
+)
+
+// GreeterServer Greeter 服务端需要实现的接口
+//
+// Greeter 问候服务
+type GreeterServer interface {
+	// SayHello 打招呼
+	SayHello(ctx drpc.CallCtx, req *HelloRequest) (*HelloReply, *drpc.Status)
+}
+
+// RegisterGreeterServer 通过 RouteCall 注册 Greeter 服务，返回注册的路由
+func RegisterGreeterServer(router drpc.CallRouter, srv GreeterServer, plugin ...drpc.Plugin) []string {
+	return router.RouteCall(drpc.CtrlFactory(func() interface{} {
+		return &greeter{srv: srv}
+	}), plugin...)
+}
+
+type greeter struct {
+	drpc.CallCtx
+	srv GreeterServer
+}
+
+func (that *greeter) SayHello(req *HelloRequest) (*HelloReply, *drpc.Status) {
+	return that.srv.SayHello(that.CallCtx, req)
+}
+
+// GreeterClient Greeter 服务的客户端
+type GreeterClient interface {
+	// SayHello 打招呼
+	SayHello(req *HelloRequest, setting ...message.MsgSetting) (*HelloReply, *drpc.Status)
+}
+
+// NewGreeterClient 创建客户端，caller 可以是 drpc.Session 或者 client.RpcClient
+func NewGreeterClient(caller drpc.Caller) GreeterClient {
+	return &greeterClient{caller: caller}
+}
+
+type greeterClient struct {
+	caller drpc.Caller
+}
+
+func (that *greeterClient) SayHello(req *HelloRequest, setting ...message.MsgSetting) (*HelloReply, *drpc.Status) {
+	return drpc.Invoke[HelloRequest, HelloReply](that.caller, Greeter_SayHello_ServiceMethod, req, setting...)
+}
+
+// GreeterClientMock 单元测试使用的 GreeterClient，调用对应的 Func 字段，未设置时返回错误
+type GreeterClientMock struct {
+	SayHelloFunc func(req *HelloRequest, setting ...message.MsgSetting) (*HelloReply, *drpc.Status)
+}
+
+var _ GreeterClient = new(GreeterClientMock)
+
+func (that *GreeterClientMock) SayHello(req *HelloRequest, setting ...message.MsgSetting) (*HelloReply, *drpc.Status) {
+	if that.SayHelloFunc == nil {
+		return nil, drpc.NewStatus(drpc.CodeInternalServerError, "GreeterClientMock.SayHelloFunc is nil", nil)
+	}
+	return that.SayHelloFunc(req, setting...)
+}
@@ -0,0 +1,41 @@
+// protoc-gen-drpc 生成代码的使用示例
+package main
+
+import (
+	"context"
+	"github.com/osgochina/dmicro/drpc"
+	"github.com/osgochina/dmicro/drpc/proto/pbproto"
+	"github.com/osgochina/dmicro/examples/protoc_gen_drpc/helloworld"
+	"github.com/osgochina/dmicro/logger"
+	"time"
+)
+
+// 实现 helloworld.GreeterServer 接口
+type greeter struct {
+	prefix string
+}
+
+func (that *greeter) SayHello(ctx drpc.CallCtx, req *helloworld.HelloRequest) (*helloworld.HelloReply, *drpc.Status) {
+	return &helloworld.HelloReply{Message: that.prefix + req.Name}, nil
+}
+
+func main() {
+	svr := drpc.NewEndpoint(drpc.EndpointConfig{ListenPort: 9090})
+	// 注册的路由为 helloworld.Greeter_SayHello_ServiceMethod
+	helloworld.RegisterGreeterServer(svr, &greeter{prefix: "hello "})
+	go svr.ListenAndServe(pbproto.NewPbProtoFunc())
+	time.Sleep(time.Second)
+
+	cli := drpc.NewEndpoint(drpc.EndpointConfig{})
+	sess, stat := cli.Dial(":9090", pbproto.NewPbProtoFunc())
+	if !stat.OK() {
+		logger.Fatal(context.TODO(), stat)
+	}
+	reply, stat := helloworld.NewGreeterClient(sess).SayHello(&helloworld.HelloRequest{Name: "world"})
+	if !stat.OK() {
+		logger.Fatal(context.TODO(), stat)
+	}
+	logger.Info(context.TODO(), reply.Message)
+	_ = cli.Close()
+	_ = svr.Close()
+}
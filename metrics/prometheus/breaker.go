@@ -0,0 +1,65 @@
+package prometheus
+
+import (
+	"github.com/osgochina/dmicro/selector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var clientNamespace = "rpc_client"
+
+// 客户端节点熔断器的状态，抓取指标时从选择器中读取
+var metricsBreaker = newBreakerCollector()
+
+type breakerCollector struct {
+	state *prometheus.Desc
+	trips *prometheus.Desc
+}
+
+var _ prometheus.Collector = new(breakerCollector)
+
+func newBreakerCollector() *breakerCollector {
+	c := &breakerCollector{
+		state: prometheus.NewDesc(
+			prometheus.BuildFQName(clientNamespace, "breaker", "state"),
+			"rpc client node circuit breaker state(0:closed,1:open,2:half-open).",
+			[]string{"service", "node"}, nil,
+		),
+		trips: prometheus.NewDesc(
+			prometheus.BuildFQName(clientNamespace, "breaker", "trips"),
+			"rpc client node circuit breaker trip count of the live selectors.",
+			[]string{"service", "node"}, nil,
+		),
+	}
+	prometheus.MustRegister(c)
+	return c
+}
+
+func (that *breakerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- that.state
+	ch <- that.trips
+}
+
+// Collect 多个选择器可能包含同一个节点，相同节点的状态取最大值，熔断次数累加
+//  熔断次数只统计存活的选择器，选择器关闭或者节点下线后会减少，所以使用 gauge 而不是 counter
+func (that *breakerCollector) Collect(ch chan<- prometheus.Metric) {
+	var (
+		stats  = selector.BreakerStats()
+		merged = make([]*selector.BreakerStat, 0, len(stats))
+	)
+	for _, stat := range stats {
+		//BreakerStats 按照服务名和节点地址排序，相同的节点是相邻的
+		if n := len(merged); n > 0 && merged[n-1].Service == stat.Service && merged[n-1].Node == stat.Node {
+			if stat.State > merged[n-1].State {
+				merged[n-1].State = stat.State
+			}
+			merged[n-1].Trips += stat.Trips
+			continue
+		}
+		cp := *stat
+		merged = append(merged, &cp)
+	}
+	for _, stat := range merged {
+		ch <- prometheus.MustNewConstMetric(that.state, prometheus.GaugeValue, float64(stat.State), stat.Service, stat.Node)
+		ch <- prometheus.MustNewConstMetric(that.trips, prometheus.GaugeValue, float64(stat.Trips), stat.Service, stat.Node)
+	}
+}
@@ -0,0 +1,27 @@
+// Package clientmetrics 把 RpcClient 的统计数据导出到 prometheus，抓取指标时从客户端中读取
+//  使用客户端的进程需要显式调用 Register 注册，只使用服务端的进程不会引入客户端
+package clientmetrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+)
+
+var clientNamespace = "rpc_client"
+
+var (
+	registerOnce sync.Once
+	registerErr  error
+)
+
+// Register 把客户端的统计注册到 prometheus 默认的注册器，多次调用只注册一次
+func Register() error {
+	registerOnce.Do(func() {
+		for _, c := range []prometheus.Collector{newHedgeCollector(), newPoolCollector()} {
+			if registerErr = prometheus.Register(c); registerErr != nil {
+				return
+			}
+		}
+	})
+	return registerErr
+}
@@ -0,0 +1,19 @@
+package clientmetrics
+
+import (
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/prometheus/client_golang/prometheus"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		t.AssertNil(Register())
+		t.AssertNil(Register())
+		// 已经注册过的收集器不能重复注册
+		for _, c := range []prometheus.Collector{newHedgeCollector(), newPoolCollector()} {
+			_, ok := prometheus.Register(c).(prometheus.AlreadyRegisteredError)
+			t.Assert(ok, true)
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package clientmetrics
+
+import (
+	"github.com/osgochina/dmicro/client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 客户端对冲请求的统计，进程内累计，不会因为客户端关闭而减少
+type hedgeCollector struct {
+	requests *prometheus.Desc
+	hedges   *prometheus.Desc
+	wins     *prometheus.Desc
+}
+
+var _ prometheus.Collector = new(hedgeCollector)
+
+func newHedgeCollector() *hedgeCollector {
+	labels := []string{"service", "path"}
+	return &hedgeCollector{
+		requests: prometheus.NewDesc(
+			prometheus.BuildFQName(clientNamespace, "hedge", "requests_total"),
+			"rpc client hedging enabled call count.",
+			labels, nil,
+		),
+		hedges: prometheus.NewDesc(
+			prometheus.BuildFQName(clientNamespace, "hedge", "hedges_total"),
+			"rpc client hedged call count.",
+			labels, nil,
+		),
+		wins: prometheus.NewDesc(
+			prometheus.BuildFQName(clientNamespace, "hedge", "wins_total"),
+			"rpc client hedged call won count.",
+			labels, nil,
+		),
+	}
+}
+
+func (that *hedgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- that.requests
+	ch <- that.hedges
+	ch <- that.wins
+}
+
+func (that *hedgeCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, stat := range client.HedgeStats() {
+		ch <- prometheus.MustNewConstMetric(that.requests, prometheus.CounterValue, float64(stat.Requests), stat.Service, stat.ServiceMethod)
+		ch <- prometheus.MustNewConstMetric(that.hedges, prometheus.CounterValue, float64(stat.Hedges), stat.Service, stat.ServiceMethod)
+		ch <- prometheus.MustNewConstMetric(that.wins, prometheus.CounterValue, float64(stat.HedgeWins), stat.Service, stat.ServiceMethod)
+	}
+}
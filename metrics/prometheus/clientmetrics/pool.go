@@ -0,0 +1,75 @@
+package clientmetrics
+
+import (
+	"github.com/osgochina/dmicro/client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 客户端节点连接池的统计，只统计存活的客户端，客户端关闭后会减少，所以都使用 gauge
+type poolCollector struct {
+	sessions   *prometheus.Desc
+	inflight   *prometheus.Desc
+	dials      *prometheus.Desc
+	idleClosed *prometheus.Desc
+}
+
+var _ prometheus.Collector = new(poolCollector)
+
+func newPoolCollector() *poolCollector {
+	labels := []string{"service", "node"}
+	return &poolCollector{
+		sessions: prometheus.NewDesc(
+			prometheus.BuildFQName(clientNamespace, "pool", "sessions"),
+			"rpc client node pool session count.",
+			labels, nil,
+		),
+		inflight: prometheus.NewDesc(
+			prometheus.BuildFQName(clientNamespace, "pool", "inflight"),
+			"rpc client node pool inflight call count.",
+			labels, nil,
+		),
+		dials: prometheus.NewDesc(
+			prometheus.BuildFQName(clientNamespace, "pool", "dials"),
+			"rpc client node pool dial count of the live clients.",
+			labels, nil,
+		),
+		idleClosed: prometheus.NewDesc(
+			prometheus.BuildFQName(clientNamespace, "pool", "idle_closed"),
+			"rpc client node pool idle session closed count of the live clients.",
+			labels, nil,
+		),
+	}
+}
+
+func (that *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- that.sessions
+	ch <- that.inflight
+	ch <- that.dials
+	ch <- that.idleClosed
+}
+
+// Collect 多个客户端可能请求同一个节点，相同节点的统计累加
+func (that *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	var (
+		stats  = client.PoolStats()
+		merged = make([]*client.PoolStat, 0, len(stats))
+	)
+	for _, stat := range stats {
+		//PoolStats 按照服务名和节点地址排序，相同的节点是相邻的
+		if n := len(merged); n > 0 && merged[n-1].Service == stat.Service && merged[n-1].Node == stat.Node {
+			merged[n-1].Sessions += stat.Sessions
+			merged[n-1].Inflight += stat.Inflight
+			merged[n-1].Dials += stat.Dials
+			merged[n-1].IdleClosed += stat.IdleClosed
+			continue
+		}
+		cp := *stat
+		merged = append(merged, &cp)
+	}
+	for _, stat := range merged {
+		ch <- prometheus.MustNewConstMetric(that.sessions, prometheus.GaugeValue, float64(stat.Sessions), stat.Service, stat.Node)
+		ch <- prometheus.MustNewConstMetric(that.inflight, prometheus.GaugeValue, float64(stat.Inflight), stat.Service, stat.Node)
+		ch <- prometheus.MustNewConstMetric(that.dials, prometheus.GaugeValue, float64(stat.Dials), stat.Service, stat.Node)
+		ch <- prometheus.MustNewConstMetric(that.idleClosed, prometheus.GaugeValue, float64(stat.IdleClosed), stat.Service, stat.Node)
+	}
+}
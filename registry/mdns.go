@@ -36,6 +36,7 @@ type mdnsEntry struct {
 type mdnsRegistry struct {
 	sync.Mutex
 	opts   Options
+	optsMu sync.RWMutex // 服务端的租约协程读取配置时，客户端可能同时初始化同一个注册中心
 	domain string
 
 	services map[string][]*mdnsEntry
@@ -80,6 +81,8 @@ func newRegistry(opts ...Option) Registry {
 }
 
 func (that *mdnsRegistry) Init(opts ...Option) error {
+	that.optsMu.Lock()
+	defer that.optsMu.Unlock()
 	for _, o := range opts {
 		o(&that.opts)
 	}
@@ -87,6 +90,8 @@ func (that *mdnsRegistry) Init(opts ...Option) error {
 }
 
 func (that *mdnsRegistry) Options() Options {
+	that.optsMu.RLock()
+	defer that.optsMu.RUnlock()
 	return that.opts
 }
 
@@ -208,7 +213,7 @@ func (that *mdnsRegistry) GetService(service string, _ ...GetOption) ([]*Service
 	p := mdns.DefaultParams(service)
 	// 设置查询时间，因为是查询是异步执行的，所以实际查询时间就是设置的超时时间
 	var cancel context.CancelFunc
-	p.Context, cancel = context.WithTimeout(context.Background(), that.opts.Timeout)
+	p.Context, cancel = context.WithTimeout(context.Background(), that.Options().Timeout)
 	defer cancel()
 
 	// set entries channel
@@ -292,7 +297,7 @@ func (that *mdnsRegistry) ListServices(_ ...ListOption) ([]*Service, error) {
 	p := mdns.DefaultParams("_services")
 	// set context with timeout
 	var cancel context.CancelFunc
-	p.Context, cancel = context.WithTimeout(context.Background(), that.opts.Timeout)
+	p.Context, cancel = context.WithTimeout(context.Background(), that.Options().Timeout)
 	defer cancel()
 	// set entries channel
 	p.Entries = entries
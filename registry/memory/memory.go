@@ -52,11 +52,11 @@ func NewRegistry(opts ...registry.Option) registry.Registry {
 
 // Init 初始化配置
 func (that *memRegistry) Init(opts ...registry.Option) error {
+	that.Lock()
+	defer that.Unlock()
 	for _, o := range opts {
 		o(&that.options)
 	}
-	that.Lock()
-	defer that.Unlock()
 
 	records := getServiceRecords(that.options.Context)
 
@@ -80,6 +80,8 @@ func (that *memRegistry) Init(opts ...registry.Option) error {
 
 // Options 获取配置
 func (that *memRegistry) Options() registry.Options {
+	that.RLock()
+	defer that.RUnlock()
 	return that.options
 }
 
@@ -123,6 +125,7 @@ func (that *memRegistry) Register(s *registry.Service, opts ...registry.Register
 					Id:       n.Id,
 					Address:  n.Address,
 					Metadata: metadata,
+					Paths:    copyPaths(n.Paths),
 				},
 				TTL:      options.TTL,
 				LastSeen: time.Now(),
@@ -136,11 +139,23 @@ func (that *memRegistry) Register(s *registry.Service, opts ...registry.Register
 		return nil
 	}
 
-	// 刷新已存在的节点生存时间
+	// 刷新已存在的节点生存时间，节点的路径发生变化时更新并发送事件
+	updatedNodes := false
 	for _, n := range s.Nodes {
 		logger.Debugf(context.TODO(), "Updated registration for service: %s, version: %s", s.Name, s.Version)
-		that.records[s.Name][s.Version].Nodes[n.Id].TTL = options.TTL
-		that.records[s.Name][s.Version].Nodes[n.Id].LastSeen = time.Now()
+		rn := that.records[s.Name][s.Version].Nodes[n.Id]
+		rn.TTL = options.TTL
+		rn.LastSeen = time.Now()
+		if !equalPaths(rn.Paths, n.Paths) {
+			// 节点对象可能与注册方共享，复制之后再修改
+			cp := *rn.Node
+			cp.Paths = copyPaths(n.Paths)
+			rn.Node = &cp
+			updatedNodes = true
+		}
+	}
+	if updatedNodes {
+		go that.event(&registry.Result{Action: registry.Update, Service: s})
 	}
 	return nil
 }
@@ -61,7 +61,8 @@ func recordToService(r *record) *registry.Service {
 		nodes[i] = &registry.Node{
 			Id:       n.Id,
 			Address:  n.Address,
-			Metadata: metadata,
+			Metadata: md,
+			Paths:    copyPaths(n.Paths),
 		}
 		i++
 	}
@@ -73,6 +74,27 @@ func recordToService(r *record) *registry.Service {
 	}
 }
 
+// 复制节点的路径列表
+func copyPaths(paths []string) []string {
+	if paths == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(paths)), paths...)
+}
+
+// 路径列表是否相同
+func equalPaths(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 type ServicesKey struct{}
 
 // 通过上下文参数,获取服务的记录
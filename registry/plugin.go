@@ -21,10 +21,12 @@ type PluginRegistry struct {
 	mu             sync.RWMutex
 	serviceVersion string
 	serviceName    string
+	refresh        chan struct{} // 路由发生变化时通知续约协程重新注册
 	exit           chan bool
 }
 
 var _ drpc.AfterRegRouterPlugin = new(PluginRegistry)
+var _ drpc.AfterUnregRouterPlugin = new(PluginRegistry)
 var _ drpc.AfterListenPlugin = new(PluginRegistry)
 var _ drpc.BeforeCloseEndpointPlugin = new(PluginRegistry)
 
@@ -33,6 +35,7 @@ func NewRegistryPlugin(registry Registry) *PluginRegistry {
 	return &PluginRegistry{
 		registry: registry,
 		allApis:  garray.NewStrArray(),
+		refresh:  make(chan struct{}, 1),
 		exit:     make(chan bool),
 	}
 }
@@ -43,9 +46,40 @@ func (that *PluginRegistry) Name() string {
 
 func (that *PluginRegistry) AfterRegRouter(handler *drpc.Handler) error {
 	that.allApis.Append(handler.Name())
+	that.refreshPaths()
 	return nil
 }
 
+// AfterUnregRouter 路由被删除后，从注册中心的节点信息中移除该路径
+func (that *PluginRegistry) AfterUnregRouter(handler *drpc.Handler) error {
+	that.allApis.RemoveValue(handler.Name())
+	that.refreshPaths()
+	return nil
+}
+
+// 服务已经注册的情况下，运行期间路由发生变化时，更新注册中心的路径列表
+//  重新注册由续约协程串行执行，连续的变化合并成一次注册，保证注册中心最终是最新的路径列表
+func (that *PluginRegistry) refreshPaths() {
+	that.mu.Lock()
+	if !that.registered || that.service == nil {
+		that.mu.Unlock()
+		return
+	}
+	svr := *that.service
+	svr.Nodes = make([]*Node, 0, len(that.service.Nodes))
+	for _, n := range that.service.Nodes {
+		node := *n
+		node.Paths = that.allApis.Slice()
+		svr.Nodes = append(svr.Nodes, &node)
+	}
+	that.service = &svr
+	that.mu.Unlock()
+	select {
+	case that.refresh <- struct{}{}:
+	default:
+	}
+}
+
 // AfterListen 服务启动成功，监听成功后，进行服务注册
 func (that *PluginRegistry) AfterListen(addr net.Addr) (err error) {
 	that.addr = addr
@@ -64,6 +98,10 @@ func (that *PluginRegistry) AfterListen(addr net.Addr) (err error) {
 						logger.Error(context.TODO(), err)
 					}
 				}
+			case <-that.refresh:
+				if err := that.Register(); err != nil {
+					logger.Error(context.TODO(), err)
+				}
 			case <-that.exit:
 				t.Stop()
 				close(that.exit)
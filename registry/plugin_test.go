@@ -0,0 +1,78 @@
+package registry_test
+
+import (
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/osgochina/dmicro/drpc"
+	"github.com/osgochina/dmicro/registry"
+	"github.com/osgochina/dmicro/registry/memory"
+	"sort"
+	"testing"
+	"time"
+)
+
+type Math struct {
+	drpc.CallCtx
+}
+
+func (m *Math) Add(arg *[]int) (int, *drpc.Status) {
+	return (*arg)[0] + (*arg)[1], nil
+}
+
+func (m *Math) Sub(arg *[]int) (int, *drpc.Status) {
+	return (*arg)[0] - (*arg)[1], nil
+}
+
+func (m *Math) Mul(arg *[]int) (int, *drpc.Status) {
+	return (*arg)[0] * (*arg)[1], nil
+}
+
+func Div(ctx drpc.CallCtx, arg *[]int) (int, *drpc.Status) {
+	return (*arg)[0] / (*arg)[1], nil
+}
+
+// 等待注册中心中节点的路径列表变成期望的值
+func waitPaths(reg registry.Registry, want ...string) []string {
+	sort.Strings(want)
+	var paths []string
+	for i := 0; i < 30; i++ {
+		services, err := reg.GetService("math")
+		if err == nil && len(services) > 0 && len(services[0].Nodes) > 0 {
+			paths = append([]string(nil), services[0].Nodes[0].Paths...)
+			sort.Strings(paths)
+			if len(paths) == len(want) {
+				equal := true
+				for j := range paths {
+					equal = equal && paths[j] == want[j]
+				}
+				if equal {
+					return paths
+				}
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return paths
+}
+
+// 运行期间路由发生变化，注册中心的路径列表跟着更新
+func TestPluginRegistryRefreshPaths(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		reg := memory.NewRegistry(
+			registry.OptServiceName("math"),
+			registry.OptServiceVersion("1.0.0"),
+		)
+		srv := drpc.NewEndpoint(drpc.EndpointConfig{ListenPort: 9264}, registry.NewRegistryPlugin(reg))
+		srv.RouteCall(new(Math))
+		go srv.ListenAndServe()
+		defer srv.Close()
+		time.Sleep(time.Second)
+
+		t.Assert(waitPaths(reg, "/math/add", "/math/sub", "/math/mul"), []string{"/math/add", "/math/mul", "/math/sub"})
+
+		// 连续的变化按照顺序生效，最终是最新的路径列表
+		t.Assert(srv.RemoveRoute("/math/sub"), true)
+		t.Assert(srv.RouteCallFunc(Div), "/div")
+		t.Assert(srv.RemoveRoute("/math/mul"), true)
+		t.Assert(waitPaths(reg, "/math/add", "/div"), []string{"/div", "/math/add"})
+	})
+}
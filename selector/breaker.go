@@ -0,0 +1,301 @@
+package selector
+
+import (
+	"github.com/gogf/gf/v2/container/gmap"
+	"github.com/osgochina/dmicro/registry"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BreakerState 熔断器的状态
+type BreakerState int
+
+const (
+	// BreakerClosed 关闭状态，节点正常提供服务
+	BreakerClosed BreakerState = iota
+	// BreakerOpen 打开状态，节点被熔断，不会被选中
+	BreakerOpen
+	// BreakerHalfOpen 半开状态，冷却时间结束后允许少量的探测请求通过，成功则关闭，失败则重新打开
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerOptions 熔断器的配置
+type BreakerOptions struct {
+	// ConsecutiveFailures 连续失败多少次后熔断，小于等于0表示不按照连续失败次数熔断
+	ConsecutiveFailures int
+	// ErrorRate 统计窗口内的错误率达到多少后熔断，取值 (0,1]，小于等于0表示不按照错误率熔断
+	ErrorRate float64
+	// MinRequests 统计窗口内的请求数达到多少后才按照错误率熔断
+	MinRequests int
+	// Window 统计错误率的时间窗口，窗口结束后重新计数
+	Window time.Duration
+	// CoolDown 熔断后经过多长时间进入半开状态
+	CoolDown time.Duration
+	// HalfOpenProbes 半开状态下同时进行的探测请求数，达到后节点不会被选中，直到探测请求的结果返回
+	HalfOpenProbes int
+}
+
+// DefaultBreakerOptions 默认的熔断器配置
+var DefaultBreakerOptions = BreakerOptions{
+	ConsecutiveFailures: 5,
+	ErrorRate:           0.5,
+	MinRequests:         20,
+	Window:              10 * time.Second,
+	CoolDown:            5 * time.Second,
+	HalfOpenProbes:      1,
+}
+
+// BreakerStat 节点熔断器的状态快照
+type BreakerStat struct {
+	Service             string       `json:"service"`
+	Node                string       `json:"node"`
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	Requests            int          `json:"requests"`
+	Failures            int          `json:"failures"`
+	Trips               int64        `json:"trips"`
+	OpenedAt            time.Time    `json:"opened_at"`
+}
+
+// Breaker 按照节点熔断的熔断器，节点使用服务名和节点地址标识
+type Breaker struct {
+	opts  BreakerOptions
+	mu    sync.Mutex
+	nodes map[string]map[string]*nodeBreaker
+	now   func() time.Time
+}
+
+// 单个节点的熔断状态
+type nodeBreaker struct {
+	state       BreakerState
+	consecutive int
+	requests    int
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+	trips       int64
+	probes      int       // 半开状态下进行中的探测请求数
+	probedAt    time.Time // 最后一个探测请求开始的时间
+}
+
+// 所有启用的熔断器，用于导出监控指标和调试信息
+var breakers = gmap.NewAnyAnyMap(true)
+
+// NewBreaker 创建熔断器，未设置的配置项使用 DefaultBreakerOptions 中的值
+func NewBreaker(opts BreakerOptions) *Breaker {
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = DefaultBreakerOptions.MinRequests
+	}
+	if opts.Window <= 0 {
+		opts.Window = DefaultBreakerOptions.Window
+	}
+	if opts.CoolDown <= 0 {
+		opts.CoolDown = DefaultBreakerOptions.CoolDown
+	}
+	if opts.HalfOpenProbes <= 0 {
+		opts.HalfOpenProbes = DefaultBreakerOptions.HalfOpenProbes
+	}
+	return &Breaker{
+		opts:  opts,
+		nodes: make(map[string]map[string]*nodeBreaker),
+		now:   time.Now,
+	}
+}
+
+// Options 获取配置
+func (that *Breaker) Options() BreakerOptions {
+	return that.opts
+}
+
+// Allow 判断节点是否可用，打开状态的节点冷却时间结束后进入半开状态
+//  半开状态下进行中的探测请求数达到 HalfOpenProbes 时不可用
+func (that *Breaker) Allow(service string, node *registry.Node) bool {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	nb, ok := that.nodes[service][node.Address]
+	if !ok {
+		return true
+	}
+	now := that.now()
+	if nb.state == BreakerOpen && now.Sub(nb.openedAt) >= that.opts.CoolDown {
+		nb.state = BreakerHalfOpen
+		nb.probes = 0
+	}
+	switch nb.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		//探测请求一直没有结果时，超过冷却时间后重新探测
+		if nb.probes > 0 && now.Sub(nb.probedAt) >= that.opts.CoolDown {
+			nb.probes = 0
+		}
+		return nb.probes < that.opts.HalfOpenProbes
+	}
+	return true
+}
+
+// Acquire 开始请求节点，半开状态下占用一个探测请求的名额，直到 Mark 记录结果
+func (that *Breaker) Acquire(service string, node *registry.Node) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	if nb, ok := that.nodes[service][node.Address]; ok && nb.state == BreakerHalfOpen {
+		nb.probes++
+		nb.probedAt = that.now()
+	}
+}
+
+// Mark 记录节点的调用结果，err 为 nil 表示调用成功
+func (that *Breaker) Mark(service string, node *registry.Node, err error) {
+	if node == nil {
+		return
+	}
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	nodes, ok := that.nodes[service]
+	if !ok {
+		nodes = make(map[string]*nodeBreaker)
+		that.nodes[service] = nodes
+	}
+	nb, ok := nodes[node.Address]
+	if !ok {
+		nb = &nodeBreaker{windowStart: that.now()}
+		nodes[node.Address] = nb
+	}
+	now := that.now()
+	if now.Sub(nb.windowStart) >= that.opts.Window {
+		nb.windowStart = now
+		nb.requests = 0
+		nb.failures = 0
+	}
+	nb.requests++
+	if err == nil {
+		nb.consecutive = 0
+		if nb.state != BreakerClosed {
+			that.close(nb, now)
+		}
+		return
+	}
+	nb.failures++
+	nb.consecutive++
+	switch nb.state {
+	case BreakerHalfOpen:
+		//半开状态下失败，重新打开
+		that.open(nb, now)
+	case BreakerClosed:
+		if that.opts.ConsecutiveFailures > 0 && nb.consecutive >= that.opts.ConsecutiveFailures {
+			that.open(nb, now)
+			return
+		}
+		if that.opts.ErrorRate > 0 && nb.requests >= that.opts.MinRequests &&
+			float64(nb.failures)/float64(nb.requests) >= that.opts.ErrorRate {
+			that.open(nb, now)
+		}
+	}
+}
+
+func (that *Breaker) open(nb *nodeBreaker, now time.Time) {
+	nb.state = BreakerOpen
+	nb.probes = 0
+	nb.openedAt = now
+	nb.trips++
+}
+
+func (that *Breaker) close(nb *nodeBreaker, now time.Time) {
+	nb.state = BreakerClosed
+	nb.probes = 0
+	nb.consecutive = 0
+	nb.windowStart = now
+	nb.requests = 0
+	nb.failures = 0
+}
+
+// Reset 清除服务所有节点的熔断状态
+func (that *Breaker) Reset(service string) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	delete(that.nodes, service)
+}
+
+// Filter 返回过滤掉被熔断节点的过滤器
+func (that *Breaker) Filter(service string) Filter {
+	return func(services []*registry.Service) []*registry.Service {
+		filtered := make([]*registry.Service, 0, len(services))
+		for _, s := range services {
+			nodes := make([]*registry.Node, 0, len(s.Nodes))
+			for _, node := range s.Nodes {
+				if that.Allow(service, node) {
+					nodes = append(nodes, node)
+				}
+			}
+			if len(nodes) == 0 {
+				continue
+			}
+			//不修改缓存中的服务信息
+			cp := *s
+			cp.Nodes = nodes
+			filtered = append(filtered, &cp)
+		}
+		return filtered
+	}
+}
+
+// Stats 获取所有节点熔断器的状态快照，按照服务名和节点地址排序
+func (that *Breaker) Stats() []*BreakerStat {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	stats := make([]*BreakerStat, 0)
+	for service, nodes := range that.nodes {
+		for addr, nb := range nodes {
+			state := nb.state
+			if state == BreakerOpen && that.now().Sub(nb.openedAt) >= that.opts.CoolDown {
+				state = BreakerHalfOpen
+			}
+			stats = append(stats, &BreakerStat{
+				Service:             service,
+				Node:                addr,
+				State:               state,
+				ConsecutiveFailures: nb.consecutive,
+				Requests:            nb.requests,
+				Failures:            nb.failures,
+				Trips:               nb.trips,
+				OpenedAt:            nb.openedAt,
+			})
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Service != stats[j].Service {
+			return stats[i].Service < stats[j].Service
+		}
+		return stats[i].Node < stats[j].Node
+	})
+	return stats
+}
+
+// BreakerStats 获取当前进程中所有选择器的熔断器状态
+func BreakerStats() []*BreakerStat {
+	var stats []*BreakerStat
+	breakers.Iterator(func(k interface{}, _ interface{}) bool {
+		stats = append(stats, k.(*Breaker).Stats()...)
+		return true
+	})
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Service != stats[j].Service {
+			return stats[i].Service < stats[j].Service
+		}
+		return stats[i].Node < stats[j].Node
+	})
+	return stats
+}
@@ -0,0 +1,156 @@
+package selector
+
+import (
+	"errors"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/osgochina/dmicro/registry"
+	"github.com/osgochina/dmicro/registry/memory"
+	"testing"
+	"time"
+)
+
+func newTestBreaker(opts BreakerOptions) (*Breaker, *time.Time) {
+	b := NewBreaker(opts)
+	now := time.Now()
+	b.now = func() time.Time {
+		return now
+	}
+	return b, &now
+}
+
+func TestBreakerConsecutiveFailures(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		b, now := newTestBreaker(BreakerOptions{ConsecutiveFailures: 3, CoolDown: time.Second})
+		node := &registry.Node{Address: "127.0.0.1:9001"}
+		errFail := errors.New("fail")
+
+		b.Mark("svc", node, errFail)
+		b.Mark("svc", node, errFail)
+		// 成功后重新计数
+		b.Mark("svc", node, nil)
+		b.Mark("svc", node, errFail)
+		b.Mark("svc", node, errFail)
+		t.Assert(b.Allow("svc", node), true)
+		b.Mark("svc", node, errFail)
+		t.Assert(b.Allow("svc", node), false)
+		t.Assert(b.Stats()[0].State, BreakerOpen)
+		t.Assert(b.Stats()[0].Trips, 1)
+
+		// 冷却时间结束后进入半开状态，失败则重新打开
+		*now = now.Add(time.Second)
+		t.Assert(b.Allow("svc", node), true)
+		t.Assert(b.Stats()[0].State, BreakerHalfOpen)
+		b.Mark("svc", node, errFail)
+		t.Assert(b.Allow("svc", node), false)
+		t.Assert(b.Stats()[0].Trips, 2)
+
+		// 半开状态下成功则关闭
+		*now = now.Add(time.Second)
+		t.Assert(b.Allow("svc", node), true)
+		b.Mark("svc", node, nil)
+		t.Assert(b.Stats()[0].State, BreakerClosed)
+
+		b.Reset("svc")
+		t.Assert(len(b.Stats()), 0)
+	})
+}
+
+func TestBreakerErrorRate(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		b, now := newTestBreaker(BreakerOptions{ErrorRate: 0.5, MinRequests: 4, Window: time.Second})
+		node := &registry.Node{Address: "127.0.0.1:9001"}
+		errFail := errors.New("fail")
+
+		b.Mark("svc", node, errFail)
+		b.Mark("svc", node, nil)
+		b.Mark("svc", node, errFail)
+		t.Assert(b.Allow("svc", node), true)
+		// 窗口结束后重新计数
+		*now = now.Add(time.Second)
+		b.Mark("svc", node, nil)
+		b.Mark("svc", node, errFail)
+		b.Mark("svc", node, nil)
+		t.Assert(b.Allow("svc", node), true)
+		b.Mark("svc", node, errFail)
+		t.Assert(b.Allow("svc", node), false)
+	})
+}
+
+// 半开状态下只允许 HalfOpenProbes 个探测请求
+func TestBreakerHalfOpenProbes(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		b, now := newTestBreaker(BreakerOptions{ConsecutiveFailures: 1, CoolDown: time.Second, HalfOpenProbes: 2})
+		node := &registry.Node{Address: "127.0.0.1:9001"}
+		b.Mark("svc", node, errors.New("fail"))
+		t.Assert(b.Allow("svc", node), false)
+
+		*now = now.Add(time.Second)
+		t.Assert(b.Allow("svc", node), true)
+		b.Acquire("svc", node)
+		t.Assert(b.Allow("svc", node), true)
+		b.Acquire("svc", node)
+		t.Assert(b.Allow("svc", node), false)
+		t.Assert(b.Stats()[0].State, BreakerHalfOpen)
+
+		// 探测请求一直没有结果时，超过冷却时间后重新探测
+		*now = now.Add(time.Second)
+		t.Assert(b.Allow("svc", node), true)
+		b.Acquire("svc", node)
+
+		// 探测成功后关闭，不再限制请求数
+		b.Mark("svc", node, nil)
+		t.Assert(b.Stats()[0].State, BreakerClosed)
+		for i := 0; i < 3; i++ {
+			b.Acquire("svc", node)
+			t.Assert(b.Allow("svc", node), true)
+		}
+	})
+}
+
+func TestBreakerFilter(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		b, _ := newTestBreaker(BreakerOptions{ConsecutiveFailures: 1})
+		n1 := &registry.Node{Address: "127.0.0.1:9001"}
+		n2 := &registry.Node{Address: "127.0.0.1:9002"}
+		services := []*registry.Service{{Name: "svc", Nodes: []*registry.Node{n1, n2}}}
+		b.Mark("svc", n1, errors.New("fail"))
+
+		filtered := b.Filter("svc")(services)
+		t.Assert(len(filtered), 1)
+		t.Assert(len(filtered[0].Nodes), 1)
+		t.Assert(filtered[0].Nodes[0].Address, n2.Address)
+		// 不修改原有的服务信息
+		t.Assert(len(services[0].Nodes), 2)
+
+		b.Mark("svc", n2, errors.New("fail"))
+		t.Assert(len(b.Filter("svc")(services)), 0)
+	})
+}
+
+// 选择器默认不启用熔断，通过 OptBreaker 开启
+func TestSelectorBreakerOptIn(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		r := memory.NewRegistry()
+		node := &registry.Node{Id: "svc-1", Address: "127.0.0.1:9001"}
+		t.AssertNil(r.Register(&registry.Service{Name: "svc", Version: "v1", Nodes: []*registry.Node{node}}))
+
+		s := NewSelector(OptRegistry(r))
+		defer s.Close()
+		for i := 0; i < 10; i++ {
+			s.Mark("svc", node, errors.New("fail"))
+		}
+		_, err := s.Select("svc")
+		t.AssertNil(err)
+
+		s = NewSelector(OptRegistry(r), OptBreaker(BreakerOptions{ConsecutiveFailures: 3}))
+		defer s.Close()
+		for i := 0; i < 3; i++ {
+			s.Mark("svc", node, errors.New("fail"))
+		}
+		next, err := s.Select("svc")
+		if err == nil {
+			_, err = next()
+		}
+		t.AssertNE(err, nil)
+	})
+}
@@ -1,18 +1,25 @@
 package selector
 
 import (
+	"github.com/gogf/gf/v2/container/gmap"
 	"github.com/osgochina/dmicro/registry"
 	"github.com/osgochina/dmicro/registry/cache"
+	"reflect"
 	"time"
 )
 
+var _ Tracker = new(registrySelector)
+
 // 服务选择器
 type registrySelector struct {
-	so Options
-	rc cache.Cache
+	so       Options
+	rc       cache.Cache
+	breaker  *Breaker
+	counters *gmap.StrAnyMap // 轮询策略每个服务的计数器
+	loads    *loadTable      // P2C 策略使用的节点负载
 }
 
-// NewSelector 创建选择器
+// NewSelector 创建选择器，默认不启用节点熔断，需要时通过 OptBreaker 开启
 func NewSelector(opts ...Option) Selector {
 	sOpt := Options{
 		Strategy: Random,
@@ -26,9 +33,12 @@ func NewSelector(opts ...Option) Selector {
 		sOpt.Registry = registry.NewRegistry()
 	}
 	s := &registrySelector{
-		so: sOpt,
+		so:       sOpt,
+		counters: gmap.NewStrAnyMap(true),
+		loads:    newLoadTable(),
 	}
 	s.rc = s.newCache()
+	s.newBreaker()
 	return s
 }
 
@@ -39,6 +49,7 @@ func (that *registrySelector) Init(opts ...Option) error {
 	}
 	that.rc.Stop()
 	that.rc = that.newCache()
+	that.newBreaker()
 
 	return nil
 }
@@ -68,25 +79,70 @@ func (that *registrySelector) Select(service string, opts ...SelectOption) (Next
 	for _, filter := range sOpts.Filters {
 		services = filter(services)
 	}
+	// 过滤掉被熔断的节点
+	if that.breaker != nil {
+		services = that.breaker.Filter(service)(services)
+	}
 	// 没有可用的服务
 	if len(services) == 0 {
 		return nil, ErrNoneAvailable
 	}
 	// 选出可用的服务
-	return sOpts.Strategy(services), nil
+	return that.bind(service, sOpts.Strategy)(services), nil
+}
+
+// 轮询以及 P2C 策略的函数地址，用来识别需要绑定选择器状态的策略
+var (
+	roundRobinPointer = reflect.ValueOf(RoundRobin).Pointer()
+	p2cPointer        = reflect.ValueOf(P2C).Pointer()
+)
+
+// 需要保存状态的策略使用选择器中的状态，每个服务相互独立
+func (that *registrySelector) bind(service string, strategy Strategy) Strategy {
+	switch reflect.ValueOf(strategy).Pointer() {
+	case roundRobinPointer:
+		counter := that.counters.GetOrSetFuncLock(service, func() interface{} {
+			return new(uint64)
+		}).(*uint64)
+		return func(services []*registry.Service) Next {
+			return roundRobin(services, counter)
+		}
+	case p2cPointer:
+		return func(services []*registry.Service) Next {
+			return p2c(services, that.loads)
+		}
+	}
+	return strategy
 }
 
-// Mark 设置针对节点的成功或错误
-func (that *registrySelector) Mark(_ string, _ *registry.Node, _ error) {
+// Mark 设置针对节点的成功或错误，err 为 nil 表示成功，熔断器根据结果决定是否熔断节点
+func (that *registrySelector) Mark(service string, node *registry.Node, err error) {
+	if that.breaker != nil {
+		that.breaker.Mark(service, node, err)
+	}
 }
 
 // Reset 重置服务的状态
-func (that *registrySelector) Reset(_ string) {
+func (that *registrySelector) Reset(service string) {
+	if that.breaker != nil {
+		that.breaker.Reset(service)
+	}
+}
+
+// Begin 开始请求节点，记录节点的请求耗时和进行中的请求数，熔断器半开时占用探测请求的名额
+func (that *registrySelector) Begin(service string, node *registry.Node) func() {
+	if that.breaker != nil {
+		that.breaker.Acquire(service, node)
+	}
+	return that.loads.get(node).begin()
 }
 
 // Close 关闭选择器
 func (that *registrySelector) Close() error {
 	that.rc.Stop()
+	if that.breaker != nil {
+		breakers.Remove(that.breaker)
+	}
 	return nil
 }
 
@@ -95,6 +151,19 @@ func (that *registrySelector) String() string {
 	return "selector"
 }
 
+// 根据配置创建熔断器，替换掉原有的熔断器
+func (that *registrySelector) newBreaker() {
+	if that.breaker != nil {
+		breakers.Remove(that.breaker)
+		that.breaker = nil
+	}
+	if that.so.Breaker == nil {
+		return
+	}
+	that.breaker = NewBreaker(*that.so.Breaker)
+	breakers.Set(that.breaker, nil)
+}
+
 // 创建服务注册信息缓存
 func (that *registrySelector) newCache() cache.Cache {
 	opts := make([]cache.Option, 0, 1)
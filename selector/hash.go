@@ -0,0 +1,147 @@
+package selector
+
+import (
+	"github.com/gogf/gf/v2/container/gmap"
+	"github.com/osgochina/dmicro/registry"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HashReplicas 一致性哈希中每个节点对应的虚拟节点数，越多分布越均匀
+const HashReplicas = 160
+
+// 每个服务最多缓存的哈希环数
+//  路由规则、熔断以及重试排除的节点会使用不同的节点子集，每个子集对应一个哈希环
+const hashRingsPerService = 8
+
+// 每个服务的哈希环缓存
+var hashRings = gmap.NewStrAnyMap(true)
+
+// 服务的哈希环缓存，按照节点列表的签名区分，超过上限时淘汰最早加入的哈希环
+type hashRingCache struct {
+	mu    sync.Mutex
+	rings map[string]*hashRing
+	order []string
+}
+
+// 获取签名对应的哈希环，不存在时创建
+func (that *hashRingCache) get(signature string, nodes []*registry.Node) *hashRing {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	if ring, ok := that.rings[signature]; ok {
+		return ring
+	}
+	if len(that.order) >= hashRingsPerService {
+		delete(that.rings, that.order[0])
+		that.order = that.order[1:]
+	}
+	ring := newHashRing(nodes)
+	ring.signature = signature
+	that.rings[signature] = ring
+	that.order = append(that.order, signature)
+	return ring
+}
+
+// 一致性哈希环
+type hashRing struct {
+	// 节点列表的签名
+	signature string
+	// 排序后的虚拟节点哈希值
+	hashes []uint32
+	// 虚拟节点对应的节点
+	nodes map[uint32]*registry.Node
+	// 去重后的节点数
+	count int
+}
+
+// ConsistentHash 返回使用一致性哈希选择节点的策略，相同的 key 总是选中相同的节点
+//  节点加入或者离开时，只有原本映射到变化节点上的 key 会重新映射
+//  多次调用 Next 时沿着哈希环依次返回其他节点，可以用于重试
+func ConsistentHash(key string) Strategy {
+	return func(services []*registry.Service) Next {
+		ring := getHashRing(services)
+		h := hashKey(key)
+		idx := sort.Search(len(ring.hashes), func(i int) bool {
+			return ring.hashes[i] >= h
+		})
+		seen := make(map[string]bool, ring.count)
+
+		return func() (*registry.Node, error) {
+			if ring.count == 0 {
+				return nil, ErrNoneAvailable
+			}
+			//所有节点都返回过以后，重新开始
+			if len(seen) == ring.count {
+				seen = make(map[string]bool, ring.count)
+			}
+			for i := 0; i < len(ring.hashes); i++ {
+				node := ring.nodes[ring.hashes[idx%len(ring.hashes)]]
+				idx++
+				if !seen[node.Address] {
+					seen[node.Address] = true
+					return node, nil
+				}
+			}
+			seen = make(map[string]bool, ring.count)
+			return ring.nodes[ring.hashes[idx%len(ring.hashes)]], nil
+		}
+	}
+}
+
+// 获取服务对应的哈希环
+func getHashRing(services []*registry.Service) *hashRing {
+	nodes := getNodes(services)
+	addrs := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		addrs = append(addrs, node.Address)
+	}
+	sort.Strings(addrs)
+	signature := strings.Join(addrs, ",")
+	var name string
+	if len(services) > 0 {
+		name = services[0].Name
+	}
+	cache := hashRings.GetOrSetFuncLock(name, func() interface{} {
+		return &hashRingCache{rings: make(map[string]*hashRing)}
+	}).(*hashRingCache)
+	return cache.get(signature, nodes)
+}
+
+// 创建哈希环，虚拟节点的位置只与节点地址有关，与节点的顺序无关
+func newHashRing(nodes []*registry.Node) *hashRing {
+	ring := &hashRing{
+		hashes: make([]uint32, 0, len(nodes)*HashReplicas),
+		nodes:  make(map[uint32]*registry.Node, len(nodes)*HashReplicas),
+	}
+	added := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		if added[node.Address] {
+			continue
+		}
+		added[node.Address] = true
+		ring.count++
+		for i := 0; i < HashReplicas; i++ {
+			h := hashKey(node.Address + "#" + strconv.Itoa(i))
+			//哈希冲突时保留地址较小的节点，保证结果与节点顺序无关
+			if exist, ok := ring.nodes[h]; ok {
+				if exist.Address < node.Address {
+					continue
+				}
+			} else {
+				ring.hashes = append(ring.hashes, h)
+			}
+			ring.nodes[h] = node
+		}
+	}
+	sort.Slice(ring.hashes, func(i, j int) bool {
+		return ring.hashes[i] < ring.hashes[j]
+	})
+	return ring
+}
+
+func hashKey(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
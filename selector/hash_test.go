@@ -0,0 +1,97 @@
+package selector
+
+import (
+	"fmt"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/osgochina/dmicro/registry"
+	"testing"
+)
+
+func newHashTestServices(n int) []*registry.Service {
+	nodes := make([]*registry.Node, 0, n)
+	for i := 0; i < n; i++ {
+		nodes = append(nodes, &registry.Node{Address: fmt.Sprintf("127.0.0.1:%d", 9001+i)})
+	}
+	return []*registry.Service{{Name: "hash", Nodes: nodes}}
+}
+
+func hashSelect(t *gtest.T, services []*registry.Service, key string) string {
+	node, err := ConsistentHash(key)(services)()
+	t.AssertNil(err)
+	return node.Address
+}
+
+func TestConsistentHash(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		services := newHashTestServices(5)
+		before := make(map[string]string)
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("user-%d", i)
+			before[key] = hashSelect(t, services, key)
+			// 相同的key总是选中相同的节点
+			t.Assert(hashSelect(t, services, key), before[key])
+		}
+
+		// 增加节点时，只有映射到新节点上的key发生变化
+		grown := newHashTestServices(6)
+		moved := 0
+		for key, addr := range before {
+			after := hashSelect(t, grown, key)
+			if after != addr {
+				t.Assert(after, "127.0.0.1:9006")
+				moved++
+			}
+		}
+		t.AssertGT(moved, 0)
+		t.AssertLT(moved, 300)
+
+		// 删除节点时，只有原本映射到该节点上的key发生变化
+		shrunk := []*registry.Service{{Name: "hash", Nodes: services[0].Nodes[1:]}}
+		for key, addr := range before {
+			if addr != "127.0.0.1:9001" {
+				t.Assert(hashSelect(t, shrunk, key), addr)
+			}
+		}
+	})
+}
+
+func TestConsistentHashNext(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		services := newHashTestServices(3)
+		next := ConsistentHash("user-1")(services)
+		seen := make(map[string]bool)
+		for i := 0; i < 3; i++ {
+			node, err := next()
+			t.AssertNil(err)
+			seen[node.Address] = true
+		}
+		// 重试时依次返回不同的节点
+		t.Assert(len(seen), 3)
+
+		_, err := ConsistentHash("user-1")([]*registry.Service{{Name: "empty"}})()
+		t.Assert(err, ErrNoneAvailable)
+	})
+}
+
+func TestConsistentHashSubsets(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		newServices := func(n int) []*registry.Service {
+			services := newHashTestServices(n)
+			services[0].Name = "hash-subsets"
+			return services
+		}
+		services := newServices(4)
+		subset := []*registry.Service{{Name: "hash-subsets", Nodes: services[0].Nodes[1:]}}
+		all, part := getHashRing(services), getHashRing(subset)
+		// 不同的节点子集交替使用时复用各自的哈希环
+		for i := 0; i < 3; i++ {
+			t.Assert(getHashRing(services) == all, true)
+			t.Assert(getHashRing(subset) == part, true)
+		}
+		// 超过上限时淘汰最早加入的哈希环
+		for i := 0; i < hashRingsPerService; i++ {
+			getHashRing(newServices(5 + i))
+		}
+		t.Assert(getHashRing(services) == all, false)
+	})
+}
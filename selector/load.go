@@ -0,0 +1,152 @@
+package selector
+
+import (
+	"github.com/gogf/gf/v2/container/gmap"
+	"github.com/osgochina/dmicro/registry"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Tracker 记录节点的请求耗时和进行中的请求数，P2C 策略根据它们选择节点
+//  registrySelector 实现了该接口，自定义的选择器可以选择实现
+type Tracker interface {
+	// Begin 开始请求节点时调用，返回的函数在请求结束时调用
+	Begin(service string, node *registry.Node) (done func())
+}
+
+// 请求耗时的衰减时间，时间越久的耗时对EWMA的影响越小
+const loadDecayTime = 10 * time.Second
+
+// 节点的负载记录超过该时间没有使用时被删除，避免节点变化后负载记录一直增长
+const loadIdleTimeout = 10 * time.Minute
+
+// 选择器中所有节点的负载，key为节点地址
+type loadTable struct {
+	loads *gmap.StrAnyMap
+	swept int64 // 上次清理的时间
+}
+
+func newLoadTable() *loadTable {
+	return &loadTable{
+		loads: gmap.NewStrAnyMap(true),
+		swept: time.Now().UnixNano(),
+	}
+}
+
+// 获取节点的负载，不存在时创建
+func (that *loadTable) get(node *registry.Node) *nodeLoad {
+	that.sweep(time.Now())
+	return that.loads.GetOrSetFuncLock(node.Address, func() interface{} {
+		return new(nodeLoad)
+	}).(*nodeLoad)
+}
+
+// 获取节点的负载，不存在时返回nil
+func (that *loadTable) peek(node *registry.Node) *nodeLoad {
+	if v := that.loads.Get(node.Address); v != nil {
+		return v.(*nodeLoad)
+	}
+	return nil
+}
+
+// 节点的负载分数，没有负载记录的节点使用 defaultCost
+func (that *loadTable) score(node *registry.Node, defaultCost float64) float64 {
+	if load := that.peek(node); load != nil {
+		return load.score(defaultCost)
+	}
+	return defaultCost
+}
+
+// 没有请求记录的节点使用的请求耗时，为其他节点请求耗时的平均值，所有节点都没有记录时为1
+func (that *loadTable) defaultCost(nodes []*registry.Node) float64 {
+	var (
+		sum   float64
+		count int
+	)
+	for _, node := range nodes {
+		if load := that.peek(node); load != nil {
+			if ewma, ok := load.cost(); ok {
+				sum += ewma
+				count++
+			}
+		}
+	}
+	if count == 0 {
+		return 1
+	}
+	return sum / float64(count)
+}
+
+// 删除超过 loadIdleTimeout 没有使用的负载记录，每 loadIdleTimeout 最多清理一次
+func (that *loadTable) sweep(now time.Time) {
+	swept := atomic.LoadInt64(&that.swept)
+	if now.UnixNano()-swept < int64(loadIdleTimeout) || !atomic.CompareAndSwapInt64(&that.swept, swept, now.UnixNano()) {
+		return
+	}
+	that.loads.LockFunc(func(m map[string]interface{}) {
+		for addr, v := range m {
+			if v.(*nodeLoad).idle(now, loadIdleTimeout) {
+				delete(m, addr)
+			}
+		}
+	})
+}
+
+// 节点的负载
+type nodeLoad struct {
+	mu       sync.Mutex
+	ewma     float64
+	last     time.Time
+	inflight int64
+	used     int64 // 最后使用的时间
+}
+
+// 开始请求
+func (that *nodeLoad) begin() func() {
+	atomic.AddInt64(&that.inflight, 1)
+	start := time.Now()
+	atomic.StoreInt64(&that.used, start.UnixNano())
+	return func() {
+		now := time.Now()
+		atomic.AddInt64(&that.inflight, -1)
+		atomic.StoreInt64(&that.used, now.UnixNano())
+		that.observe(now, now.Sub(start))
+	}
+}
+
+// 没有进行中的请求并且超过 timeout 没有使用
+func (that *nodeLoad) idle(now time.Time, timeout time.Duration) bool {
+	return atomic.LoadInt64(&that.inflight) == 0 && now.UnixNano()-atomic.LoadInt64(&that.used) > int64(timeout)
+}
+
+// 记录请求耗时，按照距离上次记录的时间计算衰减系数
+func (that *nodeLoad) observe(now time.Time, rtt time.Duration) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	if that.last.IsZero() {
+		that.ewma = float64(rtt)
+	} else {
+		w := math.Exp(-float64(now.Sub(that.last)) / float64(loadDecayTime))
+		that.ewma = that.ewma*w + float64(rtt)*(1-w)
+	}
+	that.last = now
+}
+
+// 请求耗时的EWMA，没有请求记录时返回false
+func (that *nodeLoad) cost() (float64, bool) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return that.ewma, !that.last.IsZero()
+}
+
+// 节点的负载分数，越小越优先选择
+//  没有请求记录的节点使用 defaultCost 作为请求耗时，进行中的请求数同样会增加负载
+func (that *nodeLoad) score(defaultCost float64) float64 {
+	ewma, ok := that.cost()
+	if !ok {
+		ewma = defaultCost
+	}
+	return ewma * float64(atomic.LoadInt64(&that.inflight)+1)
+}
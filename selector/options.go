@@ -11,6 +11,8 @@ type Options struct {
 	Registry registry.Registry
 	// 节点选择策略引擎
 	Strategy Strategy
+	// 节点熔断器的配置，为nil时不启用熔断
+	Breaker *BreakerOptions
 	// 扩展配置，可以添加自定义选项
 	Context context.Context
 }
@@ -45,6 +47,20 @@ func OptStrategy(fn Strategy) Option {
 	}
 }
 
+// OptBreaker 开启节点熔断，并设置熔断器的配置，例如 OptBreaker(DefaultBreakerOptions)
+func OptBreaker(opts BreakerOptions) Option {
+	return func(o *Options) {
+		o.Breaker = &opts
+	}
+}
+
+// OptDisableBreaker 不启用节点熔断，用来关闭之前通过 OptBreaker 开启的熔断
+func OptDisableBreaker() Option {
+	return func(o *Options) {
+		o.Breaker = nil
+	}
+}
+
 // OptWithFilter 添加节点过滤规则
 func OptWithFilter(fn ...Filter) SelectOption {
 	return func(o *SelectOptions) {
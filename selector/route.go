@@ -0,0 +1,154 @@
+package selector
+
+import (
+	"github.com/osgochina/dmicro/registry"
+	"math/rand"
+	"sync"
+)
+
+// RouteRule 路由规则，按照服务的版本和节点的元数据过滤节点
+//  例如:
+//  5%的请求发送到v2版本: RouteRule{Version: "v2", Percent: 5}
+//  元数据 X-Tenant=acme 的请求发送到 tenant=acme 的节点: RouteRule{Match: map[string]string{"X-Tenant": "acme"}, Labels: map[string]string{"tenant": "acme"}}
+//  优先选择同一个机房的节点: RouteRule{Prefer: map[string]string{"zone": "cn-east-1"}}
+type RouteRule struct {
+	// Name 规则名称
+	Name string `json:"name"`
+	// Match 请求的元数据需要满足的条件，全部满足时规则生效，为空时对所有请求生效
+	Match map[string]string `json:"match"`
+	// Version 选中指定版本的服务
+	Version string `json:"version"`
+	// Labels 选中元数据满足全部条件的节点，节点中不存在的key使用服务的元数据判断
+	Labels map[string]string `json:"labels"`
+	// Percent 按照百分比把请求发送到选中的节点，其余的请求发送到未选中的节点，取值 (0,100)，其他值表示全部请求
+	Percent float64 `json:"percent"`
+	// Fallback 没有选中的节点时使用全部节点，按照百分比路由时总是回退
+	Fallback bool `json:"fallback"`
+	// Prefer 优先选择元数据满足全部条件的节点，不存在这样的节点时使用全部节点
+	Prefer map[string]string `json:"prefer"`
+}
+
+// 请求是否满足规则的条件
+func (that *RouteRule) match(meta map[string]string) bool {
+	for k, v := range that.Match {
+		if meta[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// 节点是否被规则选中
+func (that *RouteRule) selected(service *registry.Service, node *registry.Node) bool {
+	if len(that.Version) > 0 && service.Version != that.Version {
+		return false
+	}
+	return matchLabels(service, node, that.Labels)
+}
+
+// 使用规则过滤节点
+func (that *RouteRule) filter(services []*registry.Service) []*registry.Service {
+	if len(that.Version) > 0 || len(that.Labels) > 0 {
+		selected := filterNodes(services, func(s *registry.Service, n *registry.Node) bool {
+			return that.selected(s, n)
+		})
+		if that.Percent > 0 && that.Percent < 100 {
+			if rand.Float64()*100 >= that.Percent {
+				selected = filterNodes(services, func(s *registry.Service, n *registry.Node) bool {
+					return !that.selected(s, n)
+				})
+			}
+			if len(selected) == 0 {
+				selected = services
+			}
+		} else if len(selected) == 0 && that.Fallback {
+			selected = services
+		}
+		services = selected
+	}
+	if len(that.Prefer) > 0 {
+		preferred := filterNodes(services, func(s *registry.Service, n *registry.Node) bool {
+			return matchLabels(s, n, that.Prefer)
+		})
+		if len(preferred) > 0 {
+			services = preferred
+		}
+	}
+	return services
+}
+
+// Router 路由规则表，规则按照顺序依次过滤节点，可以在运行时更新
+type Router struct {
+	mu    sync.RWMutex
+	rules []RouteRule
+}
+
+// NewRouter 创建路由规则表
+func NewRouter(rules ...RouteRule) *Router {
+	return &Router{rules: rules}
+}
+
+// Update 替换全部的路由规则
+func (that *Router) Update(rules ...RouteRule) {
+	that.mu.Lock()
+	that.rules = rules
+	that.mu.Unlock()
+}
+
+// Rules 获取当前的路由规则
+func (that *Router) Rules() []RouteRule {
+	that.mu.RLock()
+	defer that.mu.RUnlock()
+	rules := make([]RouteRule, len(that.rules))
+	copy(rules, that.rules)
+	return rules
+}
+
+// Filter 根据请求的元数据生成节点过滤器，使用生成时的路由规则
+func (that *Router) Filter(meta map[string]string) Filter {
+	that.mu.RLock()
+	rules := that.rules
+	that.mu.RUnlock()
+	return func(services []*registry.Service) []*registry.Service {
+		for i := range rules {
+			if rules[i].match(meta) {
+				services = rules[i].filter(services)
+			}
+		}
+		return services
+	}
+}
+
+// 节点的元数据是否满足全部条件，节点中不存在的key使用服务的元数据判断
+func matchLabels(service *registry.Service, node *registry.Node, labels map[string]string) bool {
+	for k, v := range labels {
+		value, ok := node.Metadata[k]
+		if !ok {
+			value = service.Metadata[k]
+		}
+		if value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// 保留满足条件的节点，不存在节点的服务会被删除
+func filterNodes(services []*registry.Service, fn func(*registry.Service, *registry.Node) bool) []*registry.Service {
+	filtered := make([]*registry.Service, 0, len(services))
+	for _, s := range services {
+		nodes := make([]*registry.Node, 0, len(s.Nodes))
+		for _, node := range s.Nodes {
+			if fn(s, node) {
+				nodes = append(nodes, node)
+			}
+		}
+		if len(nodes) == 0 {
+			continue
+		}
+		cp := *s
+		cp.Nodes = nodes
+		filtered = append(filtered, &cp)
+	}
+	return filtered
+}
@@ -0,0 +1,91 @@
+package selector
+
+import (
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/osgochina/dmicro/registry"
+	"github.com/osgochina/dmicro/registry/memory"
+	"testing"
+)
+
+func newRouteTestSelector(t *gtest.T) Selector {
+	r := memory.NewRegistry()
+	services := []*registry.Service{
+		{
+			Name:    "route",
+			Version: "v1",
+			Nodes: []*registry.Node{
+				{Id: "v1-a", Address: "127.0.0.1:9001", Metadata: map[string]string{"zone": "a"}},
+				{Id: "v1-b", Address: "127.0.0.1:9002", Metadata: map[string]string{"zone": "b", "tenant": "acme"}},
+			},
+		},
+		{
+			Name:    "route",
+			Version: "v2",
+			Nodes: []*registry.Node{
+				{Id: "v2-a", Address: "127.0.0.1:9003", Metadata: map[string]string{"zone": "a"}},
+			},
+		},
+	}
+	for _, s := range services {
+		t.AssertNil(r.Register(s))
+	}
+	return NewSelector(OptRegistry(r), OptDisableBreaker())
+}
+
+func routeSelect(t *gtest.T, s Selector, router *Router, meta map[string]string) string {
+	next, err := s.Select("route", OptWithFilter(router.Filter(meta)), OptWithStrategy(RoundRobin))
+	if err != nil {
+		return err.Error()
+	}
+	node, err := next()
+	t.AssertNil(err)
+	return node.Address
+}
+
+func TestRouterCanary(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		s := newRouteTestSelector(t)
+		defer s.Close()
+		router := NewRouter(RouteRule{Name: "canary", Version: "v2", Percent: 5})
+		canary := 0
+		for i := 0; i < 10000; i++ {
+			if routeSelect(t, s, router, nil) == "127.0.0.1:9003" {
+				canary++
+			}
+		}
+		t.AssertGT(canary, 300)
+		t.AssertLT(canary, 700)
+
+		// 运行时更新规则，全部请求发送到v2
+		router.Update(RouteRule{Name: "canary", Version: "v2"})
+		for i := 0; i < 10; i++ {
+			t.Assert(routeSelect(t, s, router, nil), "127.0.0.1:9003")
+		}
+		t.Assert(len(router.Rules()), 1)
+	})
+}
+
+func TestRouterLabels(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		s := newRouteTestSelector(t)
+		defer s.Close()
+		router := NewRouter(
+			RouteRule{Name: "tenant", Match: map[string]string{"X-Tenant": "acme"}, Labels: map[string]string{"tenant": "acme"}},
+			RouteRule{Name: "zone", Prefer: map[string]string{"zone": "a"}},
+		)
+		// 租户的请求只发送到租户的节点
+		for i := 0; i < 10; i++ {
+			t.Assert(routeSelect(t, s, router, map[string]string{"X-Tenant": "acme"}), "127.0.0.1:9002")
+		}
+		// 其他请求优先发送到同一个机房的节点
+		for i := 0; i < 10; i++ {
+			addr := routeSelect(t, s, router, nil)
+			t.AssertIN(addr, []string{"127.0.0.1:9001", "127.0.0.1:9003"})
+		}
+		// 没有满足条件的节点
+		router.Update(RouteRule{Labels: map[string]string{"tenant": "none"}})
+		t.Assert(routeSelect(t, s, router, nil), ErrNoneAvailable.Error())
+		router.Update(RouteRule{Labels: map[string]string{"tenant": "none"}, Fallback: true})
+		t.AssertNE(routeSelect(t, s, router, nil), ErrNoneAvailable.Error())
+	})
+}
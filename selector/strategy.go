@@ -3,20 +3,28 @@ package selector
 import (
 	"github.com/osgochina/dmicro/registry"
 	"math/rand"
+	"strconv"
+	"sync/atomic"
 	"time"
 )
 
+// WeightKey 节点元数据中保存权重的key
+const WeightKey = "weight"
+
+// DefaultWeight 节点未设置权重或者权重无效时使用的权重
+const DefaultWeight = 100
+
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
+// 直接调用 RoundRobin 时使用的计数器，所有服务共享
+//  在 registrySelector 中使用时，每个服务使用选择器中独立的计数器
+var roundRobinCounter uint64
+
 // Random 随机选择节点
 func Random(services []*registry.Service) Next {
-	nodes := make([]*registry.Node, 0, len(services))
-
-	for _, service := range services {
-		nodes = append(nodes, service.Nodes...)
-	}
+	nodes := getNodes(services)
 
 	return func() (*registry.Node, error) {
 		if len(nodes) == 0 {
@@ -26,3 +34,111 @@ func Random(services []*registry.Service) Next {
 		return nodes[i], nil
 	}
 }
+
+// RoundRobin 轮询选择节点
+func RoundRobin(services []*registry.Service) Next {
+	return roundRobin(services, &roundRobinCounter)
+}
+
+// 使用指定的计数器轮询选择节点
+func roundRobin(services []*registry.Service, counter *uint64) Next {
+	nodes := getNodes(services)
+
+	return func() (*registry.Node, error) {
+		if len(nodes) == 0 {
+			return nil, ErrNoneAvailable
+		}
+		i := atomic.AddUint64(counter, 1)
+		return nodes[i%uint64(len(nodes))], nil
+	}
+}
+
+// WeightedRandom 按照节点元数据中 WeightKey 设置的权重随机选择节点
+//  未设置权重或者权重无效的节点使用 DefaultWeight
+//  权重为0的节点不会被选中，全部节点的权重都为0时退化为随机选择
+func WeightedRandom(services []*registry.Service) Next {
+	nodes := getNodes(services)
+	weights := make([]int, len(nodes))
+	total := 0
+	for i, node := range nodes {
+		weights[i] = NodeWeight(node)
+		total += weights[i]
+	}
+
+	return func() (*registry.Node, error) {
+		if len(nodes) == 0 {
+			return nil, ErrNoneAvailable
+		}
+		if total == 0 {
+			return nodes[rand.Int()%len(nodes)], nil
+		}
+		r := rand.Intn(total)
+		for i, w := range weights {
+			if r < w {
+				return nodes[i], nil
+			}
+			r -= w
+		}
+		return nodes[len(nodes)-1], nil
+	}
+}
+
+// P2C 使用 power of two choices 算法选择节点
+//  每次随机挑选两个节点，选择 负载 = 请求耗时的EWMA * (进行中的请求数 + 1) 较小的节点
+//  没有请求记录的节点使用其他节点请求耗时的平均值，避免突发的请求全部发送到新节点
+//  节点的请求耗时和进行中的请求数由 registrySelector 通过 Tracker 记录，RpcClient 会自动反馈
+//  不在 registrySelector 中使用时没有负载记录，退化为随机选择
+func P2C(services []*registry.Service) Next {
+	return p2c(services, nil)
+}
+
+// 使用负载记录按照 power of two choices 算法选择节点，loads 为nil时随机选择
+func p2c(services []*registry.Service, loads *loadTable) Next {
+	nodes := getNodes(services)
+
+	return func() (*registry.Node, error) {
+		switch len(nodes) {
+		case 0:
+			return nil, ErrNoneAvailable
+		case 1:
+			return nodes[0], nil
+		}
+		a := rand.Intn(len(nodes))
+		b := rand.Intn(len(nodes) - 1)
+		if b >= a {
+			b++
+		}
+		if loads != nil {
+			cost := loads.defaultCost(nodes)
+			if loads.score(nodes[b], cost) < loads.score(nodes[a], cost) {
+				a = b
+			}
+		}
+		return nodes[a], nil
+	}
+}
+
+// NodeWeight 获取节点的权重
+func NodeWeight(node *registry.Node) int {
+	if node.Metadata == nil {
+		return DefaultWeight
+	}
+	s, ok := node.Metadata[WeightKey]
+	if !ok {
+		return DefaultWeight
+	}
+	w, err := strconv.Atoi(s)
+	if err != nil || w < 0 {
+		return DefaultWeight
+	}
+	return w
+}
+
+// 获取所有服务的节点
+func getNodes(services []*registry.Service) []*registry.Node {
+	nodes := make([]*registry.Node, 0, len(services))
+	for _, service := range services {
+		nodes = append(nodes, service.Nodes...)
+	}
+	return nodes
+}
@@ -0,0 +1,173 @@
+package selector
+
+import (
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/osgochina/dmicro/registry"
+	"github.com/osgochina/dmicro/registry/memory"
+	"testing"
+	"time"
+)
+
+func newTestServices(nodes ...*registry.Node) []*registry.Service {
+	return []*registry.Service{{Name: "svc", Nodes: nodes}}
+}
+
+func TestRoundRobin(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		services := newTestServices(
+			&registry.Node{Address: "127.0.0.1:9001"},
+			&registry.Node{Address: "127.0.0.1:9002"},
+			&registry.Node{Address: "127.0.0.1:9003"},
+		)
+		next := RoundRobin(services)
+		counts := make(map[string]int)
+		for i := 0; i < 30; i++ {
+			node, err := next()
+			t.AssertNil(err)
+			counts[node.Address]++
+		}
+		t.Assert(counts["127.0.0.1:9001"], 10)
+		t.Assert(counts["127.0.0.1:9002"], 10)
+		t.Assert(counts["127.0.0.1:9003"], 10)
+
+		_, err := RoundRobin(nil)()
+		t.Assert(err, ErrNoneAvailable)
+	})
+}
+
+// 选择器中每个服务使用独立的轮询计数器
+func TestRoundRobinPerService(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		r := memory.NewRegistry()
+		for _, name := range []string{"svc-a", "svc-b"} {
+			t.AssertNil(r.Register(&registry.Service{
+				Name:    name,
+				Version: "v1",
+				Nodes: []*registry.Node{
+					{Id: name + "-1", Address: "127.0.0.1:9001"},
+					{Id: name + "-2", Address: "127.0.0.1:9002"},
+				},
+			}))
+		}
+		s := NewSelector(OptRegistry(r), OptStrategy(RoundRobin))
+		defer s.Close()
+		counts := make(map[string]int)
+		for i := 0; i < 20; i++ {
+			for _, name := range []string{"svc-a", "svc-b"} {
+				next, err := s.Select(name)
+				t.AssertNil(err)
+				node, err := next()
+				t.AssertNil(err)
+				counts[name+node.Address]++
+			}
+		}
+		t.Assert(counts["svc-a127.0.0.1:9001"], 10)
+		t.Assert(counts["svc-b127.0.0.1:9002"], 10)
+	})
+}
+
+func TestWeightedRandom(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		services := newTestServices(
+			&registry.Node{Address: "127.0.0.1:9001", Metadata: map[string]string{WeightKey: "0"}},
+			&registry.Node{Address: "127.0.0.1:9002", Metadata: map[string]string{WeightKey: "300"}},
+			&registry.Node{Address: "127.0.0.1:9003"},
+		)
+		t.Assert(NodeWeight(services[0].Nodes[2]), DefaultWeight)
+		next := WeightedRandom(services)
+		counts := make(map[string]int)
+		for i := 0; i < 4000; i++ {
+			node, err := next()
+			t.AssertNil(err)
+			counts[node.Address]++
+		}
+		t.Assert(counts["127.0.0.1:9001"], 0)
+		t.AssertGT(counts["127.0.0.1:9002"], counts["127.0.0.1:9003"]*2)
+
+		// 全部权重为0时随机选择
+		node, err := WeightedRandom(newTestServices(services[0].Nodes[0]))()
+		t.AssertNil(err)
+		t.Assert(node.Address, "127.0.0.1:9001")
+	})
+}
+
+func TestP2C(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		fast := &registry.Node{Address: "127.0.0.1:9101"}
+		slow := &registry.Node{Address: "127.0.0.1:9102"}
+		loads := newLoadTable()
+		now := time.Now()
+		loads.get(fast).observe(now, time.Millisecond)
+		loads.get(slow).observe(now, 100*time.Millisecond)
+
+		next := p2c(newTestServices(fast, slow), loads)
+		for i := 0; i < 10; i++ {
+			node, err := next()
+			t.AssertNil(err)
+			t.Assert(node.Address, fast.Address)
+		}
+
+		// 进行中的请求数增加了快节点的负载
+		var dones []func()
+		for i := 0; i < 200; i++ {
+			dones = append(dones, loads.get(fast).begin())
+		}
+		node, err := next()
+		t.AssertNil(err)
+		t.Assert(node.Address, slow.Address)
+		for _, done := range dones {
+			done()
+		}
+		t.Assert(loads.get(fast).inflight, 0)
+	})
+}
+
+// 长时间没有使用的节点的负载记录被删除
+func TestLoadTableSweep(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		loads := newLoadTable()
+		idle := &registry.Node{Address: "127.0.0.1:9101"}
+		busy := &registry.Node{Address: "127.0.0.1:9102"}
+		loads.get(idle).begin()()
+		done := loads.get(busy).begin()
+		defer done()
+
+		now := time.Now().Add(loadIdleTimeout + time.Second)
+		loads.sweep(now)
+		t.AssertNil(loads.peek(idle))
+		t.AssertNE(loads.peek(busy), nil)
+	})
+}
+
+// 没有请求记录的节点，进行中的请求数同样会增加负载
+func TestP2CColdNodes(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		a := &registry.Node{Address: "127.0.0.1:9101"}
+		b := &registry.Node{Address: "127.0.0.1:9102"}
+		loads := newLoadTable()
+		next := p2c(newTestServices(a, b), loads)
+		done := loads.get(a).begin()
+		for i := 0; i < 10; i++ {
+			node, err := next()
+			t.AssertNil(err)
+			t.Assert(node.Address, b.Address)
+		}
+		done()
+
+		// 没有请求记录的节点使用其他节点请求耗时的平均值
+		c := &registry.Node{Address: "127.0.0.1:9103"}
+		loads.get(c).observe(time.Now(), 10*time.Millisecond)
+		t.Assert(loads.defaultCost([]*registry.Node{b, c}), float64(10*time.Millisecond))
+		var dones []func()
+		for i := 0; i < 3; i++ {
+			dones = append(dones, loads.get(b).begin())
+		}
+		next = p2c(newTestServices(b, c), loads)
+		node, err := next()
+		t.AssertNil(err)
+		t.Assert(node.Address, c.Address)
+		for _, done := range dones {
+			done()
+		}
+	})
+}
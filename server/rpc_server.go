@@ -32,6 +32,8 @@ type RpcServer struct {
 	closeMu  sync.Mutex
 }
 
+var _ drpc.CallRouter = new(RpcServer)
+
 // NewRpcServer 创建rpcServer
 func NewRpcServer(serviceName string, opt ...Option) *RpcServer {
 
@@ -120,6 +122,11 @@ func (that *RpcServer) RoutePushFunc(pushHandleFunc interface{}, plugin ...drpc.
 	return that.endpoint.RoutePushFunc(pushHandleFunc, plugin...)
 }
 
+// RemoveRoute 删除指定资源标识符的处理方法，服务运行期间也可以调用
+func (that *RpcServer) RemoveRoute(path string) bool {
+	return that.endpoint.RemoveRoute(path)
+}
+
 // SetUnknownCall 设置默认处理方法
 // 当请求call类型的资源标识符不存在时，则执行其设置的方法
 func (that *RpcServer) SetUnknownCall(fn func(drpc.UnknownCallCtx) (interface{}, *drpc.Status), plugin ...drpc.Plugin) {
@@ -3,6 +3,7 @@ package backoff
 
 import (
 	"math"
+	"math/rand"
 	"time"
 )
 
@@ -21,3 +22,26 @@ func Do(attempts int) time.Duration {
 	}
 	return time.Duration(math.Pow(10, float64(attempts))) * time.Millisecond
 }
+
+// Exponential 指数退避，第 attempts 次重试等待 base*2^(attempts-1)，最大不超过 max，max为0时不限制
+func Exponential(attempts int, base time.Duration, max time.Duration) time.Duration {
+	if attempts <= 0 || base <= 0 {
+		return time.Duration(0)
+	}
+	d := float64(base) * math.Pow(2, float64(attempts-1))
+	if max > 0 && d > float64(max) {
+		return max
+	}
+	if d > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(d)
+}
+
+// FullJitter 在 [0,d) 之间随机选择等待时间，避免多个客户端同时重试
+func FullJitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return time.Duration(0)
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
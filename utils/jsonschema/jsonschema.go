@@ -0,0 +1,218 @@
+// Package jsonschema 根据Go的反射类型生成 JSON-Schema 描述
+package jsonschema
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// DefsRefPrefix 默认的引用前缀，命名结构体统一放在 $defs 中
+const DefsRefPrefix = "#/$defs/"
+
+// Schema JSON-Schema 描述
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+}
+
+var (
+	typeOfTime           = reflect.TypeOf(time.Time{})
+	typeOfBytes          = reflect.TypeOf([]byte(nil))
+	typeOfJSONMarshaler  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	typeOfRawMessage     = reflect.TypeOf(json.RawMessage(nil))
+	typeOfEmptyInterface = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// Reflector 生成 JSON-Schema，多次生成时共享命名结构体的定义
+type Reflector struct {
+	// RefPrefix 引用命名结构体时使用的前缀，默认为 DefsRefPrefix
+	RefPrefix string
+	// Definitions 已经生成的命名结构体，key为定义的名字
+	Definitions map[string]*Schema
+	// Describe 为结构体字段提供额外的描述，例如从源码注释中解析出的内容，返回空字符串表示没有描述
+	Describe func(structType reflect.Type, field reflect.StructField) string
+	// DescribeType 为命名结构体提供描述，返回空字符串表示没有描述
+	DescribeType func(t reflect.Type) string
+	// 定义名字对应的类型，用于处理同名的类型
+	names map[string]reflect.Type
+	// 类型对应的定义名字
+	types map[reflect.Type]string
+}
+
+// NewReflector 创建生成器
+func NewReflector(refPrefix ...string) *Reflector {
+	r := &Reflector{
+		RefPrefix:   DefsRefPrefix,
+		Definitions: make(map[string]*Schema),
+		names:       make(map[string]reflect.Type),
+		types:       make(map[reflect.Type]string),
+	}
+	if len(refPrefix) > 0 {
+		r.RefPrefix = refPrefix[0]
+	}
+	return r
+}
+
+// Reflect 生成单个类型的 JSON-Schema，引用到的命名结构体放在返回值的 $defs 中
+func Reflect(t reflect.Type) *Schema {
+	r := NewReflector()
+	s := r.Reflect(t)
+	if len(r.Definitions) > 0 {
+		s.Defs = r.Definitions
+	}
+	return s
+}
+
+// Reflect 生成类型的 JSON-Schema，命名结构体生成到 Definitions 中并返回对它的引用
+func (that *Reflector) Reflect(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case typeOfTime:
+		return &Schema{Type: "string", Format: "date-time"}
+	case typeOfBytes:
+		return &Schema{Type: "string", Format: "byte"}
+	case typeOfRawMessage, typeOfEmptyInterface:
+		return &Schema{}
+	}
+	// 自定义了json序列化的类型，无法推断其格式
+	if t.Kind() != reflect.Struct && t.Implements(typeOfJSONMarshaler) {
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: that.Reflect(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: that.Reflect(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return that.reflectStruct(t)
+		}
+		return &Schema{Ref: that.RefPrefix + that.define(t)}
+	}
+	// interface、chan、func 等类型不做限制
+	return &Schema{}
+}
+
+// 生成命名结构体的定义，返回定义的名字
+func (that *Reflector) define(t reflect.Type) string {
+	if name, ok := that.types[t]; ok {
+		return name
+	}
+	if that.Definitions == nil {
+		that.Definitions = make(map[string]*Schema)
+	}
+	if that.names == nil {
+		that.names = make(map[string]reflect.Type)
+		that.types = make(map[reflect.Type]string)
+	}
+	name := definitionName(t.Name())
+	if _, ok := that.names[name]; ok {
+		//不同包中的同名类型，使用完整的类型名
+		name = definitionName(t.String())
+	}
+	that.names[name] = t
+	that.types[t] = name
+	//先占位，避免递归引用自己时死循环
+	that.Definitions[name] = &Schema{}
+	*that.Definitions[name] = *that.reflectStruct(t)
+	if that.DescribeType != nil {
+		that.Definitions[name].Description = that.DescribeType(t)
+	}
+	return name
+}
+
+// 生成结构体的描述，字段名与 encoding/json 的规则保持一致
+func (that *Reflector) reflectStruct(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	that.reflectFields(t, s)
+	return s
+}
+
+func (that *Reflector) reflectFields(t reflect.Type, s *Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		//匿名嵌入且没有指定名字的结构体，字段提升到外层
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				that.reflectFields(ft, s)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fs := that.Reflect(field.Type)
+		//$ref 的同级属性会被忽略，引用类型不写描述
+		if desc := that.describe(t, field); desc != "" && fs.Ref == "" {
+			fs.Description = desc
+		}
+		s.Properties[name] = fs
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Ptr {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// 获取字段的描述，优先使用 description 标签，其次是 gf 的 dc 标签，最后是 Describe 回调
+func (that *Reflector) describe(t reflect.Type, field reflect.StructField) string {
+	if desc := field.Tag.Get("description"); desc != "" {
+		return desc
+	}
+	if desc := field.Tag.Get("dc"); desc != "" {
+		return desc
+	}
+	if that.Describe != nil {
+		return that.Describe(t, field)
+	}
+	return ""
+}
+
+// 定义名字中只保留字母、数字以及 "." "_" "-"
+func definitionName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,58 @@
+package jsonschema_test
+
+import (
+	"encoding/json"
+	"github.com/gogf/gf/v2/test/gtest"
+	"github.com/osgochina/dmicro/utils/jsonschema"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type Base struct {
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type User struct {
+	Base
+	Id      int              `json:"id" description:"用户编号"`
+	Name    string           `json:"name,omitempty"`
+	Avatar  []byte           `json:"avatar,omitempty"`
+	Tags    []string         `json:"tags"`
+	Extra   map[string]any   `json:"extra,omitempty"`
+	Friends []*User          `json:"friends,omitempty"`
+	Inline  struct{ X bool } `json:"inline"`
+	Ignored string           `json:"-"`
+	secret  string
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+func TestReflect(t *testing.T) {
+	gtest.C(t, func(t *gtest.T) {
+		s := jsonschema.Reflect(reflect.TypeOf(&User{}))
+		t.Assert(s.Ref, "#/$defs/User")
+		user := s.Defs["User"]
+		t.Assert(user.Type, "object")
+		t.Assert(user.Properties["created_at"].Format, "date-time")
+		t.Assert(user.Properties["id"].Type, "integer")
+		t.Assert(user.Properties["id"].Description, "用户编号")
+		t.Assert(user.Properties["avatar"].Format, "byte")
+		t.Assert(user.Properties["tags"].Items.Type, "string")
+		t.Assert(user.Properties["friends"].Items.Ref, "#/$defs/User")
+		t.Assert(user.Properties["labels"].AdditionalProperties.Type, "string")
+		t.Assert(user.Properties["inline"].Properties["X"].Type, "boolean")
+		_, ok := user.Properties["Ignored"]
+		t.Assert(ok, false)
+		_, ok = user.Properties["secret"]
+		t.Assert(ok, false)
+		t.Assert(user.Required, []string{"created_at", "id", "tags", "inline"})
+
+		_, err := json.Marshal(s)
+		t.AssertNil(err)
+
+		r := jsonschema.NewReflector("#/components/schemas/")
+		t.Assert(r.Reflect(reflect.TypeOf(User{})).Ref, "#/components/schemas/User")
+		t.Assert(r.Reflect(reflect.TypeOf(1.5)).Format, "double")
+		t.Assert(len(r.Definitions), 1)
+	})
+}